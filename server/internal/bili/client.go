@@ -52,25 +52,27 @@ type DescV2Item struct {
 }
 
 type PublishVideoRequest struct {
-	Copyright    int                       `json:"copyright"`
-	Cover        string                    `json:"cover"`
-	Desc         string                    `json:"desc"`
-	DescFormatID int                       `json:"desc_format_id"`
-	DescV2       []DescV2Item              `json:"desc_v2,omitempty"`
-	Dynamic      string                    `json:"dynamic"`
-	DynamicV2    []DescV2Item              `json:"dynamic_v2,omitempty"`
-	Interactive  int                       `json:"interactive"`
-	NoReprint    int                       `json:"no_reprint"`
-	OpenElec     int                       `json:"open_elec"`
-	Source       string                    `json:"source"`
-	Tag          string                    `json:"tag"`
-	Tid          int                       `json:"tid"`
-	Title        string                    `json:"title"`
-	Videos       []PublishVideoPartRequest `json:"videos"`
-	CSRF         string                    `json:"csrf"`
-	UpCloseReply bool                      `json:"up_close_reply"`
-	UpCloseDanmu bool                      `json:"up_close_danmu"`
-	WebOS        int                       `json:"web_os"`
+	Copyright     int                       `json:"copyright"`
+	Cover         string                    `json:"cover"`
+	Desc          string                    `json:"desc"`
+	DescFormatID  int                       `json:"desc_format_id"`
+	DescV2        []DescV2Item              `json:"desc_v2,omitempty"`
+	Dynamic       string                    `json:"dynamic"`
+	DynamicV2     []DescV2Item              `json:"dynamic_v2,omitempty"`
+	Interactive   int                       `json:"interactive"`
+	NoReprint     int                       `json:"no_reprint"`
+	OpenElec      int                       `json:"open_elec"`
+	Source        string                    `json:"source"`
+	Tag           string                    `json:"tag"`
+	Tid           int                       `json:"tid"`
+	Title         string                    `json:"title"`
+	Videos        []PublishVideoPartRequest `json:"videos"`
+	CSRF          string                    `json:"csrf"`
+	UpCloseReply  bool                      `json:"up_close_reply"`
+	UpCloseDanmu  bool                      `json:"up_close_danmu"`
+	WebOS         int                       `json:"web_os"`
+	IsOnlySelf    int                       `json:"is_only_self"`   // 是否仅自己可见
+	NoDisturbance int                       `json:"no_disturbance"` // 是否不推送动态（粉丝不打扰）
 }
 
 type PublishVideoPartRequest struct {
@@ -154,7 +156,7 @@ func (c *BiliClient) PreUpload(filename string, filesize int64) (*PreUploadResp,
 }
 
 // PublishVideo 投稿视频
-func (c *BiliClient) PublishVideo(title, desc, tags string, tid, copyright int, cover string, videos []PublishVideoPartRequest, source string) (int64, string, error) {
+func (c *BiliClient) PublishVideo(title, desc, tags string, tid, copyright int, cover string, videos []PublishVideoPartRequest, source string, isOnlySelf, noDisturbance bool) (int64, string, error) {
 	csrf := GetCookieValue(c.Cookies, "bili_jct")
 	if csrf == "" {
 		return 0, "", fmt.Errorf("未找到CSRF token (bili_jct)")
@@ -177,6 +179,12 @@ func (c *BiliClient) PublishVideo(title, desc, tags string, tid, copyright int,
 		OpenElec:     1,
 		WebOS:        1,
 	}
+	if isOnlySelf {
+		req.IsOnlySelf = 1
+	}
+	if noDisturbance {
+		req.NoDisturbance = 1
+	}
 
 	// 调试日志：输出videos数组以检查CID
 	fmt.Printf("投稿请求 - 视频数量: %d\n", len(videos))
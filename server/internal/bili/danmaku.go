@@ -1,6 +1,7 @@
 package bili
 
 import (
+	"errors"
 	"fmt"
 	"strconv"
 )
@@ -105,32 +106,51 @@ func (c *BiliClient) sendDanmakuInternal(cid int64, bvid string, progress int, m
 	}
 
 	if resp.Code != 0 {
-		// 详细的错误码处理
-		switch resp.Code {
-		case 36701:
-			return fmt.Errorf("弹幕包含被禁止的内容 (code=%d)", resp.Code)
-		case 36702:
-			return fmt.Errorf("弹幕长度超过100字符 (code=%d)", resp.Code)
-		case 36703:
-			return fmt.Errorf("发送频率过快，需要等待 (code=%d)", resp.Code)
-		case 36704:
-			return fmt.Errorf("禁止向未审核的视频发送弹幕 (code=%d)", resp.Code)
-		case 36714:
-			return fmt.Errorf("弹幕发送时间不合法 (code=%d)", resp.Code)
-		case -101:
-			return fmt.Errorf("账号未登录 (code=%d)", resp.Code)
-		case -102:
-			return fmt.Errorf("账号被封停 (code=%d)", resp.Code)
-		case -111:
-			return fmt.Errorf("csrf校验失败 (code=%d)", resp.Code)
-		default:
-			return fmt.Errorf("发送弹幕失败: %s (code=%d)", resp.Message, resp.Code)
-		}
+		return &DanmakuError{Code: resp.Code, Message: resp.Message}
 	}
 
 	return nil
 }
 
+// DanmakuError 弹幕接口返回的业务错误，调用方可按Code区分限流、内容违规、账号异常等情况
+type DanmakuError struct {
+	Code    int
+	Message string
+}
+
+func (e *DanmakuError) Error() string {
+	// 详细的错误码处理
+	switch e.Code {
+	case 36701:
+		return fmt.Sprintf("弹幕包含被禁止的内容 (code=%d)", e.Code)
+	case 36702:
+		return fmt.Sprintf("弹幕长度超过100字符 (code=%d)", e.Code)
+	case 36703:
+		return fmt.Sprintf("发送频率过快，需要等待 (code=%d)", e.Code)
+	case 36704:
+		return fmt.Sprintf("禁止向未审核的视频发送弹幕 (code=%d)", e.Code)
+	case 36714:
+		return fmt.Sprintf("弹幕发送时间不合法 (code=%d)", e.Code)
+	case -101:
+		return fmt.Sprintf("账号未登录 (code=%d)", e.Code)
+	case -102:
+		return fmt.Sprintf("账号被封停 (code=%d)", e.Code)
+	case -111:
+		return fmt.Sprintf("csrf校验失败 (code=%d)", e.Code)
+	default:
+		return fmt.Sprintf("发送弹幕失败: %s (code=%d)", e.Message, e.Code)
+	}
+}
+
+// DanmakuErrorCode 获取弹幕发送错误的业务错误码，非业务错误返回0
+func DanmakuErrorCode(err error) int {
+	var dmErr *DanmakuError
+	if errors.As(err, &dmErr) {
+		return dmErr.Code
+	}
+	return 0
+}
+
 type DanmakuItem struct {
 	CID      int64
 	BvID     string
@@ -0,0 +1,89 @@
+package bili
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/imroc/req/v3"
+)
+
+// LiveStreamURL 直播流地址
+type LiveStreamURL struct {
+	Protocol string // http_stream / http_hls
+	Format   string // flv / ts / fmp4
+	Codec    string // avc / hevc
+	Qn       int    // 实际画质
+	URL      string
+}
+
+// GetLiveStreamURLs 获取直播间的全部直播流地址，qn为期望画质（10000原画 400蓝光 250超清 150高清）
+func GetLiveStreamURLs(roomID int64, qn int, cookies string) ([]LiveStreamURL, error) {
+	var resp struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Data    struct {
+			LiveStatus  int `json:"live_status"`
+			PlayurlInfo *struct {
+				Playurl struct {
+					Stream []struct {
+						ProtocolName string `json:"protocol_name"`
+						Format       []struct {
+							FormatName string `json:"format_name"`
+							Codec      []struct {
+								CodecName string `json:"codec_name"`
+								CurrentQn int    `json:"current_qn"`
+								BaseURL   string `json:"base_url"`
+								URLInfo   []struct {
+									Host  string `json:"host"`
+									Extra string `json:"extra"`
+								} `json:"url_info"`
+							} `json:"codec"`
+						} `json:"format"`
+					} `json:"stream"`
+				} `json:"playurl"`
+			} `json:"playurl_info"`
+		} `json:"data"`
+	}
+
+	r := req.C().SetTimeout(10*time.Second).R().
+		SetHeader("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36").
+		SetHeader("Referer", "https://live.bilibili.com/")
+	if cookies != "" {
+		r.SetHeader("Cookie", cookies)
+	}
+	res, err := r.Get(fmt.Sprintf("https://api.live.bilibili.com/xlive/web-room/v2/index/getRoomPlayInfo?room_id=%d&protocol=0,1&format=0,1,2&codec=0,1&qn=%d&platform=web&ptype=8", roomID, qn))
+	if err != nil {
+		return nil, fmt.Errorf("请求直播流地址失败: %w", err)
+	}
+	if err := json.Unmarshal(res.Bytes(), &resp); err != nil {
+		return nil, fmt.Errorf("解析直播流地址失败: %w", err)
+	}
+	if resp.Code != 0 {
+		return nil, fmt.Errorf("获取直播流地址失败: code=%d, %s", resp.Code, resp.Message)
+	}
+	if resp.Data.LiveStatus != 1 || resp.Data.PlayurlInfo == nil {
+		return nil, fmt.Errorf("直播间未开播")
+	}
+
+	var urls []LiveStreamURL
+	for _, stream := range resp.Data.PlayurlInfo.Playurl.Stream {
+		for _, format := range stream.Format {
+			for _, codec := range format.Codec {
+				for _, info := range codec.URLInfo {
+					urls = append(urls, LiveStreamURL{
+						Protocol: stream.ProtocolName,
+						Format:   format.FormatName,
+						Codec:    codec.CodecName,
+						Qn:       codec.CurrentQn,
+						URL:      info.Host + codec.BaseURL + info.Extra,
+					})
+				}
+			}
+		}
+	}
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("没有可用的直播流地址")
+	}
+	return urls, nil
+}
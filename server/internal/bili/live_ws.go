@@ -0,0 +1,426 @@
+package bili
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/gorilla/websocket"
+	"github.com/imroc/req/v3"
+)
+
+// 直播信息流数据包头长度
+const LivePacketHeaderLen = 16
+
+// 数据包协议版本
+const (
+	LiveProtoJSON   = 0 // 普通JSON
+	LiveProtoInt    = 1 // 心跳回复（人气值）、认证包
+	LiveProtoZlib   = 2 // zlib压缩的数据包集合
+	LiveProtoBrotli = 3 // brotli压缩的数据包集合
+)
+
+// 数据包操作码
+const (
+	LiveOpHeartbeat      = 2 // 心跳
+	LiveOpHeartbeatReply = 3 // 心跳回复，正文为4字节人气值
+	LiveOpCommand        = 5 // 通知消息（DANMU_MSG、LIVE、PREPARING等）
+	LiveOpAuth           = 7 // 认证
+	LiveOpAuthReply      = 8 // 认证回复
+)
+
+// DefaultLiveWSURL 获取弹幕服务器失败时使用的默认地址
+const DefaultLiveWSURL = "wss://broadcastlv.chat.bilibili.com:443/sub"
+
+// LivePacket 直播信息流数据包
+type LivePacket struct {
+	ProtoVer uint16
+	Op       uint32
+	Seq      uint32
+	Body     []byte
+}
+
+// EncodeLivePacket 编码数据包：包长(4) 头长(2) 协议版本(2) 操作码(4) 序号(4)，大端序
+func EncodeLivePacket(op uint32, protoVer uint16, body []byte) []byte {
+	buf := make([]byte, LivePacketHeaderLen+len(body))
+	binary.BigEndian.PutUint32(buf[0:4], uint32(len(buf)))
+	binary.BigEndian.PutUint16(buf[4:6], LivePacketHeaderLen)
+	binary.BigEndian.PutUint16(buf[6:8], protoVer)
+	binary.BigEndian.PutUint32(buf[8:12], op)
+	binary.BigEndian.PutUint32(buf[12:16], 1)
+	copy(buf[LivePacketHeaderLen:], body)
+	return buf
+}
+
+// DecodeLivePackets 解码一条websocket消息中的全部数据包，压缩包会解压后递归展开
+func DecodeLivePackets(data []byte) ([]LivePacket, error) {
+	var packets []LivePacket
+	for len(data) > 0 {
+		if len(data) < LivePacketHeaderLen {
+			return packets, fmt.Errorf("数据包长度不足: %d", len(data))
+		}
+		packetLen := int(binary.BigEndian.Uint32(data[0:4]))
+		headerLen := int(binary.BigEndian.Uint16(data[4:6]))
+		if packetLen < headerLen || headerLen < LivePacketHeaderLen || packetLen > len(data) {
+			return packets, fmt.Errorf("数据包头无效: 包长=%d, 头长=%d, 剩余=%d", packetLen, headerLen, len(data))
+		}
+
+		packet := LivePacket{
+			ProtoVer: binary.BigEndian.Uint16(data[6:8]),
+			Op:       binary.BigEndian.Uint32(data[8:12]),
+			Seq:      binary.BigEndian.Uint32(data[12:16]),
+			Body:     data[headerLen:packetLen],
+		}
+		data = data[packetLen:]
+
+		if packet.Op == LiveOpCommand && (packet.ProtoVer == LiveProtoZlib || packet.ProtoVer == LiveProtoBrotli) {
+			inner, err := decompressLiveBody(packet.ProtoVer, packet.Body)
+			if err != nil {
+				return packets, err
+			}
+			nested, err := DecodeLivePackets(inner)
+			packets = append(packets, nested...)
+			if err != nil {
+				return packets, err
+			}
+			continue
+		}
+		packets = append(packets, packet)
+	}
+	return packets, nil
+}
+
+// decompressLiveBody 解压数据包正文
+func decompressLiveBody(protoVer uint16, body []byte) ([]byte, error) {
+	var reader io.Reader
+	switch protoVer {
+	case LiveProtoZlib:
+		zr, err := zlib.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("zlib解压失败: %w", err)
+		}
+		defer zr.Close()
+		reader = zr
+	case LiveProtoBrotli:
+		reader = brotli.NewReader(bytes.NewReader(body))
+	default:
+		return body, nil
+	}
+
+	out, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("数据包解压失败(protover=%d): %w", protoVer, err)
+	}
+	return out, nil
+}
+
+// LiveCommandName 提取通知消息的命令名，部分命令带有 ":参数" 后缀（如 DANMU_MSG:4:0:2:2:2:0）
+func LiveCommandName(body []byte) (string, error) {
+	var msg struct {
+		Cmd string `json:"cmd"`
+	}
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return "", err
+	}
+	if idx := strings.Index(msg.Cmd, ":"); idx >= 0 {
+		return msg.Cmd[:idx], nil
+	}
+	return msg.Cmd, nil
+}
+
+// LiveDanmuInfo 弹幕服务器信息
+type LiveDanmuInfo struct {
+	Token string
+	URLs  []string
+}
+
+// GetLiveDanmuInfo 获取直播间的弹幕服务器地址和认证token
+func GetLiveDanmuInfo(roomID int64, cookies string) (*LiveDanmuInfo, error) {
+	var resp struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Data    struct {
+			Token    string `json:"token"`
+			HostList []struct {
+				Host    string `json:"host"`
+				WssPort int    `json:"wss_port"`
+			} `json:"host_list"`
+		} `json:"data"`
+	}
+
+	r := req.C().SetTimeout(10*time.Second).R().
+		SetHeader("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36").
+		SetHeader("Referer", "https://live.bilibili.com/")
+	if cookies != "" {
+		r.SetHeader("Cookie", cookies)
+	}
+	res, err := r.Get(fmt.Sprintf("https://api.live.bilibili.com/xlive/web-room/v1/index/getDanmuInfo?id=%d&type=0", roomID))
+	if err != nil {
+		return nil, fmt.Errorf("请求弹幕服务器信息失败: %w", err)
+	}
+	if err := json.Unmarshal(res.Bytes(), &resp); err != nil {
+		return nil, fmt.Errorf("解析弹幕服务器信息失败: %w", err)
+	}
+	if resp.Code != 0 {
+		return nil, fmt.Errorf("获取弹幕服务器信息失败: code=%d, %s", resp.Code, resp.Message)
+	}
+
+	info := &LiveDanmuInfo{Token: resp.Data.Token}
+	for _, host := range resp.Data.HostList {
+		if host.Host == "" || host.WssPort == 0 {
+			continue
+		}
+		info.URLs = append(info.URLs, fmt.Sprintf("wss://%s:%d/sub", host.Host, host.WssPort))
+	}
+	return info, nil
+}
+
+// LiveWSClient 直播信息流websocket客户端，断线后自动重连
+type LiveWSClient struct {
+	RoomID int64 // 真实房间号（非短号）
+	UID    int64 // 登录用户UID，0为游客
+	Buvid  string
+	Cookie string
+
+	// URLs 非空时直接使用这些地址和Token，不再请求弹幕服务器信息（用于自建/测试服务器）
+	URLs  []string
+	Token string
+
+	HeartbeatInterval time.Duration
+	ReconnectDelay    time.Duration // 重连初始间隔，失败时翻倍
+	MaxReconnectDelay time.Duration
+
+	// OnCommand 收到通知消息时回调，cmd已去除 ":参数" 后缀
+	OnCommand func(cmd string, body []byte)
+	// OnPopularity 收到心跳回复时回调人气值
+	OnPopularity func(popularity uint32)
+	// OnConnected 认证成功后回调，可用于重连后补查状态
+	OnConnected func()
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewLiveWSClient 创建直播信息流客户端
+func NewLiveWSClient(roomID int64) *LiveWSClient {
+	return &LiveWSClient{
+		RoomID:            roomID,
+		HeartbeatInterval: 30 * time.Second,
+		ReconnectDelay:    3 * time.Second,
+		MaxReconnectDelay: 2 * time.Minute,
+	}
+}
+
+// Start 在后台连接并保持订阅，重复调用无效
+func (c *LiveWSClient) Start() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cancel != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+	c.done = make(chan struct{})
+	go func() {
+		defer close(c.done)
+		c.run(ctx)
+	}()
+}
+
+// Stop 断开连接并等待后台协程退出
+func (c *LiveWSClient) Stop() {
+	c.mu.Lock()
+	cancel, done := c.cancel, c.done
+	c.cancel = nil
+	c.mu.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
+
+// run 连接循环：连接断开后按退避间隔重连，连接稳定超过1分钟后重置间隔
+func (c *LiveWSClient) run(ctx context.Context) {
+	delay := c.ReconnectDelay
+	for {
+		started := time.Now()
+		err := c.connect(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(started) > time.Minute {
+			delay = c.ReconnectDelay
+		}
+		log.Printf("[直播信息流] 房间 %d 连接断开，%v 后重连: %v", c.RoomID, delay, err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay *= 2
+		if delay > c.MaxReconnectDelay {
+			delay = c.MaxReconnectDelay
+		}
+	}
+}
+
+// endpoints 获取本次连接使用的服务器地址和token
+func (c *LiveWSClient) endpoints() ([]string, string) {
+	if len(c.URLs) > 0 {
+		return c.URLs, c.Token
+	}
+	info, err := GetLiveDanmuInfo(c.RoomID, c.Cookie)
+	if err != nil || len(info.URLs) == 0 {
+		if err != nil {
+			log.Printf("[直播信息流] 房间 %d 获取弹幕服务器失败，使用默认地址: %v", c.RoomID, err)
+		}
+		token := ""
+		if info != nil {
+			token = info.Token
+		}
+		return []string{DefaultLiveWSURL}, token
+	}
+	return info.URLs, info.Token
+}
+
+// connect 建立一次连接，直到连接出错或ctx取消才返回
+func (c *LiveWSClient) connect(ctx context.Context) error {
+	urls, token := c.endpoints()
+
+	header := http.Header{}
+	header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36")
+	header.Set("Origin", "https://live.bilibili.com")
+	if c.Cookie != "" {
+		header.Set("Cookie", c.Cookie)
+	}
+
+	dialer := websocket.Dialer{HandshakeTimeout: 10 * time.Second}
+	var conn *websocket.Conn
+	var err error
+	for _, u := range urls {
+		conn, _, err = dialer.DialContext(ctx, u, header)
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("连接弹幕服务器失败: %w", err)
+	}
+	defer conn.Close()
+
+	// ctx取消时关闭连接以中断阻塞的读取
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	var writeMu sync.Mutex
+	write := func(op uint32, body []byte) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		return conn.WriteMessage(websocket.BinaryMessage, EncodeLivePacket(op, LiveProtoInt, body))
+	}
+
+	auth, _ := json.Marshal(map[string]interface{}{
+		"uid":      c.UID,
+		"roomid":   c.RoomID,
+		"protover": LiveProtoBrotli,
+		"buvid":    c.Buvid,
+		"platform": "web",
+		"type":     2,
+		"key":      token,
+	})
+	if err := write(LiveOpAuth, auth); err != nil {
+		return fmt.Errorf("发送认证包失败: %w", err)
+	}
+
+	// 认证回复必须在超时前到达
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	authed := false
+
+	heartbeatDone := make(chan struct{})
+	defer close(heartbeatDone)
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return fmt.Errorf("读取消息失败: %w", err)
+		}
+		// 超过3个心跳周期没有任何消息视为连接失效
+		conn.SetReadDeadline(time.Now().Add(3 * c.HeartbeatInterval))
+
+		packets, err := DecodeLivePackets(data)
+		if err != nil {
+			log.Printf("[直播信息流] 房间 %d 数据包解码失败: %v", c.RoomID, err)
+		}
+
+		for _, packet := range packets {
+			switch packet.Op {
+			case LiveOpAuthReply:
+				var reply struct {
+					Code int `json:"code"`
+				}
+				if err := json.Unmarshal(packet.Body, &reply); err != nil || reply.Code != 0 {
+					return fmt.Errorf("认证失败: %s", string(packet.Body))
+				}
+				if authed {
+					continue
+				}
+				authed = true
+				if err := write(LiveOpHeartbeat, nil); err != nil {
+					return fmt.Errorf("发送心跳失败: %w", err)
+				}
+				go c.heartbeat(write, heartbeatDone)
+				if c.OnConnected != nil {
+					c.OnConnected()
+				}
+			case LiveOpHeartbeatReply:
+				if c.OnPopularity != nil && len(packet.Body) >= 4 {
+					c.OnPopularity(binary.BigEndian.Uint32(packet.Body[:4]))
+				}
+			case LiveOpCommand:
+				if c.OnCommand == nil {
+					continue
+				}
+				cmd, err := LiveCommandName(packet.Body)
+				if err != nil {
+					continue
+				}
+				c.OnCommand(cmd, packet.Body)
+			}
+		}
+
+		if !authed {
+			return errors.New("未收到认证回复")
+		}
+	}
+}
+
+// heartbeat 定时发送心跳包
+func (c *LiveWSClient) heartbeat(write func(uint32, []byte) error, done <-chan struct{}) {
+	ticker := time.NewTicker(c.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := write(LiveOpHeartbeat, nil); err != nil {
+				return
+			}
+		}
+	}
+}
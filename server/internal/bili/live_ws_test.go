@@ -0,0 +1,280 @@
+package bili
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/gorilla/websocket"
+)
+
+func zlibCompress(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	return buf.Bytes()
+}
+
+func brotliCompress(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := brotli.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	return buf.Bytes()
+}
+
+func TestLivePacketRoundTrip(t *testing.T) {
+	body := []byte(`{"cmd":"LIVE"}`)
+	data := append(EncodeLivePacket(LiveOpCommand, LiveProtoJSON, body), EncodeLivePacket(LiveOpHeartbeatReply, LiveProtoInt, []byte{0, 0, 0, 42})...)
+
+	packets, err := DecodeLivePackets(data)
+	if err != nil {
+		t.Fatalf("解码失败: %v", err)
+	}
+	if len(packets) != 2 {
+		t.Fatalf("数据包数量=%d, 期望2", len(packets))
+	}
+	if packets[0].Op != LiveOpCommand || packets[0].ProtoVer != LiveProtoJSON || string(packets[0].Body) != string(body) {
+		t.Errorf("第一个数据包不一致: %+v", packets[0])
+	}
+	if packets[1].Op != LiveOpHeartbeatReply || binary.BigEndian.Uint32(packets[1].Body) != 42 {
+		t.Errorf("第二个数据包不一致: %+v", packets[1])
+	}
+}
+
+func TestDecodeCompressedLivePackets(t *testing.T) {
+	inner := append(
+		EncodeLivePacket(LiveOpCommand, LiveProtoJSON, []byte(`{"cmd":"DANMU_MSG:4:0:2:2:2:0"}`)),
+		EncodeLivePacket(LiveOpCommand, LiveProtoJSON, []byte(`{"cmd":"SEND_GIFT"}`))...,
+	)
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"zlib", EncodeLivePacket(LiveOpCommand, LiveProtoZlib, zlibCompress(t, inner))},
+		{"brotli", EncodeLivePacket(LiveOpCommand, LiveProtoBrotli, brotliCompress(t, inner))},
+		{"nested", EncodeLivePacket(LiveOpCommand, LiveProtoBrotli, brotliCompress(t,
+			EncodeLivePacket(LiveOpCommand, LiveProtoZlib, zlibCompress(t, inner))))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			packets, err := DecodeLivePackets(tt.data)
+			if err != nil {
+				t.Fatalf("解码失败: %v", err)
+			}
+			if len(packets) != 2 {
+				t.Fatalf("数据包数量=%d, 期望2", len(packets))
+			}
+			var cmds []string
+			for _, p := range packets {
+				if p.ProtoVer != LiveProtoJSON {
+					t.Errorf("解压后协议版本=%d, 期望%d", p.ProtoVer, LiveProtoJSON)
+				}
+				cmd, err := LiveCommandName(p.Body)
+				if err != nil {
+					t.Fatalf("解析命令失败: %v", err)
+				}
+				cmds = append(cmds, cmd)
+			}
+			if strings.Join(cmds, ",") != "DANMU_MSG,SEND_GIFT" {
+				t.Errorf("命令=%v", cmds)
+			}
+		})
+	}
+}
+
+func TestDecodeLivePacketsInvalid(t *testing.T) {
+	data := EncodeLivePacket(LiveOpCommand, LiveProtoJSON, []byte(`{"cmd":"LIVE"}`))
+	if _, err := DecodeLivePackets(data[:len(data)-1]); err == nil {
+		t.Error("截断的数据包应返回错误")
+	}
+}
+
+// fakeLiveServer 模拟弹幕服务器：校验认证包和心跳，首个连接推送消息后主动断开
+type fakeLiveServer struct {
+	t     *testing.T
+	token string
+
+	mu          sync.Mutex
+	connections int
+	auths       []map[string]interface{}
+	heartbeats  int
+}
+
+func (s *fakeLiveServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// 客户端使用直播站的Origin，测试服务器不校验
+	upgrader := websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	s.mu.Lock()
+	s.connections++
+	index := s.connections
+	s.mu.Unlock()
+
+	readPacket := func() (LivePacket, bool) {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return LivePacket{}, false
+		}
+		packets, err := DecodeLivePackets(data)
+		if err != nil || len(packets) != 1 {
+			s.t.Errorf("客户端数据包无效: %v", err)
+			return LivePacket{}, false
+		}
+		return packets[0], true
+	}
+	send := func(data []byte) {
+		conn.WriteMessage(websocket.BinaryMessage, data)
+	}
+
+	// 第一个数据包必须是认证包
+	packet, ok := readPacket()
+	if !ok {
+		return
+	}
+	if packet.Op != LiveOpAuth {
+		s.t.Errorf("首个数据包操作码=%d, 期望认证包", packet.Op)
+		return
+	}
+	var auth map[string]interface{}
+	if err := json.Unmarshal(packet.Body, &auth); err != nil {
+		s.t.Errorf("认证包不是JSON: %v", err)
+		return
+	}
+	s.mu.Lock()
+	s.auths = append(s.auths, auth)
+	s.mu.Unlock()
+	send(EncodeLivePacket(LiveOpAuthReply, LiveProtoInt, []byte(`{"code":0}`)))
+
+	// 认证成功后客户端立即发送心跳
+	packet, ok = readPacket()
+	if !ok {
+		return
+	}
+	if packet.Op != LiveOpHeartbeat {
+		s.t.Errorf("认证后数据包操作码=%d, 期望心跳", packet.Op)
+		return
+	}
+	s.mu.Lock()
+	s.heartbeats++
+	s.mu.Unlock()
+	send(EncodeLivePacket(LiveOpHeartbeatReply, LiveProtoInt, []byte{0, 0, 0x30, 0x39}))
+
+	if index == 1 {
+		commands := append(
+			EncodeLivePacket(LiveOpCommand, LiveProtoJSON, []byte(`{"cmd":"DANMU_MSG:4:0:2:2:2:0","info":[]}`)),
+			EncodeLivePacket(LiveOpCommand, LiveProtoJSON, []byte(`{"cmd":"LIVE"}`))...,
+		)
+		send(EncodeLivePacket(LiveOpCommand, LiveProtoBrotli, brotliCompress(s.t, commands)))
+		// 服务器主动断开，客户端应重连
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+		return
+	}
+
+	send(EncodeLivePacket(LiveOpCommand, LiveProtoJSON, []byte(`{"cmd":"PREPARING"}`)))
+	for {
+		if _, ok := readPacket(); !ok {
+			return
+		}
+	}
+}
+
+func TestLiveWSClientReconnect(t *testing.T) {
+	server := &fakeLiveServer{t: t, token: "test-token"}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	client := NewLiveWSClient(12345)
+	client.UID = 678
+	client.URLs = []string{"ws" + strings.TrimPrefix(ts.URL, "http")}
+	client.Token = server.token
+	client.HeartbeatInterval = time.Second
+	client.ReconnectDelay = 10 * time.Millisecond
+
+	var mu sync.Mutex
+	var cmds []string
+	var bodies [][]byte
+	var popularity []uint32
+	connected := 0
+	done := make(chan struct{})
+	client.OnCommand = func(cmd string, body []byte) {
+		mu.Lock()
+		defer mu.Unlock()
+		cmds = append(cmds, cmd)
+		bodies = append(bodies, body)
+		if cmd == "PREPARING" {
+			close(done)
+		}
+	}
+	client.OnPopularity = func(p uint32) {
+		mu.Lock()
+		popularity = append(popularity, p)
+		mu.Unlock()
+	}
+	client.OnConnected = func() {
+		mu.Lock()
+		connected++
+		mu.Unlock()
+	}
+
+	client.Start()
+	defer client.Stop()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("超时未收到重连后的消息")
+	}
+	client.Stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	if server.connections != 2 {
+		t.Errorf("连接次数=%d, 期望2", server.connections)
+	}
+	if connected != 2 {
+		t.Errorf("OnConnected调用次数=%d, 期望2", connected)
+	}
+	if server.heartbeats != 2 {
+		t.Errorf("心跳次数=%d, 期望2", server.heartbeats)
+	}
+	for _, auth := range server.auths {
+		if auth["key"] != "test-token" || auth["roomid"] != float64(12345) || auth["uid"] != float64(678) {
+			t.Errorf("认证包内容不正确: %v", auth)
+		}
+		if auth["protover"] != float64(LiveProtoBrotli) {
+			t.Errorf("认证包协议版本=%v, 期望%d", auth["protover"], LiveProtoBrotli)
+		}
+	}
+	if strings.Join(cmds, ",") != "DANMU_MSG,LIVE,PREPARING" {
+		t.Errorf("收到的命令=%v", cmds)
+	}
+	if len(bodies) > 0 && !strings.Contains(string(bodies[0]), `"info":[]`) {
+		t.Errorf("命令正文不完整: %s", bodies[0])
+	}
+	if len(popularity) != 2 || popularity[0] != 12345 {
+		t.Errorf("人气值=%v, 期望两次12345", popularity)
+	}
+}
@@ -117,6 +117,24 @@ func (p *ProxyInfo) String() string {
 	return p.URL
 }
 
+// AvailableProxies 按轮询顺序返回当前可用代理的快照（本地IP总是包含在内），不移动轮询位置
+func (p *ProxyPool) AvailableProxies() []*ProxyInfo {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	result := make([]*ProxyInfo, 0, len(p.proxies))
+	for i := range p.proxies {
+		proxy := p.proxies[(p.current+i)%len(p.proxies)]
+		proxy.mu.Lock()
+		available := proxy.Available
+		proxy.mu.Unlock()
+		if proxy.IsLocal() || available {
+			result = append(result, proxy)
+		}
+	}
+	return result
+}
+
 // GetProxyCount 获取代理数量（包含本地IP）
 func (p *ProxyPool) GetProxyCount() int {
 	p.mu.Lock()
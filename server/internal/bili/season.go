@@ -0,0 +1,171 @@
+package bili
+
+import (
+	"fmt"
+	"time"
+)
+
+// SeasonSection 合集小节
+type SeasonSection struct {
+	ID       int64  `json:"id"`
+	Type     int    `json:"type"`
+	SeasonID int64  `json:"seasonId"`
+	Title    string `json:"title"`
+	Order    int    `json:"order"`
+	EpCount  int    `json:"epCount"`
+}
+
+// SeasonEpisode 合集小节中的视频
+type SeasonEpisode struct {
+	ID        int64  `json:"id"`
+	Title     string `json:"title"`
+	Aid       int64  `json:"aid"`
+	Bvid      string `json:"bvid"`
+	CID       int64  `json:"cid"`
+	SeasonID  int64  `json:"seasonId"`
+	SectionID int64  `json:"sectionId"`
+	Order     int    `json:"order"`
+}
+
+// SectionEpisodeSort 小节内视频排序项
+type SectionEpisodeSort struct {
+	ID   int64 `json:"id"`
+	Sort int   `json:"sort"`
+}
+
+// GetSeasonSections 获取合集下的小节列表
+func (c *BiliClient) GetSeasonSections(seasonID int64) ([]SeasonSection, error) {
+	apiURL := fmt.Sprintf("https://member.bilibili.com/x2/creative/web/season?id=%d", seasonID)
+
+	var result struct {
+		Code int    `json:"code"`
+		Msg  string `json:"message"`
+		Data struct {
+			Sections struct {
+				Sections []SeasonSection `json:"sections"`
+			} `json:"sections"`
+		} `json:"data"`
+	}
+
+	_, err := c.ReqClient.R().
+		SetHeader("Referer", "https://member.bilibili.com/platform/upload-manager/ep").
+		SetSuccessResult(&result).
+		Get(apiURL)
+	if err != nil {
+		return nil, fmt.Errorf("获取合集小节失败: %w", err)
+	}
+
+	if result.Code != 0 {
+		return nil, fmt.Errorf("获取合集小节失败: %s (code=%d)", result.Msg, result.Code)
+	}
+
+	return result.Data.Sections.Sections, nil
+}
+
+// GetSectionEpisodes 获取小节详情及其中的视频
+func (c *BiliClient) GetSectionEpisodes(sectionID int64) (*SeasonSection, []SeasonEpisode, error) {
+	apiURL := fmt.Sprintf("https://member.bilibili.com/x2/creative/web/season/section?id=%d", sectionID)
+
+	var result struct {
+		Code int    `json:"code"`
+		Msg  string `json:"message"`
+		Data struct {
+			Section  SeasonSection   `json:"section"`
+			Episodes []SeasonEpisode `json:"episodes"`
+		} `json:"data"`
+	}
+
+	_, err := c.ReqClient.R().
+		SetHeader("Referer", "https://member.bilibili.com/platform/upload-manager/ep").
+		SetSuccessResult(&result).
+		Get(apiURL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("获取小节视频失败: %w", err)
+	}
+
+	if result.Code != 0 {
+		return nil, nil, fmt.Errorf("获取小节视频失败: %s (code=%d)", result.Msg, result.Code)
+	}
+
+	return &result.Data.Section, result.Data.Episodes, nil
+}
+
+// CreateSeasonSection 在合集下新建小节，返回小节ID
+func (c *BiliClient) CreateSeasonSection(seasonID int64, title string) (int64, error) {
+	csrf := GetCookieValue(c.Cookies, "bili_jct")
+	if csrf == "" {
+		return 0, fmt.Errorf("未找到CSRF token")
+	}
+
+	requestBody := map[string]interface{}{
+		"seasonId": seasonID,
+		"title":    title,
+	}
+
+	var result struct {
+		Code int    `json:"code"`
+		Msg  string `json:"message"`
+		Data int64  `json:"data"`
+	}
+
+	apiURL := fmt.Sprintf("https://member.bilibili.com/x2/creative/web/season/section/add?t=%d&csrf=%s",
+		time.Now().UnixMilli(), csrf)
+
+	_, err := c.ReqClient.R().
+		SetHeader("Content-Type", "application/json").
+		SetHeader("Referer", "https://member.bilibili.com/platform/upload-manager/ep").
+		SetBodyJsonMarshal(requestBody).
+		SetSuccessResult(&result).
+		Post(apiURL)
+	if err != nil {
+		return 0, fmt.Errorf("创建合集小节失败: %w", err)
+	}
+
+	if result.Code != 0 {
+		return 0, fmt.Errorf("创建合集小节失败: %s (code=%d)", result.Msg, result.Code)
+	}
+
+	return result.Data, nil
+}
+
+// SortSectionEpisodes 调整小节内视频顺序（sorts中的sort从1开始）
+func (c *BiliClient) SortSectionEpisodes(section SeasonSection, sorts []SectionEpisodeSort) error {
+	csrf := GetCookieValue(c.Cookies, "bili_jct")
+	if csrf == "" {
+		return fmt.Errorf("未找到CSRF token")
+	}
+
+	requestBody := map[string]interface{}{
+		"section": map[string]interface{}{
+			"id":       section.ID,
+			"type":     section.Type,
+			"seasonId": section.SeasonID,
+			"title":    section.Title,
+		},
+		"sorts": sorts,
+	}
+
+	var result struct {
+		Code int    `json:"code"`
+		Msg  string `json:"message"`
+	}
+
+	apiURL := fmt.Sprintf("https://member.bilibili.com/x2/creative/web/season/section/edit?t=%d&csrf=%s",
+		time.Now().UnixMilli(), csrf)
+
+	_, err := c.ReqClient.R().
+		SetHeader("Content-Type", "application/json").
+		SetHeader("Referer", "https://member.bilibili.com/platform/upload-manager/ep").
+		SetBodyJsonMarshal(requestBody).
+		SetSuccessResult(&result).
+		Post(apiURL)
+	if err != nil {
+		return fmt.Errorf("合集小节排序失败: %w", err)
+	}
+
+	if result.Code != 0 {
+		return fmt.Errorf("合集小节排序失败: %s (code=%d)", result.Msg, result.Code)
+	}
+
+	return nil
+}
@@ -124,20 +124,35 @@ func (c *BiliClient) GetVideoInfoByAid(aid int64) (*VideoInfo, error) {
 
 // VideoPartInfo 分P详细信息
 type VideoPartInfo struct {
-	State  int `json:"state"`
+	State   int `json:"state"`
+	Archive struct {
+		Aid          int64  `json:"aid"`
+		Bvid         string `json:"bvid"`
+		Title        string `json:"title"`
+		Desc         string `json:"desc"`
+		Tag          string `json:"tag"`
+		Tid          int    `json:"tid"`
+		Copyright    int    `json:"copyright"`
+		Cover        string `json:"cover"`
+		Source       string `json:"source"`
+		State        int    `json:"state"`
+		StateDesc    string `json:"state_desc"`
+		RejectReason string `json:"reject_reason"` // 审核退回原因，可能包含违规时间段
+	} `json:"archive"`
 	Videos []struct {
-		Aid        int64  `json:"aid"`
-		Bvid       string `json:"bvid"`
-		Title      string `json:"title"`
-		Filename   string `json:"filename"`
-		CID        int64  `json:"cid"`
-		Ctime      int64  `json:"ctime"`
-		FailCode   int    `json:"failCode"`
-		XcodeState int    `json:"xcodeState"` // 转码状态
-		FailDesc   string `json:"failDesc"`
-		Page       int    `json:"page"`
-		Part       string `json:"part"`
-		Duration   int    `json:"duration"`
+		Aid          int64  `json:"aid"`
+		Bvid         string `json:"bvid"`
+		Title        string `json:"title"`
+		Filename     string `json:"filename"`
+		CID          int64  `json:"cid"`
+		Ctime        int64  `json:"ctime"`
+		FailCode     int    `json:"failCode"`
+		XcodeState   int    `json:"xcodeState"` // 转码状态
+		FailDesc     string `json:"failDesc"`
+		RejectReason string `json:"reject_reason"` // 分P退回原因
+		Page         int    `json:"page"`
+		Part         string `json:"part"`
+		Duration     int    `json:"duration"`
 	} `json:"videos"`
 }
 
@@ -9,6 +9,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/gobup/server/internal/database"
 	"github.com/gobup/server/internal/models"
+	"github.com/gobup/server/internal/services"
 )
 
 type ExportConfigParams struct {
@@ -249,6 +250,10 @@ func UpdateSystemConfig(c *gin.Context) {
 	config.OrphanScanInterval = req.OrphanScanInterval
 	config.EnableDanmakuProxy = req.EnableDanmakuProxy
 	config.DanmakuProxyList = req.DanmakuProxyList
+	config.DanmakuDailyQuota = req.DanmakuDailyQuota
+	config.EnableLiveMonitor = req.EnableLiveMonitor
+	config.LiveStatusWorkers = req.LiveStatusWorkers
+	config.LiveStatusJitter = req.LiveStatusJitter
 
 	// 参数验证
 	if config.FileScanInterval < 10 {
@@ -257,12 +262,25 @@ func UpdateSystemConfig(c *gin.Context) {
 	if config.FileScanMinAge < 1 {
 		config.FileScanMinAge = 1
 	}
+	if config.DanmakuDailyQuota < 0 {
+		config.DanmakuDailyQuota = 0
+	}
+	if config.LiveStatusWorkers < 1 {
+		config.LiveStatusWorkers = 1
+	} else if config.LiveStatusWorkers > 10 {
+		config.LiveStatusWorkers = 10
+	}
+	if config.LiveStatusJitter < 0 {
+		config.LiveStatusJitter = 0
+	}
 
 	if err := db.Save(&config).Error; err != nil {
 		c.JSON(http.StatusOK, gin.H{"type": "error", "msg": "保存失败"})
 		return
 	}
 
+	go services.GetLiveMonitorService().SyncRooms()
+
 	c.JSON(http.StatusOK, gin.H{"type": "success", "msg": "配置更新成功", "data": config})
 }
 
@@ -293,6 +311,8 @@ func ToggleSystemConfig(c *gin.Context) {
 		config.EnableOrphanScan = req.Value
 	case "enableDanmakuProxy":
 		config.EnableDanmakuProxy = req.Value
+	case "enableLiveMonitor":
+		config.EnableLiveMonitor = req.Value
 	default:
 		c.JSON(http.StatusBadRequest, gin.H{"type": "error", "msg": "未知的配置项"})
 		return
@@ -303,6 +323,11 @@ func ToggleSystemConfig(c *gin.Context) {
 		return
 	}
 
+	// 直播信息流开关立即生效
+	if req.Key == "enableLiveMonitor" {
+		go services.GetLiveMonitorService().SyncRooms()
+	}
+
 	c.JSON(http.StatusOK, gin.H{"type": "success", "msg": "配置已更新", "data": config})
 }
 
@@ -0,0 +1,88 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gobup/server/internal/services"
+)
+
+// ListHighlightClips 获取历史记录的高能剪辑列表
+func ListHighlightClips(c *gin.Context) {
+	historyID, err := strconv.ParseUint(c.Param("historyId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"type": "error", "msg": "无效的历史记录ID"})
+		return
+	}
+
+	clips, err := services.NewHighEnergyCutService().ListClips(uint(historyID))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"type": "error", "msg": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"list":  clips,
+		"total": len(clips),
+	})
+}
+
+// GetHighlightScores 获取各时间窗口的高能得分及构成，selected=true时只返回入选窗口
+func GetHighlightScores(c *gin.Context) {
+	historyID, err := strconv.ParseUint(c.Param("historyId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"type": "error", "msg": "无效的历史记录ID"})
+		return
+	}
+
+	result, err := services.NewHighEnergyCutService().ScoreHistory(uint(historyID))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"type": "error", "msg": err.Error()})
+		return
+	}
+
+	if c.Query("selected") == "true" {
+		selected := make([]services.HighlightWindowScore, 0)
+		for _, w := range result.Windows {
+			if w.Selected {
+				selected = append(selected, w)
+			}
+		}
+		result.Windows = selected
+	}
+
+	c.JSON(http.StatusOK, gin.H{"type": "success", "data": result})
+}
+
+// RegenerateHighlightClips 删除已有剪辑并重新生成高能剪辑
+func RegenerateHighlightClips(c *gin.Context) {
+	historyID, err := strconv.ParseUint(c.Param("historyId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"type": "error", "msg": "无效的历史记录ID"})
+		return
+	}
+
+	if err := services.NewHighEnergyCutService().Regenerate(uint(historyID)); err != nil {
+		c.JSON(http.StatusOK, gin.H{"type": "error", "msg": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"type": "success", "msg": "已加入高能剪辑队列"})
+}
+
+// DeleteHighlightClip 删除高能剪辑及其文件
+func DeleteHighlightClip(c *gin.Context) {
+	clipID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"type": "error", "msg": "无效的剪辑ID"})
+		return
+	}
+
+	if err := services.NewHighEnergyCutService().DeleteClip(uint(clipID)); err != nil {
+		c.JSON(http.StatusOK, gin.H{"type": "error", "msg": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"type": "success", "msg": "删除成功"})
+}
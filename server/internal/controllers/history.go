@@ -12,6 +12,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/gobup/server/internal/database"
 	"github.com/gobup/server/internal/models"
+	"github.com/gobup/server/internal/services"
 	"github.com/gobup/server/internal/upload"
 )
 
@@ -79,12 +80,42 @@ func ListHistories(c *gin.Context) {
 		}
 	}
 
+	// 统计每场直播的收益
+	sessionIDs := make([]string, 0, len(histories))
+	for _, h := range histories {
+		sessionIDs = append(sessionIDs, h.SessionID)
+	}
+	revenues := services.NewRevenueService().GetRevenueBySessions(sessionIDs)
+	for i := range histories {
+		histories[i].Revenue = revenues[histories[i].SessionID]
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"list":  histories,
 		"total": total,
 	})
 }
 
+// GetHistoryRevenue 获取单场直播的收益统计和贡献排行
+func GetHistoryRevenue(c *gin.Context) {
+	historyID, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+	db := database.GetDB()
+
+	var history models.RecordHistory
+	if err := db.First(&history, historyID).Error; err != nil {
+		c.JSON(http.StatusOK, gin.H{"type": "error", "msg": "历史记录不存在"})
+		return
+	}
+
+	stats, err := services.NewRevenueService().GetSessionRevenue(history.SessionID)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"type": "error", "msg": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"type": "success", "data": stats})
+}
+
 func UpdateHistory(c *gin.Context) {
 	var history models.RecordHistory
 	if err := c.ShouldBindJSON(&history); err != nil {
@@ -110,9 +141,12 @@ func DeleteHistory(c *gin.Context) {
 
 	// 删除弹幕解析记录
 	if history.SessionID != "" {
-		db.Delete(&models.LiveMsg{}, "session_id = ?", history.SessionID)
+		db.Unscoped().Delete(&models.LiveMsg{}, "session_id = ?", history.SessionID)
 	}
 
+	// 删除高能剪辑记录（不删除剪辑文件）
+	db.Delete(&models.HighlightClip{}, "history_id = ?", id)
+
 	// 先删除所有分P记录
 	db.Delete(&models.RecordHistoryPart{}, "history_id = ?", id)
 	// 再删除历史记录
@@ -148,6 +182,168 @@ func RePublishHistory(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"type": "success", "msg": "发布成功"})
 }
 
+// RemediateRejected 手动触发审核退回的自动处理
+func RemediateRejected(c *gin.Context) {
+	historyID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"type": "error", "msg": "无效的历史记录ID"})
+		return
+	}
+
+	if historyUploadService == nil {
+		c.JSON(http.StatusOK, gin.H{"type": "error", "msg": "上传服务未初始化"})
+		return
+	}
+
+	db := database.GetDB()
+	var history models.RecordHistory
+	if err := db.First(&history, historyID).Error; err != nil {
+		c.JSON(http.StatusOK, gin.H{"type": "error", "msg": "历史记录不存在"})
+		return
+	}
+	var room models.RecordRoom
+	if err := db.Where("room_id = ?", history.RoomID).First(&room).Error; err != nil {
+		c.JSON(http.StatusOK, gin.H{"type": "error", "msg": "房间不存在"})
+		return
+	}
+	if err := services.NewReviewRejectService().CheckRemediable(&history, &room); err != nil {
+		c.JSON(http.StatusOK, gin.H{"type": "error", "msg": err.Error()})
+		return
+	}
+
+	go func() {
+		if err := historyUploadService.RemediateRejectedHistory(uint(historyID)); err != nil {
+			log.Printf("[审核退回] 手动处理失败: history_id=%d, 错误=%v", historyID, err)
+		}
+	}()
+
+	c.JSON(http.StatusOK, gin.H{"type": "success", "msg": "已开始处理审核退回，请稍后查看处理记录"})
+}
+
+// MergeHistoryParts 手动合并历史记录中连续的短分P
+func MergeHistoryParts(c *gin.Context) {
+	historyID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"type": "error", "msg": "无效的历史记录ID"})
+		return
+	}
+
+	db := database.GetDB()
+	var history models.RecordHistory
+	if err := db.First(&history, historyID).Error; err != nil {
+		c.JSON(http.StatusOK, gin.H{"type": "error", "msg": "历史记录不存在"})
+		return
+	}
+
+	var room models.RecordRoom
+	if err := db.Where("room_id = ?", history.RoomID).First(&room).Error; err != nil {
+		c.JSON(http.StatusOK, gin.H{"type": "error", "msg": "房间不存在"})
+		return
+	}
+
+	var before int64
+	db.Model(&models.RecordHistoryPart{}).Where("history_id = ? AND excluded = ?", history.ID, false).Count(&before)
+
+	mergeSvc := services.NewPartMergeService()
+	if _, err := mergeSvc.MergeFragments(&room, &history); err != nil {
+		c.JSON(http.StatusOK, gin.H{"type": "error", "msg": err.Error()})
+		return
+	}
+
+	var after int64
+	db.Model(&models.RecordHistoryPart{}).Where("history_id = ? AND excluded = ?", history.ID, false).Count(&after)
+	c.JSON(http.StatusOK, gin.H{"type": "success", "msg": fmt.Sprintf("合并完成，分P数 %d -> %d", before, after)})
+}
+
+// ApprovePublic 手动确认将仅自己可见的稿件公开
+func ApprovePublic(c *gin.Context) {
+	historyID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"type": "error", "msg": "无效的历史记录ID"})
+		return
+	}
+
+	visibilitySvc := services.NewVisibilityService()
+	if err := visibilitySvc.ApprovePublic(uint(historyID)); err != nil {
+		c.JSON(http.StatusOK, gin.H{"type": "error", "msg": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"type": "success", "msg": "稿件已公开"})
+}
+
+// BurnInHistory 手动触发弹幕版压制和投稿
+func BurnInHistory(c *gin.Context) {
+	historyID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"type": "error", "msg": "无效的历史记录ID"})
+		return
+	}
+
+	if historyUploadService == nil {
+		c.JSON(http.StatusOK, gin.H{"type": "error", "msg": "上传服务未初始化"})
+		return
+	}
+
+	if err := historyUploadService.EnqueueBurnIn(uint(historyID)); err != nil {
+		c.JSON(http.StatusOK, gin.H{"type": "error", "msg": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"type": "success", "msg": "已加入弹幕压制队列"})
+}
+
+// ExtractClip 剪辑直播时间轴上的任意时间段，生成新的历史记录，可按正常流程上传投稿
+// 时间相对直播开始，支持 HH:MM:SS、MM:SS 或秒数；exact=true时重新编码以精确到帧
+func ExtractClip(c *gin.Context) {
+	historyID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"type": "error", "msg": "无效的历史记录ID"})
+		return
+	}
+
+	var req struct {
+		Start        string `json:"start"`
+		End          string `json:"end"`
+		Exact        bool   `json:"exact"`
+		Title        string `json:"title"`
+		PublishTitle string `json:"publishTitle"`
+		PublishDesc  string `json:"publishDesc"`
+		PublishTags  string `json:"publishTags"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, gin.H{"type": "error", "msg": "参数错误: " + err.Error()})
+		return
+	}
+
+	start, err := services.ParseClipTime(req.Start)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"type": "error", "msg": "开始" + err.Error()})
+		return
+	}
+	end, err := services.ParseClipTime(req.End)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"type": "error", "msg": "结束" + err.Error()})
+		return
+	}
+
+	result, err := services.NewClipExtractService().ExtractClip(uint(historyID), services.ClipExtractRequest{
+		Start:        start,
+		End:          end,
+		Exact:        req.Exact,
+		Title:        req.Title,
+		PublishTitle: req.PublishTitle,
+		PublishDesc:  req.PublishDesc,
+		PublishTags:  req.PublishTags,
+	})
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"type": "error", "msg": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"type": "success", "msg": "已开始剪辑，完成后可在历史记录中上传投稿", "data": result})
+}
+
 func UpdatePublishStatus(c *gin.Context) {
 	id := c.Param("id")
 	db := database.GetDB()
@@ -512,6 +708,7 @@ func BatchResetStatus(c *gin.Context) {
 		if req.Danmaku {
 			updates["danmaku_sent"] = false
 			updates["danmaku_count"] = 0
+			updates["danmaku_next_send_at"] = nil
 		}
 
 		if req.Files && history.FilePath != "" {
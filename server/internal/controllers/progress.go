@@ -104,6 +104,18 @@ func GetDanmakuProgress(c *gin.Context) {
 	c.JSON(http.StatusOK, progressData)
 }
 
+// GetDanmakuParseProgress 获取弹幕解析进度
+func GetDanmakuParseProgress(c *gin.Context) {
+	historyIDStr := c.Param("historyId")
+	historyID, err := strconv.ParseInt(historyIDStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的历史ID"})
+		return
+	}
+
+	c.JSON(http.StatusOK, danmakuprogress.GetDanmakuParseProgress(historyID))
+}
+
 // SetDanmakuProgress 设置弹幕发送进度（内部使用）
 func SetDanmakuProgress(historyID int64, current, total int, sending, completed bool) {
 	danmakuprogress.SetDanmakuProgress(historyID, current, total, sending, completed)
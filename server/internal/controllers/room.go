@@ -5,11 +5,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gobup/server/internal/bili"
 	"github.com/gobup/server/internal/database"
 	"github.com/gobup/server/internal/models"
 	"github.com/gobup/server/internal/services"
@@ -56,11 +58,95 @@ func UpdateRoom(c *gin.Context) {
 		room.SourceTemplate = "直播间: https://live.bilibili.com/${roomId}  稿件直播源"
 	}
 
+	// 校验弹幕过滤规则，避免保存后发送时才报错
+	if rules, err := services.ParseDanmakuFilterRules(room.DmFilterRules); err != nil {
+		c.JSON(http.StatusOK, gin.H{"type": "error", "msg": err.Error()})
+		return
+	} else if _, err := services.NewDanmakuFilter(rules); err != nil {
+		c.JSON(http.StatusOK, gin.H{"type": "error", "msg": err.Error()})
+		return
+	}
+
+	// 校验高能评分配置
+	if _, err := services.ParseHighlightScoringConfig(room.HighlightScoring); err != nil {
+		c.JSON(http.StatusOK, gin.H{"type": "error", "msg": err.Error()})
+		return
+	}
+
 	db := database.GetDB()
 	db.Save(&room)
+
+	// 录制开关立即生效
+	go services.GetLiveMonitorService().SyncRooms()
+
 	c.JSON(http.StatusOK, true)
 }
 
+// DmFilterSample 规则测试用的示例弹幕
+type DmFilterSample struct {
+	Message   string `json:"message"`
+	UID       int64  `json:"uid"`
+	UserName  string `json:"userName"`
+	Timestamp int64  `json:"timestamp"` // 毫秒，用于每分钟频率限制
+	Mode      int    `json:"mode"`
+}
+
+// TestDmFilter 使用示例弹幕测试过滤规则，返回每条弹幕命中的规则和处理结果
+// 未传rules时使用房间已保存的规则
+func TestDmFilter(c *gin.Context) {
+	var req struct {
+		RoomID  uint                         `json:"roomId"`
+		Rules   []services.DanmakuFilterRule `json:"rules"`
+		Samples []DmFilterSample             `json:"samples"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, gin.H{"type": "error", "msg": "参数错误: " + err.Error()})
+		return
+	}
+
+	rules := req.Rules
+	if rules == nil && req.RoomID > 0 {
+		var room models.RecordRoom
+		if err := database.GetDB().First(&room, req.RoomID).Error; err != nil {
+			c.JSON(http.StatusOK, gin.H{"type": "error", "msg": "房间不存在"})
+			return
+		}
+		parsed, err := services.ParseDanmakuFilterRules(room.DmFilterRules)
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{"type": "error", "msg": err.Error()})
+			return
+		}
+		rules = parsed
+	}
+
+	filter, err := services.NewDanmakuFilter(rules)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"type": "error", "msg": err.Error()})
+		return
+	}
+
+	results := make([]gin.H, 0, len(req.Samples))
+	for _, sample := range req.Samples {
+		mode := sample.Mode
+		if mode == 0 {
+			mode = 1
+		}
+		msg := models.LiveMsg{
+			Message:   sample.Message,
+			UID:       sample.UID,
+			UserName:  sample.UserName,
+			Timestamp: sample.Timestamp,
+			Mode:      mode,
+		}
+		results = append(results, gin.H{
+			"sample": sample,
+			"result": filter.Apply(&msg),
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"type": "success", "data": results})
+}
+
 func DeleteRoom(c *gin.Context) {
 	id := c.Param("id")
 	db := database.GetDB()
@@ -131,7 +217,65 @@ func GetUploadLines(c *gin.Context) {
 }
 
 func GetSeasons(c *gin.Context) {
-	c.JSON(http.StatusOK, []interface{}{})
+	db := database.GetDB()
+	var room models.RecordRoom
+	if err := db.Where("room_id = ?", c.Param("roomId")).First(&room).Error; err != nil || room.UploadUserID == 0 {
+		c.JSON(http.StatusOK, []interface{}{})
+		return
+	}
+
+	client, err := getRoomUploadClient(&room)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"type": "error", "msg": err.Error()})
+		return
+	}
+
+	seasons, err := client.GetSeasons(client.Mid)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"type": "error", "msg": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, seasons)
+}
+
+// GetSeasonSections 获取房间所配置合集的小节列表
+func GetSeasonSections(c *gin.Context) {
+	db := database.GetDB()
+	var room models.RecordRoom
+	if err := db.Where("room_id = ?", c.Param("roomId")).First(&room).Error; err != nil {
+		c.JSON(http.StatusOK, gin.H{"type": "error", "msg": "房间不存在"})
+		return
+	}
+	if room.CollectionID <= 0 {
+		c.JSON(http.StatusOK, []interface{}{})
+		return
+	}
+
+	client, err := getRoomUploadClient(&room)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"type": "error", "msg": err.Error()})
+		return
+	}
+
+	sections, err := client.GetSeasonSections(room.CollectionID)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"type": "error", "msg": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, sections)
+}
+
+// getRoomUploadClient 获取房间上传用户的B站客户端
+func getRoomUploadClient(room *models.RecordRoom) (*bili.BiliClient, error) {
+	db := database.GetDB()
+	var user models.BiliBiliUser
+	if err := db.First(&user, room.UploadUserID).Error; err != nil {
+		return nil, fmt.Errorf("上传用户不存在")
+	}
+	if !user.Login {
+		return nil, fmt.Errorf("上传用户未登录")
+	}
+	return bili.NewBiliClient(user.AccessKey, user.Cookies, user.UID), nil
 }
 
 // GetRecommendedLines 获取推荐线路
@@ -434,3 +578,23 @@ func VerifyTemplate(c *gin.Context) {
 func NewTemplateService() *services.TemplateService {
 	return services.NewTemplateService()
 }
+
+// GetRecordingCoverage 获取房间每场直播的时间线和录制缺口，days为统计天数，minGap为忽略的最短缺口（秒）
+func GetRecordingCoverage(c *gin.Context) {
+	days, _ := strconv.Atoi(c.DefaultQuery("days", "30"))
+	if days <= 0 {
+		days = 30
+	}
+	minGap, _ := strconv.Atoi(c.DefaultQuery("minGap", "60"))
+	if minGap < 0 {
+		minGap = 0
+	}
+
+	since := time.Now().AddDate(0, 0, -days)
+	coverage, err := services.NewLiveStatusService().GetRecordingCoverage(c.Param("roomId"), since, time.Duration(minGap)*time.Second)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"type": "error", "msg": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, coverage)
+}
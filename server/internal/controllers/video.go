@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 
@@ -20,6 +22,17 @@ func SendDanmaku(c *gin.Context) {
 
 	danmakuService := services.NewDanmakuService()
 
+	// 预览模式：只返回将要发送的弹幕及账号分配和预计耗时，不加入队列
+	if c.Query("dryRun") == "true" || c.Query("preview") == "true" {
+		preview, err := danmakuService.PreviewDanmakuSend(uint(historyID))
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{"type": "error", "msg": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"type": "success", "data": preview})
+		return
+	}
+
 	log.Printf("=== 开始启动弹幕发送任务 (history_id=%d) ===", historyID)
 
 	// 添加到队列（队列会自动异步处理，使用所有有效用户并行发送）
@@ -257,6 +270,7 @@ func ResetHistoryStatus(c *gin.Context) {
 	if options.Danmaku {
 		history.DanmakuSent = false
 		history.DanmakuCount = 0
+		history.DanmakuNextSendAt = nil
 		// 同时重置所有弹幕的sent状态
 		db.Model(&models.LiveMsg{}).Where("session_id = ?", history.SessionID).Update("sent", false)
 		resetItems = append(resetItems, "弹幕状态")
@@ -337,7 +351,7 @@ func DeleteHistoryWithFiles(c *gin.Context) {
 
 	// 删除弹幕解析记录
 	if history.SessionID != "" {
-		db.Delete(&models.LiveMsg{}, "session_id = ?", history.SessionID)
+		db.Unscoped().Delete(&models.LiveMsg{}, "session_id = ?", history.SessionID)
 	}
 
 	// 删除数据库记录
@@ -371,6 +385,97 @@ func GetDanmakuStats(c *gin.Context) {
 	})
 }
 
+// GetDanmakuAnalytics 获取单场直播的弹幕分析（密度、关键词、发言排行、勋章分布、SC时间线）
+func GetDanmakuAnalytics(c *gin.Context) {
+	historyID, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+	topN, _ := strconv.Atoi(c.Query("top"))
+
+	analytics, err := services.NewDanmakuAnalyticsService().Analyze(uint(historyID), topN)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"type": "error", "msg": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"type": "success", "data": analytics})
+}
+
+// GetDanmakuOffsets 获取各分P的弹幕时间偏移，recalibrate=true时重新自动校准
+func GetDanmakuOffsets(c *gin.Context) {
+	historyID, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+	recalibrate := c.Query("recalibrate") == "true"
+
+	offsets, err := services.NewDanmakuOffsetService().GetOffsets(uint(historyID), recalibrate)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"type": "error", "msg": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"type": "success", "data": offsets})
+}
+
+// SetDanmakuOffset 设置历史记录的手动弹幕偏移（毫秒），override为false时恢复自动校准
+func SetDanmakuOffset(c *gin.Context) {
+	historyID, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+
+	var req struct {
+		Override bool  `json:"override"`
+		Offset   int64 `json:"offset"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, gin.H{"type": "error", "msg": "参数错误: " + err.Error()})
+		return
+	}
+
+	db := database.GetDB()
+	var history models.RecordHistory
+	if err := db.First(&history, historyID).Error; err != nil {
+		c.JSON(http.StatusOK, gin.H{"type": "error", "msg": "历史记录不存在"})
+		return
+	}
+
+	db.Model(&history).Updates(map[string]interface{}{
+		"danmaku_offset_override": req.Override,
+		"danmaku_offset":          req.Offset,
+	})
+
+	offsets, err := services.NewDanmakuOffsetService().GetOffsets(uint(historyID), false)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"type": "error", "msg": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"type": "success", "msg": "弹幕偏移已更新", "data": offsets})
+}
+
+// ExportDanmaku 导出弹幕为ASS/SRT/XML文件，partId为空时导出整场直播
+func ExportDanmaku(c *gin.Context) {
+	historyID, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+	partID, _ := strconv.ParseUint(c.Query("partId"), 10, 32)
+	format := strings.ToLower(c.DefaultQuery("format", services.DanmakuExportASS))
+
+	opts := services.DefaultDanmakuExportOptions()
+	if width, err := strconv.Atoi(c.Query("width")); err == nil && width > 0 {
+		opts.Width = width
+	}
+	if height, err := strconv.Atoi(c.Query("height")); err == nil && height > 0 {
+		opts.Height = height
+		opts.FontSize = opts.FontSize * height / 1080
+	}
+
+	data, fileName, err := services.NewDanmakuExportService().Export(uint(historyID), uint(partID), format, opts)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"type": "error", "msg": err.Error()})
+		return
+	}
+
+	contentType := "text/plain; charset=utf-8"
+	if format == services.DanmakuExportXML {
+		contentType = "application/xml; charset=utf-8"
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename*=UTF-8''%s", url.PathEscape(fileName)))
+	c.Data(http.StatusOK, contentType, data)
+}
+
 // ParseDanmaku 解析弹幕XML文件（使用队列）
 func ParseDanmaku(c *gin.Context) {
 	historyID, _ := strconv.ParseUint(c.Param("id"), 10, 32)
@@ -438,6 +543,50 @@ func BatchParseDanmaku(c *gin.Context) {
 	})
 }
 
+// ImportDanmaku 上传弹幕文件（XML/JSON lines/ASS）导入到已有历史记录
+// 表单字段: file 弹幕文件, partId 可选，弹幕时间相对该分P开始录制的时间
+func ImportDanmaku(c *gin.Context) {
+	historyID, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+	partID, _ := strconv.ParseUint(c.PostForm("partId"), 10, 32)
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"type": "error", "msg": "上传文件失败"})
+		return
+	}
+
+	// 保留扩展名，便于按扩展名识别格式
+	tmpFile, err := os.CreateTemp("", "danmaku-import-*"+filepath.Ext(file.Filename))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"type": "error", "msg": "创建临时文件失败"})
+		return
+	}
+	tmpPath := tmpFile.Name()
+	tmpFile.Close()
+	defer os.Remove(tmpPath)
+
+	if err := c.SaveUploadedFile(file, tmpPath); err != nil {
+		c.JSON(http.StatusOK, gin.H{"type": "error", "msg": "保存上传文件失败"})
+		return
+	}
+
+	log.Printf("[弹幕导入] 收到导入请求: history_id=%d, part_id=%d, 文件=%s", historyID, partID, file.Filename)
+
+	count, format, err := services.NewDanmakuXMLParser().ImportDanmakuFile(uint(historyID), uint(partID), tmpPath)
+	if err != nil {
+		log.Printf("[弹幕导入] ❌ 导入失败: %v", err)
+		c.JSON(http.StatusOK, gin.H{"type": "error", "msg": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"type":   "success",
+		"msg":    fmt.Sprintf("成功导入%d条弹幕", count),
+		"format": format,
+		"count":  count,
+	})
+}
+
 // GetParseQueueStatus 获取解析队列状态
 func GetParseQueueStatus(c *gin.Context) {
 	queue := services.NewDanmakuParserQueue()
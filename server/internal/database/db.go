@@ -35,13 +35,21 @@ func InitDB(dbPath string) error {
 	sqlDB.SetMaxIdleConns(1)    // 空闲连接数
 	sqlDB.SetConnMaxLifetime(0) // 连接可以一直重用
 
+	// 迁移前记录旧表结构，用于迁移后修正旧数据
+	hasShortPartAction := DB.Migrator().HasColumn(&models.RecordRoom{}, "ShortPartAction")
+
 	// 自动迁移
 	err = DB.AutoMigrate(
 		&models.RecordRoom{},
 		&models.RecordHistory{},
 		&models.RecordHistoryPart{},
+		&models.BurnInPart{},
+		&models.HighlightClip{},
 		&models.BiliBiliUser{},
 		&models.LiveMsg{},
+		&models.LiveEvent{},
+		&models.LiveSession{},
+		&models.DanmakuQuota{},
 		&models.VideoSyncTask{},
 		&models.SystemConfig{},
 	)
@@ -49,6 +57,11 @@ func InitDB(dbPath string) error {
 		return fmt.Errorf("数据库迁移失败: %w", err)
 	}
 
+	// 旧版本的分P最小时长默认60秒但从未生效，开始按限制过滤前清零，保持旧房间的上传行为不变
+	if !hasShortPartAction {
+		DB.Exec("UPDATE record_rooms SET duration_limit = 0")
+	}
+
 	// 添加额外的索引和约束
 	// 为 RecordHistory 添加组合索引
 	DB.Exec("CREATE INDEX IF NOT EXISTS idx_history_room_time ON record_histories(room_id, end_time DESC)")
@@ -59,6 +72,18 @@ func InitDB(dbPath string) error {
 	DB.Exec("CREATE INDEX IF NOT EXISTS idx_part_file_path ON record_history_parts(file_path)")
 	DB.Exec("CREATE INDEX IF NOT EXISTS idx_part_room_time ON record_history_parts(room_id, end_time)")
 
+	// 弹幕去重唯一索引，首次创建前清理历史遗留的重复弹幕
+	var dedupeIndexCount int64
+	DB.Raw("SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND name = 'idx_live_msg_dedupe'").Scan(&dedupeIndexCount)
+	if dedupeIndexCount == 0 {
+		DB.Exec("DELETE FROM live_msgs WHERE rowid NOT IN (SELECT MIN(rowid) FROM live_msgs GROUP BY session_id, timestamp, message)")
+		if err := DB.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_live_msg_dedupe ON live_msgs(session_id, timestamp, message)").Error; err != nil {
+			return fmt.Errorf("创建弹幕去重索引失败: %w", err)
+		}
+	}
+	// 软删除的弹幕仍占用去重索引，会导致重新解析时无法写入，清理旧版本遗留的软删除记录
+	DB.Exec("DELETE FROM live_msgs WHERE deleted_at IS NOT NULL")
+
 	// 初始化系统配置（如果不存在）
 	var config models.SystemConfig
 	if err := DB.First(&config).Error; err != nil {
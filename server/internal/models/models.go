@@ -24,6 +24,11 @@ type RecordRoom struct {
 	AutoUpload         bool           `gorm:"default:true" json:"autoUpload"`        // 录制完成后自动上传分P
 	AutoPublish        bool           `gorm:"default:false" json:"autoPublish"`      // 所有分P上传完成后自动投稿
 	AutoParseDanmaku   bool           `gorm:"default:false" json:"autoParseDanmaku"` // 自动解析弹幕
+	RecordDanmaku      bool           `gorm:"default:false" json:"recordDanmaku"`    // 内置弹幕录制（通过直播信息流录制弹幕，需开启实时开播检测）
+	StreamRecord       bool           `gorm:"default:false" json:"streamRecord"`     // 内置直播录制（无需外部录播软件，开播后直接拉流录制）
+	StreamQuality      int            `gorm:"default:10000" json:"streamQuality"`    // 录制画质: 10000原画 400蓝光 250超清 150高清
+	StreamSplitSize    int64          `gorm:"default:0" json:"streamSplitSize"`      // 录制按大小分段(MB)，0不分段
+	StreamSplitTime    int            `gorm:"default:0" json:"streamSplitTime"`      // 录制按时长分段(分钟)，0不分段
 	AutoSyncInfo       bool           `gorm:"default:false" json:"autoSyncInfo"`     // 定时同步视频信息（每30分钟）
 	AutoSendDanmaku    bool           `gorm:"default:false" json:"autoSendDanmaku"`  // 自动发送弹幕（审核通过后）
 	LastSyncTime       *time.Time     `json:"lastSyncTime"`                          // 最后同步时间
@@ -31,18 +36,37 @@ type RecordRoom struct {
 	PartTitleTemplate  string         `gorm:"type:text" json:"partTitleTemplate"`
 	DescTemplate       string         `gorm:"type:text" json:"descTemplate"`
 	DynamicTemplate    string         `gorm:"type:text" json:"dynamicTemplate"`
-	FileSizeLimit      int64          `gorm:"default:0" json:"fileSizeLimit"`
-	DurationLimit      int            `gorm:"default:60" json:"durationLimit"`
+	FileSizeLimit      int64          `gorm:"default:0" json:"fileSizeLimit"`      // 分P最小文件大小(MB)，0不限制
+	DurationLimit      int            `gorm:"default:0" json:"durationLimit"`      // 分P最小时长(秒)，0不限制
+	ShortPartAction    int            `gorm:"default:0" json:"shortPartAction"`    // 低于限制的分P处理: 0-跳过 1-合并到相邻分P
+	MinTotalDuration   int            `gorm:"default:0" json:"minTotalDuration"`   // 整场有效时长低于此值(秒)不投稿，0不限制
+	MergeFragments     bool           `gorm:"default:false" json:"mergeFragments"` // 上传前合并连续的短分P（断流重连产生的碎片）
+	MergeShortLimit    int            `gorm:"default:300" json:"mergeShortLimit"`  // 参与合并的短分P时长上限(秒)
+	MergeMaxGap        int            `gorm:"default:60" json:"mergeMaxGap"`       // 参与合并的相邻分P最大间隔(秒)
 	Tags               string         `json:"tags"`
 	TID                int            `gorm:"default:171" json:"tid"`
 	Copyright          int            `gorm:"default:1" json:"copyright"`
 	SourceTemplate     string         `gorm:"type:text;default:'直播间: https://live.bilibili.com/${roomId}  稿件直播源'" json:"sourceTemplate"` // 转载来源模板，支持变量替换
 	PercentileRank     float64        `gorm:"default:0.95" json:"percentileRank"`
 	HighEnergyCut      bool           `gorm:"default:false" json:"highEnergyCut"`
-	WindowSize         int            `gorm:"default:60" json:"windowSize"`         // 高能剪辑窗口大小(秒)
-	MinSegmentDuration int            `gorm:"default:10" json:"minSegmentDuration"` // 最小片段时长(秒)
+	WindowSize         int            `gorm:"default:60" json:"windowSize"`           // 高能剪辑窗口大小(秒)
+	MinSegmentDuration int            `gorm:"default:10" json:"minSegmentDuration"`   // 最小片段时长(秒)
+	HighlightScoring   string         `gorm:"type:text" json:"highlightScoring"`      // 高能评分模型（JSON，权重和选择策略）
+	HighlightPublish   int            `gorm:"default:0" json:"highlightPublish"`      // 高能剪辑投稿: 0-不投稿 1-每场单独投稿 2-每周合集投稿
+	HighlightTitleTpl  string         `gorm:"type:text" json:"highlightTitleTpl"`     // 高能稿件标题模板
+	HighlightDescTpl   string         `gorm:"type:text" json:"highlightDescTpl"`      // 高能稿件简介模板
+	HighlightTags      string         `json:"highlightTags"`                          // 高能稿件标签，留空使用房间标签
+	HighlightUserID    uint           `gorm:"default:0" json:"highlightUserId"`       // 高能稿件投稿账号，0使用房间上传账号
+	DanmakuBurnIn      bool           `gorm:"default:false" json:"danmakuBurnIn"`     // 投稿后压制弹幕版
+	BurnInTarget       int            `gorm:"default:0" json:"burnInTarget"`          // 弹幕版投稿方式: 0-单独稿件 1-追加到原稿件的分P
+	BurnInPreset       string         `gorm:"default:veryfast" json:"burnInPreset"`   // x264编码预设
+	BurnInCRF          int            `gorm:"default:23" json:"burnInCrf"`            // x264 CRF质量参数
+	BurnInTitleSuffix  string         `gorm:"default:【弹幕版】" json:"burnInTitleSuffix"` // 弹幕版标题/分P标题后缀
 	IsOnlySelf         bool           `gorm:"default:false" json:"isOnlySelf"`
 	NoDisturbance      bool           `gorm:"default:false" json:"noDisturbance"`
+	PrivateFirst       bool           `gorm:"default:false" json:"privateFirst"`       // 先以仅自己可见投稿，审核通过后自动公开
+	PublicDelay        int            `gorm:"default:0" json:"publicDelay"`            // 审核通过后延迟公开(分钟)
+	PublicNeedApproval bool           `gorm:"default:false" json:"publicNeedApproval"` // 审核通过后需手动确认才公开
 	Line               string         `gorm:"default:cs_bda2" json:"line"`
 	AvailableLines     string         `gorm:"type:text" json:"availableLines"` // 可用线路列表，逗号分隔，用于自动切换
 	CoverURL           string         `json:"coverUrl"`
@@ -57,53 +81,83 @@ type RecordRoom struct {
 	DmUlLevel          int            `gorm:"default:0" json:"dmUlLevel"`          // 用户等级过滤
 	DmMedalLevel       int            `gorm:"default:0" json:"dmMedalLevel"`       // 粉丝勋章过滤 0-不过滤 1-佩戴粉丝勋章 2-佩戴主播粉丝勋章
 	DmKeywordBlacklist string         `gorm:"type:text" json:"dmKeywordBlacklist"` // 关键词屏蔽，一行一个
+	DmFilterRules      string         `gorm:"type:text" json:"dmFilterRules"`      // 弹幕过滤规则（JSON数组，按顺序执行）
 	Recording          bool           `gorm:"default:false;index" json:"recording"`
 	Streaming          bool           `gorm:"default:false;index" json:"streaming"`
 	SessionID          string         `gorm:"index" json:"sessionId"`
 	SeasonID           int64          `json:"seasonId"`
-	LiveStatus         int            `gorm:"default:0;index" json:"liveStatus"` // 直播状态: 0未开播 1正在直播 2轮播中
-	LastCheckTime      *time.Time     `json:"lastCheckTime"`                     // 最后检查时间
+	CollectionID       int64          `gorm:"default:0" json:"collectionId"`            // 合集ID，非default规则时在此合集下自动选择/创建小节
+	SeasonSectionRule  string         `gorm:"default:default" json:"seasonSectionRule"` // 合集小节规则: default-SeasonID即小节ID month-按月份 area-按分区 custom-自定义模板
+	SeasonSectionTpl   string         `json:"seasonSectionTpl"`                         // 自定义小节标题模板，如 ${date:yyyy年MM月}
+	SeasonSortByLive   bool           `gorm:"default:false" json:"seasonSortByLive"`    // 加入合集后按直播时间重新排序
+	RejectRemediation  int            `gorm:"default:0" json:"rejectRemediation"`       // 审核退回处理: 0-仅通知 1-删除违规分P 2-剪除违规片段后重传
+	LiveStatus         int            `gorm:"default:0;index" json:"liveStatus"`        // 直播状态: 0未开播 1正在直播 2轮播中
+	LastCheckTime      *time.Time     `json:"lastCheckTime"`                            // 最后检查时间
+	AnchorUID          int64          `gorm:"default:0;index" json:"anchorUid"`         // 主播UID，按UID批量查询开播状态，单独查询直播间时缓存
 }
 
 // RecordHistory 录制历史
 type RecordHistory struct {
-	ID               uint           `gorm:"primarykey" json:"id"`
-	CreatedAt        time.Time      `json:"createdAt"`
-	UpdatedAt        time.Time      `json:"updatedAt"`
-	DeletedAt        gorm.DeletedAt `gorm:"index" json:"-"`
-	EventID          string         `gorm:"index" json:"eventId"`
-	RoomID           string         `gorm:"index;not null" json:"roomId"`
-	SessionID        string         `gorm:"uniqueIndex:idx_session" json:"sessionId"`
-	Uname            string         `json:"uname"`
-	Title            string         `json:"title"`
-	AreaName         string         `json:"areaName"`
-	StartTime        time.Time      `gorm:"index" json:"startTime"`
-	EndTime          time.Time      `gorm:"index" json:"endTime"`
-	Recording        bool           `gorm:"default:false;index" json:"recording"`
-	Streaming        bool           `gorm:"default:false" json:"streaming"`
-	Upload           bool           `gorm:"default:true;index" json:"upload"`
-	Publish          bool           `gorm:"default:false;index" json:"publish"`
-	BvID             string         `gorm:"index" json:"bvId"`
-	AvID             string         `gorm:"index" json:"avId"`
-	Code             int            `gorm:"default:-1" json:"code"`
-	Message          string         `json:"message"`
-	FilePath         string         `json:"filePath"`
-	FileSize         int64          `gorm:"default:0" json:"fileSize"`
-	UploadRetryCount int            `gorm:"default:0" json:"uploadRetryCount"`
-	UploadStatus     int            `gorm:"default:0;index" json:"uploadStatus"`    // 上传状态: 0未上传, 1上传中, 2已上传
-	VideoState       int            `gorm:"default:-1;index" json:"videoState"`     // 视频状态: -1未知, 0审核中, 1已通过, -2未通过, 2已下架, 3仅自己可见
-	VideoStateDesc   string         `json:"videoStateDesc"`                         // 视频状态描述
-	DanmakuSent      bool           `gorm:"default:false;index" json:"danmakuSent"` // 弹幕是否已发送
-	DanmakuCount     int            `gorm:"default:0" json:"danmakuCount"`          // 弹幕总数
-	FilesMoved       bool           `gorm:"default:false;index" json:"filesMoved"`  // 文件是否已移动
-	SyncedAt         *time.Time     `json:"syncedAt"`                               // 最后同步时间
-	CoverURL         string         `json:"coverUrl"`                               // 封面URL
-	RoomName         string         `gorm:"-" json:"roomName"`
-	PartCount        int            `gorm:"-" json:"partCount"`
-	PartDuration     float64        `gorm:"-" json:"partDuration"`
-	UploadPartCount  int            `gorm:"-" json:"uploadPartCount"`
-	RecordPartCount  int            `gorm:"-" json:"recordPartCount"`
-	MsgCount         int            `gorm:"-" json:"msgCount"`
+	ID                    uint           `gorm:"primarykey" json:"id"`
+	CreatedAt             time.Time      `json:"createdAt"`
+	UpdatedAt             time.Time      `json:"updatedAt"`
+	DeletedAt             gorm.DeletedAt `gorm:"index" json:"-"`
+	EventID               string         `gorm:"index" json:"eventId"`
+	RoomID                string         `gorm:"index;not null" json:"roomId"`
+	SessionID             string         `gorm:"uniqueIndex:idx_session" json:"sessionId"`
+	Uname                 string         `json:"uname"`
+	Title                 string         `json:"title"`
+	AreaName              string         `json:"areaName"`
+	StartTime             time.Time      `gorm:"index" json:"startTime"`
+	EndTime               time.Time      `gorm:"index" json:"endTime"`
+	Recording             bool           `gorm:"default:false;index" json:"recording"`
+	Streaming             bool           `gorm:"default:false" json:"streaming"`
+	Upload                bool           `gorm:"default:true;index" json:"upload"`
+	Publish               bool           `gorm:"default:false;index" json:"publish"`
+	BvID                  string         `gorm:"index" json:"bvId"`
+	AvID                  string         `gorm:"index" json:"avId"`
+	Code                  int            `gorm:"default:-1" json:"code"`
+	Message               string         `json:"message"`
+	FilePath              string         `json:"filePath"`
+	FileSize              int64          `gorm:"default:0" json:"fileSize"`
+	UploadRetryCount      int            `gorm:"default:0" json:"uploadRetryCount"`
+	UploadStatus          int            `gorm:"default:0;index" json:"uploadStatus"`        // 上传状态: 0未上传, 1上传中, 2已上传
+	VideoState            int            `gorm:"default:-1;index" json:"videoState"`         // 视频状态: -1未知, 0审核中, 1已通过, -2未通过, 2已下架, 3仅自己可见
+	VideoStateDesc        string         `json:"videoStateDesc"`                             // 视频状态描述
+	DanmakuSent           bool           `gorm:"default:false;index" json:"danmakuSent"`     // 弹幕是否已发送
+	DanmakuCount          int            `gorm:"default:0" json:"danmakuCount"`              // 弹幕总数
+	FilesMoved            bool           `gorm:"default:false;index" json:"filesMoved"`      // 文件是否已移动
+	SyncedAt              *time.Time     `json:"syncedAt"`                                   // 最后同步时间
+	CoverURL              string         `json:"coverUrl"`                                   // 封面URL
+	RejectReason          string         `gorm:"type:text" json:"rejectReason"`              // 审核退回原因
+	RejectRanges          string         `gorm:"type:text" json:"rejectRanges"`              // 违规分P及时间段（JSON）
+	RemediationStatus     int            `gorm:"default:0;index" json:"remediationStatus"`   // 退回处理状态: 0无 1待处理 2处理中 3已重新提交 4处理失败 5仅通知
+	RemediationCount      int            `gorm:"default:0" json:"remediationCount"`          // 已自动处理次数
+	RemediationMsg        string         `gorm:"type:text" json:"remediationMsg"`            // 退回处理过程记录
+	VisibilityStatus      int            `gorm:"default:0;index" json:"visibilityStatus"`    // 公开流程: 0无 1等待审核 2等待确认 3已计划公开 4已公开 5公开失败
+	PublicAt              *time.Time     `json:"publicAt"`                                   // 计划公开时间
+	BurnInStatus          int            `gorm:"default:0;index" json:"burnInStatus"`        // 弹幕版状态: 0无 1排队中 2处理中 3已投稿 4失败
+	BurnInBvID            string         `json:"burnInBvId"`                                 // 弹幕版单独稿件BV号
+	BurnInAvID            string         `json:"burnInAvId"`                                 // 弹幕版单独稿件AV号
+	BurnInMsg             string         `gorm:"type:text" json:"burnInMsg"`                 // 弹幕版处理信息
+	DanmakuOffsetOverride bool           `gorm:"default:false" json:"danmakuOffsetOverride"` // 使用手动弹幕偏移代替自动校准
+	DanmakuOffset         int64          `gorm:"default:0" json:"danmakuOffset"`             // 手动弹幕偏移（毫秒），正数表示视频相对分P开始时间延后
+	DanmakuNextSendAt     *time.Time     `json:"danmakuNextSendAt"`                          // 配额用尽或视频未审核时，剩余弹幕的下次发送时间
+	HighlightArchive      int            `gorm:"default:0;index" json:"highlightArchive"`    // 高能集锦稿件: 0否 1单场 2周合集
+	SourceHistoryID       uint           `gorm:"default:0;index" json:"sourceHistoryId"`     // 片段剪辑的来源历史记录ID，0表示原始录制
+	ClipStart             int64          `gorm:"default:0" json:"clipStart"`                 // 片段在来源直播时间轴上的开始位置（毫秒）
+	ClipEnd               int64          `gorm:"default:0" json:"clipEnd"`                   // 片段在来源直播时间轴上的结束位置（毫秒）
+	PublishTitle          string         `json:"publishTitle"`                               // 投稿标题，非空时代替房间标题模板
+	PublishDesc           string         `gorm:"type:text" json:"publishDesc"`               // 投稿简介，非空时代替房间简介模板
+	PublishTags           string         `json:"publishTags"`                                // 投稿标签，非空时代替房间标签
+	DanmakuFile           string         `json:"danmakuFile"`                                // 内置弹幕录制的XML文件
+	RoomName              string         `gorm:"-" json:"roomName"`
+	PartCount             int            `gorm:"-" json:"partCount"`
+	PartDuration          float64        `gorm:"-" json:"partDuration"`
+	UploadPartCount       int            `gorm:"-" json:"uploadPartCount"`
+	RecordPartCount       int            `gorm:"-" json:"recordPartCount"`
+	MsgCount              int            `gorm:"-" json:"msgCount"`
+	Revenue               float64        `gorm:"-" json:"revenue"` // 本场收益（元）
 }
 
 // RecordHistoryPart 录制分P
@@ -128,13 +182,53 @@ type RecordHistoryPart struct {
 	CID                 int64      `gorm:"column:c_id" json:"cid"`
 	FileDelete          bool       `gorm:"default:false" json:"fileDelete"`
 	FileMoved           bool       `gorm:"default:false" json:"fileMoved"`
-	Page                int        `gorm:"default:0" json:"page"`                // 分P序号
-	XcodeState          int        `gorm:"default:0" json:"xcodeState"`          // 转码状态
-	UploadRetryCount    int        `gorm:"default:0" json:"uploadRetryCount"`    // 上传重试次数
-	UploadErrorMsg      string     `gorm:"type:text" json:"uploadErrorMsg"`      // 上传错误信息
-	UploadLine          string     `json:"uploadLine"`                           // 实际上传使用的线路
-	RateLimitCooldownAt *time.Time `gorm:"index" json:"rateLimitCooldownAt"`     // 速率限制冷却时间（24小时后恢复）
-	RateLimitRetryCount int        `gorm:"default:0" json:"rateLimitRetryCount"` // 406速率限制失败次数
+	Page                int        `gorm:"default:0" json:"page"`                  // 分P序号
+	XcodeState          int        `gorm:"default:0" json:"xcodeState"`            // 转码状态
+	UploadRetryCount    int        `gorm:"default:0" json:"uploadRetryCount"`      // 上传重试次数
+	UploadErrorMsg      string     `gorm:"type:text" json:"uploadErrorMsg"`        // 上传错误信息
+	UploadLine          string     `json:"uploadLine"`                             // 实际上传使用的线路
+	RateLimitCooldownAt *time.Time `gorm:"index" json:"rateLimitCooldownAt"`       // 速率限制冷却时间（24小时后恢复）
+	RateLimitRetryCount int        `gorm:"default:0" json:"rateLimitRetryCount"`   // 406速率限制失败次数
+	Excluded            bool       `gorm:"default:false;index" json:"excluded"`    // 不参与投稿（审核退回移除等）
+	ExcludeReason       string     `gorm:"type:text" json:"excludeReason"`         // 不参与投稿的原因
+	PublishSkipReason   string     `gorm:"type:text" json:"publishSkipReason"`     // 投稿时因低于限制跳过的原因
+	MergedInto          uint       `gorm:"default:0;index" json:"mergedInto"`      // 已合并到的分P ID
+	MergeState          int        `gorm:"default:0" json:"mergeState"`            // 碎片合并状态: 0-待检查 1-已检查 2-合并生成的分P
+	DanmakuOffset       int64      `gorm:"default:0" json:"danmakuOffset"`         // 视频0秒对应的直播时间轴位置（毫秒）
+	DanmakuCalibrated   bool       `gorm:"default:false" json:"danmakuCalibrated"` // 弹幕偏移是否已自动校准
+}
+
+// BurnInPart 弹幕压制版分P
+type BurnInPart struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	HistoryID uint      `gorm:"index;not null" json:"historyId"`
+	PartID    uint      `gorm:"uniqueIndex;not null" json:"partId"` // 原始分P ID
+	FilePath  string    `json:"filePath"`                           // 压制后的文件路径
+	FileSize  int64     `gorm:"default:0" json:"fileSize"`
+	Status    int       `gorm:"default:0;index" json:"status"` // 0待压制 1已压制 2已上传 3失败
+	FileName  string    `json:"fileName"`                      // 上传后B站返回的文件名
+	CID       int64     `gorm:"column:c_id" json:"cid"`
+	ErrorMsg  string    `gorm:"type:text" json:"errorMsg"`
+}
+
+// HighlightClip 高能剪辑输出
+type HighlightClip struct {
+	ID           uint      `gorm:"primarykey" json:"id"`
+	CreatedAt    time.Time `json:"createdAt"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+	HistoryID    uint      `gorm:"index;not null" json:"historyId"`
+	RoomID       string    `gorm:"index" json:"roomId"`
+	SessionID    string    `json:"sessionId"`
+	FilePath     string    `json:"filePath"`
+	FileSize     int64     `gorm:"default:0" json:"fileSize"`
+	Duration     float64   `gorm:"default:0" json:"duration"`     // 剪辑总时长（秒）
+	SegmentCount int       `gorm:"default:0" json:"segmentCount"` // 片段数
+	Segments     string    `gorm:"type:text" json:"segments"`     // 片段JSON，时间相对直播开始（毫秒）
+	Status       int       `gorm:"default:0;index" json:"status"` // 0生成中 1已完成 2失败
+	ErrorMsg     string    `gorm:"type:text" json:"errorMsg"`
+	ArchiveID    uint      `gorm:"default:0;index" json:"archiveId"` // 所属高能集锦稿件的历史记录ID
 }
 
 // BiliBiliUser B站用户
@@ -168,7 +262,7 @@ type LiveMsg struct {
 	RoomID      string         `gorm:"index" json:"roomId"`
 	SessionID   string         `gorm:"index" json:"sessionId"`
 	Timestamp   int64          `gorm:"index" json:"timestamp"` // 相对于直播开始的时间戳（毫秒）
-	Type        int            `json:"type"`                   // 1=文字弹幕
+	Type        int            `json:"type"`                   // 1=文字弹幕 2=SC 3=上舰，旧数据为0
 	Message     string         `gorm:"type:text" json:"message"`
 	UserName    string         `json:"userName"`
 	UID         int64          `json:"uid"`
@@ -182,6 +276,50 @@ type LiveMsg struct {
 	Mode        int            `gorm:"default:1" json:"mode"`                         // 弹幕模式: 1滚动 4底部 5顶部
 	FontSize    int            `gorm:"default:25" json:"fontSize"`                    // 字号
 	Color       int            `gorm:"default:16777215" json:"color"`                 // 颜色
+
+	OriginalMessage string `gorm:"-" json:"-"` // 弹幕规则改写前的内容，用于定位数据库记录
+}
+
+// LiveEvent 直播付费事件（礼物、SC、上舰）
+type LiveEvent struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+	SessionID string    `gorm:"uniqueIndex:idx_live_event_dedupe;not null" json:"sessionId"`
+	Type      string    `gorm:"uniqueIndex:idx_live_event_dedupe;index" json:"type"` // gift, sc, guard
+	Timestamp int64     `gorm:"uniqueIndex:idx_live_event_dedupe" json:"timestamp"`  // 相对于直播开始的时间戳（毫秒）
+	UserName  string    `gorm:"uniqueIndex:idx_live_event_dedupe" json:"userName"`
+	UID       int64     `gorm:"index" json:"uid"`
+	Name      string    `gorm:"uniqueIndex:idx_live_event_dedupe" json:"name"` // 礼物名称 / 大航海等级
+	Count     int       `gorm:"default:1" json:"count"`                        // 礼物数量 / 上舰月数
+	Price     float64   `gorm:"default:0" json:"price"`                        // 总价值（元），免费礼物为0
+	Message   string    `gorm:"type:text" json:"message"`                      // SC留言内容
+}
+
+// LiveSession 直播场次时间线，记录每场直播的起止、信息变更和人气峰值，与是否录制无关
+type LiveSession struct {
+	ID             uint       `gorm:"primarykey" json:"id"`
+	CreatedAt      time.Time  `json:"createdAt"`
+	UpdatedAt      time.Time  `json:"updatedAt"`
+	RoomID         string     `gorm:"index;not null" json:"roomId"`
+	StartTime      time.Time  `gorm:"index" json:"startTime"`
+	EndTime        *time.Time `gorm:"index" json:"endTime"` // 为空表示仍在直播
+	Title          string     `json:"title"`                // 当前直播标题
+	AreaName       string     `json:"areaName"`
+	ParentAreaName string     `json:"parentAreaName"`
+	CoverURL       string     `json:"coverUrl"`
+	PeakOnline     int64      `gorm:"default:0" json:"peakOnline"`      // 人气峰值
+	Events         string     `gorm:"type:text" json:"events"`          // 标题、分区、封面变更记录（JSON数组）
+	Source         string     `json:"source"`                           // 检测到开播的来源: 轮询/信息流
+	HistoryID      uint       `gorm:"default:0;index" json:"historyId"` // 对应的录制历史记录，未录制时为0
+}
+
+// DanmakuQuota 账号每日弹幕发送量
+type DanmakuQuota struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	UserID    uint      `gorm:"uniqueIndex:idx_danmaku_quota_user_date;not null" json:"userId"` // BiliBiliUser.ID
+	Date      string    `gorm:"uniqueIndex:idx_danmaku_quota_user_date;not null" json:"date"`   // 2006-01-02
+	Sent      int       `gorm:"default:0" json:"sent"`
 }
 
 // VideoSyncTask 视频同步任务
@@ -217,4 +355,8 @@ type SystemConfig struct {
 	OrphanScanInterval int       `gorm:"default:360" json:"orphanScanInterval"`   // 孤儿文件扫描间隔（分钟）
 	EnableDanmakuProxy bool      `gorm:"default:false" json:"enableDanmakuProxy"` // 启用弹幕代理池（全局配置）
 	DanmakuProxyList   string    `gorm:"type:text" json:"danmakuProxyList"`       // 代理列表，每行一个，格式: socks5://ip:port 或 http://user:pass@ip:port
+	DanmakuDailyQuota  int       `gorm:"default:0" json:"danmakuDailyQuota"`      // 每个账号每天最多发送的弹幕数，0为不限制
+	EnableLiveMonitor  bool      `gorm:"default:true" json:"enableLiveMonitor"`   // 通过直播信息流实时检测开播/下播，轮询作为兜底
+	LiveStatusWorkers  int       `gorm:"default:3" json:"liveStatusWorkers"`      // 批量查询未覆盖的房间逐个查询开播状态的并发数
+	LiveStatusJitter   int       `gorm:"default:1000" json:"liveStatusJitter"`    // 开播状态查询请求前的随机等待上限（毫秒），避免请求过于集中
 }
@@ -58,3 +58,42 @@ func ClearDanmakuProgress(historyID int64) {
 
 	delete(danmakuProgressMap, historyID)
 }
+
+// DanmakuParseProgress 弹幕解析进度
+type DanmakuParseProgress struct {
+	HistoryID int64  `json:"historyId"`
+	FileIndex int    `json:"fileIndex"` // 当前文件序号（从1开始）
+	FileTotal int    `json:"fileTotal"`
+	FileName  string `json:"fileName"`
+	Parsed    int    `json:"parsed"`   // 已解析条数
+	Inserted  int    `json:"inserted"` // 新增入库条数（重复弹幕不计）
+	Percent   int    `json:"percent"`
+	Parsing   bool   `json:"parsing"`
+	Completed bool   `json:"completed"`
+}
+
+var (
+	danmakuParseProgressMap = make(map[int64]*DanmakuParseProgress)
+	danmakuParseProgressMu  sync.RWMutex
+)
+
+// GetDanmakuParseProgress 获取弹幕解析进度
+func GetDanmakuParseProgress(historyID int64) *DanmakuParseProgress {
+	danmakuParseProgressMu.RLock()
+	defer danmakuParseProgressMu.RUnlock()
+
+	progress, exists := danmakuParseProgressMap[historyID]
+	if !exists {
+		return &DanmakuParseProgress{HistoryID: historyID}
+	}
+
+	return progress
+}
+
+// SetDanmakuParseProgress 设置弹幕解析进度
+func SetDanmakuParseProgress(progress DanmakuParseProgress) {
+	danmakuParseProgressMu.Lock()
+	defer danmakuParseProgressMu.Unlock()
+
+	danmakuParseProgressMap[progress.HistoryID] = &progress
+}
@@ -24,6 +24,7 @@ func SetupRoutes(router *gin.Engine) {
 				rooms.POST("", controllers.ListRooms)
 				rooms.POST("/add", controllers.AddRoom)
 				rooms.POST("/update", controllers.UpdateRoom)
+				rooms.POST("/testDmFilter", controllers.TestDmFilter)
 				rooms.GET("/delete/:id", controllers.DeleteRoom)
 
 				rooms.GET("/lines", controllers.GetUploadLines)
@@ -31,6 +32,8 @@ func SetupRoutes(router *gin.Engine) {
 				rooms.GET("/testLines", controllers.TestAllLines)
 				rooms.GET("/testSpeed", controllers.TestLineSpeed)
 				rooms.GET("/seasons/:roomId", controllers.GetSeasons)
+				rooms.GET("/seasonSections/:roomId", controllers.GetSeasonSections)
+				rooms.GET("/coverage/:roomId", controllers.GetRecordingCoverage)
 				rooms.GET("/verification", controllers.VerifyTemplate)
 			}
 
@@ -71,8 +74,14 @@ func SetupRoutes(router *gin.Engine) {
 				histories.POST("/sendDanmaku/:id", controllers.SendDanmaku)
 				histories.POST("/batchSendDanmaku", controllers.BatchSendDanmaku)
 				histories.GET("/danmakuStats/:id", controllers.GetDanmakuStats)
+				histories.GET("/danmakuAnalytics/:id", controllers.GetDanmakuAnalytics)
+				histories.GET("/danmakuOffset/:id", controllers.GetDanmakuOffsets)
+				histories.POST("/danmakuOffset/:id", controllers.SetDanmakuOffset)
+				histories.GET("/revenue/:id", controllers.GetHistoryRevenue)
 				histories.POST("/parseDanmaku/:id", controllers.ParseDanmaku)
 				histories.POST("/batchParseDanmaku", controllers.BatchParseDanmaku)
+				histories.POST("/importDanmaku/:id", controllers.ImportDanmaku)
+				histories.GET("/exportDanmaku/:id", controllers.ExportDanmaku)
 
 				// 文件移动
 				histories.POST("/moveFiles/:id", controllers.MoveFiles)
@@ -82,6 +91,20 @@ func SetupRoutes(router *gin.Engine) {
 				histories.POST("/syncVideo/:id", controllers.SyncVideoInfo)
 				histories.POST("/batchSyncVideo", controllers.BatchSyncVideo)
 				histories.POST("/createSyncTask/:id", controllers.CreateSyncTask)
+				histories.POST("/remediate/:id", controllers.RemediateRejected)
+				histories.POST("/mergeParts/:id", controllers.MergeHistoryParts)
+				histories.POST("/approvePublic/:id", controllers.ApprovePublic)
+				histories.POST("/burnIn/:id", controllers.BurnInHistory)
+				histories.POST("/clip/:id", controllers.ExtractClip)
+			}
+
+			// 高能剪辑
+			highlights := auth.Group("/highlight")
+			{
+				highlights.GET("/list/:historyId", controllers.ListHighlightClips)
+				highlights.GET("/scores/:historyId", controllers.GetHighlightScores)
+				highlights.POST("/regenerate/:historyId", controllers.RegenerateHighlightClips)
+				highlights.POST("/delete/:id", controllers.DeleteHighlightClip)
 			}
 
 			// 视频同步任务
@@ -173,5 +196,6 @@ func SetupRoutes(router *gin.Engine) {
 		progress.GET("/part/:partId", controllers.GetPartProgress)
 		progress.GET("/history/:historyId", controllers.GetHistoryProgress)
 		progress.GET("/danmaku/:historyId", controllers.GetDanmakuProgress)
+		progress.GET("/danmakuParse/:historyId", controllers.GetDanmakuParseProgress)
 	}
 }
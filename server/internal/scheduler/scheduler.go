@@ -76,13 +76,14 @@ func InitScheduler() {
 		}
 	})
 
-	// 直播状态监控 - 每5分钟执行一次
+	// 直播状态监控 - 每5分钟执行一次，作为直播信息流的兜底，同时同步信息流订阅的房间
 	cronJob.AddFunc("*/5 * * * *", func() {
 		log.Println("执行定时任务: 直播状态监控")
 		liveStatusService := services.NewLiveStatusService()
 		if err := liveStatusService.UpdateAllRoomsStatus(); err != nil {
 			log.Printf("直播状态监控失败: %v", err)
 		}
+		services.GetLiveMonitorService().SyncRooms()
 	})
 
 	// 清理已完成的同步任务 - 每天凌晨3点执行
@@ -126,6 +127,14 @@ func InitScheduler() {
 		}
 	})
 
+	// 碎片合并 - 每10分钟执行一次，在自动上传前合并断流重连产生的连续短分P，并处理低于时长/大小限制的分P
+	cronJob.AddFunc("7-59/10 * * * *", func() {
+		autoUploadSvc := services.NewAutoUploadService()
+		if err := autoUploadSvc.MergePendingFragments(); err != nil {
+			log.Printf("碎片合并任务失败: %v", err)
+		}
+	})
+
 	// 自动上传任务 - 每10分钟执行一次，检查并处理待上传的分P
 	cronJob.AddFunc("*/10 * * * *", func() {
 		log.Println("执行定时任务: 自动上传检查")
@@ -134,6 +143,36 @@ func InitScheduler() {
 		}
 	})
 
+	// 高能集锦投稿 - 每10分钟执行一次，为已完成的高能剪辑创建稿件并加入上传队列
+	cronJob.AddFunc("2-59/10 * * * *", func() {
+		if err := processHighlightPublish(); err != nil {
+			log.Printf("高能集锦投稿任务失败: %v", err)
+		}
+	})
+
+	// 审核退回自动处理 - 每10分钟执行一次
+	cronJob.AddFunc("5-59/10 * * * *", func() {
+		log.Println("执行定时任务: 审核退回处理")
+		if err := uploadService.ProcessPendingRemediations(); err != nil {
+			log.Printf("审核退回处理失败: %v", err)
+		}
+	})
+
+	// 计划公开稿件 - 每5分钟执行一次
+	cronJob.AddFunc("*/5 * * * *", func() {
+		visibilityService := services.NewVisibilityService()
+		if err := visibilityService.ProcessScheduledPublic(); err != nil {
+			log.Printf("计划公开任务失败: %v", err)
+		}
+	})
+
+	// 继续发送暂停的弹幕 - 每10分钟检查一次（配额用尽次日继续、视频未审核稍后重试）
+	cronJob.AddFunc("*/10 * * * *", func() {
+		if err := services.NewDanmakuService().ResumePendingDanmaku(); err != nil {
+			log.Printf("继续发送弹幕失败: %v", err)
+		}
+	})
+
 	// 房间自动任务 - 每30分钟执行一次，处理房间级别的自动同步和弹幕任务
 	cronJob.AddFunc("*/30 * * * *", func() {
 		log.Println("执行定时任务: 房间自动任务")
@@ -145,6 +184,9 @@ func InitScheduler() {
 
 	cronJob.Start()
 	log.Println("调度器已启动")
+
+	// 启动直播信息流订阅
+	go services.GetLiveMonitorService().SyncRooms()
 }
 
 // isFeatureEnabled 检查功能是否启用
@@ -276,8 +318,32 @@ func processAutoUpload() error {
 	return nil
 }
 
+// processHighlightPublish 创建高能集锦稿件，并通过上传队列上传，全部上传后自动投稿
+func processHighlightPublish() error {
+	highlightSvc := services.NewHighlightPublishService()
+	if err := highlightSvc.PrepareArchives(time.Now()); err != nil {
+		return err
+	}
+
+	tasks, err := highlightSvc.PendingUploads()
+	if err != nil {
+		return err
+	}
+
+	for _, task := range tasks {
+		if err := uploadService.UploadPart(&task.Part, &task.History, &task.Room); err != nil {
+			log.Printf("[高能投稿] 加入队列失败: part_id=%d, error=%v", task.Part.ID, err)
+		}
+	}
+	if len(tasks) > 0 {
+		log.Printf("[高能投稿] %d 个高能集锦分P已加入上传队列", len(tasks))
+	}
+	return nil
+}
+
 func StopScheduler() {
 	if cronJob != nil {
 		cronJob.Stop()
 	}
+	services.GetLiveMonitorService().StopAll()
 }
@@ -1,7 +1,9 @@
 package services
 
 import (
+	"fmt"
 	"log"
+	"sync"
 	"time"
 
 	"github.com/gobup/server/internal/database"
@@ -16,56 +18,50 @@ func NewAutoUploadService() *AutoUploadService {
 	return &AutoUploadService{}
 }
 
-// ProcessPendingUploads 处理待上传的分P
-// 根据房间的Upload和AutoUpload设置，自动将录制完成的分P加入上传队列
-func (s *AutoUploadService) ProcessPendingUploads() error {
+// GetPendingUploadParts 获取所有待上传的分P（供upload服务调用）
+func (s *AutoUploadService) GetPendingUploadParts() ([]PendingUploadTask, error) {
 	db := database.GetDB()
 
-	// 查询所有启用了上传功能的房间
+	// 查询所有启用了上传功能和自动上传的房间
 	var rooms []models.RecordRoom
-	if err := db.Where("upload = ?", true).Find(&rooms).Error; err != nil {
-		return err
-	}
-
-	if len(rooms) == 0 {
-		return nil
+	if err := db.Where("upload = ? AND auto_upload = ?", true, true).Find(&rooms).Error; err != nil {
+		return nil, err
 	}
 
-	log.Printf("[自动上传] 开始检查 %d 个房间的待上传分P", len(rooms))
-
-	totalProcessed := 0
-	totalQueued := 0
+	var tasks []PendingUploadTask
 
 	for _, room := range rooms {
-		// 只处理启用了"自动上传分P"的房间
-		if !room.AutoUpload {
-			continue
-		}
-
 		// 检查房间是否配置了上传用户
 		if room.UploadUserID == 0 {
 			continue
 		}
 
 		// 查询该房间所有录制完成但未上传的分P
-		// 条件：recording=false（录制完成）, upload=false（未上传）, uploading=false（未在上传中）
 		var parts []models.RecordHistoryPart
 		if err := db.Where(
-			"room_id = ? AND recording = ? AND upload = ? AND uploading = ?",
-			room.RoomID, false, false, false,
+			"room_id = ? AND recording = ? AND upload = ? AND uploading = ? AND excluded = ?",
+			room.RoomID, false, false, false, false,
 		).Order("start_time ASC").Find(&parts).Error; err != nil {
 			log.Printf("[自动上传] 查询房间 %s 的待上传分P失败: %v", room.RoomID, err)
 			continue
 		}
 
-		if len(parts) == 0 {
-			continue
-		}
+		for _, part := range parts {
+			// 跳过没有文件路径的分P
+			if part.FilePath == "" {
+				continue
+			}
 
-		log.Printf("[自动上传] 房间 %s (%s) 有 %d 个待上传分P", room.RoomID, room.Uname, len(parts))
+			// 跳过尚未经过碎片合并阶段检查的分P
+			if room.MergeFragments && part.MergeState == MergeStatePending {
+				continue
+			}
+
+			// 跳过速率限制冷却期中的分P
+			if part.RateLimitCooldownAt != nil && time.Now().Before(*part.RateLimitCooldownAt) {
+				continue
+			}
 
-		// 为每个分P加入上传队列
-		for _, part := range parts {
 			// 获取对应的历史记录
 			var history models.RecordHistory
 			if err := db.First(&history, part.HistoryID).Error; err != nil {
@@ -74,96 +70,283 @@ func (s *AutoUploadService) ProcessPendingUploads() error {
 				continue
 			}
 
-			// 检查文件是否存在
-			if part.FilePath == "" {
-				log.Printf("[自动上传] 跳过没有文件路径的分P: part_id=%d", part.ID)
+			// 高能集锦稿件由高能投稿任务使用单独的配置上传
+			if history.HighlightArchive != HighlightPublishOff {
 				continue
 			}
 
-			// 跳过速率限制冷却期中的分P
-			if part.RateLimitCooldownAt != nil && time.Now().Before(*part.RateLimitCooldownAt) {
-				remainingTime := time.Until(*part.RateLimitCooldownAt)
-				log.Printf("[自动上传] 跳过速率限制冷却期中的分P: part_id=%d, 剩余%.0f分钟",
-					part.ID, remainingTime.Minutes())
+			// 低于时长/大小限制的分P由碎片合并阶段按房间配置跳过或合并（手动剪辑的片段不受限制）
+			if history.SourceHistoryID == 0 && s.CheckPartLimits(&room, &part) != "" {
 				continue
 			}
 
-			// 将分P加入上传队列
-			// 注意：这里需要通过upload服务来加入队列，不能直接操作
-			// 由于循环依赖问题，我们返回需要上传的分P列表，由调用方处理
-			totalProcessed++
+			tasks = append(tasks, PendingUploadTask{
+				Part:    part,
+				History: history,
+				Room:    room,
+			})
 		}
+	}
 
-		if len(parts) > 0 {
-			totalQueued += len(parts)
-		}
+	return tasks, nil
+}
+
+// fragmentMergeMu 碎片合并阶段耗时较长，避免上一轮未结束时重复执行
+var fragmentMergeMu sync.Mutex
+
+// MergePendingFragments 碎片合并阶段：合并连续的短分P，并对低于限制的分P执行跳过或合并
+// 与上传阶段分开执行，上传阶段只上传已经过本阶段检查的分P
+func (s *AutoUploadService) MergePendingFragments() error {
+	if !fragmentMergeMu.TryLock() {
+		log.Printf("[自动上传] 上一轮碎片合并尚未完成，跳过")
+		return nil
 	}
+	defer fragmentMergeMu.Unlock()
 
-	if totalProcessed > 0 {
-		log.Printf("[自动上传] 检查完成，发现 %d 个待上传分P", totalProcessed)
+	db := database.GetDB()
+	var rooms []models.RecordRoom
+	if err := db.Where("upload = ? AND auto_upload = ?", true, true).Find(&rooms).Error; err != nil {
+		return err
 	}
 
+	for _, room := range rooms {
+		if room.UploadUserID == 0 {
+			continue
+		}
+		if room.MergeFragments {
+			s.mergeRoomFragments(&room)
+		}
+		if room.DurationLimit > 0 || room.FileSizeLimit > 0 {
+			s.applyRoomPartLimits(&room)
+		}
+	}
 	return nil
 }
 
-// GetPendingUploadParts 获取所有待上传的分P（供upload服务调用）
-func (s *AutoUploadService) GetPendingUploadParts() ([]PendingUploadTask, error) {
+// mergeRoomFragments 对房间内有待检查分P的历史记录执行短分P合并
+func (s *AutoUploadService) mergeRoomFragments(room *models.RecordRoom) {
 	db := database.GetDB()
 
-	// 查询所有启用了上传功能和自动上传的房间
-	var rooms []models.RecordRoom
-	if err := db.Where("upload = ? AND auto_upload = ?", true, true).Find(&rooms).Error; err != nil {
-		return nil, err
+	var historyIDs []uint
+	if err := db.Model(&models.RecordHistoryPart{}).
+		Where("room_id = ? AND recording = ? AND upload = ? AND uploading = ? AND excluded = ? AND merge_state = ?",
+			room.RoomID, false, false, false, false, MergeStatePending).
+		Distinct().Pluck("history_id", &historyIDs).Error; err != nil {
+		log.Printf("[自动上传] 查询房间 %s 待合并的历史记录失败: %v", room.RoomID, err)
+		return
 	}
 
-	var tasks []PendingUploadTask
+	mergeSvc := NewPartMergeService()
+	for _, historyID := range historyIDs {
+		var history models.RecordHistory
+		if err := db.First(&history, historyID).Error; err != nil || history.HighlightArchive != HighlightPublishOff {
+			continue
+		}
+		if _, err := mergeSvc.MergeFragments(room, &history); err != nil {
+			log.Printf("[自动上传] 合并短分P失败: history_id=%d, %v", historyID, err)
+		}
+	}
+}
 
-	for _, room := range rooms {
-		// 检查房间是否配置了上传用户
-		if room.UploadUserID == 0 {
+// applyRoomPartLimits 对房间内低于时长/大小限制的待上传分P执行跳过或合并
+// 合并会改变相邻分P的状态，每个分P处理前重新查询
+func (s *AutoUploadService) applyRoomPartLimits(room *models.RecordRoom) {
+	db := database.GetDB()
+
+	var partIDs []uint
+	if err := db.Model(&models.RecordHistoryPart{}).
+		Where("room_id = ? AND recording = ? AND upload = ? AND uploading = ? AND excluded = ?",
+			room.RoomID, false, false, false, false).
+		Order("start_time ASC").
+		Pluck("id", &partIDs).Error; err != nil {
+		log.Printf("[自动上传] 查询房间 %s 待检查限制的分P失败: %v", room.RoomID, err)
+		return
+	}
+
+	for _, partID := range partIDs {
+		var part models.RecordHistoryPart
+		if err := db.Where("id = ? AND recording = ? AND upload = ? AND uploading = ? AND excluded = ?",
+			partID, false, false, false, false).First(&part).Error; err != nil {
+			continue
+		}
+		if part.FilePath == "" || (room.MergeFragments && part.MergeState == MergeStatePending) {
 			continue
 		}
 
-		// 查询该房间所有录制完成但未上传的分P
-		var parts []models.RecordHistoryPart
-		if err := db.Where(
-			"room_id = ? AND recording = ? AND upload = ? AND uploading = ?",
-			room.RoomID, false, false, false,
-		).Order("start_time ASC").Find(&parts).Error; err != nil {
-			log.Printf("[自动上传] 查询房间 %s 的待上传分P失败: %v", room.RoomID, err)
+		var history models.RecordHistory
+		if err := db.First(&history, part.HistoryID).Error; err != nil {
+			continue
+		}
+		// 高能集锦稿件和手动剪辑的片段不受限制
+		if history.HighlightArchive != HighlightPublishOff || history.SourceHistoryID != 0 {
 			continue
 		}
 
-		for _, part := range parts {
-			// 跳过没有文件路径的分P
-			if part.FilePath == "" {
-				continue
-			}
+		s.applyPartLimits(room, &history, &part)
+	}
+}
 
-			// 跳过速率限制冷却期中的分P
-			if part.RateLimitCooldownAt != nil && time.Now().Before(*part.RateLimitCooldownAt) {
-				continue
-			}
+// CheckPartLimits 检查分P是否满足房间的时长/大小限制，不满足时返回原因
+func (s *AutoUploadService) CheckPartLimits(room *models.RecordRoom, part *models.RecordHistoryPart) string {
+	if room.FileSizeLimit > 0 && part.FileSize < room.FileSizeLimit*1024*1024 {
+		return fmt.Sprintf("文件大小%.1fMB低于限制%dMB", float64(part.FileSize)/1024/1024, room.FileSizeLimit)
+	}
 
-			// 获取对应的历史记录
-			var history models.RecordHistory
-			if err := db.First(&history, part.HistoryID).Error; err != nil {
-				log.Printf("[自动上传] 获取历史记录失败: part_id=%d, history_id=%d, error=%v",
-					part.ID, part.HistoryID, err)
-				continue
-			}
+	if room.DurationLimit > 0 {
+		// 时长未知时不做限制
+		duration := NewMediaService().EnsurePartDuration(part)
+		if duration > 0 && duration < room.DurationLimit {
+			return fmt.Sprintf("时长%d秒低于限制%d秒", duration, room.DurationLimit)
+		}
+	}
 
-			tasks = append(tasks, PendingUploadTask{
-				Part:    part,
-				History: history,
-				Room:    room,
-			})
+	return ""
+}
+
+// applyPartLimits 对低于限制的分P执行跳过或合并，满足限制的分P不做处理
+func (s *AutoUploadService) applyPartLimits(room *models.RecordRoom, history *models.RecordHistory, part *models.RecordHistoryPart) {
+	reason := s.CheckPartLimits(room, part)
+	if reason == "" {
+		return
+	}
+
+	if room.ShortPartAction == ShortPartActionMerge {
+		merged, deferred, err := s.mergeShortPart(history, part, reason)
+		if deferred {
+			log.Printf("[自动上传] 分P低于限制，等待相邻分P录制完成后合并: part_id=%d, %s", part.ID, reason)
+			return
+		}
+		if err == nil && merged != nil {
+			log.Printf("[自动上传] 分P低于限制，已与相邻分P合并: part_id=%d -> part_id=%d, %s", part.ID, merged.ID, reason)
+			return
+		}
+		if err != nil {
+			reason = fmt.Sprintf("%s，合并失败: %v", reason, err)
 		}
 	}
 
-	return tasks, nil
+	db := database.GetDB()
+	db.Model(part).Updates(map[string]interface{}{
+		"excluded":       true,
+		"exclude_reason": reason,
+	})
+	log.Printf("[自动上传] 跳过低于限制的分P: part_id=%d, file=%s, %s", part.ID, part.FileName, reason)
+}
+
+// mergeShortPart 将过短的分P与相邻的未上传分P合并，相邻分P仍在录制时返回deferred
+func (s *AutoUploadService) mergeShortPart(history *models.RecordHistory, part *models.RecordHistoryPart, reason string) (*models.RecordHistoryPart, bool, error) {
+	db := database.GetDB()
+
+	var siblings []models.RecordHistoryPart
+	if err := db.Where("history_id = ? AND excluded = ? AND file_delete = ?", part.HistoryID, false, false).
+		Order("start_time ASC").
+		Find(&siblings).Error; err != nil {
+		return nil, false, err
+	}
+
+	idx := -1
+	for i := range siblings {
+		if siblings[i].ID == part.ID {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		return nil, false, fmt.Errorf("未找到分P")
+	}
+
+	// 优先与后一个分P合并
+	if idx+1 < len(siblings) {
+		next := siblings[idx+1]
+		if next.Recording {
+			return nil, true, nil
+		}
+		if !next.Upload && !next.Uploading {
+			merged, err := s.mergeClaimedParts([]models.RecordHistoryPart{*part, next}, reason)
+			return merged, false, err
+		}
+	} else if history.Recording {
+		// 直播仍在录制，等待后续分P
+		return nil, true, nil
+	}
+
+	if idx > 0 {
+		prev := siblings[idx-1]
+		if !prev.Upload && !prev.Uploading && !prev.Recording {
+			merged, err := s.mergeClaimedParts([]models.RecordHistoryPart{prev, *part}, reason)
+			return merged, false, err
+		}
+	}
+
+	return nil, false, fmt.Errorf("没有可合并的相邻未上传分P")
 }
 
+// mergeClaimingReason 合并期间分P的排除原因，合并失败时据此恢复
+const mergeClaimingReason = "等待合并"
+
+// mergeClaimedParts 合并前先将分P标记为不参与投稿，防止合并期间被上传队列取走，合并失败时恢复
+func (s *AutoUploadService) mergeClaimedParts(parts []models.RecordHistoryPart, reason string) (*models.RecordHistoryPart, error) {
+	db := database.GetDB()
+
+	ids := make([]uint, 0, len(parts))
+	for _, part := range parts {
+		ids = append(ids, part.ID)
+	}
+	release := func() {
+		db.Model(&models.RecordHistoryPart{}).
+			Where("id IN ? AND exclude_reason = ?", ids, mergeClaimingReason).
+			Updates(map[string]interface{}{"excluded": false, "exclude_reason": ""})
+	}
+
+	claim := db.Model(&models.RecordHistoryPart{}).
+		Where("id IN ? AND upload = ? AND uploading = ? AND excluded = ?", ids, false, false, false).
+		Updates(map[string]interface{}{"excluded": true, "exclude_reason": mergeClaimingReason})
+	if claim.Error != nil {
+		return nil, claim.Error
+	}
+	if claim.RowsAffected != int64(len(ids)) {
+		release()
+		return nil, fmt.Errorf("相邻分P已开始上传")
+	}
+
+	merged, err := NewPartMergeService().MergeParts(parts, reason)
+	if err != nil {
+		release()
+	}
+	return merged, err
+}
+
+// FilterPublishableParts 过滤投稿分P中低于限制的部分，返回剩余分P及其总时长
+// 已上传的分P无法再合并，只在本次投稿中跳过，跳过原因记录在分P上供页面展示
+func (s *AutoUploadService) FilterPublishableParts(room *models.RecordRoom, parts []models.RecordHistoryPart) ([]models.RecordHistoryPart, int) {
+	db := database.GetDB()
+
+	var result []models.RecordHistoryPart
+	totalDuration := 0
+	for i := range parts {
+		part := &parts[i]
+		reason := s.CheckPartLimits(room, part)
+		if reason != part.PublishSkipReason {
+			part.PublishSkipReason = reason
+			db.Model(part).Update("publish_skip_reason", reason)
+		}
+		if reason != "" {
+			log.Printf("[投稿] 跳过低于限制的分P: part_id=%d, file=%s, %s", part.ID, part.FileName, reason)
+			continue
+		}
+		totalDuration += NewMediaService().EnsurePartDuration(part)
+		result = append(result, *part)
+	}
+
+	return result, totalDuration
+}
+
+// 低于限制分P的处理方式
+const (
+	ShortPartActionSkip  = 0 // 跳过
+	ShortPartActionMerge = 1 // 合并到相邻分P
+)
+
 // PendingUploadTask 待上传任务
 type PendingUploadTask struct {
 	Part    models.RecordHistoryPart
@@ -0,0 +1,245 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gobup/server/internal/database"
+	"github.com/gobup/server/internal/models"
+	"gorm.io/gorm"
+)
+
+// maxClipDuration 单次剪辑的最大时长
+const maxClipDuration = 12 * time.Hour
+
+// clipExtractMu 剪辑任务串行执行，避免多个ffmpeg重新编码同时占满CPU
+var clipExtractMu sync.Mutex
+
+// ClipExtractRequest 片段剪辑请求，时间相对直播开始
+type ClipExtractRequest struct {
+	Start        int64  // 毫秒
+	End          int64  // 毫秒
+	Exact        bool   // 重新编码以精确到帧，否则直接复制流（对齐到关键帧）
+	Title        string // 新历史记录的直播标题，留空沿用原标题
+	PublishTitle string // 投稿标题，留空按房间模板生成
+	PublishDesc  string // 投稿简介，留空按房间模板生成
+	PublishTags  string // 投稿标签，留空使用房间标签
+}
+
+// ClipExtractResult 剪辑任务信息
+type ClipExtractResult struct {
+	HistoryID uint    `json:"historyId"`
+	PartID    uint    `json:"partId"`
+	FilePath  string  `json:"filePath"`
+	Pieces    int     `json:"pieces"`   // 跨分P拆分后的片段数
+	Duration  float64 `json:"duration"` // 分P视频实际覆盖的时长（秒），不含分P之间的空档
+}
+
+// ClipExtractService 从直播录像中剪辑任意时间段，作为新的历史记录参与上传投稿
+type ClipExtractService struct{}
+
+// NewClipExtractService 创建片段剪辑服务
+func NewClipExtractService() *ClipExtractService {
+	return &ClipExtractService{}
+}
+
+// ParseClipTime 解析时间，支持 HH:MM:SS(.mmm)、MM:SS 和秒数，返回毫秒
+func ParseClipTime(value string) (int64, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, fmt.Errorf("时间不能为空")
+	}
+
+	fields := strings.Split(value, ":")
+	if len(fields) > 3 {
+		return 0, fmt.Errorf("时间格式错误: %s", value)
+	}
+
+	var total float64
+	for i, field := range fields {
+		v, err := strconv.ParseFloat(field, 64)
+		if err != nil || v < 0 {
+			return 0, fmt.Errorf("时间格式错误: %s", value)
+		}
+		// 只有最后一段（秒）允许小数，分和秒不能超过59
+		if i < len(fields)-1 && v != float64(int64(v)) {
+			return 0, fmt.Errorf("时间格式错误: %s", value)
+		}
+		if i > 0 && v >= 60 {
+			return 0, fmt.Errorf("时间格式错误: %s", value)
+		}
+		total = total*60 + v
+	}
+	return int64(total * 1000), nil
+}
+
+// ExtractClip 校验时间范围并创建新的历史记录和分P，剪辑在后台执行
+// 剪辑完成前分P处于录制中状态，不会被自动上传
+func (s *ClipExtractService) ExtractClip(historyID uint, req ClipExtractRequest) (*ClipExtractResult, error) {
+	db := database.GetDB()
+
+	if req.Start < 0 || req.End <= req.Start {
+		return nil, fmt.Errorf("结束时间必须晚于开始时间")
+	}
+	if time.Duration(req.End-req.Start)*time.Millisecond > maxClipDuration {
+		return nil, fmt.Errorf("剪辑时长不能超过%d小时", int(maxClipDuration.Hours()))
+	}
+
+	var source models.RecordHistory
+	if err := db.First(&source, historyID).Error; err != nil {
+		return nil, fmt.Errorf("历史记录不存在: %w", err)
+	}
+
+	var parts []models.RecordHistoryPart
+	if err := db.Where("history_id = ? AND merged_into = 0 AND recording = ?", historyID, false).
+		Order("start_time ASC").
+		Find(&parts).Error; err != nil {
+		return nil, fmt.Errorf("查询分P失败: %w", err)
+	}
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("没有视频文件")
+	}
+
+	// 复用高能剪辑的时间轴映射：按弹幕偏移校准结果定位各分P，跨分P的范围拆分后拼接
+	cutSvc := NewHighEnergyCutService()
+	clips := mapSegmentsToParts(cutSvc.partRanges(&source, parts), []TimeSegment{{Start: req.Start, End: req.End}})
+	if len(clips) == 0 {
+		return nil, fmt.Errorf("时间范围不在任何分P视频范围内")
+	}
+
+	var covered int64
+	for _, clip := range clips {
+		covered += clip.end - clip.start
+	}
+
+	sourceFile := clips[0].part.FilePath
+	outputFile := filepath.Join(filepath.Dir(sourceFile), fmt.Sprintf("%s_clip_%s-%s_%d.mp4",
+		strings.TrimSuffix(filepath.Base(sourceFile), filepath.Ext(sourceFile)),
+		clipTimeLabel(req.Start), clipTimeLabel(req.End), time.Now().Unix()))
+
+	title := req.Title
+	if title == "" {
+		title = source.Title
+	}
+	startTime := source.StartTime.Add(time.Duration(req.Start) * time.Millisecond)
+	endTime := startTime.Add(time.Duration(covered) * time.Millisecond)
+
+	history := models.RecordHistory{
+		RoomID:          source.RoomID,
+		SessionID:       fmt.Sprintf("clip-%d-%d", source.ID, time.Now().UnixNano()),
+		Uname:           source.Uname,
+		Title:           title,
+		AreaName:        source.AreaName,
+		CoverURL:        source.CoverURL,
+		StartTime:       startTime,
+		EndTime:         endTime,
+		Upload:          true,
+		Message:         "片段剪辑中",
+		SourceHistoryID: source.ID,
+		ClipStart:       req.Start,
+		ClipEnd:         req.End,
+		PublishTitle:    req.PublishTitle,
+		PublishDesc:     req.PublishDesc,
+		PublishTags:     req.PublishTags,
+	}
+	part := models.RecordHistoryPart{
+		RoomID:    source.RoomID,
+		Title:     title,
+		LiveTitle: title,
+		AreaName:  source.AreaName,
+		FilePath:  outputFile,
+		FileName:  filepath.Base(outputFile),
+		StartTime: startTime,
+		EndTime:   endTime,
+		Recording: true,
+	}
+
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&history).Error; err != nil {
+			return err
+		}
+		part.HistoryID = history.ID
+		part.SessionID = history.SessionID
+		return tx.Create(&part).Error
+	}); err != nil {
+		return nil, fmt.Errorf("创建历史记录失败: %w", err)
+	}
+
+	log.Printf("[片段剪辑] 创建剪辑任务: 来源=%d, 范围=%s-%s, 片段数=%d, 精确=%v, history_id=%d",
+		source.ID, clipTimeLabel(req.Start), clipTimeLabel(req.End), len(clips), req.Exact, history.ID)
+
+	go s.runClip(cutSvc, clips, &history, &part, req.Exact)
+
+	return &ClipExtractResult{
+		HistoryID: history.ID,
+		PartID:    part.ID,
+		FilePath:  outputFile,
+		Pieces:    len(clips),
+		Duration:  float64(covered) / 1000,
+	}, nil
+}
+
+// runClip 执行剪辑，完成后结束分P的录制状态以进入正常的上传流程；失败时保留记录并写入错误信息
+func (s *ClipExtractService) runClip(cutSvc *HighEnergyCutService, clips []partClip, history *models.RecordHistory, part *models.RecordHistoryPart, exact bool) {
+	clipExtractMu.Lock()
+	defer clipExtractMu.Unlock()
+
+	db := database.GetDB()
+
+	if err := cutSvc.cutVideoSegments(clips, part.FilePath, exact); err != nil {
+		log.Printf("[片段剪辑] ❌ 剪辑失败 history_id=%d: %v", history.ID, err)
+		db.Model(part).Updates(map[string]interface{}{
+			"recording":        false,
+			"excluded":         true,
+			"exclude_reason":   "片段剪辑失败",
+			"upload_error_msg": err.Error(),
+		})
+		db.Model(history).Update("message", fmt.Sprintf("片段剪辑失败: %v", err))
+		return
+	}
+
+	updates := map[string]interface{}{"recording": false}
+	if info, err := os.Stat(part.FilePath); err == nil {
+		updates["file_size"] = info.Size()
+	}
+	db.Model(part).Updates(updates)
+
+	// 以实际文件时长为准
+	if duration := NewMediaService().EnsurePartDuration(part); duration > 0 {
+		endTime := part.StartTime.Add(time.Duration(duration) * time.Second)
+		db.Model(part).Update("end_time", endTime)
+		db.Model(history).Update("end_time", endTime)
+	}
+	db.Model(history).Update("message", "片段剪辑完成")
+
+	log.Printf("[片段剪辑] ✓ 剪辑完成 history_id=%d: %s", history.ID, part.FilePath)
+}
+
+// RecoverInterrupted 服务重启前未完成的片段剪辑标记为失败，避免分P一直处于录制中
+func (s *ClipExtractService) RecoverInterrupted() {
+	db := database.GetDB()
+	result := db.Model(&models.RecordHistoryPart{}).
+		Where("recording = ? AND history_id IN (?)", true,
+			db.Model(&models.RecordHistory{}).Select("id").Where("source_history_id > ?", 0)).
+		Updates(map[string]interface{}{
+			"recording":        false,
+			"excluded":         true,
+			"exclude_reason":   "片段剪辑失败",
+			"upload_error_msg": "服务重启，剪辑中断",
+		})
+	if result.RowsAffected > 0 {
+		log.Printf("[片段剪辑] %d 个未完成的剪辑因服务重启标记为失败", result.RowsAffected)
+	}
+}
+
+// clipTimeLabel 将毫秒格式化为文件名可用的 HHMMSS
+func clipTimeLabel(ms int64) string {
+	seconds := ms / 1000
+	return fmt.Sprintf("%02d%02d%02d", seconds/3600, seconds%3600/60, seconds%60)
+}
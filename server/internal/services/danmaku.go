@@ -3,7 +3,6 @@ package services
 import (
 	"fmt"
 	"log"
-	"math/rand"
 	"strings"
 	"sync"
 	"time"
@@ -12,6 +11,7 @@ import (
 	"github.com/gobup/server/internal/database"
 	"github.com/gobup/server/internal/models"
 	danmakuprogress "github.com/gobup/server/internal/progress"
+	"gorm.io/gorm"
 )
 
 type DanmakuService struct {
@@ -113,8 +113,15 @@ func (s *DanmakuService) getValidUsers() ([]models.BiliBiliUser, error) {
 	return validUsers, nil
 }
 
-// sendDanmakuForHistoryWithSerialUsers 使用多个用户串行发送弹幕
-func (s *DanmakuService) sendDanmakuForHistoryWithSerialUsers(historyID uint) error {
+// danmakuSendPlan 经过过滤、去重和时间校准后的发送计划
+type danmakuSendPlan struct {
+	history    models.RecordHistory
+	validUsers []models.BiliBiliUser
+	pending    []*pendingDanmaku
+}
+
+// buildDanmakuSendPlan 查询待发送弹幕并映射到各分P的CID和进度，preview为true时不检查是否已发送
+func (s *DanmakuService) buildDanmakuSendPlan(historyID uint, preview bool) (*danmakuSendPlan, error) {
 	db := database.GetDB()
 
 	log.Printf("[弹幕发送] 步骤1: 开始处理历史记录 %d", historyID)
@@ -123,25 +130,25 @@ func (s *DanmakuService) sendDanmakuForHistoryWithSerialUsers(historyID uint) er
 	var history models.RecordHistory
 	if err := db.First(&history, historyID).Error; err != nil {
 		log.Printf("[弹幕发送] ❌ 历史记录不存在: %v", err)
-		return fmt.Errorf("历史记录不存在: %w", err)
+		return nil, fmt.Errorf("历史记录不存在: %w", err)
 	}
 
 	log.Printf("[弹幕发送] 步骤2: 检查视频状态 (BV号=%s, 已发送=%v)", history.BvID, history.DanmakuSent)
 
 	if history.BvID == "" {
 		log.Printf("[弹幕发送] ❌ 视频尚未投稿")
-		return fmt.Errorf("视频尚未投稿")
+		return nil, fmt.Errorf("视频尚未投稿")
 	}
 
 	// 检查BV号格式
 	if !strings.HasPrefix(history.BvID, "BV") {
 		log.Printf("[弹幕发送] ❌ 无效的BV号格式: %s", history.BvID)
-		return fmt.Errorf("无效的BV号格式")
+		return nil, fmt.Errorf("无效的BV号格式")
 	}
 
-	if history.DanmakuSent {
+	if history.DanmakuSent && !preview {
 		log.Printf("[弹幕发送] ⚠️ 弹幕已发送，跳过")
-		return fmt.Errorf("弹幕已发送，请勿重复操作")
+		return nil, fmt.Errorf("弹幕已发送，请勿重复操作")
 	}
 
 	log.Printf("[弹幕发送] 步骤3: 获取有效的B站用户")
@@ -150,7 +157,7 @@ func (s *DanmakuService) sendDanmakuForHistoryWithSerialUsers(historyID uint) er
 	validUsers, err := s.getValidUsers()
 	if err != nil {
 		log.Printf("[弹幕发送] ❌ 获取有效用户失败: %v", err)
-		return err
+		return nil, err
 	}
 
 	log.Printf("[弹幕发送] 步骤4: 获取房间配置 (room_id=%s)", history.RoomID)
@@ -159,55 +166,33 @@ func (s *DanmakuService) sendDanmakuForHistoryWithSerialUsers(historyID uint) er
 	var room models.RecordRoom
 	if err := db.Where("room_id = ?", history.RoomID).First(&room).Error; err != nil {
 		log.Printf("[弹幕发送] ❌ 房间配置不存在: %v", err)
-		return fmt.Errorf("房间配置不存在: %w", err)
+		return nil, fmt.Errorf("房间配置不存在: %w", err)
 	}
 
-	// 获取弹幕列表（应用过滤规则）
+	// 获取整场弹幕（应用过滤规则），每分钟上限和去重需要按整场计算，过滤后再排除已发送的
 	var danmakus []models.LiveMsg
-	query := db.Where("session_id = ? AND sent = ?", history.SessionID, false).
+	query := db.Where("session_id = ?", history.SessionID).
 		Where("message != '' AND message IS NOT NULL"). // 过滤空弹幕和抽奖弹幕
 		Order("timestamp ASC")
 
 	// 应用弹幕过滤规则
-	if room.DmUlLevel > 0 {
-		// 用户等级过滤（佩戴勋章的不受影响）
-		query = query.Where("u_level >= ? OR medal_level > 0", room.DmUlLevel)
-		log.Printf("[弹幕发送] 应用用户等级过滤: >= %d (佩戴勋章者不受限)", room.DmUlLevel)
-	}
-
-	if room.DmMedalLevel == 1 {
-		// 必须佩戴粉丝勋章
-		query = query.Where("medal_level > 0")
-		log.Printf("[弹幕发送] 应用粉丝勋章过滤: 必须佩戴粉丝勋章")
-	} else if room.DmMedalLevel == 2 {
-		// 必须佩戴主播粉丝勋章（通过房间ID匹配）
-		query = query.Where("medal_room_id = ?", history.RoomID)
-		log.Printf("[弹幕发送] 应用粉丝勋章过滤: 必须佩戴主播【%s】(房间%s)的粉丝勋章", room.Uname, history.RoomID)
-	}
-
-	// 关键词屏蔽
-	if room.DmKeywordBlacklist != "" {
-		keywords := strings.Split(room.DmKeywordBlacklist, "\n")
-		keywordCount := 0
-		for _, keyword := range keywords {
-			keyword = strings.TrimSpace(keyword)
-			if keyword != "" {
-				query = query.Where("LOWER(message) NOT LIKE ?", "%"+strings.ToLower(keyword)+"%")
-				keywordCount++
-			}
-		}
-		if keywordCount > 0 {
-			log.Printf("[弹幕发送] 应用关键词屏蔽: %d 个关键词", keywordCount)
-		}
-	}
+	query = applyDanmakuFilters(query, &room, history.RoomID)
 
 	if err := query.Find(&danmakus).Error; err != nil {
 		log.Printf("[弹幕发送] ❌ 查询弹幕失败: %v", err)
-		return fmt.Errorf("查询弹幕失败: %w", err)
+		return nil, fmt.Errorf("查询弹幕失败: %w", err)
 	}
 
 	log.Printf("[弹幕发送] 步骤5: 查询到 %d 条弹幕 (session_id=%s)", len(danmakus), history.SessionID)
 
+	// 应用房间的弹幕规则
+	dmFilter, err := NewRoomDanmakuFilter(&room)
+	if err != nil {
+		log.Printf("[弹幕发送] ❌ 弹幕过滤规则无效: %v", err)
+		return nil, err
+	}
+	danmakus = dmFilter.Filter(danmakus)
+
 	// 应用去重逻辑
 	if room.DmDistinct && len(danmakus) > 0 {
 		beforeCount := len(danmakus)
@@ -215,20 +200,23 @@ func (s *DanmakuService) sendDanmakuForHistoryWithSerialUsers(historyID uint) er
 		log.Printf("[弹幕发送] 步骤6: 去重后剩余 %d 条弹幕 (去重了%d条)", len(danmakus), beforeCount-len(danmakus))
 	}
 
-	if len(danmakus) == 0 {
-		log.Printf("[弹幕发送] ⚠️ 没有可发送的弹幕 (history_id=%d)", historyID)
-		history.DanmakuSent = true
-		history.DanmakuCount = 0
-		db.Save(&history)
-		return nil
+	unsent := danmakus[:0]
+	for _, dm := range danmakus {
+		if !dm.Sent {
+			unsent = append(unsent, dm)
+		}
 	}
+	if sentCount := len(danmakus) - len(unsent); sentCount > 0 {
+		log.Printf("[弹幕发送] 已发送 %d 条，剩余 %d 条待发送", sentCount, len(unsent))
+	}
+	danmakus = unsent
 
-	log.Printf("[弹幕发送] 步骤7: 初始化发送进度 (总计 %d 条)", len(danmakus))
-
-	// 初始化进度
-	danmakuprogress.SetDanmakuProgress(int64(historyID), 0, len(danmakus), true, false)
+	plan := &danmakuSendPlan{history: history, validUsers: validUsers}
+	if len(danmakus) == 0 {
+		return plan, nil
+	}
 
-	log.Printf("[弹幕发送] 步骤8: 获取视频信息 (BV号=%s)", history.BvID)
+	log.Printf("[弹幕发送] 步骤7: 获取视频信息 (BV号=%s)", history.BvID)
 
 	// 使用第一个有效用户获取视频信息
 	firstUser := validUsers[0]
@@ -236,12 +224,12 @@ func (s *DanmakuService) sendDanmakuForHistoryWithSerialUsers(historyID uint) er
 	videoInfo, err := client.GetVideoInfo(history.BvID)
 	if err != nil {
 		log.Printf("[弹幕发送] ❌ 获取视频信息失败: %v", err)
-		return fmt.Errorf("获取视频信息失败: %w", err)
+		return nil, fmt.Errorf("获取视频信息失败: %w", err)
 	}
 
 	log.Printf("[弹幕发送] ✓ 视频信息获取成功 (aid=%d, 分P数=%d)", videoInfo.Aid, len(videoInfo.Pages))
 
-	log.Printf("[弹幕发送] 步骤9: 获取分P信息")
+	log.Printf("[弹幕发送] 步骤8: 获取分P信息")
 
 	// 获取所有分P
 	var parts []models.RecordHistoryPart
@@ -249,26 +237,30 @@ func (s *DanmakuService) sendDanmakuForHistoryWithSerialUsers(historyID uint) er
 		Order("start_time ASC").
 		Find(&parts).Error; err != nil {
 		log.Printf("[弹幕发送] ❌ 查询分P失败: %v", err)
-		return fmt.Errorf("查询分P失败: %w", err)
+		return nil, fmt.Errorf("查询分P失败: %w", err)
 	}
 
 	if len(parts) == 0 {
 		log.Printf("[弹幕发送] ❌ 没有已上传的分P")
-		return fmt.Errorf("没有已上传的分P")
+		return nil, fmt.Errorf("没有已上传的分P")
 	}
 
 	log.Printf("[弹幕发送] ✓ 找到 %d 个分P", len(parts))
 
-	// 构建分P时间映射（毫秒）
+	// 构建分P时间映射（毫秒），videoMs为校准后分P视频0秒在直播时间轴上的位置
 	partTimeMap := make(map[int]struct {
 		startMs int64
 		endMs   int64
+		videoMs int64
 		cid     int64
 	})
 
-	for i, part := range parts {
+	offsetSvc := NewDanmakuOffsetService()
+	for i := range parts {
+		part := &parts[i]
 		startMs := part.StartTime.UnixMilli() - history.StartTime.UnixMilli()
 		endMs := part.EndTime.UnixMilli() - history.StartTime.UnixMilli()
+		videoMs := offsetSvc.PartOffset(&history, part)
 
 		// 查找对应的CID
 		cid := part.CID
@@ -279,52 +271,31 @@ func (s *DanmakuService) sendDanmakuForHistoryWithSerialUsers(historyID uint) er
 		partTimeMap[i] = struct {
 			startMs int64
 			endMs   int64
+			videoMs int64
 			cid     int64
-		}{startMs, endMs, cid}
+		}{startMs, endMs, videoMs, cid}
 	}
 
-	// 准备发送的弹幕
-	var danmakuItems []bili.DanmakuItem
-	sentCount := 0
+	// 准备发送的弹幕（发送成功后才标记为已发送，未发送的留待下次继续）
+	var pending []*pendingDanmaku
 
 	for _, dm := range danmakus {
-		// 找到弹幕所属的分P
-		found := false
-		for partIdx, timeRange := range partTimeMap {
-			if dm.Timestamp >= timeRange.startMs && dm.Timestamp < timeRange.endMs {
-				// 计算相对于分P的时间
-				relativeProgress := int(dm.Timestamp - timeRange.startMs)
-
-				danmakuItems = append(danmakuItems, bili.DanmakuItem{
-					CID:      timeRange.cid,
-					BvID:     history.BvID,
-					Progress: relativeProgress,
-					Message:  dm.Message,
-					Mode:     dm.Mode,
-					FontSize: dm.FontSize,
-					Color:    dm.Color,
-				})
-
-				// 更新弹幕记录
-				dm.Sent = true
-				dm.CID = timeRange.cid
-				dm.Progress = relativeProgress
-				dm.BvID = history.BvID
-				db.Save(&dm)
-
-				found = true
-				sentCount++
-				break
+		// 找到弹幕所属的分P，超出最后一个分P的归到最后一个分P
+		for partIdx := 0; partIdx < len(partTimeMap); partIdx++ {
+			timeRange := partTimeMap[partIdx]
+			inRange := dm.Timestamp >= timeRange.startMs && dm.Timestamp < timeRange.endMs
+			if !inRange && partIdx != len(partTimeMap)-1 {
+				continue
 			}
 
-			// 如果超出最后一个分P，归到最后一个分P
-			if !found && partIdx == len(partTimeMap)-1 {
-				relativeProgress := int(dm.Timestamp - timeRange.startMs)
-				if relativeProgress < 0 {
-					relativeProgress = 0
-				}
+			// 计算相对于分P视频的时间（已校准录制延迟）
+			relativeProgress := int(dm.Timestamp - timeRange.videoMs)
+			if relativeProgress < 0 {
+				relativeProgress = 0
+			}
 
-				danmakuItems = append(danmakuItems, bili.DanmakuItem{
+			pending = append(pending, &pendingDanmaku{
+				item: bili.DanmakuItem{
 					CID:      timeRange.cid,
 					BvID:     history.BvID,
 					Progress: relativeProgress,
@@ -332,261 +303,390 @@ func (s *DanmakuService) sendDanmakuForHistoryWithSerialUsers(historyID uint) er
 					Mode:     dm.Mode,
 					FontSize: dm.FontSize,
 					Color:    dm.Color,
-				})
-
-				dm.Sent = true
-				dm.CID = timeRange.cid
-				dm.Progress = relativeProgress
-				dm.BvID = history.BvID
-				db.Save(&dm)
-				sentCount++
-			}
+				},
+				msg:  dm,
+				page: partIdx + 1,
+			})
+			break
 		}
 	}
 
-	log.Printf("[弹幕发送] 步骤10: 开始映射弹幕到分P (映射成功 %d 条)", len(danmakuItems))
-
-	// 串行发送弹幕（多个用户轮流发送，每个用户维护自己的随机间隔）
-	if len(danmakuItems) > 0 {
-		log.Printf("[弹幕发送] 步骤11: 开始使用 %d 个用户串行发送 %d 条弹幕到视频 %s",
-			len(validUsers), len(danmakuItems), history.BvID)
+	log.Printf("[弹幕发送] 步骤9: 映射弹幕到分P (映射成功 %d 条)", len(pending))
 
-		userCount := len(validUsers)
-		successCount := 0
-
-		// 将弹幕按用户分组
-		userDanmakuGroups := make([][]bili.DanmakuItem, userCount)
-		for i := 0; i < userCount; i++ {
-			userDanmakuGroups[i] = make([]bili.DanmakuItem, 0)
-		}
-
-		// 轮流分配弹幕给各个用户
-		for i, dm := range danmakuItems {
-			userIdx := i % userCount
-			userDanmakuGroups[userIdx] = append(userDanmakuGroups[userIdx], dm)
-		}
+	plan.pending = pending
+	return plan, nil
+}
 
-		// 获取全局代理池配置
-		proxyPool := s.getGlobalProxyPool()
-		proxyCount := proxyPool.GetProxyCount()
+// sendDanmakuForHistoryWithSerialUsers 使用多个用户发送弹幕
+func (s *DanmakuService) sendDanmakuForHistoryWithSerialUsers(historyID uint) error {
+	db := database.GetDB()
 
-		// 如果代理池有多个IP（除了本地IP），使用并行发送
-		if proxyCount > 1 {
-			log.Printf("[弹幕发送] 使用全局代理池并行发送模式 (%d个IP)", proxyCount)
-			successCount = s.sendDanmakuWithProxyPool(validUsers, userDanmakuGroups, history.BvID, int64(historyID), proxyPool)
-		} else {
-			// 仅本地IP，使用传统的串行发送
-			log.Printf("[弹幕发送] 使用传统串行发送模式（仅本地IP）")
-			successCount = s.sendDanmakuSerial(validUsers, userDanmakuGroups, history.BvID, int64(historyID), len(danmakuItems))
-		}
+	plan, err := s.buildDanmakuSendPlan(historyID, false)
+	if err != nil {
+		// 继续发送的任务在开始发送前失败时推迟重试，手动发送的任务不自动重试
+		retryAt := time.Now().Add(30 * time.Minute)
+		db.Model(&models.RecordHistory{}).
+			Where("id = ? AND danmaku_sent = ? AND danmaku_next_send_at IS NOT NULL", historyID, false).
+			Update("danmaku_next_send_at", retryAt)
+		return err
+	}
+	history := plan.history
+	pending := plan.pending
 
-		log.Printf("[弹幕发送] ✅ 全部发送完成: 成功 %d/%d 条 (成功率 %.1f%%)",
-			successCount, len(danmakuItems), float64(successCount)*100/float64(len(danmakuItems)))
+	// 开始发送，清除继续发送时间，发送再次暂停时重新写入
+	history.DanmakuNextSendAt = nil
+	db.Model(&history).Update("danmaku_next_send_at", nil)
 
-		// 更新历史记录
+	if len(pending) == 0 {
+		log.Printf("[弹幕发送] ⚠️ 没有可发送的弹幕 (history_id=%d)", historyID)
 		history.DanmakuSent = true
-		history.DanmakuCount = sentCount
+		history.DanmakuCount = 0
+		history.DanmakuNextSendAt = nil
 		db.Save(&history)
 
 		// 完成进度
-		danmakuprogress.SetDanmakuProgress(int64(historyID), len(danmakuItems), len(danmakuItems), false, true)
+		danmakuprogress.ClearDanmakuProgress(int64(historyID))
+		return nil
+	}
+
+	log.Printf("[弹幕发送] 步骤10: 初始化发送进度 (总计 %d 条)", len(pending))
+
+	// 初始化进度
+	danmakuprogress.SetDanmakuProgress(int64(historyID), 0, len(pending), true, false)
+
+	// 获取全局代理池配置
+	proxyPool := s.getGlobalProxyPool()
+	proxyCount := proxyPool.GetProxyCount()
+
+	senders, dailyQuota := loadDanmakuSenders(plan.validUsers)
+
+	log.Printf("[弹幕发送] 步骤11: 开始使用 %d 个用户发送 %d 条弹幕到视频 %s (每账号每日上限=%d)",
+		len(senders), len(pending), history.BvID, dailyQuota)
+
+	queue := &danmakuWorkQueue{items: pending}
+	run := &danmakuSendRun{historyID: int64(historyID), total: len(pending), proxyPool: proxyPool}
+
+	if proxyCount > 1 {
+		// 代理池有多个IP时，每个用户独立并行发送，共享待发送队列
+		log.Printf("[弹幕发送] 使用全局代理池并行发送模式 (%d个IP)", proxyCount)
+		var wg sync.WaitGroup
+		for _, sender := range senders {
+			wg.Add(1)
+			go func(sender *danmakuSender) {
+				defer wg.Done()
+				s.runDanmakuWorker([]*danmakuSender{sender}, queue, run)
+			}(sender)
+		}
+		wg.Wait()
+	} else {
+		// 仅本地IP，多个用户轮流串行发送
+		log.Printf("[弹幕发送] 使用串行发送模式（仅本地IP）")
+		s.runDanmakuWorker(senders, queue, run)
+	}
 
+	remaining := queue.len() + run.dropped
+	log.Printf("[弹幕发送] 本次发送结束: 成功 %d 条, 跳过 %d 条, 剩余 %d 条",
+		run.success, run.skipped, remaining)
+
+	if remaining > 0 {
+		// 剩余弹幕保持未发送状态，到时间后由定时任务继续发送
+		var nextAt time.Time
+		switch {
+		case run.videoPending:
+			nextAt = time.Now().Add(time.Hour)
+			log.Printf("[弹幕发送] ⏸️ 视频尚未审核通过，1小时后继续发送剩余 %d 条", remaining)
+		case run.quotaExhausted:
+			nextAt = nextDanmakuQuotaReset()
+			log.Printf("[弹幕发送] ⏸️ 所有账号今日配额已用完，剩余 %d 条将于 %s 继续发送",
+				remaining, nextAt.Format("2006-01-02 15:04"))
+		default:
+			nextAt = time.Now().Add(30 * time.Minute)
+			log.Printf("[弹幕发送] ⏸️ 剩余 %d 条发送失败，30分钟后重试", remaining)
+		}
+		history.DanmakuSent = false
+		history.DanmakuNextSendAt = &nextAt
+		db.Save(&history)
+
+		danmakuprogress.SetDanmakuProgress(int64(historyID), run.done, len(pending), false, false)
 		return nil
 	}
 
+	// 更新历史记录
+	var sentTotal int64
+	db.Model(&models.LiveMsg{}).Where("session_id = ? AND sent = ?", history.SessionID, true).Count(&sentTotal)
 	history.DanmakuSent = true
-	history.DanmakuCount = 0
+	history.DanmakuCount = int(sentTotal)
+	history.DanmakuNextSendAt = nil
 	db.Save(&history)
 
 	// 完成进度
-	danmakuprogress.ClearDanmakuProgress(int64(historyID))
+	danmakuprogress.SetDanmakuProgress(int64(historyID), len(pending), len(pending), false, true)
 
 	return nil
 }
 
-// sendDanmakuSerial 串行发送弹幕（传统方式）
-func (s *DanmakuService) sendDanmakuSerial(validUsers []models.BiliBiliUser, userDanmakuGroups [][]bili.DanmakuItem, bvid string, historyID int64, totalCount int) int {
-	successCount := 0
-	totalSent := 0
+// pendingDanmaku 待发送的弹幕
+type pendingDanmaku struct {
+	item     bili.DanmakuItem
+	msg      models.LiveMsg
+	page     int // 分P序号（从1开始）
+	attempts int
+}
+
+// danmakuSender 发送弹幕的账号，remaining为今日剩余配额（-1不限制）
+type danmakuSender struct {
+	user      models.BiliBiliUser
+	remaining int
+	disabled  bool
+}
 
-	// 用户串行发送（一个用户发送完后才轮到下一个用户）
-	for userIdx, user := range validUsers {
-		userDanmakus := userDanmakuGroups[userIdx]
-		if len(userDanmakus) == 0 {
-			continue
+// loadDanmakuSenders 读取各账号今日剩余配额，返回发送账号和每日上限
+func loadDanmakuSenders(users []models.BiliBiliUser) ([]*danmakuSender, int) {
+	dailyQuota := getDanmakuDailyQuota()
+	senders := make([]*danmakuSender, 0, len(users))
+	for _, user := range users {
+		remaining := GetDanmakuQuotaRemaining(user.ID, dailyQuota)
+		if remaining == 0 {
+			log.Printf("[弹幕发送] ⚠️ 用户%s 今日弹幕配额已用完 (上限%d)", user.Uname, dailyQuota)
 		}
+		senders = append(senders, &danmakuSender{user: user, remaining: remaining})
+	}
+	return senders, dailyQuota
+}
 
-		log.Printf("[弹幕发送] 👤 用户%s开始发送 %d 条弹幕", user.Uname, len(userDanmakus))
-
-		client := bili.NewBiliClient(user.AccessKey, user.Cookies, user.UID)
-		userSuccessCount := 0
-		consecutiveFailures := 0 // 连续失败计数
-
-		// 该用户发送其负责的所有弹幕
-		for dmIdx, dm := range userDanmakus {
-			totalSent++
-
-			// 发送弹幕
-			err := client.SendDanmakuWithoutWait(dm.CID, dm.BvID, dm.Progress, dm.Message, dm.Mode, dm.FontSize, dm.Color)
-			if err != nil {
-				consecutiveFailures++
-				log.Printf("[弹幕发送] ❌ 用户%s 第%d/%d条失败 (连续失败%d次, 进度=%dms, 内容=%s): %v",
-					user.Uname, dmIdx+1, len(userDanmakus), consecutiveFailures, dm.Progress, dm.Message, err)
-
-				// 指数退避机制：30秒 -> 1分钟 -> 2分钟 -> 5分钟 -> 10分钟
-				var waitTime time.Duration
-				switch consecutiveFailures {
-				case 1:
-					waitTime = 30 * time.Second
-				case 2:
-					waitTime = 1 * time.Minute
-				case 3:
-					waitTime = 2 * time.Minute
-				case 4:
-					waitTime = 5 * time.Minute
-				default:
-					waitTime = 10 * time.Minute
-				}
-				log.Printf("[弹幕发送] ⚠️ 用户%s 连续失败%d次，等待%v后继续...", user.Uname, consecutiveFailures, waitTime)
-				time.Sleep(waitTime)
-			} else {
-				userSuccessCount++
-				successCount++
-				consecutiveFailures = 0 // 成功后重置失败计数
+func (s *danmakuSender) available() bool {
+	return !s.disabled && s.remaining != 0
+}
+
+// danmakuWorkQueue 多个发送协程共享的待发送队列
+type danmakuWorkQueue struct {
+	mu      sync.Mutex
+	items   []*pendingDanmaku
+	stopped bool
+}
 
-				// 成功后添加随机等待（全局限流器已确保至少22秒间隔）
-				// 这里添加3-8秒的额外随机延迟，总延迟在25-30秒之间，接近biliupforjava的25秒策略
-				extraWait := 3 + rand.Intn(6) // 3-8秒
+func (q *danmakuWorkQueue) pop() *pendingDanmaku {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.stopped || len(q.items) == 0 {
+		return nil
+	}
+	item := q.items[0]
+	q.items = q.items[1:]
+	return item
+}
 
-				log.Printf("[弹幕发送] ✓ 用户%s 第%d/%d条成功，额外等待%d秒...",
-					user.Uname, dmIdx+1, len(userDanmakus), extraWait)
-				time.Sleep(time.Duration(extraWait) * time.Second)
-			}
+// pushFront 放回队首，保持弹幕按时间顺序发送
+func (q *danmakuWorkQueue) pushFront(item *pendingDanmaku) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.items = append([]*pendingDanmaku{item}, q.items...)
+}
+
+func (q *danmakuWorkQueue) stop() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.stopped = true
+}
+
+func (q *danmakuWorkQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items)
+}
 
-			// 更新进度
-			if totalSent%10 == 0 || totalSent == totalCount {
-				log.Printf("[弹幕发送] ⏳ 总进度: %d/%d (%.1f%%)",
-					totalSent, totalCount, float64(totalSent)*100/float64(totalCount))
+// danmakuSendRun 一次发送任务的统计
+type danmakuSendRun struct {
+	mu             sync.Mutex
+	historyID      int64
+	total          int
+	proxyPool      *bili.ProxyPool
+	done           int
+	success        int
+	skipped        int
+	dropped        int // 多次网络错误后放弃的条数（保持未发送）
+	videoPending   bool
+	quotaExhausted bool
+}
+
+// danmakuMaxAttempts 单条弹幕网络错误的最大重试次数
+const danmakuMaxAttempts = 3
+
+// runDanmakuWorker 使用给定账号轮流发送队列中的弹幕，直到队列为空、账号配额用尽或视频未审核
+func (s *DanmakuService) runDanmakuWorker(senders []*danmakuSender, queue *danmakuWorkQueue, run *danmakuSendRun) {
+	db := database.GetDB()
+	controller := GetDanmakuRateController()
+	next := 0
+
+	for {
+		// 轮流选择仍可发送的账号
+		var sender *danmakuSender
+		for i := 0; i < len(senders); i++ {
+			candidate := senders[(next+i)%len(senders)]
+			if candidate.available() {
+				sender = candidate
+				next = (next + i + 1) % len(senders)
+				break
 			}
-			danmakuprogress.SetDanmakuProgress(historyID, totalSent, totalCount, true, false)
+		}
+		if sender == nil {
+			run.mu.Lock()
+			for _, candidate := range senders {
+				if !candidate.disabled && candidate.remaining == 0 {
+					run.quotaExhausted = true
+				}
+			}
+			run.mu.Unlock()
+			return
 		}
 
-		log.Printf("[弹幕发送] ✅ 用户%s 发送完成: 成功 %d/%d 条",
-			user.Uname, userSuccessCount, len(userDanmakus))
+		p := queue.pop()
+		if p == nil {
+			return
+		}
 
-		// 用户切换时额外等待，进一步降低风控风险
-		if userIdx < len(validUsers)-1 {
-			switchWait := 10 + rand.Intn(11) // 10-20秒
-			log.Printf("[弹幕发送] 🔄 切换到下一个用户，等待%d秒...", switchWait)
-			time.Sleep(time.Duration(switchWait) * time.Second)
+		proxyInfo := run.proxyPool.GetNextAvailableProxy()
+		proxyURL := ""
+		if proxyInfo != nil {
+			proxyURL = proxyInfo.GetProxyURL()
 		}
-	}
 
-	return successCount
-}
+		var client *bili.BiliClient
+		if proxyURL == "" {
+			client = bili.NewBiliClient(sender.user.AccessKey, sender.user.Cookies, sender.user.UID)
+		} else {
+			client = bili.NewBiliClientWithProxy(sender.user.AccessKey, sender.user.Cookies, sender.user.UID, proxyURL)
+		}
 
-// sendDanmakuWithProxyPool 使用全局代理池并行发送弹幕
-func (s *DanmakuService) sendDanmakuWithProxyPool(validUsers []models.BiliBiliUser, userDanmakuGroups [][]bili.DanmakuItem, bvid string, historyID int64, proxyPool *bili.ProxyPool) int {
-	var wg sync.WaitGroup
-	var mu sync.Mutex
-	totalSuccessCount := 0
-	totalSent := 0
-
-	// 为每个用户创建一个goroutine，所有用户共享全局代理池
-	for userIdx, user := range validUsers {
-		userDanmakus := userDanmakuGroups[userIdx]
-		if len(userDanmakus) == 0 {
-			continue
+		// 发送节奏由自适应控制器决定（账号节奏和出口IP的最小间隔）
+		controller.Wait(sender.user.UID, proxyURL)
+		dm := p.item
+		err := client.SendDanmakuWithProxy(dm.CID, dm.BvID, dm.Progress, dm.Message, dm.Mode, dm.FontSize, dm.Color, nil)
+		result := classifyDanmakuError(err)
+		controller.Report(sender.user.UID, proxyURL, result)
+
+		run.mu.Lock()
+		switch result {
+		case danmakuSendSuccess:
+			markDanmakuSent(db, &p.msg, dm.CID, dm.Progress, dm.BvID)
+			consumeDanmakuQuota(sender.user.ID)
+			if sender.remaining > 0 {
+				sender.remaining--
+			}
+			run.success++
+			run.done++
+			log.Printf("[弹幕发送] ✓ 用户%s(%s) 第%d/%d条成功", sender.user.Uname, proxyLabel(proxyURL), run.done, run.total)
+		case danmakuSendRejected:
+			// 内容问题重试也不会成功，标记后不再发送
+			markDanmakuSent(db, &p.msg, dm.CID, dm.Progress, dm.BvID)
+			run.skipped++
+			run.done++
+			log.Printf("[弹幕发送] ⚠️ 跳过弹幕 (内容=%s): %v", dm.Message, err)
+		case danmakuSendRateLimited:
+			queue.pushFront(p)
+			log.Printf("[弹幕发送] ⚠️ 用户%s(%s) 触发频率限制，稍后重试: %v", sender.user.Uname, proxyLabel(proxyURL), err)
+		case danmakuSendAccountError:
+			sender.disabled = true
+			queue.pushFront(p)
+			log.Printf("[弹幕发送] ❌ 用户%s 账号异常，停止使用该账号: %v", sender.user.Uname, err)
+		case danmakuSendVideoPending:
+			queue.pushFront(p)
+			queue.stop()
+			run.videoPending = true
+			log.Printf("[弹幕发送] ⚠️ 视频尚未审核通过，暂停发送: %v", err)
+		default:
+			p.attempts++
+			if p.attempts < danmakuMaxAttempts {
+				queue.pushFront(p)
+			} else {
+				run.dropped++
+				run.done++
+			}
+			log.Printf("[弹幕发送] ❌ 用户%s(%s) 发送失败 (第%d次, 内容=%s): %v",
+				sender.user.Uname, proxyLabel(proxyURL), p.attempts, dm.Message, err)
 		}
 
-		wg.Add(1)
-		go func(user models.BiliBiliUser, danmakus []bili.DanmakuItem, userIdx int) {
-			defer wg.Done()
+		if run.done%10 == 0 || run.done == run.total {
+			log.Printf("[弹幕发送] ⏳ 总进度: %d/%d (%.1f%%)", run.done, run.total, float64(run.done)*100/float64(run.total))
+		}
+		danmakuprogress.SetDanmakuProgress(run.historyID, run.done, run.total, true, false)
+		run.mu.Unlock()
+	}
+}
 
-			log.Printf("[弹幕发送] 👤 用户%s 开始使用全局代理池发送 %d 条弹幕", user.Uname, len(danmakus))
+// ResumePendingDanmaku 继续发送因配额用尽或视频未审核而暂停的弹幕
+func (s *DanmakuService) ResumePendingDanmaku() error {
+	db := database.GetDB()
 
-			userSuccessCount := 0
-			consecutiveFailures := 0
+	var histories []models.RecordHistory
+	if err := db.Where("danmaku_sent = ? AND danmaku_next_send_at IS NOT NULL AND danmaku_next_send_at <= ?", false, time.Now()).
+		Find(&histories).Error; err != nil {
+		return fmt.Errorf("查询待继续发送的弹幕失败: %w", err)
+	}
 
-			log.Printf("[弹幕发送] 👤 用户%s 开始发送 %d 条弹幕", user.Uname, len(danmakus))
+	// 下次发送时间在任务实际开始发送时清除，已在队列中的任务不会重复加入
+	for _, history := range histories {
+		log.Printf("[弹幕发送] ▶️ 继续发送剩余弹幕 (history_id=%d)", history.ID)
+		if err := s.SendDanmakuForHistory(history.ID); err != nil {
+			// 保留下次发送时间，下个周期重试
+			log.Printf("[弹幕发送] ❌ 继续发送失败 (history_id=%d): %v", history.ID, err)
+		}
+	}
+	return nil
+}
 
-			for dmIdx, dm := range danmakus {
-				// 获取下一个可用代理（跳过不可达的代理）
-				proxyInfo := proxyPool.GetNextAvailableProxy()
-				if proxyInfo == nil {
-					log.Printf("[弹幕发送] ❌ 用户%s 无法获取代理", user.Uname)
-					break
-				}
+// markDanmakuSent 标记弹幕已发送，只更新发送相关字段，不回写规则改写后的内容
+// 弹幕表没有主键，按去重索引的字段定位记录，规则改写过内容时使用改写前的内容
+func markDanmakuSent(db *gorm.DB, dm *models.LiveMsg, cid int64, progress int, bvid string) {
+	message := dm.Message
+	if dm.OriginalMessage != "" {
+		message = dm.OriginalMessage
+	}
+	db.Model(&models.LiveMsg{}).
+		Where("session_id = ? AND timestamp = ? AND message = ?", dm.SessionID, dm.Timestamp, message).
+		Updates(map[string]interface{}{
+			"sent":     true,
+			"c_id":     cid,
+			"progress": progress,
+			"bv_id":    bvid,
+		})
+}
 
-				// 创建带代理的客户端
-				var client *bili.BiliClient
-				if proxyInfo.IsLocal() {
-					client = bili.NewBiliClient(user.AccessKey, user.Cookies, user.UID)
-				} else {
-					client = bili.NewBiliClientWithProxy(user.AccessKey, user.Cookies, user.UID, proxyInfo.GetProxyURL())
-				}
+// applyDanmakuFilters 按房间配置应用弹幕过滤规则（用户等级、粉丝勋章、关键词屏蔽）
+func applyDanmakuFilters(query *gorm.DB, room *models.RecordRoom, roomID string) *gorm.DB {
+	if room.DmUlLevel > 0 {
+		// 用户等级过滤（佩戴勋章的不受影响）
+		query = query.Where("u_level >= ? OR medal_level > 0", room.DmUlLevel)
+		log.Printf("[弹幕过滤] 应用用户等级过滤: >= %d (佩戴勋章者不受限)", room.DmUlLevel)
+	}
 
-				// 发送弹幕（使用代理特定的限流器）
-				err := client.SendDanmakuWithProxy(dm.CID, dm.BvID, dm.Progress, dm.Message, dm.Mode, dm.FontSize, dm.Color, proxyInfo)
-
-				mu.Lock()
-				totalSent++
-				currentTotal := totalSent
-				mu.Unlock()
-
-				if err != nil {
-					consecutiveFailures++
-					log.Printf("[弹幕发送] ❌ 用户%s 代理%s 第%d/%d条失败 (连续失败%d次): %v",
-						user.Uname, proxyInfo.String(), dmIdx+1, len(danmakus), consecutiveFailures, err)
-
-					// 指数退避
-					var waitTime time.Duration
-					switch consecutiveFailures {
-					case 1:
-						waitTime = 30 * time.Second
-					case 2:
-						waitTime = 1 * time.Minute
-					case 3:
-						waitTime = 2 * time.Minute
-					default:
-						waitTime = 5 * time.Minute
-					}
-					if consecutiveFailures >= 3 {
-						log.Printf("[弹幕发送] ⚠️ 用户%s 连续失败%d次，等待%v后继续...", user.Uname, consecutiveFailures, waitTime)
-						time.Sleep(waitTime)
-					}
-				} else {
-					userSuccessCount++
-					mu.Lock()
-					totalSuccessCount++
-					mu.Unlock()
-					consecutiveFailures = 0
-
-					log.Printf("[弹幕发送] ✓ 用户%s 代理%s 第%d/%d条成功",
-						user.Uname, proxyInfo.String(), dmIdx+1, len(danmakus))
-
-					// 成功后添加3-8秒随机延迟（代理限流器已保证22秒基础间隔）
-					extraWait := 3 + rand.Intn(6)
-					time.Sleep(time.Duration(extraWait) * time.Second)
-				}
+	if room.DmMedalLevel == 1 {
+		// 必须佩戴粉丝勋章
+		query = query.Where("medal_level > 0")
+		log.Printf("[弹幕过滤] 应用粉丝勋章过滤: 必须佩戴粉丝勋章")
+	} else if room.DmMedalLevel == 2 {
+		// 必须佩戴主播粉丝勋章（通过房间ID匹配）
+		query = query.Where("medal_room_id = ?", roomID)
+		log.Printf("[弹幕过滤] 应用粉丝勋章过滤: 必须佩戴主播【%s】(房间%s)的粉丝勋章", room.Uname, roomID)
+	}
 
-				// 更新进度
-				mu.Lock()
-				if currentTotal%10 == 0 {
-					log.Printf("[弹幕发送] ⏳ 总进度: %d 条已发送", currentTotal)
-				}
-				danmakuprogress.SetDanmakuProgress(historyID, currentTotal, -1, true, false)
-				mu.Unlock()
+	// 关键词屏蔽
+	if room.DmKeywordBlacklist != "" {
+		keywords := strings.Split(room.DmKeywordBlacklist, "\n")
+		keywordCount := 0
+		for _, keyword := range keywords {
+			keyword = strings.TrimSpace(keyword)
+			if keyword != "" {
+				query = query.Where("LOWER(message) NOT LIKE ?", "%"+strings.ToLower(keyword)+"%")
+				keywordCount++
 			}
-
-			log.Printf("[弹幕发送] ✅ 用户%s 发送完成: 成功 %d/%d 条",
-				user.Uname, userSuccessCount, len(danmakus))
-		}(user, userDanmakus, userIdx)
+		}
+		if keywordCount > 0 {
+			log.Printf("[弹幕过滤] 应用关键词屏蔽: %d 个关键词", keywordCount)
+		}
 	}
 
-	// 等待所有用户发送完成
-	wg.Wait()
-	return totalSuccessCount
+	return query
 }
 
 // deduplicateDanmakus 弹幕去重（参考biliupforjava的布隆过滤器实现）
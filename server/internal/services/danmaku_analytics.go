@@ -0,0 +1,350 @@
+package services
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/gobup/server/internal/database"
+	"github.com/gobup/server/internal/models"
+	"gorm.io/gorm"
+)
+
+// 弹幕分析默认返回的排行数量
+const defaultAnalyticsTopN = 20
+
+// danmakuEmoteRegex 匹配 [doge]、[妙啊] 等表情
+var danmakuEmoteRegex = regexp.MustCompile(`\[[^\[\]\s]{1,12}\]`)
+
+// danmakuStopWords 关键词统计时忽略的常见虚词
+var danmakuStopWords = map[string]bool{
+	"的": true, "了": true, "是": true, "我": true, "你": true, "他": true, "她": true,
+	"它": true, "这": true, "那": true, "就": true, "都": true, "也": true, "在": true,
+	"有": true, "和": true, "不": true, "吗": true, "吧": true, "呢": true, "啊": true,
+	"呀": true, "哦": true, "嗯": true, "么": true, "还": true, "又": true, "很": true,
+	"我们": true, "你们": true, "他们": true, "这个": true, "那个": true, "什么": true,
+	"就是": true, "还是": true, "没有": true, "一个": true, "可以": true, "不是": true,
+	"the": true, "a": true, "is": true, "to": true, "of": true, "and": true,
+}
+
+// DensityPoint 每分钟弹幕数
+type DensityPoint struct {
+	Minute int `json:"minute"` // 相对于直播开始的分钟数
+	Count  int `json:"count"`
+}
+
+// KeywordCount 关键词/表情出现次数（同一条弹幕只计一次）
+type KeywordCount struct {
+	Word  string `json:"word"`
+	Count int    `json:"count"`
+}
+
+// ChatterCount 发言用户及发言数
+type ChatterCount struct {
+	UserName string `json:"userName"`
+	UID      int64  `json:"uid"`
+	Count    int    `json:"count"`
+}
+
+// MedalCount 粉丝勋章分布
+type MedalCount struct {
+	MedalName string  `json:"medalName"`
+	Users     int     `json:"users"`    // 佩戴该勋章的发言人数
+	Messages  int     `json:"messages"` // 佩戴该勋章发送的弹幕数
+	AvgLevel  float64 `json:"avgLevel"`
+}
+
+// SCTimelineItem SC时间线条目
+type SCTimelineItem struct {
+	Timestamp int64   `json:"timestamp"` // 相对于直播开始的时间戳（毫秒）
+	UserName  string  `json:"userName"`
+	Price     float64 `json:"price"`
+	Message   string  `json:"message"`
+}
+
+// DanmakuAnalytics 单场直播的弹幕分析结果
+type DanmakuAnalytics struct {
+	HistoryID      uint             `json:"historyId"`
+	SessionID      string           `json:"sessionId"`
+	TotalMessages  int              `json:"totalMessages"`
+	UniqueChatters int              `json:"uniqueChatters"`
+	PeakMinute     int              `json:"peakMinute"`
+	PeakCount      int              `json:"peakCount"`
+	Density        []DensityPoint   `json:"density"`
+	Keywords       []KeywordCount   `json:"keywords"`
+	Emotes         []KeywordCount   `json:"emotes"`
+	TopChatters    []ChatterCount   `json:"topChatters"`
+	NoMedalCount   int              `json:"noMedalCount"` // 未佩戴勋章的弹幕数
+	Medals         []MedalCount     `json:"medals"`
+	SCTimeline     []SCTimelineItem `json:"scTimeline"`
+}
+
+// DanmakuAnalyticsService 弹幕分析服务
+type DanmakuAnalyticsService struct{}
+
+func NewDanmakuAnalyticsService() *DanmakuAnalyticsService {
+	return &DanmakuAnalyticsService{}
+}
+
+// danmakuOnly 只统计文字弹幕，排除SC和上舰消息（旧数据类型为0，按解析时生成的文本识别）
+func danmakuOnly(db *gorm.DB, sessionID string) *gorm.DB {
+	return db.Model(&models.LiveMsg{}).
+		Where("session_id = ? AND type NOT IN ?", sessionID, []int{LiveMsgTypeSC, LiveMsgTypeGuard}).
+		Where("NOT (type = 0 AND mode = 5 AND (message LIKE ? OR message LIKE ?))", "%元留言：%", "%开通了%个月%")
+}
+
+// chatterKeyExpr 用户标识，有UID时按UID区分，否则按用户名
+const chatterKeyExpr = "CASE WHEN uid != 0 THEN CAST(uid AS TEXT) ELSE user_name END"
+
+// Analyze 统计单场直播的弹幕密度、关键词、发言用户、勋章分布和SC时间线
+func (s *DanmakuAnalyticsService) Analyze(historyID uint, topN int) (*DanmakuAnalytics, error) {
+	db := database.GetDB()
+
+	var history models.RecordHistory
+	if err := db.First(&history, historyID).Error; err != nil {
+		return nil, fmt.Errorf("历史记录不存在: %w", err)
+	}
+	if topN <= 0 {
+		topN = defaultAnalyticsTopN
+	}
+
+	result := &DanmakuAnalytics{
+		HistoryID:   history.ID,
+		SessionID:   history.SessionID,
+		Density:     []DensityPoint{},
+		Keywords:    []KeywordCount{},
+		Emotes:      []KeywordCount{},
+		TopChatters: []ChatterCount{},
+		Medals:      []MedalCount{},
+		SCTimeline:  []SCTimelineItem{},
+	}
+
+	var messages []string
+	if err := danmakuOnly(db, history.SessionID).Pluck("message", &messages).Error; err != nil {
+		return nil, fmt.Errorf("查询弹幕失败: %w", err)
+	}
+	result.TotalMessages = len(messages)
+	result.Keywords, result.Emotes = s.countKeywords(messages, topN)
+
+	if err := s.fillDensity(db, history.SessionID, result); err != nil {
+		return nil, err
+	}
+	if err := s.fillChatters(db, history.SessionID, topN, result); err != nil {
+		return nil, err
+	}
+	if err := s.fillMedals(db, history.SessionID, topN, result); err != nil {
+		return nil, err
+	}
+	if err := s.fillSCTimeline(db, history.SessionID, result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// fillDensity 按分钟统计弹幕数，没有弹幕的分钟补0
+func (s *DanmakuAnalyticsService) fillDensity(db *gorm.DB, sessionID string, result *DanmakuAnalytics) error {
+	var rows []DensityPoint
+	if err := danmakuOnly(db, sessionID).
+		Select("timestamp / 60000 AS minute, COUNT(*) AS count").
+		Where("timestamp >= 0").
+		Group("minute").
+		Order("minute ASC").
+		Scan(&rows).Error; err != nil {
+		return fmt.Errorf("统计弹幕密度失败: %w", err)
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+
+	last := rows[len(rows)-1].Minute
+	result.Density = make([]DensityPoint, last+1)
+	for i := range result.Density {
+		result.Density[i].Minute = i
+	}
+	for _, row := range rows {
+		result.Density[row.Minute].Count = row.Count
+		if row.Count > result.PeakCount {
+			result.PeakMinute, result.PeakCount = row.Minute, row.Count
+		}
+	}
+	return nil
+}
+
+// fillChatters 统计发言人数和发言排行
+func (s *DanmakuAnalyticsService) fillChatters(db *gorm.DB, sessionID string, topN int, result *DanmakuAnalytics) error {
+	var unique int64
+	if err := danmakuOnly(db, sessionID).
+		Where("uid != 0 OR user_name != ''").
+		Select("COUNT(DISTINCT " + chatterKeyExpr + ")").
+		Scan(&unique).Error; err != nil {
+		return fmt.Errorf("统计发言人数失败: %w", err)
+	}
+	result.UniqueChatters = int(unique)
+
+	if err := danmakuOnly(db, sessionID).
+		Select("MAX(user_name) AS user_name, MAX(uid) AS uid, COUNT(*) AS count").
+		Where("uid != 0 OR user_name != ''").
+		Group(chatterKeyExpr).
+		Order("count DESC").
+		Limit(topN).
+		Scan(&result.TopChatters).Error; err != nil {
+		return fmt.Errorf("统计发言排行失败: %w", err)
+	}
+	return nil
+}
+
+// fillMedals 统计粉丝勋章分布
+func (s *DanmakuAnalyticsService) fillMedals(db *gorm.DB, sessionID string, topN int, result *DanmakuAnalytics) error {
+	var noMedal int64
+	danmakuOnly(db, sessionID).Where("medal_name = '' OR medal_name IS NULL").Count(&noMedal)
+	result.NoMedalCount = int(noMedal)
+
+	if err := danmakuOnly(db, sessionID).
+		Select("medal_name, COUNT(DISTINCT " + chatterKeyExpr + ") AS users, COUNT(*) AS messages, AVG(medal_level) AS avg_level").
+		Where("medal_name != ''").
+		Group("medal_name").
+		Order("messages DESC").
+		Limit(topN).
+		Scan(&result.Medals).Error; err != nil {
+		return fmt.Errorf("统计勋章分布失败: %w", err)
+	}
+	return nil
+}
+
+// fillSCTimeline 按时间顺序列出SC，金额优先取付费事件表中的记录
+func (s *DanmakuAnalyticsService) fillSCTimeline(db *gorm.DB, sessionID string, result *DanmakuAnalytics) error {
+	var scMsgs []models.LiveMsg
+	if err := db.Where("session_id = ? AND (type = ? OR (type = 0 AND mode = 5 AND message LIKE ?))",
+		sessionID, LiveMsgTypeSC, "%元留言：%").
+		Order("timestamp ASC").
+		Find(&scMsgs).Error; err != nil {
+		return fmt.Errorf("查询SC失败: %w", err)
+	}
+	if len(scMsgs) == 0 {
+		return nil
+	}
+
+	var events []models.LiveEvent
+	db.Where("session_id = ? AND type = ?", sessionID, "sc").Find(&events)
+	eventByTime := make(map[int64]models.LiveEvent, len(events))
+	for _, event := range events {
+		eventByTime[event.Timestamp] = event
+	}
+
+	for _, msg := range scMsgs {
+		item := SCTimelineItem{
+			Timestamp: msg.Timestamp,
+			UserName:  msg.UserName,
+			Message:   msg.Message,
+		}
+		if event, ok := eventByTime[msg.Timestamp]; ok {
+			item.UserName = event.UserName
+			item.Price = event.Price
+			item.Message = event.Message
+		} else {
+			// 旧数据格式："用户名发送了N元留言：内容"
+			if idx := strings.Index(msg.Message, "元留言："); idx >= 0 {
+				item.Message = msg.Message[idx+len("元留言："):]
+				head := msg.Message[:idx]
+				if sep := strings.LastIndex(head, "发送了"); sep >= 0 {
+					if item.UserName == "" {
+						item.UserName = head[:sep]
+					}
+					fmt.Sscanf(head[sep+len("发送了"):], "%g", &item.Price)
+				}
+			}
+		}
+		result.SCTimeline = append(result.SCTimeline, item)
+	}
+	return nil
+}
+
+// countKeywords 统计关键词和表情，每条弹幕中同一个词只计一次
+func (s *DanmakuAnalyticsService) countKeywords(messages []string, topN int) ([]KeywordCount, []KeywordCount) {
+	keywordCounts := make(map[string]int)
+	emoteCounts := make(map[string]int)
+
+	for _, msg := range messages {
+		seen := make(map[string]bool)
+		for _, emote := range danmakuEmoteRegex.FindAllString(msg, -1) {
+			if !seen[emote] {
+				seen[emote] = true
+				emoteCounts[emote]++
+			}
+		}
+
+		text := danmakuEmoteRegex.ReplaceAllString(msg, " ")
+		for _, word := range splitDanmakuWords(text) {
+			if danmakuStopWords[word] || seen[word] {
+				continue
+			}
+			seen[word] = true
+			keywordCounts[word]++
+		}
+	}
+
+	return topKeywords(keywordCounts, topN), topKeywords(emoteCounts, topN)
+}
+
+// splitDanmakuWords 按标点和空白切分弹幕，连续重复字符折叠为最多三个（如"哈哈哈哈哈"→"哈哈哈"），
+// 过长的片段视为句子不计入关键词
+func splitDanmakuWords(text string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsPunct(r) || unicode.IsSymbol(r)
+	})
+
+	var words []string
+	for _, field := range fields {
+		word := collapseRepeatedRunes(field, 3)
+		length := len([]rune(word))
+		if length == 0 || length > 8 {
+			continue
+		}
+		if length == 1 && !unicode.Is(unicode.Han, []rune(word)[0]) {
+			continue
+		}
+		words = append(words, word)
+	}
+	return words
+}
+
+// collapseRepeatedRunes 将连续重复的字符折叠为最多limit个
+func collapseRepeatedRunes(s string, limit int) string {
+	var b strings.Builder
+	var last rune
+	repeat := 0
+	for _, r := range s {
+		if r == last {
+			repeat++
+		} else {
+			last, repeat = r, 1
+		}
+		if repeat <= limit {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func topKeywords(counts map[string]int, topN int) []KeywordCount {
+	list := make([]KeywordCount, 0, len(counts))
+	for word, count := range counts {
+		// 只出现一次的词没有统计意义
+		if count > 1 {
+			list = append(list, KeywordCount{Word: word, Count: count})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Count != list[j].Count {
+			return list[i].Count > list[j].Count
+		}
+		return list[i].Word < list[j].Word
+	})
+	if len(list) > topN {
+		list = list[:topN]
+	}
+	return list
+}
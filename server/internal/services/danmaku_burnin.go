@@ -0,0 +1,145 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gobup/server/internal/database"
+	"github.com/gobup/server/internal/models"
+)
+
+// 弹幕版投稿方式
+const (
+	BurnInTargetSeparate = 0 // 单独稿件
+	BurnInTargetAppend   = 1 // 追加到原稿件的分P
+)
+
+// 弹幕版历史记录状态
+const (
+	BurnInStatusNone       = 0
+	BurnInStatusQueued     = 1
+	BurnInStatusProcessing = 2
+	BurnInStatusPublished  = 3
+	BurnInStatusFailed     = 4
+)
+
+// 弹幕版分P状态
+const (
+	BurnInPartPending  = 0
+	BurnInPartEncoded  = 1
+	BurnInPartUploaded = 2
+	BurnInPartFailed   = 3
+)
+
+// ErrNoBurnInDanmaku 分P时间范围内没有弹幕，无需压制
+var ErrNoBurnInDanmaku = errors.New("分P没有可压制的弹幕")
+
+// DanmakuBurnInService 弹幕压制服务
+type DanmakuBurnInService struct {
+	exportSvc *DanmakuExportService
+	mediaSvc  *MediaService
+}
+
+func NewDanmakuBurnInService() *DanmakuBurnInService {
+	return &DanmakuBurnInService{
+		exportSvc: NewDanmakuExportService(),
+		mediaSvc:  NewMediaService(),
+	}
+}
+
+// EnsureDanmakuParsed 弹幕尚未解析时先解析XML
+func (s *DanmakuBurnInService) EnsureDanmakuParsed(history *models.RecordHistory) error {
+	var count int64
+	database.GetDB().Model(&models.LiveMsg{}).Where("session_id = ?", history.SessionID).Count(&count)
+	if count > 0 {
+		return nil
+	}
+
+	log.Printf("[弹幕压制] history_id=%d 尚未解析弹幕，先解析XML", history.ID)
+	if _, err := NewDanmakuXMLParser().ParseDanmakuForHistory(history.ID); err != nil {
+		return fmt.Errorf("解析弹幕失败: %w", err)
+	}
+	return nil
+}
+
+// RenderPart 将弹幕压制到分P视频中（仅CPU编码），返回压制后的文件路径
+func (s *DanmakuBurnInService) RenderPart(history *models.RecordHistory, part *models.RecordHistoryPart, room *models.RecordRoom) (string, error) {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		return "", fmt.Errorf("ffmpeg未安装或不在PATH中: %w", err)
+	}
+	if _, err := os.Stat(part.FilePath); err != nil {
+		return "", fmt.Errorf("分P文件不存在: %s", part.FilePath)
+	}
+
+	// 按视频实际分辨率生成字幕，保证字号比例一致
+	opts := DefaultDanmakuExportOptions()
+	if info, err := s.mediaSvc.ProbeMediaInfo(part.FilePath); err == nil && info.Width > 0 && info.Height > 0 {
+		opts.FontSize = opts.FontSize * info.Height / opts.Height
+		opts.Width = info.Width
+		opts.Height = info.Height
+	} else if err != nil {
+		log.Printf("[弹幕压制] 探测分辨率失败，使用默认1080p: %v", err)
+	}
+
+	_, _, danmakus, err := s.exportSvc.LoadDanmakus(history.ID, part.ID)
+	if err != nil {
+		return "", err
+	}
+	if len(danmakus) == 0 {
+		return "", ErrNoBurnInDanmaku
+	}
+
+	dir := filepath.Dir(part.FilePath)
+	ext := filepath.Ext(part.FilePath)
+	outputPath := strings.TrimSuffix(part.FilePath, ext) + "_danmaku.mp4"
+
+	// 字幕文件使用固定的ASCII文件名并在视频目录中执行ffmpeg，避免滤镜参数中的路径转义问题
+	assName := fmt.Sprintf("burnin_%d.ass", part.ID)
+	assPath := filepath.Join(dir, assName)
+	if err := os.WriteFile(assPath, s.exportSvc.RenderASS(danmakus, opts), 0644); err != nil {
+		return "", fmt.Errorf("写入字幕文件失败: %w", err)
+	}
+	defer os.Remove(assPath)
+
+	preset := room.BurnInPreset
+	if preset == "" {
+		preset = "veryfast"
+	}
+	crf := room.BurnInCRF
+	if crf <= 0 || crf > 51 {
+		crf = 23
+	}
+
+	cmd := exec.Command("ffmpeg",
+		"-i", part.FilePath,
+		"-vf", "ass="+assName,
+		"-c:v", "libx264",
+		"-preset", preset,
+		"-crf", strconv.Itoa(crf),
+		"-c:a", "copy",
+		"-movflags", "+faststart",
+		"-y",
+		outputPath,
+	)
+	cmd.Dir = dir
+
+	log.Printf("[弹幕压制] 开始压制: part_id=%d, 弹幕数=%d, preset=%s, crf=%d", part.ID, len(danmakus), preset, crf)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		os.Remove(outputPath)
+		tail := string(output)
+		if len(tail) > 500 {
+			tail = tail[len(tail)-500:]
+		}
+		log.Printf("[弹幕压制] ffmpeg压制失败: %s, output: %s", err, tail)
+		return "", fmt.Errorf("ffmpeg压制失败: %w", err)
+	}
+
+	log.Printf("[弹幕压制] 压制完成: %s", outputPath)
+	return outputPath, nil
+}
@@ -0,0 +1,386 @@
+package services
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gobup/server/internal/database"
+	"github.com/gobup/server/internal/models"
+)
+
+// 弹幕导出格式
+const (
+	DanmakuExportASS = "ass"
+	DanmakuExportSRT = "srt"
+	DanmakuExportXML = "xml"
+)
+
+// 弹幕模式（与B站一致）
+const (
+	danmakuModeScroll = 1
+	danmakuModeBottom = 4
+	danmakuModeTop    = 5
+)
+
+// DanmakuExportOptions 弹幕导出参数
+type DanmakuExportOptions struct {
+	Width          int     // 画布宽度
+	Height         int     // 画布高度
+	FontName       string  // 字体
+	FontSize       int     // 标准字号(25)弹幕在画布上的字号
+	ScrollDuration float64 // 滚动弹幕停留时间（秒）
+	FixedDuration  float64 // 顶部/底部弹幕停留时间（秒）
+	Opacity        float64 // 不透明度 0-1
+	DisplayArea    float64 // 滚动弹幕占用的屏幕高度比例 0-1
+}
+
+// DefaultDanmakuExportOptions 默认导出参数（1080p）
+func DefaultDanmakuExportOptions() DanmakuExportOptions {
+	return DanmakuExportOptions{
+		Width:          1920,
+		Height:         1080,
+		FontName:       "Microsoft YaHei",
+		FontSize:       42,
+		ScrollDuration: 10,
+		FixedDuration:  5,
+		Opacity:        0.8,
+		DisplayArea:    1,
+	}
+}
+
+// TimedDanmaku 已映射到输出时间轴的弹幕
+type TimedDanmaku struct {
+	models.LiveMsg
+	Offset int64 // 相对于导出文件开头的时间（毫秒）
+}
+
+// DanmakuExportService 弹幕导出服务
+type DanmakuExportService struct{}
+
+func NewDanmakuExportService() *DanmakuExportService {
+	return &DanmakuExportService{}
+}
+
+// LoadDanmakus 加载历史记录（或其中一个分P）的弹幕，应用房间过滤规则并映射到对应时间轴
+// partID为0时以整场直播开始为时间零点
+func (s *DanmakuExportService) LoadDanmakus(historyID, partID uint) (*models.RecordHistory, *models.RecordHistoryPart, []TimedDanmaku, error) {
+	db := database.GetDB()
+
+	var history models.RecordHistory
+	if err := db.First(&history, historyID).Error; err != nil {
+		return nil, nil, nil, fmt.Errorf("历史记录不存在: %w", err)
+	}
+
+	var part *models.RecordHistoryPart
+	if partID > 0 {
+		part = &models.RecordHistoryPart{}
+		if err := db.Where("id = ? AND history_id = ?", partID, historyID).First(part).Error; err != nil {
+			return nil, nil, nil, fmt.Errorf("分P不存在: %w", err)
+		}
+	}
+
+	query := db.Where("session_id = ?", history.SessionID).
+		Where("message != '' AND message IS NOT NULL").
+		Order("timestamp ASC")
+
+	var room models.RecordRoom
+	hasRoom := db.Where("room_id = ?", history.RoomID).First(&room).Error == nil
+	if hasRoom {
+		query = applyDanmakuFilters(query, &room, history.RoomID)
+	}
+
+	var msgs []models.LiveMsg
+	if err := query.Find(&msgs).Error; err != nil {
+		return nil, nil, nil, fmt.Errorf("查询弹幕失败: %w", err)
+	}
+	if hasRoom {
+		dmFilter, err := NewRoomDanmakuFilter(&room)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		msgs = dmFilter.Filter(msgs)
+	}
+	if hasRoom && room.DmDistinct && len(msgs) > 0 {
+		msgs = NewDanmakuService().deduplicateDanmakus(msgs)
+	}
+
+	// 计算时间窗口，分P按其相对直播开始的偏移截取，videoMs为校准后分P视频0秒的位置
+	var startMs, endMs, videoMs int64 = 0, -1, 0
+	if part != nil {
+		videoMs = NewDanmakuOffsetService().PartOffset(&history, part)
+		startMs = part.StartTime.UnixMilli() - history.StartTime.UnixMilli()
+		if part.EndTime.After(part.StartTime) {
+			endMs = part.EndTime.UnixMilli() - history.StartTime.UnixMilli()
+		} else if duration := NewMediaService().EnsurePartDuration(part); duration > 0 {
+			endMs = startMs + int64(duration)*1000
+		}
+	}
+
+	danmakus := make([]TimedDanmaku, 0, len(msgs))
+	for _, msg := range msgs {
+		if msg.Timestamp < startMs || (endMs >= 0 && msg.Timestamp >= endMs) {
+			continue
+		}
+		offset := msg.Timestamp - videoMs
+		if offset < 0 {
+			offset = 0
+		}
+		danmakus = append(danmakus, TimedDanmaku{LiveMsg: msg, Offset: offset})
+	}
+
+	return &history, part, danmakus, nil
+}
+
+// Export 导出弹幕，返回文件内容和建议的文件名
+func (s *DanmakuExportService) Export(historyID, partID uint, format string, opts DanmakuExportOptions) ([]byte, string, error) {
+	history, part, danmakus, err := s.LoadDanmakus(historyID, partID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	baseName := fmt.Sprintf("%s_%d", history.Uname, history.ID)
+	if part != nil {
+		baseName = strings.TrimSuffix(part.FileName, filepath.Ext(part.FileName))
+	}
+
+	var data []byte
+	switch format {
+	case DanmakuExportASS, "":
+		format = DanmakuExportASS
+		data = s.RenderASS(danmakus, opts)
+	case DanmakuExportSRT:
+		data = s.RenderSRT(danmakus, opts)
+	case DanmakuExportXML:
+		data = s.RenderXML(danmakus)
+	default:
+		return nil, "", fmt.Errorf("不支持的导出格式: %s", format)
+	}
+
+	return data, baseName + "." + format, nil
+}
+
+// assLane 记录轨道上最后一条弹幕的位置信息
+type assLane struct {
+	start    float64 // 出现时间（秒）
+	end      float64 // 消失时间（秒）
+	width    float64 // 文本宽度
+	duration float64
+}
+
+// RenderASS 生成ASS字幕，滚动弹幕从右向左移动，顶部/底部弹幕居中固定显示
+// 每种弹幕按轨道排布，放不下的弹幕直接丢弃以避免重叠
+func (s *DanmakuExportService) RenderASS(danmakus []TimedDanmaku, opts DanmakuExportOptions) []byte {
+	var buf bytes.Buffer
+	alpha := int((1 - opts.Opacity) * 255)
+	if alpha < 0 {
+		alpha = 0
+	} else if alpha > 255 {
+		alpha = 255
+	}
+
+	fmt.Fprintf(&buf, "[Script Info]\nScriptType: v4.00+\nPlayResX: %d\nPlayResY: %d\nWrapStyle: 2\nScaledBorderAndShadow: yes\n\n", opts.Width, opts.Height)
+	buf.WriteString("[V4+ Styles]\n")
+	buf.WriteString("Format: Name, Fontname, Fontsize, PrimaryColour, SecondaryColour, OutlineColour, BackColour, Bold, Italic, Underline, StrikeOut, ScaleX, ScaleY, Spacing, Angle, BorderStyle, Outline, Shadow, Alignment, MarginL, MarginR, MarginV, Encoding\n")
+	fmt.Fprintf(&buf, "Style: Danmaku,%s,%d,&H%02XFFFFFF,&H%02XFFFFFF,&H%02X000000,&H%02X000000,0,0,0,0,100,100,0,0,1,1.5,0,7,0,0,0,1\n\n",
+		opts.FontName, opts.FontSize, alpha, alpha, alpha, alpha)
+	buf.WriteString("[Events]\n")
+	buf.WriteString("Format: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text\n")
+
+	scrollArea := float64(opts.Height) * opts.DisplayArea
+	if scrollArea <= 0 {
+		scrollArea = float64(opts.Height)
+	}
+	laneHeight := float64(opts.FontSize) * 1.15
+	scrollLanes := make([]*assLane, int(scrollArea/laneHeight))
+	topLanes := make([]*assLane, int(float64(opts.Height)/2/laneHeight))
+	bottomLanes := make([]*assLane, len(topLanes))
+	screenWidth := float64(opts.Width)
+
+	for _, dm := range danmakus {
+		text := escapeASSText(dm.Message)
+		if text == "" {
+			continue
+		}
+		fontSize := s.scaleFontSize(dm.FontSize, opts.FontSize)
+		width := estimateTextWidth(dm.Message, fontSize)
+		start := float64(dm.Offset) / 1000
+
+		colorTag := ""
+		if color := dm.Color & 0xFFFFFF; color != 0xFFFFFF {
+			colorTag = fmt.Sprintf(`\c&H%s&`, assColor(color))
+			if color == 0 {
+				// 黑色弹幕使用白色描边保证可读
+				colorTag += `\3c&HFFFFFF&`
+			}
+		}
+		sizeTag := ""
+		if fontSize != opts.FontSize {
+			sizeTag = fmt.Sprintf(`\fs%d`, fontSize)
+		}
+
+		switch dm.Mode {
+		case danmakuModeTop, danmakuModeBottom:
+			lanes := topLanes
+			if dm.Mode == danmakuModeBottom {
+				lanes = bottomLanes
+			}
+			end := start + opts.FixedDuration
+			lane := findFixedLane(lanes, start)
+			if lane < 0 {
+				continue
+			}
+			lanes[lane] = &assLane{start: start, end: end, width: width}
+
+			var pos string
+			if dm.Mode == danmakuModeTop {
+				pos = fmt.Sprintf(`\an8\pos(%d,%d)`, opts.Width/2, int(float64(lane)*laneHeight))
+			} else {
+				pos = fmt.Sprintf(`\an2\pos(%d,%d)`, opts.Width/2, int(float64(opts.Height)-float64(lane)*laneHeight))
+			}
+			fmt.Fprintf(&buf, "Dialogue: 1,%s,%s,Danmaku,,0,0,0,,{%s%s%s}%s\n",
+				formatASSTime(start), formatASSTime(end), pos, sizeTag, colorTag, text)
+		default:
+			end := start + opts.ScrollDuration
+			lane := findScrollLane(scrollLanes, start, width, screenWidth, opts.ScrollDuration)
+			if lane < 0 {
+				continue
+			}
+			scrollLanes[lane] = &assLane{start: start, end: end, width: width, duration: opts.ScrollDuration}
+
+			y := int(float64(lane) * laneHeight)
+			move := fmt.Sprintf(`\move(%d,%d,%d,%d)`, opts.Width, y, -int(width), y)
+			fmt.Fprintf(&buf, "Dialogue: 0,%s,%s,Danmaku,,0,0,0,,{%s%s%s}%s\n",
+				formatASSTime(start), formatASSTime(end), move, sizeTag, colorTag, text)
+		}
+	}
+
+	return buf.Bytes()
+}
+
+// scaleFontSize 按标准字号25换算画布字号，特大字号（如SC）限制在1.6倍以内
+func (s *DanmakuExportService) scaleFontSize(size, base int) int {
+	if size <= 0 {
+		return base
+	}
+	scaled := size * base / 25
+	if limit := base * 16 / 10; scaled > limit {
+		scaled = limit
+	}
+	return scaled
+}
+
+// findScrollLane 查找可放置滚动弹幕的轨道：前一条弹幕尾部已完全进入屏幕，且新弹幕在到达左侧前不会追上它
+func findScrollLane(lanes []*assLane, start, width, screenWidth, duration float64) int {
+	speed := (screenWidth + width) / duration
+	for i, prev := range lanes {
+		if prev == nil || prev.end <= start {
+			return i
+		}
+		prevSpeed := (screenWidth + prev.width) / prev.duration
+		tailEntered := prev.start+prev.width/prevSpeed <= start
+		noCatchUp := start+screenWidth/speed >= prev.end
+		if tailEntered && noCatchUp {
+			return i
+		}
+	}
+	return -1
+}
+
+// findFixedLane 查找空闲的顶部/底部轨道
+func findFixedLane(lanes []*assLane, start float64) int {
+	for i, prev := range lanes {
+		if prev == nil || prev.end <= start {
+			return i
+		}
+	}
+	return -1
+}
+
+// estimateTextWidth 估算文本宽度，半角字符按半个字宽计算
+func estimateTextWidth(text string, fontSize int) float64 {
+	width := 0.0
+	for _, r := range text {
+		if r < 0x80 {
+			width += float64(fontSize) * 0.55
+		} else {
+			width += float64(fontSize)
+		}
+	}
+	return width
+}
+
+// assColor 将RGB颜色转换为ASS使用的BGR格式
+func assColor(rgb int) string {
+	r := (rgb >> 16) & 0xFF
+	g := (rgb >> 8) & 0xFF
+	b := rgb & 0xFF
+	return fmt.Sprintf("%02X%02X%02X", b, g, r)
+}
+
+// escapeASSText 转义ASS特殊字符，花括号和反斜杠替换为全角字符
+func escapeASSText(text string) string {
+	text = strings.TrimSpace(text)
+	replacer := strings.NewReplacer(
+		`\`, `＼`,
+		"{", "｛",
+		"}", "｝",
+		"\r", "",
+		"\n", " ",
+	)
+	return replacer.Replace(text)
+}
+
+// formatASSTime 格式化为ASS时间 H:MM:SS.cc
+func formatASSTime(seconds float64) string {
+	cs := int64(seconds*100 + 0.5)
+	return fmt.Sprintf("%d:%02d:%02d.%02d", cs/360000, cs/6000%60, cs/100%60, cs%100)
+}
+
+// RenderSRT 生成SRT字幕，每条弹幕显示固定时长
+func (s *DanmakuExportService) RenderSRT(danmakus []TimedDanmaku, opts DanmakuExportOptions) []byte {
+	var buf bytes.Buffer
+	index := 0
+	for _, dm := range danmakus {
+		text := strings.TrimSpace(strings.ReplaceAll(dm.Message, "\n", " "))
+		if !utf8.ValidString(text) || text == "" {
+			continue
+		}
+		index++
+		start := float64(dm.Offset) / 1000
+		fmt.Fprintf(&buf, "%d\n%s --> %s\n%s\n\n", index, formatSRTTime(start), formatSRTTime(start+opts.FixedDuration), text)
+	}
+	return buf.Bytes()
+}
+
+// formatSRTTime 格式化为SRT时间 HH:MM:SS,mmm
+func formatSRTTime(seconds float64) string {
+	ms := int64(seconds*1000 + 0.5)
+	return fmt.Sprintf("%02d:%02d:%02d,%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+// RenderXML 生成B站格式的弹幕XML，可供播放器直接加载
+func (s *DanmakuExportService) RenderXML(danmakus []TimedDanmaku) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	buf.WriteString("<i>\n<chatserver>chat.bilibili.com</chatserver>\n<chatid>0</chatid>\n")
+	for i, dm := range danmakus {
+		mode := dm.Mode
+		if mode == 0 {
+			mode = danmakuModeScroll
+		}
+		fontSize := dm.FontSize
+		if fontSize == 0 {
+			fontSize = 25
+		}
+		fmt.Fprintf(&buf, `<d p="%.3f,%d,%d,%d,%d,0,%d,%d">`,
+			float64(dm.Offset)/1000, mode, fontSize, dm.Color&0xFFFFFF, dm.CreatedAt.Unix(), dm.UID, i+1)
+		xml.EscapeText(&buf, []byte(dm.Message))
+		buf.WriteString("</d>\n")
+	}
+	buf.WriteString("</i>\n")
+	return buf.Bytes()
+}
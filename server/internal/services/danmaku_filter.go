@@ -0,0 +1,218 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gobup/server/internal/models"
+)
+
+// 弹幕过滤规则动作
+const (
+	DmRuleActionKeep     = "keep"     // 保留并停止匹配后续规则（白名单）
+	DmRuleActionDrop     = "drop"     // 丢弃
+	DmRuleActionRewrite  = "rewrite"  // 改写内容后继续匹配
+	DmRuleActionCollapse = "collapse" // 折叠连续重复字符后继续匹配
+	DmRuleActionTop      = "top"      // 改为顶部弹幕后继续匹配
+	DmRuleActionBottom   = "bottom"   // 改为底部弹幕后继续匹配
+)
+
+// defaultCollapseRepeat 折叠重复字符时默认保留的个数
+const defaultCollapseRepeat = 3
+
+// DanmakuFilterRule 弹幕过滤规则，所有已设置的条件同时满足时命中
+type DanmakuFilterRule struct {
+	Name         string  `json:"name"`
+	Disabled     bool    `json:"disabled"`
+	Pattern      string  `json:"pattern"`      // 正则匹配弹幕内容
+	UIDs         []int64 `json:"uids"`         // 发送者UID在列表中（配合keep/drop作为白名单/黑名单）
+	MaxPerMinute int     `json:"maxPerMinute"` // 同一用户每分钟发送超过该条数
+	MinLength    int     `json:"minLength"`    // 内容短于该长度（字符数）
+	MaxLength    int     `json:"maxLength"`    // 内容长于该长度（字符数）
+	Action       string  `json:"action"`
+	Replacement  string  `json:"replacement"` // rewrite: 有Pattern时替换匹配部分（支持$1），否则替换整条内容
+	RepeatLimit  int     `json:"repeatLimit"` // collapse: 连续重复字符最多保留个数，默认3
+}
+
+// DanmakuRuleHit 规则命中记录
+type DanmakuRuleHit struct {
+	Index  int    `json:"index"`
+	Name   string `json:"name"`
+	Action string `json:"action"`
+}
+
+// DanmakuFilterResult 单条弹幕的过滤结果
+type DanmakuFilterResult struct {
+	Dropped bool             `json:"dropped"`
+	Message string           `json:"message"` // 处理后的内容
+	Mode    int              `json:"mode"`
+	Hits    []DanmakuRuleHit `json:"hits"`
+}
+
+type compiledDanmakuRule struct {
+	DanmakuFilterRule
+	index   int
+	pattern *regexp.Regexp
+	uids    map[int64]bool
+}
+
+// DanmakuFilter 按顺序执行的弹幕规则过滤器，包含每分钟发言计数状态，每次过滤一批弹幕时新建
+type DanmakuFilter struct {
+	rules        []compiledDanmakuRule
+	minuteCounts map[string]int
+}
+
+// ParseDanmakuFilterRules 解析房间保存的规则JSON
+func ParseDanmakuFilterRules(raw string) ([]DanmakuFilterRule, error) {
+	var rules []DanmakuFilterRule
+	if strings.TrimSpace(raw) == "" {
+		return rules, nil
+	}
+	if err := json.Unmarshal([]byte(raw), &rules); err != nil {
+		return nil, fmt.Errorf("弹幕过滤规则格式错误: %w", err)
+	}
+	return rules, nil
+}
+
+// NewDanmakuFilter 编译规则，正则或动作无效时返回错误
+func NewDanmakuFilter(rules []DanmakuFilterRule) (*DanmakuFilter, error) {
+	filter := &DanmakuFilter{minuteCounts: make(map[string]int)}
+	for i, rule := range rules {
+		if rule.Disabled {
+			continue
+		}
+		compiled := compiledDanmakuRule{DanmakuFilterRule: rule, index: i}
+
+		switch rule.Action {
+		case DmRuleActionKeep, DmRuleActionDrop, DmRuleActionRewrite, DmRuleActionCollapse, DmRuleActionTop, DmRuleActionBottom:
+		default:
+			return nil, fmt.Errorf("规则%d: 不支持的动作 %q", i+1, rule.Action)
+		}
+
+		if rule.Pattern != "" {
+			re, err := regexp.Compile(rule.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("规则%d: 正则表达式无效: %w", i+1, err)
+			}
+			compiled.pattern = re
+		}
+		if len(rule.UIDs) > 0 {
+			compiled.uids = make(map[int64]bool, len(rule.UIDs))
+			for _, uid := range rule.UIDs {
+				compiled.uids[uid] = true
+			}
+		}
+		if compiled.RepeatLimit <= 0 {
+			compiled.RepeatLimit = defaultCollapseRepeat
+		}
+		filter.rules = append(filter.rules, compiled)
+	}
+	return filter, nil
+}
+
+// NewRoomDanmakuFilter 根据房间配置创建过滤器，没有规则时返回nil
+func NewRoomDanmakuFilter(room *models.RecordRoom) (*DanmakuFilter, error) {
+	rules, err := ParseDanmakuFilterRules(room.DmFilterRules)
+	if err != nil || len(rules) == 0 {
+		return nil, err
+	}
+	return NewDanmakuFilter(rules)
+}
+
+// Apply 按顺序对一条弹幕执行规则，弹幕需按时间顺序传入以保证频率限制准确
+func (f *DanmakuFilter) Apply(msg *models.LiveMsg) DanmakuFilterResult {
+	result := DanmakuFilterResult{Message: msg.Message, Mode: msg.Mode, Hits: []DanmakuRuleHit{}}
+
+	for i := range f.rules {
+		rule := &f.rules[i]
+		if !f.matches(rule, msg, result.Message) {
+			continue
+		}
+		result.Hits = append(result.Hits, DanmakuRuleHit{Index: rule.index, Name: rule.Name, Action: rule.Action})
+
+		switch rule.Action {
+		case DmRuleActionKeep:
+			return result
+		case DmRuleActionDrop:
+			result.Dropped = true
+			return result
+		case DmRuleActionRewrite:
+			if rule.pattern != nil {
+				result.Message = rule.pattern.ReplaceAllString(result.Message, rule.Replacement)
+			} else {
+				result.Message = rule.Replacement
+			}
+		case DmRuleActionCollapse:
+			result.Message = collapseRepeatedRunes(result.Message, rule.RepeatLimit)
+		case DmRuleActionTop:
+			result.Mode = danmakuModeTop
+		case DmRuleActionBottom:
+			result.Mode = danmakuModeBottom
+		}
+	}
+
+	// 改写后内容为空视为丢弃
+	if strings.TrimSpace(result.Message) == "" {
+		result.Dropped = true
+	}
+	return result
+}
+
+// matches 判断规则的所有条件是否满足，message为前序规则处理后的内容
+func (f *DanmakuFilter) matches(rule *compiledDanmakuRule, msg *models.LiveMsg, message string) bool {
+	if rule.pattern != nil && !rule.pattern.MatchString(message) {
+		return false
+	}
+	if rule.uids != nil && !rule.uids[msg.UID] {
+		return false
+	}
+	length := len([]rune(message))
+	if rule.MinLength > 0 && length >= rule.MinLength {
+		return false
+	}
+	if rule.MaxLength > 0 && length <= rule.MaxLength {
+		return false
+	}
+	if rule.MaxPerMinute > 0 {
+		user := msg.UserName
+		if msg.UID != 0 {
+			user = strconv.FormatInt(msg.UID, 10)
+		}
+		key := fmt.Sprintf("%d|%s|%d", rule.index, user, msg.Timestamp/60000)
+		f.minuteCounts[key]++
+		if f.minuteCounts[key] <= rule.MaxPerMinute {
+			return false
+		}
+	}
+	return true
+}
+
+// Filter 过滤一批弹幕，返回保留的弹幕（内容和模式已按规则改写）
+func (f *DanmakuFilter) Filter(danmakus []models.LiveMsg) []models.LiveMsg {
+	if f == nil {
+		return danmakus
+	}
+
+	result := make([]models.LiveMsg, 0, len(danmakus))
+	dropped := 0
+	for _, dm := range danmakus {
+		res := f.Apply(&dm)
+		if res.Dropped {
+			dropped++
+			continue
+		}
+		if dm.OriginalMessage == "" {
+			dm.OriginalMessage = dm.Message
+		}
+		dm.Message = res.Message
+		dm.Mode = res.Mode
+		result = append(result, dm)
+	}
+
+	log.Printf("[弹幕过滤] 应用过滤规则: %d 条规则, 丢弃 %d 条, 剩余 %d 条", len(f.rules), dropped, len(result))
+	return result
+}
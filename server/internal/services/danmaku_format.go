@@ -0,0 +1,214 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gobup/server/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DanmakuSink 弹幕格式解析器的输出，时间戳为相对文件开始录制的毫秒数
+type DanmakuSink interface {
+	// SetRecordStart 设置文件中记录的录制开始时间，需在添加弹幕前调用
+	SetRecordStart(t time.Time)
+	AddMsg(msg *models.LiveMsg) error
+	AddEvent(event *models.LiveEvent) error
+}
+
+// DanmakuFormatParser 弹幕文件格式解析器，统一转换为 LiveMsg / LiveEvent
+type DanmakuFormatParser interface {
+	Name() string
+	// Extensions 支持的扩展名（小写，含点）
+	Extensions() []string
+	// Sniff 根据文件开头内容判断是否为该格式
+	Sniff(head []byte) bool
+	Parse(r io.Reader, sessionID string, sink DanmakuSink) error
+}
+
+var (
+	danmakuFormatsMu sync.RWMutex
+	danmakuFormats   []DanmakuFormatParser
+)
+
+// RegisterDanmakuFormat 注册弹幕格式解析器，同名解析器会被替换
+func RegisterDanmakuFormat(parser DanmakuFormatParser) {
+	danmakuFormatsMu.Lock()
+	defer danmakuFormatsMu.Unlock()
+	for i, existing := range danmakuFormats {
+		if existing.Name() == parser.Name() {
+			danmakuFormats[i] = parser
+			return
+		}
+	}
+	danmakuFormats = append(danmakuFormats, parser)
+}
+
+func init() {
+	xmlParser := NewDanmakuXMLParser()
+	RegisterDanmakuFormat(&xmlDanmakuFormat{parser: xmlParser})
+	RegisterDanmakuFormat(&jsonDanmakuFormat{parser: xmlParser})
+	RegisterDanmakuFormat(&assDanmakuFormat{})
+}
+
+func registeredDanmakuFormats() []DanmakuFormatParser {
+	danmakuFormatsMu.RLock()
+	defer danmakuFormatsMu.RUnlock()
+	return append([]DanmakuFormatParser(nil), danmakuFormats...)
+}
+
+// danmakuSniffSize 内容识别读取的字节数
+const danmakuSniffSize = 4096
+
+// DetectDanmakuFormat 选择弹幕文件的解析器：扩展名匹配且内容相符时优先，否则按内容识别
+func DetectDanmakuFormat(path string, head []byte) (DanmakuFormatParser, error) {
+	head = bytes.TrimPrefix(head, []byte("\xef\xbb\xbf"))
+	formats := registeredDanmakuFormats()
+
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, format := range formats {
+		for _, e := range format.Extensions() {
+			if e == ext && (len(head) == 0 || format.Sniff(head)) {
+				return format, nil
+			}
+		}
+	}
+	for _, format := range formats {
+		if format.Sniff(head) {
+			return format, nil
+		}
+	}
+	return nil, fmt.Errorf("无法识别的弹幕文件格式: %s", filepath.Base(path))
+}
+
+// DetectDanmakuFileFormat 读取文件开头识别弹幕格式
+func DetectDanmakuFileFormat(path string) (DanmakuFormatParser, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("无法打开文件: %w", err)
+	}
+	defer file.Close()
+
+	head := make([]byte, danmakuSniffSize)
+	n, _ := io.ReadFull(file, head)
+	return DetectDanmakuFormat(path, head[:n])
+}
+
+// findDanmakuFile 查找分P视频对应的弹幕文件，优先XML，其次按注册顺序查找其他格式
+func findDanmakuFile(videoPath string) string {
+	if xmlPath := findDanmakuXML(videoPath); xmlPath != "" {
+		return xmlPath
+	}
+	base := strings.TrimSuffix(videoPath, filepath.Ext(videoPath))
+	for _, format := range registeredDanmakuFormats() {
+		for _, ext := range format.Extensions() {
+			if _, err := os.Stat(base + ext); err == nil {
+				return base + ext
+			}
+		}
+	}
+	return ""
+}
+
+// countingReader 记录已读取的字节数，用于计算解析进度
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// danmakuBatchWriter 将解析结果换算到直播时间轴并分批写入数据库
+// 每批使用独立的短事务，避免长时间占用SQLite唯一的写连接
+type danmakuBatchWriter struct {
+	db       *gorm.DB
+	base     danmakuTimeBase
+	offsetMs int64
+	batch    []*models.LiveMsg
+	events   []*models.LiveEvent
+	parsed   int
+	inserted int
+
+	counter  *countingReader
+	fileSize int64
+	onBatch  func(ratio float64, parsed, inserted int)
+}
+
+func (w *danmakuBatchWriter) SetRecordStart(t time.Time) {
+	w.offsetMs = w.base.offsetMs(t)
+}
+
+func (w *danmakuBatchWriter) AddMsg(msg *models.LiveMsg) error {
+	msg.Timestamp += w.offsetMs
+	w.parsed++
+	w.batch = append(w.batch, msg)
+	if len(w.batch) >= danmakuInsertBatchSize {
+		return w.flush()
+	}
+	return nil
+}
+
+func (w *danmakuBatchWriter) AddEvent(event *models.LiveEvent) error {
+	event.Timestamp += w.offsetMs
+	w.events = append(w.events, event)
+	if len(w.events) >= danmakuInsertBatchSize {
+		return w.flush()
+	}
+	return nil
+}
+
+func (w *danmakuBatchWriter) flush() error {
+	if len(w.events) > 0 {
+		if err := w.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&w.events).Error; err != nil {
+			return fmt.Errorf("保存付费事件失败: %w", err)
+		}
+		w.events = w.events[:0]
+	}
+	if len(w.batch) == 0 {
+		return nil
+	}
+	result := w.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&w.batch)
+	if result.Error != nil {
+		return fmt.Errorf("保存弹幕失败: %w", result.Error)
+	}
+	w.inserted += int(result.RowsAffected)
+	w.batch = w.batch[:0]
+
+	if w.onBatch != nil {
+		ratio := 1.0
+		if w.fileSize > 0 && w.counter.n < w.fileSize {
+			ratio = float64(w.counter.n) / float64(w.fileSize)
+		}
+		w.onBatch(ratio, w.parsed, w.inserted)
+	}
+	return nil
+}
+
+// newDanmakuFileReader 打开弹幕文件并识别格式
+func newDanmakuFileReader(path string) (*os.File, *bufio.Reader, *countingReader, DanmakuFormatParser, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, nil, nil, fmt.Errorf("无法打开文件: %w", err)
+	}
+	counter := &countingReader{r: file}
+	reader := bufio.NewReaderSize(counter, danmakuSniffSize*4)
+	head, _ := reader.Peek(danmakuSniffSize)
+	format, err := DetectDanmakuFormat(path, head)
+	if err != nil {
+		file.Close()
+		return nil, nil, nil, nil, err
+	}
+	return file, reader, counter, format, nil
+}
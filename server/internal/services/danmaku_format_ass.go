@@ -0,0 +1,173 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gobup/server/internal/models"
+)
+
+var (
+	assOverrideTagRe = regexp.MustCompile(`\{[^}]*\}`)
+	assColorRe       = regexp.MustCompile(`\\1?c&H([0-9A-Fa-f]{1,8})&?`)
+	assFontSizeRe    = regexp.MustCompile(`\\fs(\d+)`)
+	assAlignRe       = regexp.MustCompile(`\\an?(\d)`)
+)
+
+// assDanmakuFormat 其他工具（DanmakuFactory、biliass等）生成的ASS弹幕字幕
+// 滚动弹幕使用\move，顶部/底部弹幕使用\pos配合\an8/\an2或TOP/BTM样式
+type assDanmakuFormat struct{}
+
+func (f *assDanmakuFormat) Name() string { return "ass" }
+
+func (f *assDanmakuFormat) Extensions() []string { return []string{".ass", ".ssa"} }
+
+func (f *assDanmakuFormat) Sniff(head []byte) bool {
+	return bytes.Contains(head, []byte("[Script Info]")) || bytes.Contains(head, []byte("[Events]"))
+}
+
+func (f *assDanmakuFormat) Parse(r io.Reader, sessionID string, sink DanmakuSink) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	// 默认字段顺序，遇到[Events]下的Format行时更新
+	fields := []string{"layer", "start", "end", "style", "name", "marginl", "marginr", "marginv", "effect", "text"}
+	inEvents := false
+	count, skipped := 0, 0
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\ufeff"))
+		if strings.HasPrefix(line, "[") {
+			inEvents = strings.EqualFold(line, "[Events]")
+			continue
+		}
+		if !inEvents {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "format":
+			fields = fields[:0]
+			for _, name := range strings.Split(value, ",") {
+				fields = append(fields, strings.ToLower(strings.TrimSpace(name)))
+			}
+			continue
+		case "dialogue":
+		default:
+			continue
+		}
+
+		// Text为最后一个字段，内容中可能包含逗号
+		values := strings.SplitN(value, ",", len(fields))
+		if len(values) < len(fields) {
+			skipped++
+			continue
+		}
+		row := make(map[string]string, len(fields))
+		for i, name := range fields {
+			row[name] = strings.TrimSpace(values[i])
+		}
+
+		msg := parseASSDialogue(row, sessionID)
+		if msg == nil {
+			skipped++
+			continue
+		}
+		count++
+		if err := sink.AddMsg(msg); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Printf("[弹幕解析] ⚠️  ASS读取中断，保留已解析内容: %v", err)
+	}
+
+	log.Printf("[弹幕解析] 解析到: ASS弹幕=%d, 跳过=%d", count, skipped)
+	return nil
+}
+
+// parseASSDialogue 将一行Dialogue转换为弹幕
+func parseASSDialogue(row map[string]string, sessionID string) *models.LiveMsg {
+	timestamp, ok := parseASSTime(row["start"])
+	if !ok {
+		return nil
+	}
+
+	raw := row["text"]
+	text := assOverrideTagRe.ReplaceAllString(raw, "")
+	text = strings.NewReplacer(`\N`, " ", `\n`, " ", `\h`, " ").Replace(text)
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil
+	}
+
+	mode := 1
+	style := strings.ToLower(row["style"])
+	switch {
+	case strings.Contains(raw, `\move`):
+		mode = 1
+	case strings.Contains(style, "top"):
+		mode = danmakuModeTop
+	case strings.Contains(style, "btm") || strings.Contains(style, "bottom"):
+		mode = danmakuModeBottom
+	default:
+		if m := assAlignRe.FindStringSubmatch(raw); m != nil {
+			switch m[1] {
+			case "7", "8", "9":
+				mode = danmakuModeTop
+			case "1", "2", "3":
+				mode = danmakuModeBottom
+			}
+		}
+	}
+
+	// ASS颜色为BGR顺序
+	color := 16777215
+	if m := assColorRe.FindStringSubmatch(raw); m != nil {
+		if bgr, err := strconv.ParseUint(m[1], 16, 32); err == nil {
+			color = int((bgr&0xFF)<<16 | (bgr>>8&0xFF)<<8 | (bgr >> 16 & 0xFF))
+		}
+	}
+
+	fontSize := 25
+	if m := assFontSizeRe.FindStringSubmatch(raw); m != nil {
+		if size, err := strconv.Atoi(m[1]); err == nil && size > 0 {
+			fontSize = size
+		}
+	}
+
+	return &models.LiveMsg{
+		SessionID: sessionID,
+		Timestamp: timestamp,
+		Type:      LiveMsgTypeDanmaku,
+		UserName:  row["name"],
+		Message:   text,
+		Mode:      mode,
+		FontSize:  fontSize,
+		Color:     color,
+	}
+}
+
+// parseASSTime 解析ASS时间 H:MM:SS.cc，返回毫秒
+func parseASSTime(value string) (int64, bool) {
+	parts := strings.Split(strings.TrimSpace(value), ":")
+	if len(parts) != 3 {
+		return 0, false
+	}
+	hours, err1 := strconv.Atoi(parts[0])
+	minutes, err2 := strconv.Atoi(parts[1])
+	seconds, err3 := strconv.ParseFloat(parts[2], 64)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return 0, false
+	}
+	return int64(hours)*3600000 + int64(minutes)*60000 + int64(seconds*1000), true
+}
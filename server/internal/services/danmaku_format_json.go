@@ -0,0 +1,241 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gobup/server/internal/models"
+)
+
+// jsonDanmakuFormat JSON lines（biliup/DanmakuFactory风格）弹幕，也兼容整个文件为JSON数组
+// 每行一个对象，常见字段：
+//
+//	{"time": 12.3, "text": "弹幕", "mode": 1, "size": 25, "color": 16777215, "uid": 123, "user": "用户名"}
+//	{"type": "sc", "time": 60, "user": "用户名", "uid": 123, "price": 30, "text": "留言"}
+//	{"type": "gift", "time": 61, "user": "用户名", "gift_name": "小心心", "num": 5, "price": 0}
+//	{"type": "guard", "time": 62, "user": "用户名", "level": 3, "num": 1}
+//	{"record_start_time": 1704110400}
+//
+// 时间字段为相对录制开始的秒数，progress为毫秒；数值为Unix时间戳时按绝对时间处理
+type jsonDanmakuFormat struct {
+	parser *DanmakuXMLParser
+}
+
+func (f *jsonDanmakuFormat) Name() string { return "jsonl" }
+
+func (f *jsonDanmakuFormat) Extensions() []string { return []string{".jsonl", ".json"} }
+
+func (f *jsonDanmakuFormat) Sniff(head []byte) bool {
+	head = bytes.TrimSpace(head)
+	if bytes.HasPrefix(head, []byte("{")) {
+		return true
+	}
+	// 数组需以对象开头，避免与ASS的[Script Info]混淆
+	if rest, ok := bytes.CutPrefix(head, []byte("[")); ok {
+		rest = bytes.TrimSpace(rest)
+		return len(rest) == 0 || rest[0] == '{' || rest[0] == ']'
+	}
+	return false
+}
+
+func (f *jsonDanmakuFormat) Parse(r io.Reader, sessionID string, sink DanmakuSink) error {
+	reader := bufio.NewReader(r)
+	if bom, _ := reader.Peek(3); bytes.Equal(bom, []byte("\xef\xbb\xbf")) {
+		reader.Discard(3)
+	}
+
+	decoder := json.NewDecoder(reader)
+	decoder.UseNumber()
+
+	// 整个文件为JSON数组时先读掉开头的 [
+	if head, _ := reader.Peek(danmakuSniffSize); bytes.HasPrefix(bytes.TrimSpace(head), []byte("[")) {
+		if _, err := decoder.Token(); err != nil {
+			return fmt.Errorf("JSON格式错误: %w", err)
+		}
+	}
+
+	state := &jsonDanmakuState{}
+	var dCount, scCount, giftCount, guardCount, skipped int
+	for decoder.More() {
+		var obj map[string]interface{}
+		if err := decoder.Decode(&obj); err != nil {
+			// 文件被截断时保留已解析的部分
+			log.Printf("[弹幕解析] ⚠️  JSON读取中断，保留已解析内容: %v", err)
+			break
+		}
+
+		if start, ok := parseRecordStartTime(jsonString(obj, "record_start_time", "start_time")); ok && jsonString(obj, "text", "content", "message", "msg") == "" {
+			state.recordStart = start
+			sink.SetRecordStart(start)
+			continue
+		}
+
+		ts, ok := state.timestamp(obj, sink)
+		if !ok {
+			skipped++
+			continue
+		}
+		seconds := strconv.FormatFloat(float64(ts)/1000, 'f', 3, 64)
+		user := jsonString(obj, "user", "uname", "username", "user_name", "nickname")
+		uid := jsonString(obj, "uid", "mid", "user_id")
+
+		switch jsonDanmakuType(obj) {
+		case "sc":
+			scCount++
+			sc := SC{TS: seconds, User: user, UID: uid, Price: jsonString(obj, "price"), Text: jsonString(obj, "text", "content", "message", "msg")}
+			if event := f.parser.parseSCEvent(sc, sessionID, danmakuSourceRecorder); event != nil {
+				if err := sink.AddEvent(event); err != nil {
+					return err
+				}
+			}
+			if msg, err := f.parser.parseSC(sc, sessionID, danmakuSourceRecorder); err == nil {
+				if err := sink.AddMsg(msg); err != nil {
+					return err
+				}
+			}
+		case "guard":
+			guardCount++
+			guard := Guard{TS: seconds, User: user, UID: uid, Level: jsonString(obj, "level", "guard_level"), Count: jsonString(obj, "num", "count")}
+			if event := f.parser.parseGuardEvent(guard, sessionID); event != nil {
+				if err := sink.AddEvent(event); err != nil {
+					return err
+				}
+			}
+			if msg, err := f.parser.parseGuard(guard, sessionID); err == nil {
+				if err := sink.AddMsg(msg); err != nil {
+					return err
+				}
+			}
+		case "gift":
+			giftCount++
+			gift := Gift{TS: seconds, User: user, UID: uid, GiftName: jsonString(obj, "gift_name", "giftName", "name"), Num: jsonString(obj, "num", "count"), CoinType: jsonString(obj, "coin_type", "cointype"), Price: jsonString(obj, "price")}
+			if event := f.parser.parseGiftEvent(gift, sessionID); event != nil {
+				if err := sink.AddEvent(event); err != nil {
+					return err
+				}
+			}
+		default:
+			text := strings.TrimSpace(jsonString(obj, "text", "content", "message", "msg"))
+			if text == "" {
+				skipped++
+				continue
+			}
+			dCount++
+			mode, _ := strconv.Atoi(jsonString(obj, "mode"))
+			if mode == 0 {
+				mode = 1
+			}
+			fontSize, _ := strconv.Atoi(jsonString(obj, "size", "fontsize", "font_size"))
+			if fontSize == 0 {
+				fontSize = 25
+			}
+			color, err := strconv.Atoi(jsonString(obj, "color"))
+			if err != nil {
+				color = 16777215
+			}
+			uidNum, _ := strconv.ParseInt(uid, 10, 64)
+			medalLevel, _ := strconv.Atoi(jsonString(obj, "medal_level"))
+			ulevel, _ := strconv.Atoi(jsonString(obj, "ulevel", "user_level"))
+			msg := &models.LiveMsg{
+				SessionID:  sessionID,
+				Timestamp:  ts,
+				Type:       LiveMsgTypeDanmaku,
+				Message:    text,
+				Mode:       mode,
+				FontSize:   fontSize,
+				Color:      color,
+				UID:        uidNum,
+				UserName:   user,
+				ULevel:     ulevel,
+				MedalName:  jsonString(obj, "medal_name"),
+				MedalLevel: medalLevel,
+			}
+			if err := sink.AddMsg(msg); err != nil {
+				return err
+			}
+		}
+	}
+
+	log.Printf("[弹幕解析] 解析到: 普通弹幕=%d, SC=%d, 礼物=%d, 上舰=%d, 跳过=%d", dCount, scCount, giftCount, guardCount, skipped)
+	return nil
+}
+
+// jsonDanmakuState 解析过程中的时间基准
+type jsonDanmakuState struct {
+	recordStart time.Time
+}
+
+// timestamp 读取相对录制开始的毫秒数，遇到绝对时间且没有录制开始时间时以第一条的时间为零点
+func (s *jsonDanmakuState) timestamp(obj map[string]interface{}, sink DanmakuSink) (int64, bool) {
+	if v, ok := jsonFloat(obj, "progress"); ok {
+		return int64(v), true
+	}
+	v, ok := jsonFloat(obj, "time", "ts", "timestamp", "stime")
+	if !ok {
+		return 0, false
+	}
+
+	var abs time.Time
+	switch {
+	case v > 1e12:
+		abs = time.UnixMilli(int64(v))
+	case v > 1e9:
+		abs = time.UnixMilli(int64(v * 1000))
+	default:
+		return int64(v * 1000), true
+	}
+	if s.recordStart.IsZero() {
+		s.recordStart = abs
+		sink.SetRecordStart(abs)
+	}
+	return abs.UnixMilli() - s.recordStart.UnixMilli(), true
+}
+
+// jsonDanmakuType 识别消息类型，兼容B站原始cmd
+func jsonDanmakuType(obj map[string]interface{}) string {
+	t := strings.ToLower(jsonString(obj, "type", "cmd", "msg_type"))
+	switch {
+	case t == "sc" || t == "superchat" || strings.HasPrefix(t, "super_chat"):
+		return "sc"
+	case t == "gift" || t == "send_gift":
+		return "gift"
+	case t == "guard" || t == "guard_buy" || t == "user_toast_msg":
+		return "guard"
+	default:
+		return "danmaku"
+	}
+}
+
+// jsonString 按顺序读取第一个存在的字段并转为字符串
+func jsonString(obj map[string]interface{}, keys ...string) string {
+	for _, key := range keys {
+		switch v := obj[key].(type) {
+		case string:
+			return v
+		case json.Number:
+			return v.String()
+		case bool:
+			return strconv.FormatBool(v)
+		}
+	}
+	return ""
+}
+
+// jsonFloat 按顺序读取第一个存在的数值字段（兼容数字字符串）
+func jsonFloat(obj map[string]interface{}, keys ...string) (float64, bool) {
+	for _, key := range keys {
+		if s := jsonString(obj, key); s != "" {
+			if v, err := strconv.ParseFloat(s, 64); err == nil {
+				return v, true
+			}
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,226 @@
+package services
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gobup/server/internal/database"
+	"github.com/gobup/server/internal/models"
+)
+
+// danmakuTimeBase 弹幕XML时间零点，XML中的时间戳相对于文件开始录制的时间
+type danmakuTimeBase struct {
+	SessionStart time.Time // 直播开始时间，LiveMsg.Timestamp 以此为零点
+	FileStart    time.Time // XML头中没有录制开始时间时使用的文件开始时间（分P StartTime）
+}
+
+// offsetMs 计算XML时间戳到直播时间轴的偏移，recordStart为XML头中读取到的录制开始时间
+func (b danmakuTimeBase) offsetMs(recordStart time.Time) int64 {
+	if b.SessionStart.IsZero() {
+		return 0
+	}
+	start := b.FileStart
+	if !recordStart.IsZero() {
+		start = recordStart
+	}
+	if start.IsZero() {
+		return 0
+	}
+	return start.UnixMilli() - b.SessionStart.UnixMilli()
+}
+
+// recordStartFromElement 从XML头元素中读取录制开始时间
+// 录播姬: <BililiveRecorderRecordInfo start_time="2024-01-01T20:00:00.0000000+08:00" />
+// blrec: <metadata><record_start_time>1704110400</record_start_time></metadata>
+func recordStartFromElement(decoder *xml.Decoder, start *xml.StartElement) (time.Time, bool) {
+	switch start.Name.Local {
+	case "BililiveRecorderRecordInfo":
+		for _, attr := range start.Attr {
+			if attr.Name.Local == "start_time" {
+				return parseRecordStartTime(attr.Value)
+			}
+		}
+	case "metadata":
+		var meta struct {
+			RecordStartTime string `xml:"record_start_time"`
+		}
+		if err := decoder.DecodeElement(&meta, start); err == nil {
+			return parseRecordStartTime(meta.RecordStartTime)
+		}
+	}
+	return time.Time{}, false
+}
+
+// parseRecordStartTime 解析Unix时间戳（秒/毫秒）或时间字符串，不带时区的按录播软件写入的北京时间解析
+func parseRecordStartTime(value string) (time.Time, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, false
+	}
+	if ts, err := strconv.ParseFloat(value, 64); err == nil && ts > 0 {
+		if ts > 1e12 {
+			return time.UnixMilli(int64(ts)), true
+		}
+		return time.UnixMilli(int64(ts * 1000)), true
+	}
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t, true
+	}
+	if t, err := time.ParseInLocation("2006-01-02 15:04:05", value, biliLiveTimeLocation); err == nil {
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+// readDanmakuXMLRecordStart 读取弹幕XML头中的录制开始时间，只扫描到第一条弹幕为止
+func readDanmakuXMLRecordStart(xmlPath string) (time.Time, bool) {
+	file, err := os.Open(xmlPath)
+	if err != nil {
+		return time.Time{}, false
+	}
+	defer file.Close()
+
+	decoder := xml.NewDecoder(file)
+	decoder.Strict = false
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF || err != nil {
+			return time.Time{}, false
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch start.Name.Local {
+		case "d", "sc", "gift", "guard":
+			return time.Time{}, false
+		}
+		if t, ok := recordStartFromElement(decoder, &start); ok {
+			return t, true
+		}
+	}
+}
+
+// findDanmakuXML 查找分P视频对应的弹幕XML文件
+func findDanmakuXML(videoPath string) string {
+	base := strings.TrimSuffix(filepath.Base(videoPath), filepath.Ext(videoPath))
+	candidates := []string{
+		strings.TrimSuffix(videoPath, filepath.Ext(videoPath)) + ".xml",
+		filepath.Join(filepath.Dir(videoPath), base+".xml"),
+	}
+	for _, path := range candidates {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// PartDanmakuOffset 分P的弹幕时间偏移
+type PartDanmakuOffset struct {
+	PartID     uint   `json:"partId"`
+	FileName   string `json:"fileName"`
+	Offset     int64  `json:"offset"`     // 分P视频0秒对应的直播时间轴位置（毫秒）
+	StartDelay int64  `json:"startDelay"` // 相对分P开始时间的录制延迟（毫秒）
+	Manual     bool   `json:"manual"`
+}
+
+// DanmakuOffsetService 弹幕与视频时间轴校准服务
+type DanmakuOffsetService struct {
+	mediaSvc *MediaService
+}
+
+func NewDanmakuOffsetService() *DanmakuOffsetService {
+	return &DanmakuOffsetService{
+		mediaSvc: NewMediaService(),
+	}
+}
+
+// PartOffset 获取分P视频0秒在直播时间轴上的位置（毫秒），弹幕在该分P中的进度 = Timestamp - PartOffset
+// 历史记录设置了手动偏移时，以分P开始时间加手动偏移为准；否则使用自动校准结果（首次使用时计算并保存）
+func (s *DanmakuOffsetService) PartOffset(history *models.RecordHistory, part *models.RecordHistoryPart) int64 {
+	partStart := part.StartTime.UnixMilli() - history.StartTime.UnixMilli()
+	if history.DanmakuOffsetOverride {
+		return partStart + history.DanmakuOffset
+	}
+	if part.DanmakuCalibrated {
+		return part.DanmakuOffset
+	}
+
+	offset, err := s.Calibrate(history, part)
+	if err != nil {
+		log.Printf("[弹幕校准] part_id=%d 无法探测视频首帧时间，仅按录制开始时间计算: %v", part.ID, err)
+	}
+	return offset
+}
+
+// Calibrate 根据弹幕XML头的录制开始时间和视频首帧时间戳计算分P偏移并保存
+// 探测视频失败时保存仅按录制开始时间计算的偏移并返回错误，避免每次使用时重复探测，可通过重新校准重试
+func (s *DanmakuOffsetService) Calibrate(history *models.RecordHistory, part *models.RecordHistoryPart) (int64, error) {
+	base := danmakuTimeBase{SessionStart: history.StartTime, FileStart: part.StartTime}
+
+	// 弹幕时间以XML记录的录制开始时间为零点
+	var recordStart time.Time
+	if xmlPath := findDanmakuXML(part.FilePath); xmlPath != "" {
+		recordStart, _ = readDanmakuXMLRecordStart(xmlPath)
+	}
+	offset := base.offsetMs(recordStart)
+
+	// 视频首个时间戳不为0时（录制器连接后等待关键帧），播放器0秒对应的是首帧时间
+	info, probeErr := s.mediaSvc.ProbeMediaInfo(part.FilePath)
+	if probeErr == nil && info.StartTime > 0 {
+		offset += int64(info.StartTime * 1000)
+	}
+
+	part.DanmakuOffset = offset
+	part.DanmakuCalibrated = true
+	database.GetDB().Model(part).Updates(map[string]interface{}{
+		"danmaku_offset":     offset,
+		"danmaku_calibrated": true,
+	})
+
+	if probeErr != nil {
+		return offset, probeErr
+	}
+	log.Printf("[弹幕校准] part_id=%d 偏移=%dms (XML头录制时间=%v, 首帧=%.3fs)", part.ID, offset, !recordStart.IsZero(), info.StartTime)
+	return offset, nil
+}
+
+// GetOffsets 获取历史记录所有分P的偏移，recalibrate为true时重新自动校准
+func (s *DanmakuOffsetService) GetOffsets(historyID uint, recalibrate bool) ([]PartDanmakuOffset, error) {
+	db := database.GetDB()
+
+	var history models.RecordHistory
+	if err := db.First(&history, historyID).Error; err != nil {
+		return nil, fmt.Errorf("历史记录不存在: %w", err)
+	}
+
+	var parts []models.RecordHistoryPart
+	if err := db.Where("history_id = ?", historyID).Order("start_time ASC").Find(&parts).Error; err != nil {
+		return nil, fmt.Errorf("查询分P失败: %w", err)
+	}
+
+	offsets := make([]PartDanmakuOffset, 0, len(parts))
+	for i := range parts {
+		part := &parts[i]
+		if recalibrate {
+			part.DanmakuCalibrated = false
+		}
+		offset := s.PartOffset(&history, part)
+		offsets = append(offsets, PartDanmakuOffset{
+			PartID:     part.ID,
+			FileName:   part.FileName,
+			Offset:     offset,
+			StartDelay: offset - (part.StartTime.UnixMilli() - history.StartTime.UnixMilli()),
+			Manual:     history.DanmakuOffsetOverride,
+		})
+	}
+	return offsets, nil
+}
@@ -1,6 +1,7 @@
 package services
 
 import (
+	"bytes"
 	"encoding/json"
 	"encoding/xml"
 	"fmt"
@@ -10,10 +11,13 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gobup/server/internal/database"
 	"github.com/gobup/server/internal/models"
+	danmakuprogress "github.com/gobup/server/internal/progress"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // DanmakuXMLParser 弹幕XML解析器
@@ -43,115 +47,224 @@ type D struct {
 type SC struct {
 	TS    string `xml:"ts,attr"`    // 时间戳
 	User  string `xml:"user,attr"`  // 用户名
+	UID   string `xml:"uid,attr"`   // 用户ID
 	Price string `xml:"price,attr"` // 价格
 	Text  string `xml:",chardata"`  // 留言内容
+	Raw   string `xml:"raw,attr"`   // 原始消息（录播姬/内置弹幕录制，包含粉丝勋章和用户等级）
 }
 
 // Gift 礼物
 type Gift struct {
-	TS       string `xml:"ts,attr"`       // 时间戳
-	User     string `xml:"user,attr"`     // 用户名
-	GiftName string `xml:"giftName,attr"` // 礼物名称
-	Num      string `xml:"num,attr"`      // 数量
+	TS        string `xml:"ts,attr"`        // 时间戳
+	User      string `xml:"user,attr"`      // 用户名
+	UID       string `xml:"uid,attr"`       // 用户ID
+	GiftName  string `xml:"giftName,attr"`  // 礼物名称
+	Num       string `xml:"num,attr"`       // 数量
+	GiftName2 string `xml:"giftname,attr"`  // 礼物名称（录播姬/blrec）
+	GiftCount string `xml:"giftcount,attr"` // 数量（录播姬/blrec）
+	CoinType  string `xml:"cointype,attr"`  // 货币类型: gold 金瓜子 silver 银瓜子（blrec）
+	Price     string `xml:"price,attr"`     // 单价，金瓜子（blrec）
 }
 
 // Guard 上舰/续费
 type Guard struct {
 	TS    string `xml:"ts,attr"`    // 时间戳
 	User  string `xml:"user,attr"`  // 用户名
+	UID   string `xml:"uid,attr"`   // 用户ID
 	Level string `xml:"level,attr"` // 等级 (1=总督, 2=提督, 3=舰长)
 	Count string `xml:"count,attr"` // 数量/月数
+	Price string `xml:"price,attr"` // 单价，金瓜子（blrec/内置弹幕录制）
 }
 
-// ParseDanmakuFile 解析弹幕XML文件
+// danmakuSource 弹幕文件来源，决定SC金额的单位
+type danmakuSource int
+
+const (
+	danmakuSourceRecorder danmakuSource = iota // 录播姬、内置弹幕录制及JSON lines，SC金额单位为元
+	danmakuSourceBlrec                         // blrec，SC金额单位为金瓜子
+)
+
+// scPrice 将SC金额换算为元
+func (src danmakuSource) scPrice(value string) float64 {
+	price, _ := strconv.ParseFloat(value, 64)
+	if src == danmakuSourceBlrec {
+		price /= 1000
+	}
+	return price
+}
+
+// guardMonthlyPrice 大航海每月价格（元），弹幕文件未记录价格时使用
+var guardMonthlyPrice = map[int]float64{
+	1: 19998,
+	2: 1998,
+	3: 198,
+}
+
+// LiveMsg 消息类型，旧数据未区分类型时为0
+const (
+	LiveMsgTypeDanmaku = 1 // 文字弹幕
+	LiveMsgTypeSC      = 2 // SC
+	LiveMsgTypeGuard   = 3 // 上舰
+)
+
+// danmakuInsertBatchSize 每批写入的弹幕条数
+const danmakuInsertBatchSize = 500
+
+// ParseDanmakuFile 解析弹幕文件（XML/JSON lines/ASS）
 func (p *DanmakuXMLParser) ParseDanmakuFile(xmlPath string, sessionID string) (int, error) {
-	log.Printf("[弹幕解析] 开始解析文件: %s (session_id=%s)", xmlPath, sessionID)
+	return p.parseDanmakuFile(xmlPath, sessionID, danmakuTimeBase{}, nil)
+}
 
-	// 打开XML文件
-	file, err := os.Open(xmlPath)
+// parseDanmakuFile 流式解析弹幕文件并分批写入，按扩展名或内容选择格式，时间戳按base换算到直播时间轴
+// onBatch在每批写入后回调（已读取比例、已解析条数、新增条数）
+func (p *DanmakuXMLParser) parseDanmakuFile(path string, sessionID string, base danmakuTimeBase, onBatch func(ratio float64, parsed, inserted int)) (int, error) {
+	file, reader, counter, format, err := newDanmakuFileReader(path)
 	if err != nil {
-		return 0, fmt.Errorf("无法打开文件: %w", err)
+		return 0, err
 	}
 	defer file.Close()
 
-	// 解析XML
-	data, err := io.ReadAll(file)
-	if err != nil {
-		return 0, fmt.Errorf("读取文件失败: %w", err)
+	log.Printf("[弹幕解析] 开始解析文件: %s (格式=%s, session_id=%s)", path, format.Name(), sessionID)
+
+	writer := &danmakuBatchWriter{
+		db:       database.GetDB(),
+		base:     base,
+		offsetMs: base.offsetMs(time.Time{}),
+		counter:  counter,
+		onBatch:  onBatch,
+	}
+	if info, err := file.Stat(); err == nil {
+		writer.fileSize = info.Size()
 	}
 
-	var dmXML DanmakuXML
-	if err := xml.Unmarshal(data, &dmXML); err != nil {
-		return 0, fmt.Errorf("解析XML失败: %w", err)
+	if err := format.Parse(reader, sessionID, writer); err != nil {
+		return writer.inserted, err
+	}
+	if err := writer.flush(); err != nil {
+		return writer.inserted, err
 	}
 
-	db := database.GetDB()
-	count := 0
+	log.Printf("[弹幕解析] ✅ 解析完成: 成功导入 %d 条弹幕", writer.inserted)
+	return writer.inserted, nil
+}
 
-	log.Printf("[弹幕解析] 解析到: 普通弹幕=%d, SC=%d, 礼物=%d, 上舰=%d",
-		len(dmXML.D), len(dmXML.SC), len(dmXML.Gift), len(dmXML.Guard))
+// xmlDanmakuFormat 录播姬/blrec XML格式
+type xmlDanmakuFormat struct {
+	parser *DanmakuXMLParser
+}
 
-	// 收集所有需要保存的弹幕
-	var msgsToSave []*models.LiveMsg
+func (f *xmlDanmakuFormat) Name() string { return "xml" }
 
-	// 解析普通弹幕
-	for _, d := range dmXML.D {
-		msg, err := p.parseDanmaku(d, sessionID)
-		if err != nil {
-			log.Printf("[弹幕解析] ⚠️  解析弹幕失败: %v", err)
-			continue
-		}
-		msgsToSave = append(msgsToSave, msg)
-	}
+func (f *xmlDanmakuFormat) Extensions() []string { return []string{".xml"} }
 
-	// 解析SC留言
-	for _, sc := range dmXML.SC {
-		msg, err := p.parseSC(sc, sessionID)
+func (f *xmlDanmakuFormat) Sniff(head []byte) bool {
+	head = bytes.TrimSpace(head)
+	return bytes.HasPrefix(head, []byte("<?xml")) || bytes.HasPrefix(head, []byte("<i>")) || bytes.HasPrefix(head, []byte("<i "))
+}
+
+func (f *xmlDanmakuFormat) Parse(r io.Reader, sessionID string, sink DanmakuSink) error {
+	p := f.parser
+	decoder := xml.NewDecoder(r)
+	// 录播姬/blrec的XML中偶尔包含非法字符，使用宽松模式
+	decoder.Strict = false
+
+	var dCount, scCount, giftCount, guardCount int
+	source := danmakuSourceRecorder
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
-			log.Printf("[弹幕解析] ⚠️  解析SC失败: %v", err)
-			continue
+			// 录制中断导致XML不完整时，保留已解析的部分
+			log.Printf("[弹幕解析] ⚠️  XML读取中断，保留已解析内容: %v", err)
+			break
 		}
-		msgsToSave = append(msgsToSave, msg)
-	}
 
-	// 解析上舰（转换为特殊弹幕）
-	for _, guard := range dmXML.Guard {
-		msg, err := p.parseGuard(guard, sessionID)
-		if err != nil {
-			log.Printf("[弹幕解析] ⚠️  解析上舰失败: %v", err)
+		start, ok := token.(xml.StartElement)
+		if !ok {
 			continue
 		}
-		msgsToSave = append(msgsToSave, msg)
-	}
 
-	// 使用事务批量保存，减少数据库锁定时间
-	err = db.Transaction(func(tx *gorm.DB) error {
-		for _, msg := range msgsToSave {
-			// 检查是否已存在（去重）
-			var existing models.LiveMsg
-			if err := tx.Where("session_id = ? AND timestamp = ? AND message = ?",
-				msg.SessionID, msg.Timestamp, msg.Message).First(&existing).Error; err == nil {
-				// 已存在，跳过
+		var msg *models.LiveMsg
+		switch start.Name.Local {
+		case "BililiveRecorderRecordInfo", "metadata":
+			// blrec的XML头为metadata
+			if start.Name.Local == "metadata" {
+				source = danmakuSourceBlrec
+			}
+			// XML头在弹幕之前，读到录制开始时间后更新偏移
+			if recordStart, ok := recordStartFromElement(decoder, &start); ok {
+				sink.SetRecordStart(recordStart)
+				log.Printf("[弹幕解析] XML录制开始时间: %s", recordStart.Format("2006-01-02 15:04:05"))
+			}
+			continue
+		case "d":
+			var d D
+			if err := decoder.DecodeElement(&d, &start); err != nil {
 				continue
 			}
-
-			// 保存到数据库
-			if err := tx.Create(msg).Error; err != nil {
-				log.Printf("[弹幕解析] ❌ 保存弹幕失败: %v", err)
-				// 不中断事务，继续处理下一条
+			dCount++
+			msg, err = p.parseDanmaku(d, sessionID)
+			if err != nil {
+				log.Printf("[弹幕解析] ⚠️  解析弹幕失败: %v", err)
+				continue
+			}
+		case "sc":
+			var sc SC
+			if err := decoder.DecodeElement(&sc, &start); err != nil {
+				continue
+			}
+			scCount++
+			if event := p.parseSCEvent(sc, sessionID, source); event != nil {
+				if err := sink.AddEvent(event); err != nil {
+					return err
+				}
+			}
+			msg, err = p.parseSC(sc, sessionID, source)
+			if err != nil {
+				log.Printf("[弹幕解析] ⚠️  解析SC失败: %v", err)
+				continue
+			}
+		case "guard":
+			var guard Guard
+			if err := decoder.DecodeElement(&guard, &start); err != nil {
+				continue
+			}
+			guardCount++
+			if event := p.parseGuardEvent(guard, sessionID); event != nil {
+				if err := sink.AddEvent(event); err != nil {
+					return err
+				}
+			}
+			msg, err = p.parseGuard(guard, sessionID)
+			if err != nil {
+				log.Printf("[弹幕解析] ⚠️  解析上舰失败: %v", err)
+				continue
+			}
+		case "gift":
+			var gift Gift
+			if err := decoder.DecodeElement(&gift, &start); err != nil {
 				continue
 			}
-			count++
+			giftCount++
+			if event := p.parseGiftEvent(gift, sessionID); event != nil {
+				if err := sink.AddEvent(event); err != nil {
+					return err
+				}
+			}
+			continue
+		default:
+			continue
 		}
-		return nil
-	})
 
-	if err != nil {
-		return 0, fmt.Errorf("保存弹幕事务失败: %w", err)
+		if err := sink.AddMsg(msg); err != nil {
+			return err
+		}
 	}
 
-	log.Printf("[弹幕解析] ✅ 解析完成: 成功导入 %d 条弹幕", count)
-	return count, nil
+	log.Printf("[弹幕解析] 解析到: 普通弹幕=%d, SC=%d, 礼物=%d, 上舰=%d", dCount, scCount, giftCount, guardCount)
+	return nil
 }
 
 // parseDanmaku 解析普通弹幕
@@ -184,6 +297,7 @@ func (p *DanmakuXMLParser) parseDanmaku(d D, sessionID string) (*models.LiveMsg,
 	msg := &models.LiveMsg{
 		SessionID: sessionID,
 		Timestamp: timestampMs,
+		Type:      LiveMsgTypeDanmaku,
 		Message:   strings.TrimSpace(d.Text),
 		Mode:      mode,
 		FontSize:  fontSize,
@@ -208,7 +322,7 @@ func (p *DanmakuXMLParser) parseDanmaku(d D, sessionID string) (*models.LiveMsg,
 }
 
 // parseSC 解析SC留言
-func (p *DanmakuXMLParser) parseSC(sc SC, sessionID string) (*models.LiveMsg, error) {
+func (p *DanmakuXMLParser) parseSC(sc SC, sessionID string, source danmakuSource) (*models.LiveMsg, error) {
 	// 时间戳（秒 -> 毫秒）
 	timestamp, err := strconv.ParseFloat(sc.TS, 64)
 	if err != nil {
@@ -216,13 +330,8 @@ func (p *DanmakuXMLParser) parseSC(sc SC, sessionID string) (*models.LiveMsg, er
 	}
 	timestampMs := int64(timestamp * 1000)
 
-	// 价格
-	price, _ := strconv.Atoi(sc.Price)
-
-	// blrec的金额需要除以1000
-	if price > 1000 {
-		price = price / 1000
-	}
+	// 价格（元）
+	price := int(source.scPrice(sc.Price))
 
 	// 构建SC消息
 	message := fmt.Sprintf("%s发送了%d元留言：%s", sc.User, price, sc.Text)
@@ -230,15 +339,56 @@ func (p *DanmakuXMLParser) parseSC(sc SC, sessionID string) (*models.LiveMsg, er
 		message = message[:99]
 	}
 
-	return &models.LiveMsg{
+	uid, _ := strconv.ParseInt(sc.UID, 10, 64)
+	msg := &models.LiveMsg{
 		SessionID: sessionID,
 		Timestamp: timestampMs,
+		Type:      LiveMsgTypeSC,
+		UserName:  sc.User,
+		UID:       uid,
 		Message:   message,
 		Mode:      5,        // 顶部弹幕
 		FontSize:  64,       // 大字号
 		Color:     16776960, // 金色
 		Sent:      false,
-	}, nil
+	}
+	if sc.Raw != "" {
+		p.parseSCRaw(sc.Raw, msg)
+	}
+	return msg, nil
+}
+
+// parseSCRaw 从SC原始消息中读取粉丝勋章和用户等级
+func (p *DanmakuXMLParser) parseSCRaw(raw string, msg *models.LiveMsg) {
+	// raw为 SUPER_CHAT_MESSAGE 完整消息或其data部分
+	type scData struct {
+		UserInfo struct {
+			UserLevel int `json:"user_level"`
+		} `json:"user_info"`
+		MedalInfo *struct {
+			MedalLevel   int    `json:"medal_level"`
+			MedalName    string `json:"medal_name"`
+			AnchorRoomID int64  `json:"anchor_roomid"`
+		} `json:"medal_info"`
+	}
+	var full struct {
+		Data *scData `json:"data"`
+		scData
+	}
+	if err := json.Unmarshal([]byte(raw), &full); err != nil {
+		return
+	}
+	data := &full.scData
+	if full.Data != nil {
+		data = full.Data
+	}
+
+	msg.ULevel = data.UserInfo.UserLevel
+	if data.MedalInfo != nil && data.MedalInfo.MedalName != "" {
+		msg.MedalName = data.MedalInfo.MedalName
+		msg.MedalLevel = data.MedalInfo.MedalLevel
+		msg.MedalRoomID = strconv.FormatInt(data.MedalInfo.AnchorRoomID, 10)
+	}
 }
 
 // parseGuard 解析上舰信息
@@ -270,9 +420,13 @@ func (p *DanmakuXMLParser) parseGuard(guard Guard, sessionID string) (*models.Li
 		message = message[:99]
 	}
 
+	uid, _ := strconv.ParseInt(guard.UID, 10, 64)
 	return &models.LiveMsg{
 		SessionID: sessionID,
 		Timestamp: timestampMs,
+		Type:      LiveMsgTypeGuard,
+		UserName:  guard.User,
+		UID:       uid,
 		Message:   message,
 		Mode:      5,        // 顶部弹幕
 		FontSize:  64,       // 大字号
@@ -281,6 +435,108 @@ func (p *DanmakuXMLParser) parseGuard(guard Guard, sessionID string) (*models.Li
 	}, nil
 }
 
+// parseGiftEvent 解析礼物事件，金瓜子礼物按 1000金瓜子=1元 计算价值
+func (p *DanmakuXMLParser) parseGiftEvent(gift Gift, sessionID string) *models.LiveEvent {
+	timestamp, err := strconv.ParseFloat(gift.TS, 64)
+	if err != nil {
+		return nil
+	}
+
+	name := gift.GiftName
+	if name == "" {
+		name = gift.GiftName2
+	}
+	countStr := gift.Num
+	if countStr == "" {
+		countStr = gift.GiftCount
+	}
+	count, _ := strconv.Atoi(countStr)
+	if count <= 0 {
+		count = 1
+	}
+
+	var price float64
+	if gift.CoinType != "silver" {
+		unitPrice, _ := strconv.ParseFloat(gift.Price, 64)
+		price = unitPrice * float64(count) / 1000
+	}
+
+	uid, _ := strconv.ParseInt(gift.UID, 10, 64)
+	return &models.LiveEvent{
+		SessionID: sessionID,
+		Type:      "gift",
+		Timestamp: int64(timestamp * 1000),
+		UserName:  gift.User,
+		UID:       uid,
+		Name:      name,
+		Count:     count,
+		Price:     price,
+	}
+}
+
+// parseSCEvent 解析SC事件，金额单位由弹幕来源决定
+func (p *DanmakuXMLParser) parseSCEvent(sc SC, sessionID string, source danmakuSource) *models.LiveEvent {
+	timestamp, err := strconv.ParseFloat(sc.TS, 64)
+	if err != nil {
+		return nil
+	}
+	price := source.scPrice(sc.Price)
+
+	uid, _ := strconv.ParseInt(sc.UID, 10, 64)
+	return &models.LiveEvent{
+		SessionID: sessionID,
+		Type:      "sc",
+		Timestamp: int64(timestamp * 1000),
+		UserName:  sc.User,
+		UID:       uid,
+		Name:      "SC",
+		Count:     1,
+		Price:     price,
+		Message:   strings.TrimSpace(sc.Text),
+	}
+}
+
+// parseGuardEvent 解析上舰事件，优先使用记录的单价（金瓜子），没有时按大航海月价计算价值
+func (p *DanmakuXMLParser) parseGuardEvent(guard Guard, sessionID string) *models.LiveEvent {
+	timestamp, err := strconv.ParseFloat(guard.TS, 64)
+	if err != nil {
+		return nil
+	}
+
+	level, _ := strconv.Atoi(guard.Level)
+	if _, ok := guardMonthlyPrice[level]; !ok {
+		level = 3
+	}
+	levelName := "舰长"
+	switch level {
+	case 1:
+		levelName = "总督"
+	case 2:
+		levelName = "提督"
+	}
+	count, _ := strconv.Atoi(guard.Count)
+	if count <= 0 {
+		count = 1
+	}
+
+	price := guardMonthlyPrice[level] * float64(count)
+	if unitPrice, _ := strconv.ParseFloat(guard.Price, 64); unitPrice > 0 {
+		price = unitPrice * float64(count) / 1000
+	}
+
+	uid, _ := strconv.ParseInt(guard.UID, 10, 64)
+	return &models.LiveEvent{
+		SessionID: sessionID,
+		Type:      "guard",
+		Timestamp: int64(timestamp * 1000),
+		UserName:  guard.User,
+		UID:       uid,
+		Name:      levelName,
+		Count:     count,
+		Price:     price,
+	}
+}
+
 // parseRawData 解析原始数据（blrec格式），返回是否为抽奖弹幕
 func (p *DanmakuXMLParser) parseRawData(raw string, msg *models.LiveMsg) bool {
 	// raw格式: [[时间,模式,字号,颜色,时间戳,弹幕池,用户ID,弹幕ID,权重,抽奖标志],[...],[粉丝勋章信息],[用户等级,...],...]
@@ -358,7 +614,7 @@ func (p *DanmakuXMLParser) ParseDanmakuForHistory(historyID uint) (int, error) {
 
 	// 获取所有分P
 	var parts []models.RecordHistoryPart
-	if err := db.Where("history_id = ?", historyID).Find(&parts).Error; err != nil {
+	if err := db.Where("history_id = ?", historyID).Order("start_time ASC").Find(&parts).Error; err != nil {
 		return 0, fmt.Errorf("查询分P失败: %w", err)
 	}
 
@@ -366,48 +622,214 @@ func (p *DanmakuXMLParser) ParseDanmakuForHistory(historyID uint) (int, error) {
 		return 0, fmt.Errorf("没有找到分P记录")
 	}
 
-	totalCount := 0
-
-	// 对每个分P查找对应的XML文件
+	// 对每个分P查找对应的弹幕文件，弹幕时间戳相对于各自文件开始录制的时间
+	var xmlFiles []string
+	var bases []danmakuTimeBase
 	for _, part := range parts {
-		// 尝试多种可能的XML文件路径
-		xmlPaths := []string{
-			strings.TrimSuffix(part.FilePath, filepath.Ext(part.FilePath)) + ".xml",
-			filepath.Join(filepath.Dir(part.FilePath), strings.TrimSuffix(filepath.Base(part.FilePath), filepath.Ext(part.FilePath))+".xml"),
+		xmlPath := findDanmakuFile(part.FilePath)
+		if xmlPath == "" {
+			log.Printf("[弹幕解析] ⚠️  未找到弹幕文件: %s", part.FilePath)
+			continue
 		}
+		xmlFiles = append(xmlFiles, xmlPath)
+		bases = append(bases, danmakuTimeBase{SessionStart: history.StartTime, FileStart: part.StartTime})
+	}
 
-		var xmlPath string
-		for _, path := range xmlPaths {
-			if _, err := os.Stat(path); err == nil {
-				xmlPath = path
-				break
-			}
+	// 录播文件旁没有弹幕文件时使用内置弹幕录制的XML，录制时已直接写入弹幕的不再重复导入
+	if len(xmlFiles) == 0 && history.DanmakuFile != "" && !history.Recording {
+		var existing int64
+		db.Model(&models.LiveMsg{}).Where("session_id = ?", history.SessionID).Count(&existing)
+		if existing > 0 {
+			db.Model(&history).Update("danmaku_count", existing)
+			log.Printf("[弹幕解析] 历史记录%d的弹幕已由内置弹幕录制写入: %d 条", historyID, existing)
+			return int(existing), nil
+		}
+		if _, err := os.Stat(history.DanmakuFile); err == nil {
+			xmlFiles = append(xmlFiles, history.DanmakuFile)
+			bases = append(bases, danmakuTimeBase{SessionStart: history.StartTime, FileStart: history.StartTime})
 		}
+	}
 
-		if xmlPath == "" {
-			log.Printf("[弹幕解析] ⚠️  未找到弹幕XML文件: %s", part.FilePath)
-			continue
+	// 重新解析时先清除该场直播已有的弹幕和付费事件：旧版本按文件开始时间记录时间戳，
+	// 与按直播时间轴换算后的时间戳不同，去重索引无法识别，会重复写入
+	// 已发送到视频的弹幕在解析后重新对应到新记录；录制中的直播由内置弹幕录制持续写入，不清除
+	var sentDanmakus []models.LiveMsg
+	reparse := len(xmlFiles) > 0 && !history.Recording
+	if reparse {
+		sent, err := p.clearSessionDanmaku(history.SessionID)
+		if err != nil {
+			return 0, err
 		}
+		sentDanmakus = sent
+	}
+
+	progress := &danmakuprogress.DanmakuParseProgress{
+		HistoryID: int64(historyID),
+		FileTotal: len(xmlFiles),
+		Parsing:   true,
+	}
+	danmakuprogress.SetDanmakuParseProgress(*progress)
+
+	totalCount, totalParsed := 0, 0
+	for i, xmlPath := range xmlFiles {
+		progress.FileIndex = i + 1
+		progress.FileName = filepath.Base(xmlPath)
 
 		// 解析XML文件
-		count, err := p.ParseDanmakuFile(xmlPath, history.SessionID)
+		count, err := p.parseDanmakuFile(xmlPath, history.SessionID, bases[i], func(ratio float64, parsed, inserted int) {
+			progress.Parsed = totalParsed + parsed
+			progress.Inserted = totalCount + inserted
+			progress.Percent = int((float64(i) + ratio) * 100 / float64(len(xmlFiles)))
+			danmakuprogress.SetDanmakuParseProgress(*progress)
+		})
 		if err != nil {
 			log.Printf("[弹幕解析] ❌ 解析失败: %s, error: %v", xmlPath, err)
-			continue
 		}
 
 		totalCount += count
+		totalParsed = progress.Parsed
+	}
+
+	progress.Parsing = false
+	progress.Completed = true
+	progress.Percent = 100
+	progress.Inserted = totalCount
+	danmakuprogress.SetDanmakuParseProgress(*progress)
+
+	if len(sentDanmakus) > 0 {
+		p.restoreSentDanmaku(history.SessionID, sentDanmakus)
 	}
 
 	if totalCount == 0 {
 		return 0, fmt.Errorf("没有解析到任何弹幕")
 	}
 
-	// 更新历史记录的弹幕统计
-	history.DanmakuCount = totalCount
-	db.Save(&history)
+	// 更新历史记录的弹幕统计（包含保留的已发送弹幕）
+	var sessionCount int64
+	db.Model(&models.LiveMsg{}).Where("session_id = ?", history.SessionID).Count(&sessionCount)
+	db.Model(&history).Update("danmaku_count", sessionCount)
 
 	log.Printf("[弹幕解析] ✅ 历史记录%d解析完成: 共导入 %d 条弹幕", historyID, totalCount)
 
+	// 弹幕解析完成后生成高能剪辑（房间启用时）
+	NewHighEnergyCutService().EnqueueIfEnabled(historyID)
+
 	return totalCount, nil
 }
+
+// clearSessionDanmaku 清除一场直播已解析的弹幕和付费事件，返回清除前已发送到视频的弹幕
+func (p *DanmakuXMLParser) clearSessionDanmaku(sessionID string) ([]models.LiveMsg, error) {
+	db := database.GetDB()
+
+	var sent []models.LiveMsg
+	if err := db.Where("session_id = ? AND sent = ?", sessionID, true).Find(&sent).Error; err != nil {
+		return nil, fmt.Errorf("查询已发送弹幕失败: %w", err)
+	}
+
+	// 去重索引包含软删除的记录，必须物理删除
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("session_id = ?", sessionID).Delete(&models.LiveMsg{}).Error; err != nil {
+			return fmt.Errorf("清除已有弹幕失败: %w", err)
+		}
+		if err := tx.Where("session_id = ?", sessionID).Delete(&models.LiveEvent{}).Error; err != nil {
+			return fmt.Errorf("清除已有付费事件失败: %w", err)
+		}
+		return nil
+	})
+	return sent, err
+}
+
+// restoreSentDanmaku 将重新解析前已发送的弹幕对应到新记录并标记为已发送，避免再次发送
+// 新旧记录的时间戳可能因时间轴换算不同而不一致，按内容匹配时间最接近的新记录，找不到时保留原记录
+func (p *DanmakuXMLParser) restoreSentDanmaku(sessionID string, sent []models.LiveMsg) {
+	db := database.GetDB()
+
+	// 弹幕表没有主键，使用SQLite的rowid定位记录
+	type candidate struct {
+		RowID     int64
+		Timestamp int64
+	}
+	var fresh []struct {
+		RowID     int64
+		Timestamp int64
+		Message   string
+	}
+	db.Model(&models.LiveMsg{}).Select("rowid AS row_id", "timestamp", "message").
+		Where("session_id = ? AND sent = ?", sessionID, false).
+		Find(&fresh)
+	byMessage := make(map[string][]candidate)
+	for _, dm := range fresh {
+		byMessage[dm.Message] = append(byMessage[dm.Message], candidate{RowID: dm.RowID, Timestamp: dm.Timestamp})
+	}
+
+	matched, restored := 0, 0
+	for _, dm := range sent {
+		candidates := byMessage[dm.Message]
+		best := -1
+		for i, c := range candidates {
+			if best < 0 || absInt64(c.Timestamp-dm.Timestamp) < absInt64(candidates[best].Timestamp-dm.Timestamp) {
+				best = i
+			}
+		}
+		if best < 0 {
+			db.Clauses(clause.OnConflict{DoNothing: true}).Create(&dm)
+			restored++
+			continue
+		}
+		db.Model(&models.LiveMsg{}).Where("rowid = ?", candidates[best].RowID).Updates(map[string]interface{}{
+			"sent":     true,
+			"c_id":     dm.CID,
+			"progress": dm.Progress,
+			"bv_id":    dm.BvID,
+		})
+		byMessage[dm.Message] = append(candidates[:best], candidates[best+1:]...)
+		matched++
+	}
+
+	log.Printf("[弹幕解析] 保留已发送弹幕 %d 条: 对应到新记录 %d 条, 保留原记录 %d 条", len(sent), matched, restored)
+}
+
+func absInt64(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// ImportDanmakuFile 将上传的弹幕文件导入到已有的历史记录，返回新增条数和识别到的格式
+// partID不为0时按该分P的开始时间对齐弹幕时间轴，否则按直播开始时间对齐
+func (p *DanmakuXMLParser) ImportDanmakuFile(historyID, partID uint, path string) (int, string, error) {
+	db := database.GetDB()
+
+	var history models.RecordHistory
+	if err := db.First(&history, historyID).Error; err != nil {
+		return 0, "", fmt.Errorf("历史记录不存在: %w", err)
+	}
+
+	base := danmakuTimeBase{SessionStart: history.StartTime, FileStart: history.StartTime}
+	if partID != 0 {
+		var part models.RecordHistoryPart
+		if err := db.Where("id = ? AND history_id = ?", partID, historyID).First(&part).Error; err != nil {
+			return 0, "", fmt.Errorf("分P不存在: %w", err)
+		}
+		base.FileStart = part.StartTime
+	}
+
+	format, err := DetectDanmakuFileFormat(path)
+	if err != nil {
+		return 0, "", err
+	}
+
+	count, err := p.parseDanmakuFile(path, history.SessionID, base, nil)
+	if err != nil {
+		return count, format.Name(), err
+	}
+
+	// 重新统计历史记录的弹幕数，导入的文件可能与已有弹幕重复
+	var total int64
+	db.Model(&models.LiveMsg{}).Where("session_id = ?", history.SessionID).Count(&total)
+	db.Model(&history).Update("danmaku_count", total)
+
+	log.Printf("[弹幕导入] ✅ 历史记录%d导入完成: 格式=%s, 新增 %d 条弹幕, 共 %d 条", historyID, format.Name(), count, total)
+	return count, format.Name(), nil
+}
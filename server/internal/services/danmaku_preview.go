@@ -0,0 +1,181 @@
+package services
+
+import (
+	"time"
+)
+
+// danmakuAverageJitter 发送间隔随机抖动的平均值（3-8秒）
+const danmakuAverageJitter = 5500 * time.Millisecond
+
+// DanmakuPreviewItem 预览中的单条弹幕
+type DanmakuPreviewItem struct {
+	Index     int    `json:"index"`
+	Timestamp int64  `json:"timestamp"` // 相对于直播开始的时间（毫秒）
+	Page      int    `json:"page"`
+	CID       int64  `json:"cid"`
+	Progress  int    `json:"progress"` // 视频中的位置（毫秒）
+	Message   string `json:"message"`
+	Mode      int    `json:"mode"`
+	Account   string `json:"account"`
+	UID       int64  `json:"uid"`
+	Proxy     string `json:"proxy"`
+	SendAfter int64  `json:"sendAfter"` // 预计在开始后多少秒发送
+	Day       int    `json:"day"`       // 0为今天，超出今日配额的顺延天数
+}
+
+// DanmakuPreviewAccount 参与发送的账号
+type DanmakuPreviewAccount struct {
+	Uname     string `json:"uname"`
+	UID       int64  `json:"uid"`
+	Remaining int    `json:"remaining"` // 今日剩余配额，-1为不限制
+	Assigned  int    `json:"assigned"`  // 今天分配到的条数
+}
+
+// DanmakuSendPreview 弹幕发送预览
+type DanmakuSendPreview struct {
+	HistoryID        uint                    `json:"historyId"`
+	BvID             string                  `json:"bvid"`
+	Total            int                     `json:"total"`
+	Today            int                     `json:"today"`    // 今天能发送的条数
+	Deferred         int                     `json:"deferred"` // 超出配额顺延的条数
+	ProxyMode        bool                    `json:"proxyMode"`
+	DailyQuota       int                     `json:"dailyQuota"`
+	EstimatedSeconds int64                   `json:"estimatedSeconds"` // 今天部分的预计耗时
+	EstimatedDays    int                     `json:"estimatedDays"`
+	Accounts         []DanmakuPreviewAccount `json:"accounts"`
+	Items            []DanmakuPreviewItem    `json:"items"`
+}
+
+// PreviewDanmakuSend 模拟发送流程：返回过滤、去重、时间校准后的弹幕，以及分配到的账号/代理和预计耗时，不消耗配额
+func (s *DanmakuService) PreviewDanmakuSend(historyID uint) (*DanmakuSendPreview, error) {
+	plan, err := s.buildDanmakuSendPlan(historyID, true)
+	if err != nil {
+		return nil, err
+	}
+
+	proxyPool := s.getGlobalProxyPool()
+	proxyMode := proxyPool.GetProxyCount() > 1
+	// 使用代理列表快照模拟轮询，不影响实际发送的轮询位置
+	proxies := proxyPool.AvailableProxies()
+	nextProxy := 0
+	senders, dailyQuota := loadDanmakuSenders(plan.validUsers)
+	controller := GetDanmakuRateController()
+
+	preview := &DanmakuSendPreview{
+		HistoryID:  plan.history.ID,
+		BvID:       plan.history.BvID,
+		Total:      len(plan.pending),
+		ProxyMode:  proxyMode,
+		DailyQuota: dailyQuota,
+		Items:      make([]DanmakuPreviewItem, 0, len(plan.pending)),
+	}
+	for _, sender := range senders {
+		preview.Accounts = append(preview.Accounts, DanmakuPreviewAccount{
+			Uname:     sender.user.Uname,
+			UID:       sender.user.UID,
+			Remaining: sender.remaining,
+		})
+	}
+
+	// 按实际发送逻辑模拟：串行模式所有账号共用一个时钟，代理模式每个账号一个发送协程
+	start := time.Now()
+	workerClock := make([]time.Time, len(senders))
+	for i := range workerClock {
+		workerClock[i] = start
+	}
+	pacerNext := make(map[string]time.Time)
+	next := 0
+	var lastSend time.Time
+
+	for i, p := range plan.pending {
+		item := DanmakuPreviewItem{
+			Index:     i + 1,
+			Timestamp: p.msg.Timestamp,
+			Page:      p.page,
+			CID:       p.item.CID,
+			Progress:  p.item.Progress,
+			Message:   p.item.Message,
+			Mode:      p.item.Mode,
+		}
+
+		// 选择账号：串行模式轮流使用，代理模式由最早空闲的账号领取
+		chosen := -1
+		if proxyMode {
+			for idx, sender := range senders {
+				if sender.available() && (chosen < 0 || workerClock[idx].Before(workerClock[chosen])) {
+					chosen = idx
+				}
+			}
+		} else {
+			for j := 0; j < len(senders); j++ {
+				idx := (next + j) % len(senders)
+				if senders[idx].available() {
+					chosen = idx
+					next = (idx + 1) % len(senders)
+					break
+				}
+			}
+		}
+
+		if chosen < 0 {
+			// 所有账号今日配额已用完，顺延到之后的日期
+			preview.Deferred++
+			item.Day = 1
+			if capacity := dailyQuota * len(senders); capacity > 0 {
+				item.Day = 1 + (preview.Deferred-1)/capacity
+			}
+			preview.Items = append(preview.Items, item)
+			continue
+		}
+
+		sender := senders[chosen]
+		proxyURL := ""
+		if len(proxies) > 0 {
+			proxyURL = proxies[nextProxy%len(proxies)].GetProxyURL()
+			nextProxy++
+		}
+
+		clockIdx := chosen
+		if !proxyMode {
+			clockIdx = 0
+		}
+		key := proxyLabel(proxyURL) + "|" + sender.user.Uname
+		sendAt := workerClock[clockIdx]
+		if t, ok := pacerNext[key]; ok && t.After(sendAt) {
+			sendAt = t
+		}
+		pacerNext[key] = sendAt.Add(controller.Interval(sender.user.UID, proxyURL) + danmakuAverageJitter)
+		if proxyMode {
+			workerClock[clockIdx] = sendAt
+		} else {
+			for idx := range workerClock {
+				workerClock[idx] = sendAt
+			}
+		}
+		if sendAt.After(lastSend) {
+			lastSend = sendAt
+		}
+
+		if sender.remaining > 0 {
+			sender.remaining--
+		}
+		preview.Accounts[chosen].Assigned++
+		preview.Today++
+
+		item.Account = sender.user.Uname
+		item.UID = sender.user.UID
+		item.Proxy = proxyLabel(proxyURL)
+		item.SendAfter = int64(sendAt.Sub(start).Seconds())
+		preview.Items = append(preview.Items, item)
+	}
+
+	if preview.Today > 0 {
+		preview.EstimatedSeconds = int64(lastSend.Sub(start).Seconds())
+		preview.EstimatedDays = 1
+	}
+	if preview.Deferred > 0 && len(preview.Items) > 0 {
+		preview.EstimatedDays = preview.Items[len(preview.Items)-1].Day + 1
+	}
+
+	return preview, nil
+}
@@ -14,6 +14,7 @@ type DanmakuTask struct {
 // DanmakuQueueManager 弹幕队列管理器（全局单视频处理）
 type DanmakuQueueManager struct {
 	tasks      chan *DanmakuTask
+	queued     map[uint]bool // 已在队列中等待处理的历史记录
 	processing bool
 	mu         sync.Mutex
 	service    *DanmakuService
@@ -23,6 +24,7 @@ type DanmakuQueueManager struct {
 func NewDanmakuQueueManager(service *DanmakuService) *DanmakuQueueManager {
 	qm := &DanmakuQueueManager{
 		tasks:   make(chan *DanmakuTask, 100), // 全局队列，最多缓存100个任务
+		queued:  make(map[uint]bool),
 		service: service,
 	}
 	// 启动全局队列处理器
@@ -31,9 +33,18 @@ func NewDanmakuQueueManager(service *DanmakuService) *DanmakuQueueManager {
 }
 
 // AddTask 添加弹幕发送任务到全局队列
+// 同一历史记录已在队列中等待时不重复添加
 func (m *DanmakuQueueManager) AddTask(historyID uint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.queued[historyID] {
+		log.Printf("[弹幕队列] 任务已在队列中，跳过 (history_id=%d)", historyID)
+		return nil
+	}
+
 	select {
 	case m.tasks <- &DanmakuTask{HistoryID: historyID}:
+		m.queued[historyID] = true
 		log.Printf("[弹幕队列] ➕ 添加任务到全局队列 (history_id=%d, 队列长度=%d)",
 			historyID, len(m.tasks))
 		return nil
@@ -49,6 +60,7 @@ func (m *DanmakuQueueManager) process() {
 	for task := range m.tasks {
 		m.mu.Lock()
 		m.processing = true
+		delete(m.queued, task.HistoryID)
 		m.mu.Unlock()
 
 		log.Printf("[弹幕队列] 🎬 开始处理视频的弹幕发送任务 (history_id=%d, 剩余队列=%d)",
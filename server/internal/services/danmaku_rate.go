@@ -0,0 +1,211 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/gobup/server/internal/bili"
+	"github.com/gobup/server/internal/database"
+	"github.com/gobup/server/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 弹幕发送结果分类
+const (
+	danmakuSendSuccess      = iota
+	danmakuSendRateLimited  // 36703 发送频率过快，退避后重试
+	danmakuSendRejected     // 内容违规/过长/时间不合法，跳过该条
+	danmakuSendAccountError // 账号未登录/封停/csrf失效，停用该账号
+	danmakuSendVideoPending // 36704 视频未审核，稍后整体重试
+	danmakuSendNetworkError // 网络等其他错误
+)
+
+// 自适应发送间隔
+const (
+	danmakuBaseInterval  = 22 * time.Second // 初始间隔，参考biliupforjava的25秒策略
+	danmakuMinInterval   = 18 * time.Second
+	danmakuMaxInterval   = 10 * time.Minute
+	danmakuRecoverStreak = 10               // 连续成功多少条后缩短间隔
+	danmakuNetworkPause  = 30 * time.Second // 网络错误后暂停
+
+	// 同一出口IP两次发送的最小间隔，多个账号共用一个IP时整体不超过单账号的最快速度
+	danmakuEgressInterval = danmakuMinInterval
+)
+
+// classifyDanmakuError 按B站返回码对发送结果分类
+func classifyDanmakuError(err error) int {
+	if err == nil {
+		return danmakuSendSuccess
+	}
+	switch bili.DanmakuErrorCode(err) {
+	case 36703:
+		return danmakuSendRateLimited
+	case 36704:
+		return danmakuSendVideoPending
+	case 36701, 36702, 36714:
+		return danmakuSendRejected
+	case -101, -102, -111:
+		return danmakuSendAccountError
+	default:
+		return danmakuSendNetworkError
+	}
+}
+
+// danmakuPacer 单个账号+代理组合的发送节奏
+type danmakuPacer struct {
+	interval      time.Duration
+	nextAt        time.Time
+	successStreak int
+}
+
+// DanmakuRateController 按账号和代理自适应调整弹幕发送间隔：
+// 频率限制时间隔翻倍，连续成功后逐步缩短，同时保证同一出口IP的最小发送间隔
+type DanmakuRateController struct {
+	mu     sync.Mutex
+	pacers map[string]*danmakuPacer
+	egress map[string]time.Time // 出口IP（代理地址，本地为空）下一次允许发送的时间
+}
+
+var (
+	danmakuRateController     *DanmakuRateController
+	danmakuRateControllerOnce sync.Once
+)
+
+// GetDanmakuRateController 获取全局弹幕发送控制器（单例，节奏在多次发送任务间保留）
+func GetDanmakuRateController() *DanmakuRateController {
+	danmakuRateControllerOnce.Do(func() {
+		danmakuRateController = &DanmakuRateController{
+			pacers: make(map[string]*danmakuPacer),
+			egress: make(map[string]time.Time),
+		}
+	})
+	return danmakuRateController
+}
+
+func (c *DanmakuRateController) pacer(uid int64, proxy string) *danmakuPacer {
+	key := fmt.Sprintf("%d|%s", uid, proxy)
+	p, ok := c.pacers[key]
+	if !ok {
+		p = &danmakuPacer{interval: danmakuBaseInterval}
+		c.pacers[key] = p
+	}
+	return p
+}
+
+// Wait 等待到该账号在该代理上允许发送且出口IP满足最小间隔的时间，并预留下一次发送时间（附加3-8秒随机抖动）
+func (c *DanmakuRateController) Wait(uid int64, proxy string) {
+	c.mu.Lock()
+	p := c.pacer(uid, proxy)
+	now := time.Now()
+	sendAt := p.nextAt
+	if egressAt := c.egress[proxy]; egressAt.After(sendAt) {
+		sendAt = egressAt
+	}
+	if sendAt.Before(now) {
+		sendAt = now
+	}
+	jitter := time.Duration(3+rand.Intn(6)) * time.Second
+	p.nextAt = sendAt.Add(p.interval + jitter)
+	c.egress[proxy] = sendAt.Add(danmakuEgressInterval)
+	c.mu.Unlock()
+
+	if wait := time.Until(sendAt); wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+// Interval 获取账号在该代理上当前的发送间隔，尚未发送过的组合返回基础间隔（只读，不创建节奏记录）
+func (c *DanmakuRateController) Interval(uid int64, proxy string) time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if p, ok := c.pacers[fmt.Sprintf("%d|%s", uid, proxy)]; ok {
+		return p.interval
+	}
+	return danmakuBaseInterval
+}
+
+// Report 根据发送结果调整节奏
+func (c *DanmakuRateController) Report(uid int64, proxy string, result int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	p := c.pacer(uid, proxy)
+	switch result {
+	case danmakuSendSuccess:
+		p.successStreak++
+		if p.successStreak >= danmakuRecoverStreak && p.interval > danmakuMinInterval {
+			p.interval = p.interval * 9 / 10
+			if p.interval < danmakuMinInterval {
+				p.interval = danmakuMinInterval
+			}
+			p.successStreak = 0
+			log.Printf("[弹幕发送] 📉 账号%d(%s) 连续成功，发送间隔缩短为 %v", uid, proxyLabel(proxy), p.interval)
+		}
+	case danmakuSendRateLimited:
+		p.successStreak = 0
+		p.interval *= 2
+		if p.interval > danmakuMaxInterval {
+			p.interval = danmakuMaxInterval
+		}
+		p.nextAt = time.Now().Add(p.interval)
+		log.Printf("[弹幕发送] 📈 账号%d(%s) 触发频率限制，发送间隔增加到 %v", uid, proxyLabel(proxy), p.interval)
+	case danmakuSendNetworkError:
+		p.successStreak = 0
+		if next := time.Now().Add(danmakuNetworkPause); next.After(p.nextAt) {
+			p.nextAt = next
+		}
+	}
+}
+
+func proxyLabel(proxy string) string {
+	if proxy == "" {
+		return "本地IP"
+	}
+	return proxy
+}
+
+// getDanmakuDailyQuota 读取每个账号每日弹幕上限，0为不限制
+func getDanmakuDailyQuota() int {
+	var config models.SystemConfig
+	if err := database.GetDB().First(&config).Error; err != nil {
+		return 0
+	}
+	return config.DanmakuDailyQuota
+}
+
+// danmakuQuotaDate 配额按自然日计算
+func danmakuQuotaDate(t time.Time) string {
+	return t.Format("2006-01-02")
+}
+
+// GetDanmakuQuotaRemaining 获取账号今天剩余的弹幕配额，limit为0时返回-1表示不限制
+func GetDanmakuQuotaRemaining(userID uint, limit int) int {
+	if limit <= 0 {
+		return -1
+	}
+	var quota models.DanmakuQuota
+	database.GetDB().Where("user_id = ? AND date = ?", userID, danmakuQuotaDate(time.Now())).First(&quota)
+	if remaining := limit - quota.Sent; remaining > 0 {
+		return remaining
+	}
+	return 0
+}
+
+// consumeDanmakuQuota 记录账号今天发送了一条弹幕
+func consumeDanmakuQuota(userID uint) {
+	quota := models.DanmakuQuota{UserID: userID, Date: danmakuQuotaDate(time.Now()), Sent: 1}
+	database.GetDB().Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "date"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"sent": gorm.Expr("sent + 1"), "updated_at": time.Now()}),
+	}).Create(&quota)
+}
+
+// nextDanmakuQuotaReset 下一次配额重置时间（次日零点后5分钟）
+func nextDanmakuQuotaReset() time.Time {
+	now := time.Now()
+	return time.Date(now.Year(), now.Month(), now.Day()+1, 0, 5, 0, 0, now.Location())
+}
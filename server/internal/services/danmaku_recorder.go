@@ -0,0 +1,474 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gobup/server/internal/database"
+	"github.com/gobup/server/internal/models"
+)
+
+// danmakuRecordFlushInterval 弹幕写入文件和数据库的间隔
+const danmakuRecordFlushInterval = 5 * time.Second
+
+// xmlFooter 弹幕XML结束标签，续录时需要先去掉
+const xmlFooter = "</i>\n"
+
+// DanmakuRecorderService 内置弹幕录制：通过直播信息流录制弹幕、SC、礼物和上舰，
+// 写入录播姬兼容的XML文件，同时直接写入弹幕和付费事件记录
+type DanmakuRecorderService struct {
+	mu       sync.Mutex
+	sessions map[string]*danmakuRecordSession // key: RecordRoom.RoomID
+}
+
+// danmakuRecordSession 单个房间的弹幕录制
+type danmakuRecordSession struct {
+	mu          sync.Mutex
+	roomID      string
+	history     models.RecordHistory
+	recordStart time.Time // XML时间戳的零点
+	file        *os.File
+	out         *bufio.Writer
+	parser      *DanmakuXMLParser
+	sink        *danmakuBatchWriter
+	stop        chan struct{}
+	done        chan struct{}
+}
+
+var (
+	danmakuRecorderService     *DanmakuRecorderService
+	danmakuRecorderServiceOnce sync.Once
+)
+
+// GetDanmakuRecorderService 获取内置弹幕录制服务（单例）
+func GetDanmakuRecorderService() *DanmakuRecorderService {
+	danmakuRecorderServiceOnce.Do(func() {
+		danmakuRecorderService = &DanmakuRecorderService{
+			sessions: make(map[string]*danmakuRecordSession),
+		}
+	})
+	return danmakuRecorderService
+}
+
+// Start 开始录制房间弹幕，已在录制时跳过
+func (s *DanmakuRecorderService) Start(room *models.RecordRoom) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.sessions[room.RoomID]; ok {
+		return nil
+	}
+
+	history, err := liveSessionHistory(room, time.Now())
+	if err != nil {
+		return err
+	}
+
+	session, err := openDanmakuRecordSession(room, history)
+	if err != nil {
+		return err
+	}
+	s.sessions[room.RoomID] = session
+	go session.flushLoop()
+
+	log.Printf("[弹幕录制] 房间 %s 开始录制弹幕: history_id=%d, 文件=%s", room.RoomID, history.ID, session.file.Name())
+	return nil
+}
+
+// Stop 结束房间的弹幕录制，live为false表示直播已结束
+func (s *DanmakuRecorderService) Stop(roomID string, live bool) {
+	s.mu.Lock()
+	session, ok := s.sessions[roomID]
+	delete(s.sessions, roomID)
+	s.mu.Unlock()
+	if !ok {
+		return
+	}
+	session.close(!live)
+}
+
+// StopAll 结束所有录制，用于服务退出，录制中的历史记录保留录制状态以便重启后续录
+func (s *DanmakuRecorderService) StopAll() {
+	s.mu.Lock()
+	sessions := s.sessions
+	s.sessions = make(map[string]*danmakuRecordSession)
+	s.mu.Unlock()
+
+	for _, session := range sessions {
+		session.close(false)
+	}
+}
+
+// Recording 房间是否正在录制弹幕
+func (s *DanmakuRecorderService) Recording(roomID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.sessions[roomID]
+	return ok
+}
+
+// RoomIDs 正在录制弹幕的房间
+func (s *DanmakuRecorderService) RoomIDs() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	roomIDs := make([]string, 0, len(s.sessions))
+	for roomID := range s.sessions {
+		roomIDs = append(roomIDs, roomID)
+	}
+	return roomIDs
+}
+
+// HandleCommand 处理直播信息流中的弹幕、SC、礼物和上舰消息
+func (s *DanmakuRecorderService) HandleCommand(roomID, cmd string, body []byte) {
+	switch cmd {
+	case "DANMU_MSG", "SUPER_CHAT_MESSAGE", "SEND_GIFT", "GUARD_BUY":
+	default:
+		return
+	}
+
+	s.mu.Lock()
+	session, ok := s.sessions[roomID]
+	s.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	if err := session.handle(cmd, body); err != nil {
+		log.Printf("[弹幕录制] 房间 %s 处理 %s 失败: %v", roomID, cmd, err)
+	}
+}
+
+// openDanmakuRecordSession 打开弹幕文件：历史记录已有弹幕文件时续写，否则创建新文件并写入XML头
+func openDanmakuRecordSession(room *models.RecordRoom, history *models.RecordHistory) (*danmakuRecordSession, error) {
+	db := database.GetDB()
+	session := &danmakuRecordSession{
+		roomID:  room.RoomID,
+		history: *history,
+		parser:  NewDanmakuXMLParser(),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	path := history.DanmakuFile
+	resume := false
+	if path != "" {
+		if start, ok := readDanmakuXMLRecordStart(path); ok {
+			session.recordStart = start
+			resume = true
+		}
+	}
+
+	if resume {
+		file, err := os.OpenFile(path, os.O_RDWR, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("打开弹幕文件失败: %w", err)
+		}
+		if err := trimXMLFooter(file); err != nil {
+			file.Close()
+			return nil, err
+		}
+		session.file = file
+	} else {
+		session.recordStart = time.Now()
+		path = liveRecordPath(room, session.recordStart, ".xml")
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return nil, fmt.Errorf("创建弹幕目录失败: %w", err)
+		}
+		file, err := os.Create(path)
+		if err != nil {
+			return nil, fmt.Errorf("创建弹幕文件失败: %w", err)
+		}
+		session.file = file
+		if err := db.Model(history).Update("danmaku_file", path).Error; err != nil {
+			file.Close()
+			return nil, fmt.Errorf("保存弹幕文件路径失败: %w", err)
+		}
+	}
+
+	session.out = bufio.NewWriter(session.file)
+	if !resume {
+		writeDanmakuXMLHeader(session.out, room, session.recordStart)
+	}
+
+	base := danmakuTimeBase{SessionStart: history.StartTime, FileStart: session.recordStart}
+	session.sink = &danmakuBatchWriter{db: db, base: base, offsetMs: base.offsetMs(session.recordStart)}
+	return session, nil
+}
+
+// trimXMLFooter 去掉文件末尾的结束标签，使续写的内容仍在<i>元素内
+func trimXMLFooter(file *os.File) error {
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+	tail := make([]byte, len(xmlFooter))
+	if size >= int64(len(tail)) {
+		if _, err := file.ReadAt(tail, size-int64(len(tail))); err == nil && string(tail) == xmlFooter {
+			size -= int64(len(tail))
+			if err := file.Truncate(size); err != nil {
+				return fmt.Errorf("续写弹幕文件失败: %w", err)
+			}
+		}
+	}
+	_, err = file.Seek(size, io.SeekStart)
+	return err
+}
+
+// closeDanmakuXML 为异常中断的弹幕文件补上结束标签
+func closeDanmakuXML(path string) {
+	if path == "" {
+		return
+	}
+	file, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	if err := trimXMLFooter(file); err == nil {
+		file.WriteString(xmlFooter)
+	}
+}
+
+// writeDanmakuXMLHeader 写入录播姬格式的XML头，start_time用于解析时对齐直播时间轴
+func writeDanmakuXMLHeader(w *bufio.Writer, room *models.RecordRoom, start time.Time) {
+	w.WriteString(`<?xml version="1.0" encoding="utf-8"?>` + "\n")
+	w.WriteString("<i><chatserver>chat.bilibili.com</chatserver><chatid>0</chatid><mission>0</mission><maxlimit>1000</maxlimit><state>0</state><real_name>0</real_name><source>0</source>\n")
+	w.WriteString(`<BililiveRecorder version="gobup" />` + "\n")
+	fmt.Fprintf(w, `<BililiveRecorderRecordInfo roomid="%s" shortid="0" name="%s" title="%s" areanameparent="%s" areanamechild="%s" start_time="%s" />`+"\n",
+		xmlAttr(room.RoomID), xmlAttr(room.Uname), xmlAttr(room.Title), xmlAttr(room.AreaNameParent), xmlAttr(room.AreaName),
+		start.Format("2006-01-02T15:04:05.0000000-07:00"))
+}
+
+// xmlAttr 转义XML属性值
+func xmlAttr(value string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(value))
+	return buf.String()
+}
+
+// flushLoop 定时将弹幕写入文件和数据库
+func (s *danmakuRecordSession) flushLoop() {
+	defer close(s.done)
+	ticker := time.NewTicker(danmakuRecordFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			s.flush()
+			s.mu.Unlock()
+		}
+	}
+}
+
+// flush 写出缓冲区，调用方持有锁
+func (s *danmakuRecordSession) flush() {
+	if err := s.out.Flush(); err != nil {
+		log.Printf("[弹幕录制] 房间 %s 写入弹幕文件失败: %v", s.roomID, err)
+	}
+	if err := s.sink.flush(); err != nil {
+		log.Printf("[弹幕录制] 房间 %s 保存弹幕失败: %v", s.roomID, err)
+	}
+}
+
+// close 结束录制，finish为true时直播已结束，直播流录制也结束后更新历史记录的结束时间和弹幕数
+func (s *danmakuRecordSession) close(finish bool) {
+	close(s.stop)
+	<-s.done
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.out.WriteString(xmlFooter)
+	s.flush()
+	s.file.Close()
+
+	if finish {
+		finishLiveSession(&s.history, time.Now())
+		log.Printf("[弹幕录制] 房间 %s 弹幕录制结束: history_id=%d, 本次写入 %d 条弹幕", s.roomID, s.history.ID, s.sink.inserted)
+	} else {
+		log.Printf("[弹幕录制] 房间 %s 弹幕录制暂停: history_id=%d, 本次写入 %d 条弹幕", s.roomID, s.history.ID, s.sink.inserted)
+	}
+}
+
+// handle 将消息写入XML，并复用XML解析逻辑生成弹幕和付费事件记录
+func (s *danmakuRecordSession) handle(cmd string, body []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ts := strconv.FormatFloat(time.Since(s.recordStart).Seconds(), 'f', 3, 64)
+	sessionID := s.history.SessionID
+
+	switch cmd {
+	case "DANMU_MSG":
+		var msg struct {
+			Info []json.RawMessage `json:"info"`
+		}
+		if err := json.Unmarshal(body, &msg); err != nil || len(msg.Info) < 3 {
+			return fmt.Errorf("弹幕格式错误")
+		}
+		var basic []interface{}
+		var text string
+		var user []interface{}
+		decodeLoose(msg.Info[0], &basic)
+		json.Unmarshal(msg.Info[1], &text)
+		json.Unmarshal(msg.Info[2], &user)
+		if len(basic) < 5 || len(user) < 2 {
+			return fmt.Errorf("弹幕格式错误")
+		}
+		uid := jsonInt(user[0])
+		uname, _ := user[1].(string)
+		raw, _ := json.Marshal(msg.Info)
+
+		d := D{
+			P:    fmt.Sprintf("%s,%v,%v,%v,%v,0,%d,0", ts, basic[1], basic[2], basic[3], basic[4], uid),
+			Text: text,
+			Raw:  string(raw),
+		}
+		fmt.Fprintf(s.out, `<d p="%s" user="%s" raw="%s">%s</d>`+"\n", d.P, xmlAttr(uname), xmlAttr(d.Raw), xmlAttr(d.Text))
+
+		// 抽奖弹幕只写入文件，不入库
+		if liveMsg, err := s.parser.parseDanmaku(d, sessionID); err == nil {
+			return s.sink.AddMsg(liveMsg)
+		}
+		return nil
+
+	case "SUPER_CHAT_MESSAGE":
+		var msg struct {
+			Data struct {
+				UID      json.Number `json:"uid"`
+				Price    float64     `json:"price"`
+				Message  string      `json:"message"`
+				Time     int         `json:"time"`
+				UserInfo struct {
+					Uname string `json:"uname"`
+				} `json:"user_info"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(body, &msg); err != nil {
+			return err
+		}
+		sc := SC{
+			TS:    ts,
+			User:  msg.Data.UserInfo.Uname,
+			UID:   msg.Data.UID.String(),
+			Price: strconv.FormatFloat(msg.Data.Price, 'f', -1, 64),
+			Text:  msg.Data.Message,
+			Raw:   string(body),
+		}
+		fmt.Fprintf(s.out, `<sc ts="%s" user="%s" uid="%s" price="%s" time="%d" raw="%s">%s</sc>`+"\n",
+			sc.TS, xmlAttr(sc.User), sc.UID, sc.Price, msg.Data.Time, xmlAttr(sc.Raw), xmlAttr(sc.Text))
+
+		if event := s.parser.parseSCEvent(sc, sessionID, danmakuSourceRecorder); event != nil {
+			if err := s.sink.AddEvent(event); err != nil {
+				return err
+			}
+		}
+		liveMsg, err := s.parser.parseSC(sc, sessionID, danmakuSourceRecorder)
+		if err != nil {
+			return err
+		}
+		return s.sink.AddMsg(liveMsg)
+
+	case "SEND_GIFT":
+		var msg struct {
+			Data struct {
+				UID      json.Number `json:"uid"`
+				Uname    string      `json:"uname"`
+				GiftName string      `json:"giftName"`
+				Num      int         `json:"num"`
+				Price    int64       `json:"price"`
+				CoinType string      `json:"coin_type"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(body, &msg); err != nil {
+			return err
+		}
+		gift := Gift{
+			TS:        ts,
+			User:      msg.Data.Uname,
+			UID:       msg.Data.UID.String(),
+			GiftName2: msg.Data.GiftName,
+			GiftCount: strconv.Itoa(msg.Data.Num),
+			CoinType:  msg.Data.CoinType,
+			Price:     strconv.FormatInt(msg.Data.Price, 10),
+		}
+		fmt.Fprintf(s.out, `<gift ts="%s" user="%s" uid="%s" giftname="%s" giftcount="%s" cointype="%s" price="%s" raw="%s" />`+"\n",
+			gift.TS, xmlAttr(gift.User), gift.UID, xmlAttr(gift.GiftName2), gift.GiftCount, xmlAttr(gift.CoinType), gift.Price, xmlAttr(string(body)))
+
+		if event := s.parser.parseGiftEvent(gift, sessionID); event != nil {
+			return s.sink.AddEvent(event)
+		}
+		return nil
+
+	case "GUARD_BUY":
+		var msg struct {
+			Data struct {
+				UID        json.Number `json:"uid"`
+				Username   string      `json:"username"`
+				GuardLevel int         `json:"guard_level"`
+				Num        int         `json:"num"`
+				Price      int64       `json:"price"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(body, &msg); err != nil {
+			return err
+		}
+		guard := Guard{
+			TS:    ts,
+			User:  msg.Data.Username,
+			UID:   msg.Data.UID.String(),
+			Level: strconv.Itoa(msg.Data.GuardLevel),
+			Count: strconv.Itoa(msg.Data.Num),
+			Price: strconv.FormatInt(msg.Data.Price, 10),
+		}
+		fmt.Fprintf(s.out, `<guard ts="%s" user="%s" uid="%s" level="%s" count="%s" price="%s" raw="%s" />`+"\n",
+			guard.TS, xmlAttr(guard.User), guard.UID, guard.Level, guard.Count, guard.Price, xmlAttr(string(body)))
+
+		if event := s.parser.parseGuardEvent(guard, sessionID); event != nil {
+			if err := s.sink.AddEvent(event); err != nil {
+				return err
+			}
+		}
+		liveMsg, err := s.parser.parseGuard(guard, sessionID)
+		if err != nil {
+			return err
+		}
+		return s.sink.AddMsg(liveMsg)
+	}
+	return nil
+}
+
+// decodeLoose 使用json.Number解码，保留弹幕参数中数字的原始格式（发送时间为毫秒时间戳）
+func decodeLoose(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+// jsonInt 将JSON中的数字转为int64
+func jsonInt(v interface{}) int64 {
+	switch n := v.(type) {
+	case float64:
+		return int64(n)
+	case json.Number:
+		i, _ := n.Int64()
+		return i
+	case string:
+		i, _ := strconv.ParseInt(n, 10, 64)
+		return i
+	}
+	return 0
+}
@@ -183,6 +183,12 @@ func (s *DataRepairService) handleEmptyHistories(result *RepairResult, dryRun bo
 			continue
 		}
 
+		// 内置弹幕录制的记录保留，等待录播文件扫描入库
+		if history.DanmakuFile != "" {
+			log.Printf("[DataRepair] 保留内置弹幕录制的空历史记录: ID=%d, SessionID=%s", history.ID, history.SessionID)
+			continue
+		}
+
 		// 如果是最近1小时内创建的，保留（可能是刚开始的录制会话）
 		if history.CreatedAt.After(oneHourAgo) {
 			log.Printf("[DataRepair] 保留最近的空历史记录: ID=%d, SessionID=%s, 创建时间=%s (不到1小时)",
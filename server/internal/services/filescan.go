@@ -539,9 +539,8 @@ func (s *FileScanService) importFile(filePath string, info os.FileInfo) error {
 	log.Printf("[FileScan] 成功导入文件: %s -> HistoryID=%d, PartID=%d",
 		filepath.Base(filePath), history.ID, part.ID)
 
-	// 尝试解析弹幕XML文件
-	xmlPath := strings.TrimSuffix(filePath, filepath.Ext(filePath)) + ".xml"
-	if _, err := os.Stat(xmlPath); err == nil {
+	// 尝试解析弹幕文件（XML/JSON lines/ASS）
+	if xmlPath := findDanmakuFile(filePath); xmlPath != "" {
 		parser := NewDanmakuXMLParser()
 		count, err := parser.ParseDanmakuFile(xmlPath, metadata.SessionID)
 		if err != nil {
@@ -692,6 +691,20 @@ func (s *FileScanService) getOrCreateHistory(db *gorm.DB, metadata *FileMetadata
 		}
 	}
 
+	// 内置弹幕录制在开播时已创建历史记录，录播文件开始时间落在该场直播内时合并过去
+	var liveHistory models.RecordHistory
+	if err := db.Where("room_id = ? AND danmaku_file != '' AND start_time <= ? AND (recording = ? OR end_time >= ?)",
+		metadata.RoomID, metadata.StartTime.Add(10*time.Minute), true, metadata.StartTime.Add(-10*time.Minute)).
+		Order("start_time DESC").
+		First(&liveHistory).Error; err == nil {
+		if metadata.EndTime.After(liveHistory.EndTime) && !liveHistory.Recording {
+			liveHistory.EndTime = metadata.EndTime
+			db.Save(&liveHistory)
+		}
+		log.Printf("[FileScan] 合并到内置弹幕录制的历史记录: ID=%d, SessionID=%s", liveHistory.ID, liveHistory.SessionID)
+		return &liveHistory, nil
+	}
+
 	// 创建新的历史记录
 	// 尝试从直播间API获取真实的主播名
 	uname := metadata.Uname
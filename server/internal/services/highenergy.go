@@ -1,6 +1,7 @@
 package services
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
 	"math"
@@ -8,171 +9,429 @@ import (
 	"os/exec"
 	"path/filepath"
 	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gobup/server/internal/database"
 	"github.com/gobup/server/internal/models"
 )
 
+// 高能剪辑状态
+const (
+	HighlightStatusProcessing = 0
+	HighlightStatusCompleted  = 1
+	HighlightStatusFailed     = 2
+)
+
+// 房间未设置时使用的默认参数
+const (
+	defaultHighlightWindow     = 60 // 秒
+	defaultHighlightMinSegment = 10 // 秒
+	defaultHighlightPercentile = 0.95
+)
+
 type HighEnergyCutService struct{}
 
 func NewHighEnergyCutService() *HighEnergyCutService {
 	return &HighEnergyCutService{}
 }
 
-// CutHighEnergySegments 根据弹幕密度剪辑高能片段
+// highlightParams 房间的高能剪辑参数
+type highlightParams struct {
+	windowMs     int
+	minSegmentMs int64
+	percentile   float64
+}
+
+// highlightParamsForRoom 读取房间设置，百分位兼容0-1小数和前端保存的百分数
+func highlightParamsForRoom(room *models.RecordRoom) highlightParams {
+	window := room.WindowSize
+	if window <= 0 {
+		window = defaultHighlightWindow
+	}
+	minSegment := room.MinSegmentDuration
+	if minSegment <= 0 {
+		minSegment = defaultHighlightMinSegment
+	}
+	percentile := room.PercentileRank
+	if percentile > 1 {
+		percentile /= 100
+	}
+	if percentile <= 0 || percentile > 1 {
+		percentile = defaultHighlightPercentile
+	}
+	return highlightParams{
+		windowMs:     window * 1000,
+		minSegmentMs: int64(minSegment) * 1000,
+		percentile:   percentile,
+	}
+}
+
+// CutHighEnergySegments 房间启用高能剪辑时，根据高能评分剪辑高能片段并记录
 func (s *HighEnergyCutService) CutHighEnergySegments(historyID uint) (string, error) {
+	var history models.RecordHistory
+	if err := database.GetDB().First(&history, historyID).Error; err != nil {
+		return "", fmt.Errorf("历史记录不存在: %w", err)
+	}
+
+	var room models.RecordRoom
+	if err := database.GetDB().Where("room_id = ?", history.RoomID).First(&room).Error; err != nil {
+		return "", fmt.Errorf("房间配置不存在: %w", err)
+	}
+
+	if !room.HighEnergyCut {
+		return "", fmt.Errorf("未启用高能剪辑")
+	}
+
+	clip, err := s.GenerateClip(historyID)
+	if err != nil {
+		return "", err
+	}
+	return clip.FilePath, nil
+}
+
+// GenerateClip 根据高能评分生成高能剪辑并记录到数据库（不检查房间是否启用）
+func (s *HighEnergyCutService) GenerateClip(historyID uint) (*models.HighlightClip, error) {
 	db := database.GetDB()
 
 	// 获取历史记录
 	var history models.RecordHistory
 	if err := db.First(&history, historyID).Error; err != nil {
-		return "", fmt.Errorf("历史记录不存在: %w", err)
+		return nil, fmt.Errorf("历史记录不存在: %w", err)
 	}
 
 	// 获取房间配置
 	var room models.RecordRoom
 	if err := db.Where("room_id = ?", history.RoomID).First(&room).Error; err != nil {
-		return "", fmt.Errorf("房间配置不存在: %w", err)
+		return nil, fmt.Errorf("房间配置不存在: %w", err)
 	}
 
-	if !room.HighEnergyCut {
-		return "", fmt.Errorf("未启用高能剪辑")
+	clip := &models.HighlightClip{
+		HistoryID: history.ID,
+		RoomID:    history.RoomID,
+		SessionID: history.SessionID,
+		Status:    HighlightStatusProcessing,
+	}
+	if err := db.Create(clip).Error; err != nil {
+		return nil, fmt.Errorf("创建剪辑记录失败: %w", err)
 	}
 
-	// 获取弹幕数据
-	var danmakus []models.LiveMsg
-	if err := db.Where("session_id = ?", history.SessionID).
-		Order("timestamp ASC").
-		Find(&danmakus).Error; err != nil {
-		return "", fmt.Errorf("查询弹幕失败: %w", err)
+	outputFile, segments, duration, err := s.cutHistory(&history, &room)
+	if err != nil {
+		db.Model(clip).Updates(map[string]interface{}{
+			"status":    HighlightStatusFailed,
+			"error_msg": err.Error(),
+		})
+		return nil, err
 	}
 
-	if len(danmakus) == 0 {
-		return "", fmt.Errorf("没有弹幕数据")
+	segmentsJSON, _ := json.Marshal(segments)
+	clip.FilePath = outputFile
+	clip.Duration = duration
+	clip.SegmentCount = len(segments)
+	clip.Segments = string(segmentsJSON)
+	clip.Status = HighlightStatusCompleted
+	if info, err := os.Stat(outputFile); err == nil {
+		clip.FileSize = info.Size()
 	}
+	db.Save(clip)
 
-	// 计算弹幕密度
-	timeWindow := 10 * 1000 // 10秒时间窗口（毫秒）
-	densityMap := s.calculateDanmakuDensity(danmakus, timeWindow)
+	log.Printf("高能剪辑完成: %s", outputFile)
+	return clip, nil
+}
 
-	// 获取高能阈值
-	threshold := s.calculateThreshold(densityMap, room.PercentileRank)
+// cutHistory 识别高能片段并剪辑，返回输出文件、片段和实际剪辑时长（秒）
+func (s *HighEnergyCutService) cutHistory(history *models.RecordHistory, room *models.RecordRoom) (string, []TimeSegment, float64, error) {
+	db := database.GetDB()
+	params := highlightParamsForRoom(room)
 
-	// 识别高能片段
-	segments := s.identifyHighEnergySegments(densityMap, threshold, timeWindow)
+	// 按评分模型计算各窗口得分，按选择策略识别高能片段
+	scores, err := s.scoreHistory(history, room)
+	if err != nil {
+		return "", nil, 0, err
+	}
+	segments := s.identifyHighEnergySegments(scores.Windows)
 
 	if len(segments) == 0 {
-		return "", fmt.Errorf("未识别到高能片段")
+		return "", nil, 0, fmt.Errorf("未识别到高能片段")
 	}
 
-	// 合并相近的片段
-	segments = s.mergeNearSegments(segments, 30*1000) // 30秒内的片段合并
+	// 过短的片段扩展到最小时长，再合并相距不足一个窗口的片段
+	segments = s.extendShortSegments(segments, params.minSegmentMs)
+	segments = s.mergeNearSegments(segments, int64(params.windowMs))
 
-	log.Printf("识别到 %d 个高能片段", len(segments))
+	log.Printf("识别到 %d 个高能片段 (窗口=%ds, 策略=%s, 阈值=%.2f)", len(segments), params.windowMs/1000, scores.Strategy, scores.Threshold)
 
 	// 获取原视频文件
 	var parts []models.RecordHistoryPart
-	if err := db.Where("history_id = ?", historyID).
+	if err := db.Where("history_id = ? AND merged_into = 0 AND recording = ?", history.ID, false).
 		Order("start_time ASC").
 		Find(&parts).Error; err != nil {
-		return "", fmt.Errorf("查询分P失败: %w", err)
+		return "", nil, 0, fmt.Errorf("查询分P失败: %w", err)
 	}
 
 	if len(parts) == 0 {
-		return "", fmt.Errorf("没有视频文件")
+		return "", nil, 0, fmt.Errorf("没有视频文件")
 	}
 
-	// 使用第一个视频文件作为源（简化处理，实际可能需要拼接多个分P）
-	sourceFile := parts[0].FilePath
+	// 将直播时间轴上的片段映射到各分P文件，跨分P的片段拆分后依次拼接
+	clips := mapSegmentsToParts(s.partRanges(history, parts), segments)
+	if len(clips) == 0 {
+		return "", nil, 0, fmt.Errorf("高能片段不在任何分P视频范围内")
+	}
 
 	// 创建输出文件
+	sourceFile := clips[0].part.FilePath
 	outputFile := filepath.Join(filepath.Dir(sourceFile),
 		fmt.Sprintf("%s_highlight_%d.mp4", filepath.Base(sourceFile), time.Now().Unix()))
 
 	// 使用ffmpeg剪辑（这里需要安装ffmpeg）
-	if err := s.cutVideoSegments(sourceFile, outputFile, segments); err != nil {
-		return "", fmt.Errorf("视频剪辑失败: %w", err)
+	if err := s.cutVideoSegments(clips, outputFile, false); err != nil {
+		return "", nil, 0, fmt.Errorf("视频剪辑失败: %w", err)
 	}
 
-	log.Printf("高能剪辑完成: %s", outputFile)
-	return outputFile, nil
+	var total int64
+	for _, clip := range clips {
+		total += clip.end - clip.start
+	}
+	return outputFile, segments, float64(total) / 1000, nil
 }
 
-// DanmakuDensity 弹幕密度数据点
-type DanmakuDensity struct {
-	Timestamp int64 // 时间点（毫秒）
-	Count     int   // 弹幕数量
+// partClip 分P文件中的一段，时间为视频内的位置（毫秒）
+type partClip struct {
+	part  *models.RecordHistoryPart
+	start int64
+	end   int64
 }
 
-// calculateDanmakuDensity 计算弹幕密度
-func (s *HighEnergyCutService) calculateDanmakuDensity(danmakus []models.LiveMsg, windowMs int) []DanmakuDensity {
-	if len(danmakus) == 0 {
-		return nil
+// partRange 分P视频覆盖的直播时间轴范围（毫秒）
+type partRange struct {
+	part  *models.RecordHistoryPart
+	start int64
+	end   int64
+}
+
+// partRanges 计算各分P在直播时间轴上的范围（视频0秒位置+时长），文件不存在或时长未知的分P会被跳过
+func (s *HighEnergyCutService) partRanges(history *models.RecordHistory, parts []models.RecordHistoryPart) []partRange {
+	offsetSvc := NewDanmakuOffsetService()
+	mediaSvc := NewMediaService()
+
+	var ranges []partRange
+	for i := range parts {
+		part := &parts[i]
+		if _, err := os.Stat(part.FilePath); err != nil {
+			log.Printf("[高能剪辑] ⚠️  分P文件不存在，跳过: %s", part.FilePath)
+			continue
+		}
+		duration := mediaSvc.EnsurePartDuration(part)
+		if duration <= 0 {
+			log.Printf("[高能剪辑] ⚠️  无法获取分P时长，跳过: %s", part.FilePath)
+			continue
+		}
+		start := offsetSvc.PartOffset(history, part)
+		ranges = append(ranges, partRange{part: part, start: start, end: start + int64(duration)*1000})
 	}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].start < ranges[j].start })
+	return ranges
+}
 
-	// 找出时间范围
-	minTime := danmakus[0].Timestamp
-	maxTime := danmakus[len(danmakus)-1].Timestamp
+// mapSegmentsToParts 将直播时间轴上的片段映射到分P文件，跨分P的片段拆分为多段
+// 片段中没有被任何分P覆盖的部分直接丢弃
+func mapSegmentsToParts(ranges []partRange, segments []TimeSegment) []partClip {
+	var clips []partClip
+	for _, seg := range segments {
+		// cursor避免分P时间范围重叠时重复剪辑同一段直播内容
+		cursor := seg.Start
+		for _, r := range ranges {
+			lo, hi := cursor, seg.End
+			if r.start > lo {
+				lo = r.start
+			}
+			if r.end < hi {
+				hi = r.end
+			}
+			if hi <= lo {
+				continue
+			}
+			clips = append(clips, partClip{part: r.part, start: lo - r.start, end: hi - r.start})
+			cursor = hi
+		}
+	}
+	return clips
+}
 
-	var densities []DanmakuDensity
+// ListClips 获取历史记录的高能剪辑
+func (s *HighEnergyCutService) ListClips(historyID uint) ([]models.HighlightClip, error) {
+	var clips []models.HighlightClip
+	err := database.GetDB().Where("history_id = ?", historyID).Order("created_at DESC").Find(&clips).Error
+	return clips, err
+}
 
-	// 以时间窗口滑动计算密度
-	for t := minTime; t <= maxTime; t += int64(windowMs / 2) { // 窗口重叠50%
-		count := 0
-		for _, dm := range danmakus {
-			if dm.Timestamp >= t && dm.Timestamp < t+int64(windowMs) {
-				count++
-			}
+// DeleteClip 删除高能剪辑记录及输出文件
+func (s *HighEnergyCutService) DeleteClip(clipID uint) error {
+	db := database.GetDB()
+
+	var clip models.HighlightClip
+	if err := db.First(&clip, clipID).Error; err != nil {
+		return fmt.Errorf("剪辑记录不存在: %w", err)
+	}
+	if clip.Status == HighlightStatusProcessing {
+		return fmt.Errorf("剪辑正在生成中，无法删除")
+	}
+
+	if clip.FilePath != "" {
+		if err := os.Remove(clip.FilePath); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("删除剪辑文件失败: %w", err)
 		}
-		densities = append(densities, DanmakuDensity{
-			Timestamp: t,
-			Count:     count,
-		})
+		// 已加入高能集锦稿件的分P文件随之失效，避免继续上传
+		db.Model(&models.RecordHistoryPart{}).Where("file_path = ?", clip.FilePath).Update("file_delete", true)
+	}
+	return db.Delete(&clip).Error
+}
+
+// EnqueueIfEnabled 弹幕解析完成后调用，房间启用高能剪辑且尚未生成时加入剪辑队列
+func (s *HighEnergyCutService) EnqueueIfEnabled(historyID uint) {
+	db := database.GetDB()
+
+	var history models.RecordHistory
+	if err := db.First(&history, historyID).Error; err != nil {
+		return
+	}
+	var room models.RecordRoom
+	if err := db.Where("room_id = ?", history.RoomID).First(&room).Error; err != nil || !room.HighEnergyCut {
+		return
+	}
+
+	// 直播录制中视频不完整，录制结束或投稿后再次触发时生成
+	var recording int64
+	db.Model(&models.RecordHistoryPart{}).Where("history_id = ? AND recording = ?", historyID, true).Count(&recording)
+	if history.Recording || recording > 0 {
+		return
+	}
+
+	var count int64
+	db.Model(&models.HighlightClip{}).
+		Where("history_id = ? AND status IN ?", historyID, []int{HighlightStatusProcessing, HighlightStatusCompleted}).
+		Count(&count)
+	if count > 0 {
+		return
 	}
 
-	return densities
+	if err := getHighlightQueue().add(historyID); err != nil {
+		log.Printf("[高能剪辑] ⚠️  加入队列失败 history_id=%d: %v", historyID, err)
+	}
 }
 
-// calculateThreshold 计算高能阈值（基于百分位数）
-func (s *HighEnergyCutService) calculateThreshold(densities []DanmakuDensity, percentile float64) int {
-	if len(densities) == 0 {
-		return 0
+// Regenerate 删除已有剪辑并重新生成
+func (s *HighEnergyCutService) Regenerate(historyID uint) error {
+	db := database.GetDB()
+
+	var history models.RecordHistory
+	if err := db.First(&history, historyID).Error; err != nil {
+		return fmt.Errorf("历史记录不存在: %w", err)
 	}
 
-	// 提取所有密度值并排序
-	counts := make([]int, len(densities))
-	for i, d := range densities {
-		counts[i] = d.Count
+	queue := getHighlightQueue()
+	if queue.isPending(historyID) {
+		return fmt.Errorf("该历史记录的高能剪辑已在队列中")
 	}
-	sort.Ints(counts)
 
-	// 计算百分位数
-	index := int(float64(len(counts)) * percentile)
-	if index >= len(counts) {
-		index = len(counts) - 1
+	clips, err := s.ListClips(historyID)
+	if err != nil {
+		return err
+	}
+	for _, clip := range clips {
+		if err := s.DeleteClip(clip.ID); err != nil {
+			return err
+		}
+	}
+
+	return queue.add(historyID)
+}
+
+// highlightQueue 高能剪辑队列，ffmpeg剪辑逐个执行
+type highlightQueue struct {
+	mu      sync.Mutex
+	pending map[uint]bool
+	tasks   chan uint
+}
+
+var (
+	highlightQueueInstance *highlightQueue
+	highlightQueueOnce     sync.Once
+)
+
+func getHighlightQueue() *highlightQueue {
+	highlightQueueOnce.Do(func() {
+		// 服务重启前未完成的剪辑标记为失败
+		database.GetDB().Model(&models.HighlightClip{}).
+			Where("status = ?", HighlightStatusProcessing).
+			Updates(map[string]interface{}{"status": HighlightStatusFailed, "error_msg": "服务重启，剪辑中断"})
+
+		highlightQueueInstance = &highlightQueue{
+			pending: make(map[uint]bool),
+			tasks:   make(chan uint, 50),
+		}
+		go highlightQueueInstance.run()
+	})
+	return highlightQueueInstance
+}
+
+func (q *highlightQueue) add(historyID uint) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.pending[historyID] {
+		return nil
 	}
+	select {
+	case q.tasks <- historyID:
+		q.pending[historyID] = true
+		log.Printf("[高能剪辑] ➕ 添加任务: history_id=%d (队列长度: %d)", historyID, len(q.tasks))
+		return nil
+	default:
+		return fmt.Errorf("高能剪辑队列已满")
+	}
+}
+
+func (q *highlightQueue) isPending(historyID uint) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.pending[historyID]
+}
+
+func (q *highlightQueue) run() {
+	svc := NewHighEnergyCutService()
+	for historyID := range q.tasks {
+		log.Printf("[高能剪辑] 🎬 开始剪辑: history_id=%d", historyID)
+		if _, err := svc.GenerateClip(historyID); err != nil {
+			log.Printf("[高能剪辑] ❌ 剪辑失败 history_id=%d: %v", historyID, err)
+		}
 
-	return counts[index]
+		q.mu.Lock()
+		delete(q.pending, historyID)
+		q.mu.Unlock()
+	}
 }
 
 // TimeSegment 时间片段
 type TimeSegment struct {
-	Start int64 // 开始时间（毫秒）
-	End   int64 // 结束时间（毫秒）
+	Start int64 `json:"start"` // 开始时间（毫秒）
+	End   int64 `json:"end"`   // 结束时间（毫秒）
 }
 
-// identifyHighEnergySegments 识别高能片段
-func (s *HighEnergyCutService) identifyHighEnergySegments(densities []DanmakuDensity, threshold int, windowMs int) []TimeSegment {
+// identifyHighEnergySegments 将连续入选的窗口合并为高能片段
+func (s *HighEnergyCutService) identifyHighEnergySegments(windows []HighlightWindowScore) []TimeSegment {
 	var segments []TimeSegment
 	var currentSegment *TimeSegment
 
-	for _, d := range densities {
-		if d.Count >= threshold {
+	for _, w := range windows {
+		if w.Selected {
 			if currentSegment == nil {
-				currentSegment = &TimeSegment{Start: d.Timestamp}
+				currentSegment = &TimeSegment{Start: w.Start}
 			}
-			currentSegment.End = d.Timestamp + int64(windowMs)
+			currentSegment.End = w.End
 		} else {
 			if currentSegment != nil {
 				segments = append(segments, *currentSegment)
@@ -201,7 +460,9 @@ func (s *HighEnergyCutService) mergeNearSegments(segments []TimeSegment, gapMs i
 	for i := 1; i < len(segments); i++ {
 		if segments[i].Start-current.End <= gapMs {
 			// 合并
-			current.End = segments[i].End
+			if segments[i].End > current.End {
+				current.End = segments[i].End
+			}
 		} else {
 			merged = append(merged, current)
 			current = segments[i]
@@ -212,9 +473,26 @@ func (s *HighEnergyCutService) mergeNearSegments(segments []TimeSegment, gapMs i
 	return merged
 }
 
-// cutVideoSegments 使用ffmpeg剪辑视频片段
-func (s *HighEnergyCutService) cutVideoSegments(inputFile, outputFile string, segments []TimeSegment) error {
-	if len(segments) == 0 {
+// extendShortSegments 将短于最小时长的片段向两侧扩展到最小时长
+func (s *HighEnergyCutService) extendShortSegments(segments []TimeSegment, minMs int64) []TimeSegment {
+	for i := range segments {
+		seg := &segments[i]
+		if seg.End-seg.Start >= minMs {
+			continue
+		}
+		seg.Start -= (minMs - (seg.End - seg.Start)) / 2
+		if seg.Start < 0 {
+			seg.Start = 0
+		}
+		seg.End = seg.Start + minMs
+	}
+	return segments
+}
+
+// cutVideoSegments 使用ffmpeg从各分P文件剪辑片段并按顺序拼接
+// exact为false时直接复制流，起止位置对齐到关键帧；为true时重新编码以精确到帧
+func (s *HighEnergyCutService) cutVideoSegments(clips []partClip, outputFile string, exact bool) error {
+	if len(clips) == 0 {
 		return fmt.Errorf("没有片段可剪辑")
 	}
 
@@ -223,26 +501,39 @@ func (s *HighEnergyCutService) cutVideoSegments(inputFile, outputFile string, se
 		return fmt.Errorf("ffmpeg未安装或不在PATH中: %w", err)
 	}
 
-	// 为每个片段生成临时文件
+	// 为每个片段生成临时文件（以输出文件名为前缀，避免多个任务同时剪辑时冲突）
 	var tempFiles []string
-	tempDir := filepath.Dir(outputFile)
+	tempPrefix := strings.TrimSuffix(outputFile, filepath.Ext(outputFile))
 
-	for i, seg := range segments {
-		tempFile := filepath.Join(tempDir, fmt.Sprintf("temp_segment_%d.mp4", i))
+	for i, clip := range clips {
+		tempFile := fmt.Sprintf("%s.segment_%d.mp4", tempPrefix, i)
 		tempFiles = append(tempFiles, tempFile)
 
-		startSec := float64(seg.Start) / 1000.0
-		duration := float64(seg.End-seg.Start) / 1000.0
-
-		// ffmpeg命令：剪辑片段
-		args := []string{
-			"-i", inputFile,
-			"-ss", fmt.Sprintf("%.3f", startSec),
-			"-t", fmt.Sprintf("%.3f", duration),
-			"-c", "copy", // 快速复制，不重新编码
-			"-y",
-			tempFile,
+		startSec := fmt.Sprintf("%.3f", float64(clip.start)/1000.0)
+		duration := fmt.Sprintf("%.3f", float64(clip.end-clip.start)/1000.0)
+
+		var args []string
+		if exact {
+			// 输入前seek配合重新编码，起止时间精确到帧
+			args = []string{
+				"-ss", startSec,
+				"-i", clip.part.FilePath,
+				"-t", duration,
+				"-c:v", "libx264",
+				"-preset", "veryfast",
+				"-crf", "20",
+				"-c:a", "aac",
+				"-b:a", "192k",
+			}
+		} else {
+			args = []string{
+				"-i", clip.part.FilePath,
+				"-ss", startSec,
+				"-t", duration,
+				"-c", "copy", // 快速复制，不重新编码
+			}
 		}
+		args = append(args, "-avoid_negative_ts", "1", "-y", tempFile)
 
 		cmd := exec.Command("ffmpeg", args...)
 		if output, err := cmd.CombinedOutput(); err != nil {
@@ -280,14 +571,15 @@ func (s *HighEnergyCutService) concatenateVideos(inputFiles []string, outputFile
 	}
 
 	// 创建concat列表文件
-	concatFile := filepath.Join(filepath.Dir(outputFile), "concat_list.txt")
+	concatFile := outputFile + ".concat_list.txt"
 	f, err := os.Create(concatFile)
 	if err != nil {
 		return err
 	}
 
 	for _, inputFile := range inputFiles {
-		fmt.Fprintf(f, "file '%s'\n", inputFile)
+		// concat列表中单引号需要转义
+		fmt.Fprintf(f, "file '%s'\n", strings.ReplaceAll(inputFile, "'", `'\''`))
 	}
 	f.Close()
 	defer os.Remove(concatFile)
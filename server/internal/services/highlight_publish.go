@@ -0,0 +1,361 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gobup/server/internal/database"
+	"github.com/gobup/server/internal/models"
+	"gorm.io/gorm"
+)
+
+// 高能剪辑投稿方式（RecordRoom.HighlightPublish），同时用作 RecordHistory.HighlightArchive 的稿件类型
+const (
+	HighlightPublishOff     = 0 // 不投稿
+	HighlightPublishSession = 1 // 每场直播单独投稿
+	HighlightPublishWeekly  = 2 // 每周合集投稿
+)
+
+const (
+	// highlightSessionLookback 单场投稿只处理最近的直播，避免开启后把历史剪辑全部投稿
+	highlightSessionLookback = 7 * 24 * time.Hour
+
+	defaultHighlightSessionTitle = "【高能集锦】${uname} ${yyyy-MM-dd} ${title}"
+	defaultHighlightWeeklyTitle  = "【高能周报】${uname} ${weekRange}"
+	defaultHighlightSessionDesc  = "${uname} 本场直播高能片段\n完整录播: ${fullLinks}"
+	defaultHighlightWeeklyDesc   = "${uname} 本周直播高能片段合集\n完整录播:\n${fullLinks}"
+	defaultHighlightPartTitle    = "${yyyy-MM-dd} ${title}"
+)
+
+// HighlightPublishService 高能集锦投稿服务
+// 高能剪辑以派生的历史记录（每个剪辑一个分P）进入原有的上传队列和投稿流程
+type HighlightPublishService struct{}
+
+// NewHighlightPublishService 创建高能集锦投稿服务
+func NewHighlightPublishService() *HighlightPublishService {
+	return &HighlightPublishService{}
+}
+
+// HighlightArchiveRoom 返回高能集锦稿件实际使用的房间配置
+// 替换模板和投稿账号，并关闭只适用于完整录播的后续处理；普通历史记录原样返回
+func HighlightArchiveRoom(room *models.RecordRoom, history *models.RecordHistory) *models.RecordRoom {
+	if history.HighlightArchive == HighlightPublishOff {
+		return room
+	}
+
+	archiveRoom := *room
+	if room.HighlightUserID > 0 {
+		archiveRoom.UploadUserID = room.HighlightUserID
+	}
+
+	archiveRoom.TitleTemplate = room.HighlightTitleTpl
+	archiveRoom.DescTemplate = room.HighlightDescTpl
+	if archiveRoom.TitleTemplate == "" {
+		archiveRoom.TitleTemplate = defaultHighlightSessionTitle
+		if history.HighlightArchive == HighlightPublishWeekly {
+			archiveRoom.TitleTemplate = defaultHighlightWeeklyTitle
+		}
+	}
+	if archiveRoom.DescTemplate == "" {
+		archiveRoom.DescTemplate = defaultHighlightSessionDesc
+		if history.HighlightArchive == HighlightPublishWeekly {
+			archiveRoom.DescTemplate = defaultHighlightWeeklyDesc
+		}
+	}
+	if room.HighlightTags != "" {
+		archiveRoom.Tags = room.HighlightTags
+	}
+	archiveRoom.PartTitleTemplate = defaultHighlightPartTitle
+	archiveRoom.DynamicTemplate = ""
+
+	// 剪辑已经很短，不再按录播的时长/大小限制过滤或合并
+	archiveRoom.AutoPublish = true
+	archiveRoom.FileSizeLimit = 0
+	archiveRoom.DurationLimit = 0
+	archiveRoom.MinTotalDuration = 0
+	archiveRoom.MergeFragments = false
+
+	// 剪辑文件由高能剪辑管理，不参与录播的文件处理、合集、弹幕和二次剪辑
+	archiveRoom.DeleteType = 0
+	archiveRoom.SeasonID = 0
+	archiveRoom.CollectionID = 0
+	archiveRoom.DanmakuBurnIn = false
+	archiveRoom.HighEnergyCut = false
+	archiveRoom.AutoSendDanmaku = false
+	archiveRoom.SendDm = false
+	return &archiveRoom
+}
+
+// PrepareArchives 为开启高能投稿的房间创建待上传的高能集锦稿件
+func (s *HighlightPublishService) PrepareArchives(now time.Time) error {
+	db := database.GetDB()
+
+	var rooms []models.RecordRoom
+	if err := db.Where("upload = ? AND highlight_publish > ?", true, HighlightPublishOff).Find(&rooms).Error; err != nil {
+		return err
+	}
+
+	for i := range rooms {
+		room := &rooms[i]
+		var err error
+		switch room.HighlightPublish {
+		case HighlightPublishSession:
+			err = s.prepareSessionArchives(room, now)
+		case HighlightPublishWeekly:
+			err = s.prepareWeeklyArchive(room, now)
+		}
+		if err != nil {
+			log.Printf("[高能投稿] 房间 %s 创建高能集锦失败: %v", room.RoomID, err)
+		}
+	}
+	return nil
+}
+
+// prepareSessionArchives 每场直播的高能剪辑单独成稿，等待完整录播投稿后再处理以便附上链接
+func (s *HighlightPublishService) prepareSessionArchives(room *models.RecordRoom, now time.Time) error {
+	clips, sources, err := s.pendingClips(room.RoomID, now.Add(-highlightSessionLookback), now)
+	if err != nil {
+		return err
+	}
+
+	for _, clip := range clips {
+		source := sources[clip.HistoryID]
+		if source.BvID == "" {
+			continue
+		}
+		archive := models.RecordHistory{
+			RoomID:           room.RoomID,
+			SessionID:        fmt.Sprintf("highlight-%d", clip.ID),
+			Uname:            source.Uname,
+			Title:            source.Title,
+			AreaName:         source.AreaName,
+			CoverURL:         source.CoverURL,
+			HighlightArchive: HighlightPublishSession,
+		}
+		if err := s.createArchive(&archive, []models.HighlightClip{clip}, sources); err != nil {
+			log.Printf("[高能投稿] 创建单场高能集锦失败 clip_id=%d: %v", clip.ID, err)
+			continue
+		}
+		log.Printf("[高能投稿] 已创建单场高能集锦: history_id=%d, 来源=%d", archive.ID, source.ID)
+	}
+	return nil
+}
+
+// prepareWeeklyArchive 上一自然周（周一至周日）的高能剪辑合为一个稿件，每场一个分P
+func (s *HighlightPublishService) prepareWeeklyArchive(room *models.RecordRoom, now time.Time) error {
+	to := highlightWeekStart(now)
+	from := to.AddDate(0, 0, -7)
+
+	sessionID := fmt.Sprintf("highlight-week-%s-%s", room.RoomID, from.Format("20060102"))
+	var count int64
+	database.GetDB().Unscoped().Model(&models.RecordHistory{}).Where("session_id = ?", sessionID).Count(&count)
+	if count > 0 {
+		return nil
+	}
+
+	clips, sources, err := s.pendingClips(room.RoomID, from, to)
+	if err != nil || len(clips) == 0 {
+		return err
+	}
+
+	first := sources[clips[0].HistoryID]
+	archive := models.RecordHistory{
+		RoomID:           room.RoomID,
+		SessionID:        sessionID,
+		Uname:            first.Uname,
+		Title:            "高能周报 " + highlightWeekRange(from),
+		AreaName:         first.AreaName,
+		CoverURL:         first.CoverURL,
+		HighlightArchive: HighlightPublishWeekly,
+	}
+	if err := s.createArchive(&archive, clips, sources); err != nil {
+		return err
+	}
+	log.Printf("[高能投稿] 已创建高能周报: history_id=%d, 剪辑数=%d", archive.ID, len(clips))
+	return nil
+}
+
+// pendingClips 查询房间内指定时间段直播的未投稿高能剪辑，每场直播只取最新的一个
+// 同一场直播已有剪辑被投稿过（包括重新生成的情况）时不再重复投稿
+func (s *HighlightPublishService) pendingClips(roomID string, from, to time.Time) ([]models.HighlightClip, map[uint]models.RecordHistory, error) {
+	db := database.GetDB()
+
+	var histories []models.RecordHistory
+	if err := db.Where("room_id = ? AND highlight_archive = ? AND start_time >= ? AND start_time < ?", roomID, HighlightPublishOff, from, to).
+		Where("id NOT IN (?)", db.Model(&models.HighlightClip{}).Select("history_id").Where("archive_id > ?", 0)).
+		Order("start_time ASC").
+		Find(&histories).Error; err != nil {
+		return nil, nil, err
+	}
+
+	var clips []models.HighlightClip
+	sources := make(map[uint]models.RecordHistory, len(histories))
+	for _, history := range histories {
+		var clip models.HighlightClip
+		if err := db.Where("history_id = ? AND status = ?", history.ID, HighlightStatusCompleted).
+			Order("created_at DESC").
+			First(&clip).Error; err != nil {
+			continue
+		}
+		clips = append(clips, clip)
+		sources[history.ID] = history
+	}
+	return clips, sources, nil
+}
+
+// createArchive 创建高能集锦历史记录，每个剪辑一个分P，并标记剪辑所属稿件
+func (s *HighlightPublishService) createArchive(archive *models.RecordHistory, clips []models.HighlightClip, sources map[uint]models.RecordHistory) error {
+	var parts []models.RecordHistoryPart
+	for _, clip := range clips {
+		fileInfo, err := os.Stat(clip.FilePath)
+		if err != nil {
+			log.Printf("[高能投稿] ⚠️  剪辑文件不存在，跳过: %s", clip.FilePath)
+			continue
+		}
+		source := sources[clip.HistoryID]
+		duration := int(math.Round(clip.Duration))
+		parts = append(parts, models.RecordHistoryPart{
+			RoomID:    archive.RoomID,
+			SessionID: archive.SessionID,
+			Title:     source.Title,
+			LiveTitle: source.Title,
+			AreaName:  source.AreaName,
+			FilePath:  clip.FilePath,
+			FileName:  filepath.Base(clip.FilePath),
+			FileSize:  fileInfo.Size(),
+			Duration:  duration,
+			StartTime: source.StartTime,
+			EndTime:   source.StartTime.Add(time.Duration(duration) * time.Second),
+		})
+	}
+	if len(parts) == 0 {
+		return fmt.Errorf("没有可投稿的剪辑文件")
+	}
+
+	archive.StartTime = parts[0].StartTime
+	archive.EndTime = parts[len(parts)-1].EndTime
+	archive.Upload = true
+	archive.Message = "等待上传高能集锦"
+
+	return database.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(archive).Error; err != nil {
+			return err
+		}
+		for i := range parts {
+			parts[i].HistoryID = archive.ID
+			if err := tx.Create(&parts[i]).Error; err != nil {
+				return err
+			}
+		}
+		clipIDs := make([]uint, len(clips))
+		for i, clip := range clips {
+			clipIDs[i] = clip.ID
+		}
+		return tx.Model(&models.HighlightClip{}).Where("id IN ?", clipIDs).Update("archive_id", archive.ID).Error
+	})
+}
+
+// PendingUploads 获取高能集锦稿件中待上传的分P，房间配置已替换为高能稿件使用的配置
+func (s *HighlightPublishService) PendingUploads() ([]PendingUploadTask, error) {
+	db := database.GetDB()
+
+	var histories []models.RecordHistory
+	if err := db.Where("highlight_archive > ? AND publish = ?", HighlightPublishOff, false).Find(&histories).Error; err != nil {
+		return nil, err
+	}
+
+	var tasks []PendingUploadTask
+	for _, history := range histories {
+		var room models.RecordRoom
+		if err := db.Where("room_id = ?", history.RoomID).First(&room).Error; err != nil || !room.Upload {
+			continue
+		}
+		archiveRoom := HighlightArchiveRoom(&room, &history)
+		if archiveRoom.UploadUserID == 0 {
+			continue
+		}
+
+		var parts []models.RecordHistoryPart
+		db.Where("history_id = ? AND upload = ? AND uploading = ? AND excluded = ? AND file_delete = ?", history.ID, false, false, false, false).
+			Order("start_time ASC").
+			Find(&parts)
+		for _, part := range parts {
+			if part.RateLimitCooldownAt != nil && time.Now().Before(*part.RateLimitCooldownAt) {
+				continue
+			}
+			tasks = append(tasks, PendingUploadTask{Part: part, History: history, Room: *archiveRoom})
+		}
+	}
+	return tasks, nil
+}
+
+// AddTemplateData 为高能集锦稿件添加模板变量：完整录播链接、周范围和剪辑数
+func (s *HighlightPublishService) AddTemplateData(data map[string]interface{}, history *models.RecordHistory) {
+	db := database.GetDB()
+
+	var clips []models.HighlightClip
+	db.Where("archive_id = ?", history.ID).Find(&clips)
+
+	var sourceIDs []uint
+	for _, clip := range clips {
+		sourceIDs = append(sourceIDs, clip.HistoryID)
+	}
+	var sources []models.RecordHistory
+	if len(sourceIDs) > 0 {
+		db.Where("id IN ?", sourceIDs).Order("start_time ASC").Find(&sources)
+	}
+
+	var links []string
+	fullBvid := ""
+	for _, source := range sources {
+		if source.BvID == "" {
+			continue
+		}
+		url := "https://www.bilibili.com/video/" + source.BvID
+		if fullBvid == "" {
+			fullBvid = source.BvID
+			data["fullUrl"] = url
+		}
+		if history.HighlightArchive == HighlightPublishWeekly {
+			links = append(links, fmt.Sprintf("%s %s %s", source.StartTime.Format("01-02"), source.Title, url))
+		} else {
+			links = append(links, url)
+		}
+	}
+
+	data["fullBvid"] = fullBvid
+	data["fullLinks"] = strings.Join(links, "\n")
+	data["weekRange"] = highlightWeekRange(highlightWeekStart(history.StartTime))
+	data["clipCount"] = len(clips)
+
+	// 单场集锦沿用原直播的收益统计
+	if len(sources) == 1 {
+		NewRevenueService().AddTemplateData(data, sources[0].SessionID)
+	}
+}
+
+// EnsureFullLink 简介模板中未包含完整录播链接时追加到末尾
+func (s *HighlightPublishService) EnsureFullLink(desc string, data map[string]interface{}) string {
+	fullBvid, _ := data["fullBvid"].(string)
+	fullLinks, _ := data["fullLinks"].(string)
+	if fullBvid == "" || strings.Contains(desc, fullBvid) {
+		return desc
+	}
+	return strings.TrimRight(desc, "\n") + "\n\n完整录播: " + fullLinks
+}
+
+// highlightWeekStart 返回所在自然周周一零点
+func highlightWeekStart(t time.Time) time.Time {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+}
+
+// highlightWeekRange 格式化周范围，如 2024.01.01-01.07
+func highlightWeekRange(weekStart time.Time) string {
+	return weekStart.Format("2006.01.02") + "-" + weekStart.AddDate(0, 0, 6).Format("01.02")
+}
@@ -0,0 +1,373 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gobup/server/internal/database"
+	"github.com/gobup/server/internal/models"
+)
+
+// 高能片段选择策略
+const (
+	HighlightStrategyPercentile = "percentile" // 得分不低于百分位阈值（默认，沿用原有逻辑）
+	HighlightStrategyTopN       = "topN"       // 得分最高的N个窗口
+	HighlightStrategyZScore     = "zscore"     // 得分高于均值+K倍标准差
+	HighlightStrategyAbsolute   = "absolute"   // 得分不低于固定值
+)
+
+// defaultHighlightKeywords 默认的高能关键词
+var defaultHighlightKeywords = []string{"草", "？？？", "???", "哈哈哈", "233", "awsl", "xswl", "卧槽", "666", "牛"}
+
+// HighlightScoringConfig 高能评分模型，保存在房间配置中（JSON），未设置的权重使用默认值
+// 弹幕数、独立发言人数、关键词弹幕数按相对全场均值的倍数计分；SC/上舰/礼物按金额（元）计分；复读比例为0-1
+type HighlightScoringConfig struct {
+	DanmakuWeight float64  `json:"danmakuWeight"`
+	UniqueWeight  float64  `json:"uniqueWeight"`
+	KeywordWeight float64  `json:"keywordWeight"`
+	Keywords      []string `json:"keywords"`
+	RepeatWeight  float64  `json:"repeatWeight"`
+	SCWeight      float64  `json:"scWeight"`    // 每元SC
+	GuardWeight   float64  `json:"guardWeight"` // 每元上舰
+	GiftWeight    float64  `json:"giftWeight"`  // 每元礼物
+
+	Strategy   string  `json:"strategy"`
+	Percentile float64 `json:"percentile"` // percentile策略，为0时使用房间的阈值百分位
+	TopN       int     `json:"topN"`
+	ZScore     float64 `json:"zScore"`
+	MinScore   float64 `json:"minScore"`
+}
+
+// DefaultHighlightScoringConfig 默认评分模型
+func DefaultHighlightScoringConfig() HighlightScoringConfig {
+	return HighlightScoringConfig{
+		DanmakuWeight: 1,
+		UniqueWeight:  0.5,
+		KeywordWeight: 0.5,
+		Keywords:      defaultHighlightKeywords,
+		RepeatWeight:  0.5,
+		SCWeight:      0.02,
+		GuardWeight:   0.005,
+		GiftWeight:    0.02,
+		Strategy:      HighlightStrategyPercentile,
+		TopN:          10,
+		ZScore:        2,
+	}
+}
+
+// ParseHighlightScoringConfig 解析房间保存的评分模型，JSON中未出现的字段保留默认值
+func ParseHighlightScoringConfig(raw string) (HighlightScoringConfig, error) {
+	config := DefaultHighlightScoringConfig()
+	if strings.TrimSpace(raw) == "" {
+		return config, nil
+	}
+	if err := json.Unmarshal([]byte(raw), &config); err != nil {
+		return config, fmt.Errorf("高能评分配置格式错误: %w", err)
+	}
+	switch config.Strategy {
+	case "":
+		config.Strategy = HighlightStrategyPercentile
+	case HighlightStrategyPercentile, HighlightStrategyTopN, HighlightStrategyZScore, HighlightStrategyAbsolute:
+	default:
+		return config, fmt.Errorf("不支持的高能片段选择策略 %q", config.Strategy)
+	}
+	return config, nil
+}
+
+// HighlightScoreReason 得分构成
+type HighlightScoreReason struct {
+	Feature string  `json:"feature"` // danmaku/unique/keyword/repeat/sc/guard/gift
+	Value   float64 `json:"value"`
+	Score   float64 `json:"score"`
+	Detail  string  `json:"detail"`
+}
+
+// HighlightWindowScore 单个时间窗口的得分
+type HighlightWindowScore struct {
+	Start          int64                  `json:"start"` // 相对直播开始（毫秒）
+	End            int64                  `json:"end"`
+	Score          float64                `json:"score"`
+	Selected       bool                   `json:"selected"`
+	Danmaku        int                    `json:"danmaku"`
+	UniqueChatters int                    `json:"uniqueChatters"`
+	KeywordHits    int                    `json:"keywordHits"`
+	RepeatRatio    float64                `json:"repeatRatio"`
+	SCAmount       float64                `json:"scAmount"`
+	GuardAmount    float64                `json:"guardAmount"`
+	GiftAmount     float64                `json:"giftAmount"`
+	Reasons        []HighlightScoreReason `json:"reasons"`
+	Explanation    string                 `json:"explanation"`
+}
+
+// HighlightScoreResult 全场窗口得分
+type HighlightScoreResult struct {
+	HistoryID uint                   `json:"historyId"`
+	WindowMs  int                    `json:"windowMs"`
+	Strategy  string                 `json:"strategy"`
+	Threshold float64                `json:"threshold"`
+	Config    HighlightScoringConfig `json:"config"`
+	Windows   []HighlightWindowScore `json:"windows"`
+}
+
+// ScoreHistory 按房间的评分模型计算每个窗口的高能得分并标记入选窗口
+func (s *HighEnergyCutService) ScoreHistory(historyID uint) (*HighlightScoreResult, error) {
+	db := database.GetDB()
+
+	var history models.RecordHistory
+	if err := db.First(&history, historyID).Error; err != nil {
+		return nil, fmt.Errorf("历史记录不存在: %w", err)
+	}
+	var room models.RecordRoom
+	if err := db.Where("room_id = ?", history.RoomID).First(&room).Error; err != nil {
+		return nil, fmt.Errorf("房间配置不存在: %w", err)
+	}
+	return s.scoreHistory(&history, &room)
+}
+
+func (s *HighEnergyCutService) scoreHistory(history *models.RecordHistory, room *models.RecordRoom) (*HighlightScoreResult, error) {
+	db := database.GetDB()
+
+	config, err := ParseHighlightScoringConfig(room.HighlightScoring)
+	if err != nil {
+		return nil, err
+	}
+	params := highlightParamsForRoom(room)
+	if config.Percentile > 1 {
+		config.Percentile /= 100
+	}
+	if config.Percentile <= 0 || config.Percentile > 1 {
+		config.Percentile = params.percentile
+	}
+
+	var danmakus []models.LiveMsg
+	if err := danmakuOnly(db, history.SessionID).
+		Select("timestamp, message, uid, user_name").
+		Order("timestamp ASC").
+		Find(&danmakus).Error; err != nil {
+		return nil, fmt.Errorf("查询弹幕失败: %w", err)
+	}
+	if len(danmakus) == 0 {
+		return nil, fmt.Errorf("没有弹幕数据")
+	}
+
+	var events []models.LiveEvent
+	db.Where("session_id = ?", history.SessionID).Order("timestamp ASC").Find(&events)
+
+	windows := scoreHighlightWindows(danmakus, events, params.windowMs, config)
+	threshold := selectHighlightWindows(windows, config)
+
+	return &HighlightScoreResult{
+		HistoryID: history.ID,
+		WindowMs:  params.windowMs,
+		Strategy:  config.Strategy,
+		Threshold: threshold,
+		Config:    config,
+		Windows:   windows,
+	}, nil
+}
+
+// scoreHighlightWindows 以50%重叠的滑动窗口统计各项指标并计分，弹幕和事件需按时间排序
+func scoreHighlightWindows(danmakus []models.LiveMsg, events []models.LiveEvent, windowMs int, config HighlightScoringConfig) []HighlightWindowScore {
+	step := int64(windowMs / 2)
+	if step <= 0 {
+		step = 1
+	}
+	minTime := danmakus[0].Timestamp
+	maxTime := danmakus[len(danmakus)-1].Timestamp
+
+	isKeyword := make([]bool, len(danmakus))
+	for i, dm := range danmakus {
+		for _, kw := range config.Keywords {
+			if kw != "" && strings.Contains(dm.Message, kw) {
+				isKeyword[i] = true
+				break
+			}
+		}
+	}
+
+	// 窗口两端单调前进，增量维护发言用户和重复内容计数
+	chatters := make(map[string]int)
+	messages := make(map[string]int)
+	keywordHits := 0
+	add := func(i int) {
+		if key := highlightChatterKey(&danmakus[i]); key != "" {
+			chatters[key]++
+		}
+		messages[collapseRepeatedRunes(strings.TrimSpace(danmakus[i].Message), 3)]++
+		if isKeyword[i] {
+			keywordHits++
+		}
+	}
+	remove := func(i int) {
+		if key := highlightChatterKey(&danmakus[i]); key != "" {
+			if chatters[key]--; chatters[key] <= 0 {
+				delete(chatters, key)
+			}
+		}
+		msg := collapseRepeatedRunes(strings.TrimSpace(danmakus[i].Message), 3)
+		if messages[msg]--; messages[msg] <= 0 {
+			delete(messages, msg)
+		}
+		if isKeyword[i] {
+			keywordHits--
+		}
+	}
+
+	var windows []HighlightWindowScore
+	left, right := 0, 0
+	evLeft, evRight := 0, 0
+	for t := minTime; t <= maxTime; t += step {
+		end := t + int64(windowMs)
+		for right < len(danmakus) && danmakus[right].Timestamp < end {
+			add(right)
+			right++
+		}
+		for left < right && danmakus[left].Timestamp < t {
+			remove(left)
+			left++
+		}
+		for evRight < len(events) && events[evRight].Timestamp < end {
+			evRight++
+		}
+		for evLeft < evRight && events[evLeft].Timestamp < t {
+			evLeft++
+		}
+
+		w := HighlightWindowScore{
+			Start:          t,
+			End:            end,
+			Danmaku:        right - left,
+			UniqueChatters: len(chatters),
+			KeywordHits:    keywordHits,
+		}
+		if w.Danmaku > 0 {
+			w.RepeatRatio = float64(w.Danmaku-len(messages)) / float64(w.Danmaku)
+		}
+		for _, ev := range events[evLeft:evRight] {
+			switch ev.Type {
+			case "sc":
+				w.SCAmount += ev.Price
+			case "guard":
+				w.GuardAmount += ev.Price
+			case "gift":
+				w.GiftAmount += ev.Price
+			}
+		}
+		windows = append(windows, w)
+	}
+
+	// 弹幕数、发言人数、关键词按相对全场均值的倍数计分，体现“突增”
+	var sumDanmaku, sumUnique, sumKeyword float64
+	for _, w := range windows {
+		sumDanmaku += float64(w.Danmaku)
+		sumUnique += float64(w.UniqueChatters)
+		sumKeyword += float64(w.KeywordHits)
+	}
+	n := float64(len(windows))
+	meanDanmaku := math.Max(sumDanmaku/n, 1)
+	meanUnique := math.Max(sumUnique/n, 1)
+	meanKeyword := math.Max(sumKeyword/n, 1)
+
+	for i := range windows {
+		w := &windows[i]
+		w.Reasons = []HighlightScoreReason{}
+		addReason := func(feature string, value, score float64, detail string) {
+			if score == 0 {
+				return
+			}
+			w.Reasons = append(w.Reasons, HighlightScoreReason{Feature: feature, Value: value, Score: roundScore(score), Detail: detail})
+			w.Score += score
+		}
+
+		ratio := float64(w.Danmaku) / meanDanmaku
+		addReason("danmaku", float64(w.Danmaku), config.DanmakuWeight*ratio,
+			fmt.Sprintf("弹幕%d条(均值%.1f倍)", w.Danmaku, ratio))
+		ratio = float64(w.UniqueChatters) / meanUnique
+		addReason("unique", float64(w.UniqueChatters), config.UniqueWeight*ratio,
+			fmt.Sprintf("发言%d人(均值%.1f倍)", w.UniqueChatters, ratio))
+		ratio = float64(w.KeywordHits) / meanKeyword
+		addReason("keyword", float64(w.KeywordHits), config.KeywordWeight*ratio,
+			fmt.Sprintf("关键词弹幕%d条(均值%.1f倍)", w.KeywordHits, ratio))
+		addReason("repeat", w.RepeatRatio, config.RepeatWeight*w.RepeatRatio,
+			fmt.Sprintf("复读比例%.0f%%", w.RepeatRatio*100))
+		addReason("sc", w.SCAmount, config.SCWeight*w.SCAmount, fmt.Sprintf("SC %.0f元", w.SCAmount))
+		addReason("guard", w.GuardAmount, config.GuardWeight*w.GuardAmount, fmt.Sprintf("上舰 %.0f元", w.GuardAmount))
+		addReason("gift", w.GiftAmount, config.GiftWeight*w.GiftAmount, fmt.Sprintf("礼物 %.1f元", w.GiftAmount))
+
+		w.Score = roundScore(w.Score)
+		sort.SliceStable(w.Reasons, func(a, b int) bool { return w.Reasons[a].Score > w.Reasons[b].Score })
+		parts := make([]string, 0, len(w.Reasons))
+		for _, r := range w.Reasons {
+			parts = append(parts, fmt.Sprintf("%s +%s", r.Detail, strconv.FormatFloat(r.Score, 'f', -1, 64)))
+		}
+		w.Explanation = strings.Join(parts, "，")
+	}
+
+	return windows
+}
+
+// selectHighlightWindows 按选择策略计算阈值并标记入选窗口，返回阈值
+func selectHighlightWindows(windows []HighlightWindowScore, config HighlightScoringConfig) float64 {
+	if len(windows) == 0 {
+		return 0
+	}
+	scores := make([]float64, len(windows))
+	for i, w := range windows {
+		scores[i] = w.Score
+	}
+	sort.Float64s(scores)
+
+	var threshold float64
+	switch config.Strategy {
+	case HighlightStrategyTopN:
+		topN := config.TopN
+		if topN <= 0 {
+			topN = 1
+		}
+		if topN > len(scores) {
+			topN = len(scores)
+		}
+		threshold = scores[len(scores)-topN]
+	case HighlightStrategyZScore:
+		var sum, sq float64
+		for _, v := range scores {
+			sum += v
+		}
+		mean := sum / float64(len(scores))
+		for _, v := range scores {
+			sq += (v - mean) * (v - mean)
+		}
+		threshold = mean + config.ZScore*math.Sqrt(sq/float64(len(scores)))
+	case HighlightStrategyAbsolute:
+		threshold = config.MinScore
+	default:
+		index := int(float64(len(scores)) * config.Percentile)
+		if index >= len(scores) {
+			index = len(scores) - 1
+		}
+		threshold = scores[index]
+	}
+	threshold = roundScore(threshold)
+
+	for i := range windows {
+		// 没有任何指标的窗口不入选，避免阈值为0时全场入选
+		windows[i].Selected = windows[i].Score > 0 && windows[i].Score >= threshold
+	}
+	return threshold
+}
+
+// highlightChatterKey 用户标识，有UID时按UID区分，否则按用户名
+func highlightChatterKey(dm *models.LiveMsg) string {
+	if dm.UID != 0 {
+		return strconv.FormatInt(dm.UID, 10)
+	}
+	return dm.UserName
+}
+
+func roundScore(v float64) float64 {
+	return math.Round(v*100) / 100
+}
@@ -0,0 +1,375 @@
+package services
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gobup/server/internal/bili"
+	"github.com/gobup/server/internal/database"
+	"github.com/gobup/server/internal/models"
+)
+
+// LiveMonitorService 通过直播信息流实时感知开播、下播和直播间信息变更，定时轮询作为兜底
+type LiveMonitorService struct {
+	mu        sync.Mutex
+	conns     map[string]*liveRoomConn // key: RecordRoom.RoomID
+	statusSvc *LiveStatusService
+}
+
+// liveRoomConn 单个房间的信息流订阅
+type liveRoomConn struct {
+	realRoomID int64
+	client     *bili.LiveWSClient
+	connected  int // 认证成功的次数，用于区分首次连接和断线重连
+}
+
+var (
+	liveMonitorService     *LiveMonitorService
+	liveMonitorServiceOnce sync.Once
+)
+
+// GetLiveMonitorService 获取直播信息流监控服务（单例）
+func GetLiveMonitorService() *LiveMonitorService {
+	liveMonitorServiceOnce.Do(func() {
+		liveMonitorService = &LiveMonitorService{
+			conns:     make(map[string]*liveRoomConn),
+			statusSvc: NewLiveStatusService(),
+		}
+	})
+	return liveMonitorService
+}
+
+// liveMonitorEnabled 读取系统配置中的直播信息流开关，读取失败时默认启用
+func liveMonitorEnabled() bool {
+	var config models.SystemConfig
+	if err := database.GetDB().First(&config).Error; err != nil {
+		return true
+	}
+	return config.EnableLiveMonitor
+}
+
+// SyncRooms 按当前房间列表增减订阅：开启上传的房间保持连接，删除或关闭上传的房间断开连接
+// 同时按开播状态开始或结束内置弹幕录制和直播录制
+func (s *LiveMonitorService) SyncRooms() {
+	db := database.GetDB()
+
+	// 直播录制不依赖信息流，关闭信息流时也需要同步
+	var rooms []models.RecordRoom
+	if err := db.Where("upload = ?", true).Find(&rooms).Error; err != nil {
+		log.Printf("[直播信息流] 查询房间列表失败: %v", err)
+		return
+	}
+
+	enabled := liveMonitorEnabled()
+	roomSet := make(map[string]bool, len(rooms))
+	wanted := make(map[string]bool, len(rooms))
+	for i := range rooms {
+		roomSet[rooms[i].RoomID] = true
+		wanted[rooms[i].RoomID] = enabled
+	}
+
+	s.mu.Lock()
+	var stale []*liveRoomConn
+	for roomID, conn := range s.conns {
+		if !wanted[roomID] {
+			stale = append(stale, conn)
+			delete(s.conns, roomID)
+			log.Printf("[直播信息流] 取消订阅房间 %s", roomID)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, conn := range stale {
+		conn.client.Stop()
+	}
+
+	if enabled {
+		for i := range rooms {
+			// 新订阅需要查询真实房间号，避免请求过快
+			if s.subscribe(&rooms[i]) && i < len(rooms)-1 {
+				time.Sleep(500 * time.Millisecond)
+			}
+		}
+	}
+
+	// 取消订阅或删除的房间无法继续录制，停止录制但保留录制状态，直播结束后由FinishStaleLiveSessions收尾
+	danmakuRecorder := GetDanmakuRecorderService()
+	for _, roomID := range danmakuRecorder.RoomIDs() {
+		if !wanted[roomID] {
+			danmakuRecorder.Stop(roomID, true)
+		}
+	}
+	streamRecorder := GetStreamRecorderService()
+	for _, roomID := range streamRecorder.RoomIDs() {
+		if !roomSet[roomID] {
+			streamRecorder.Stop(roomID, true)
+		}
+	}
+	for i := range rooms {
+		s.syncRecorders(&rooms[i])
+	}
+
+	var streaming []string
+	db.Model(&models.RecordRoom{}).Where("streaming = ?", true).Pluck("room_id", &streaming)
+	streamingSet := make(map[string]bool, len(streaming))
+	for _, roomID := range streaming {
+		streamingSet[roomID] = true
+	}
+	FinishStaleLiveSessions(streamingSet)
+}
+
+// syncRecorders 按房间配置和开播状态开始或结束内置弹幕录制和直播录制
+// 先停止弹幕录制，由直播录制写完最后一个文件后结束历史记录
+func (s *LiveMonitorService) syncRecorders(room *models.RecordRoom) {
+	s.mu.Lock()
+	_, subscribed := s.conns[room.RoomID]
+	s.mu.Unlock()
+
+	danmakuRecorder := GetDanmakuRecorderService()
+	want := room.RecordDanmaku && room.Streaming && subscribed
+	recording := danmakuRecorder.Recording(room.RoomID)
+	if want && !recording {
+		if err := danmakuRecorder.Start(room); err != nil {
+			log.Printf("[弹幕录制] 房间 %s 开始录制失败: %v", room.RoomID, err)
+		}
+	} else if !want && recording {
+		danmakuRecorder.Stop(room.RoomID, room.Streaming)
+	}
+
+	streamRecorder := GetStreamRecorderService()
+	want = room.StreamRecord && room.Streaming
+	recording = streamRecorder.Recording(room.RoomID)
+	if want && !recording {
+		if err := streamRecorder.Start(room); err != nil {
+			log.Printf("[直播录制] 房间 %s 开始录制失败: %v", room.RoomID, err)
+		}
+	} else if !want && recording {
+		streamRecorder.Stop(room.RoomID, room.Streaming)
+	}
+}
+
+// subscribe 订阅单个房间，已订阅时跳过，返回是否请求了直播间信息
+func (s *LiveMonitorService) subscribe(room *models.RecordRoom) bool {
+	s.mu.Lock()
+	_, exists := s.conns[room.RoomID]
+	s.mu.Unlock()
+	if exists {
+		return false
+	}
+
+	// 信息流只接受真实房间号，短号需要先换算
+	info, err := s.statusSvc.GetRoomInfo(room.RoomID)
+	if err != nil || info.Data.RoomID == 0 {
+		log.Printf("[直播信息流] 房间 %s 获取真实房间号失败，等待下次同步: %v", room.RoomID, err)
+		return true
+	}
+
+	roomID := room.RoomID
+	client := bili.NewLiveWSClient(info.Data.RoomID)
+	s.applyCredentials(client, room.UploadUserID)
+
+	conn := &liveRoomConn{realRoomID: info.Data.RoomID, client: client}
+	client.OnCommand = func(cmd string, body []byte) {
+		s.dispatchCommand(roomID, cmd, body)
+	}
+	client.OnPopularity = func(popularity uint32) {
+		s.statusSvc.trackPeakOnline(roomID, int64(popularity))
+	}
+	client.OnConnected = func() {
+		s.mu.Lock()
+		conn.connected++
+		reconnected := conn.connected > 1
+		s.mu.Unlock()
+		// 断线期间可能错过了开播/下播消息，重连后主动查询一次
+		if reconnected {
+			go s.refresh(roomID)
+		}
+	}
+
+	s.mu.Lock()
+	if _, exists := s.conns[roomID]; exists {
+		s.mu.Unlock()
+		return true
+	}
+	s.conns[roomID] = conn
+	s.mu.Unlock()
+
+	client.Start()
+	log.Printf("[直播信息流] 订阅房间 %s (真实房间号 %d)", roomID, info.Data.RoomID)
+	return true
+}
+
+// applyCredentials 使用房间上传账号的登录信息连接，游客连接时弹幕用户名会被隐藏
+func (s *LiveMonitorService) applyCredentials(client *bili.LiveWSClient, userID uint) {
+	user := loginUser(userID)
+	if user == nil {
+		return
+	}
+	client.UID = user.UID
+	client.Cookie = user.Cookies
+	client.Buvid = bili.GetCookieValue(user.Cookies, "buvid3")
+}
+
+// loginUser 获取已登录的账号，未配置或未登录时返回nil
+func loginUser(userID uint) *models.BiliBiliUser {
+	if userID == 0 {
+		return nil
+	}
+	var user models.BiliBiliUser
+	if err := database.GetDB().First(&user, userID).Error; err != nil || !user.Login || user.Cookies == "" {
+		return nil
+	}
+	return &user
+}
+
+// StopAll 断开所有房间的信息流连接
+func (s *LiveMonitorService) StopAll() {
+	s.mu.Lock()
+	conns := s.conns
+	s.conns = make(map[string]*liveRoomConn)
+	s.mu.Unlock()
+
+	for _, conn := range conns {
+		conn.client.Stop()
+	}
+	GetDanmakuRecorderService().StopAll()
+	GetStreamRecorderService().StopAll()
+	if len(conns) > 0 {
+		log.Printf("[直播信息流] 已断开 %d 个房间的连接", len(conns))
+	}
+}
+
+// dispatchCommand 分发通知消息：直播状态消息由监控处理，弹幕和付费消息交给内置弹幕录制
+func (s *LiveMonitorService) dispatchCommand(roomID, cmd string, body []byte) {
+	s.handleCommand(roomID, cmd, body)
+	GetDanmakuRecorderService().HandleCommand(roomID, cmd, body)
+}
+
+// handleCommand 处理与直播状态相关的通知消息
+func (s *LiveMonitorService) handleCommand(roomID, cmd string, body []byte) {
+	switch cmd {
+	case "LIVE":
+		s.onLive(roomID)
+	case "PREPARING":
+		var msg struct {
+			Round int `json:"round"`
+		}
+		json.Unmarshal(body, &msg)
+		s.onPreparing(roomID, msg.Round == 1)
+	case "ROOM_CHANGE":
+		var msg struct {
+			Data struct {
+				Title          string `json:"title"`
+				AreaName       string `json:"area_name"`
+				ParentAreaName string `json:"parent_area_name"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(body, &msg); err != nil {
+			return
+		}
+		s.onRoomChange(roomID, msg.Data.Title, msg.Data.AreaName, msg.Data.ParentAreaName)
+	}
+}
+
+// loadRoom 加载房间最新配置
+func (s *LiveMonitorService) loadRoom(roomID string) *models.RecordRoom {
+	var room models.RecordRoom
+	if err := database.GetDB().Where("room_id = ?", roomID).First(&room).Error; err != nil {
+		return nil
+	}
+	return &room
+}
+
+// onLive 开播：LIVE消息不含标题和分区，查询一次直播间信息补全
+func (s *LiveMonitorService) onLive(roomID string) {
+	room := s.loadRoom(roomID)
+	if room == nil {
+		return
+	}
+
+	status := &roomLiveStatus{Title: room.Title, AreaName: room.AreaName}
+	updates := map[string]interface{}{
+		"live_status":     1,
+		"last_check_time": time.Now(),
+	}
+	if info, err := s.statusSvc.GetRoomInfo(roomID); err == nil {
+		status = roomStatusFromInfo(info, "")
+		updates["title"] = info.Data.Title
+		updates["area_name"] = info.Data.AreaName
+		updates["area_name_parent"] = info.Data.ParentAreaName
+	} else {
+		log.Printf("[直播信息流] 房间 %s 开播后获取直播间信息失败: %v", roomID, err)
+	}
+	status.LiveStatus = 1
+	database.GetDB().Model(room).Updates(updates)
+
+	s.statusSvc.SetStreaming(room, true, status.Title, status.AreaName, "信息流")
+	s.statusSvc.trackLiveSession(room, status, "信息流")
+	if room = s.loadRoom(roomID); room != nil {
+		s.syncRecorders(room)
+	}
+}
+
+// onPreparing 下播，round为1时表示进入轮播
+func (s *LiveMonitorService) onPreparing(roomID string, round bool) {
+	room := s.loadRoom(roomID)
+	if room == nil {
+		return
+	}
+
+	liveStatus := 0
+	if round {
+		liveStatus = 2
+	}
+	database.GetDB().Model(room).Updates(map[string]interface{}{
+		"live_status":     liveStatus,
+		"last_check_time": time.Now(),
+	})
+
+	s.statusSvc.SetStreaming(room, false, room.Title, room.AreaName, "信息流")
+	s.statusSvc.trackLiveSession(room, &roomLiveStatus{LiveStatus: liveStatus}, "信息流")
+	s.syncRecorders(room)
+}
+
+// onRoomChange 直播间标题或分区变更
+func (s *LiveMonitorService) onRoomChange(roomID, title, areaName, parentAreaName string) {
+	room := s.loadRoom(roomID)
+	if room == nil {
+		return
+	}
+
+	updates := map[string]interface{}{}
+	if title != "" && title != room.Title {
+		updates["title"] = title
+	}
+	if areaName != "" && areaName != room.AreaName {
+		updates["area_name"] = areaName
+	}
+	if parentAreaName != "" && parentAreaName != room.AreaNameParent {
+		updates["area_name_parent"] = parentAreaName
+	}
+	if len(updates) == 0 {
+		return
+	}
+
+	database.GetDB().Model(room).Updates(updates)
+	s.statusSvc.trackRoomChange(roomID, &roomLiveStatus{Title: title, AreaName: areaName, ParentAreaName: parentAreaName})
+	log.Printf("[直播信息流] 房间 %s 直播间信息变更: 标题=%s, 分区=%s/%s", roomID, title, parentAreaName, areaName)
+}
+
+// refresh 主动查询一次房间状态
+func (s *LiveMonitorService) refresh(roomID string) {
+	room := s.loadRoom(roomID)
+	if room == nil {
+		return
+	}
+	if err := s.statusSvc.UpdateRoomLiveStatus(room); err != nil {
+		log.Printf("[直播信息流] 房间 %s 重连后刷新状态失败: %v", roomID, err)
+		return
+	}
+	if room = s.loadRoom(roomID); room != nil {
+		s.syncRecorders(room)
+	}
+}
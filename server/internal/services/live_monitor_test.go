@@ -0,0 +1,92 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gobup/server/internal/database"
+	"github.com/gobup/server/internal/models"
+)
+
+// setupTestDB 在临时目录初始化数据库，工作目录指向同一临时目录
+func setupTestDB(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := database.InitDB(filepath.Join(dir, "gobup.db")); err != nil {
+		t.Fatalf("初始化数据库失败: %v", err)
+	}
+	t.Cleanup(database.CloseDB)
+
+	workPath := filepath.Join(dir, "work")
+	if err := os.MkdirAll(workPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	database.GetDB().Model(&models.SystemConfig{}).Where("1 = 1").Update("work_path", workPath)
+	return workPath
+}
+
+func TestLiveMonitorForwardsDanmakuToRecorder(t *testing.T) {
+	setupTestDB(t)
+	db := database.GetDB()
+
+	room := models.RecordRoom{RoomID: "21452505", Uname: "测试主播", Title: "测试直播", Upload: true}
+	if err := db.Create(&room).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := GetDanmakuRecorderService()
+	if err := recorder.Start(&room); err != nil {
+		t.Fatalf("开始弹幕录制失败: %v", err)
+	}
+
+	body := []byte(`{"cmd":"DANMU_MSG:4:0:2:2:2:0","info":[[0,1,25,16777215,1760000000000,0,0,"",0,0,0,"",0,"{}","{}",{}],` +
+		`"测试弹幕<1>",[123456,"测试用户",0,0,0,10000,1,""],[12,"勋章","测试主播",21452505],[25,0,6406234,">50000",0],["",""],0,0,null,{"ts":1760000000,"ct":"ABC"},0,0,null,null,0,7]}`)
+	GetLiveMonitorService().dispatchCommand(room.RoomID, "DANMU_MSG", body)
+	recorder.Stop(room.RoomID, false)
+
+	var history models.RecordHistory
+	if err := db.Where("room_id = ?", room.RoomID).First(&history).Error; err != nil {
+		t.Fatalf("未创建历史记录: %v", err)
+	}
+	if history.Recording {
+		t.Error("直播结束后历史记录仍在录制")
+	}
+	if history.DanmakuCount != 1 {
+		t.Errorf("弹幕数=%d, 期望1", history.DanmakuCount)
+	}
+
+	content, err := os.ReadFile(history.DanmakuFile)
+	if err != nil {
+		t.Fatalf("读取弹幕文件失败: %v", err)
+	}
+	var line string
+	for _, l := range strings.Split(string(content), "\n") {
+		if strings.HasPrefix(l, "<d ") {
+			line = l
+		}
+	}
+	if !strings.Contains(line, `user="测试用户"`) || !strings.HasSuffix(line, ">测试弹幕&lt;1&gt;</d>") {
+		t.Errorf("弹幕行不正确: %s", line)
+	}
+	if !strings.Contains(line, ",1,25,16777215,1760000000000,0,123456,0\"") {
+		t.Errorf("弹幕p属性不正确: %s", line)
+	}
+	if !strings.HasSuffix(string(content), xmlFooter) {
+		t.Error("弹幕文件缺少结束标签")
+	}
+
+	var msgs []models.LiveMsg
+	db.Where("session_id = ?", history.SessionID).Find(&msgs)
+	if len(msgs) != 1 {
+		t.Fatalf("弹幕记录数=%d, 期望1", len(msgs))
+	}
+	msg := msgs[0]
+	if msg.Message != "测试弹幕<1>" || msg.UID != 123456 || msg.UserName != "测试用户" || msg.Type != LiveMsgTypeDanmaku {
+		t.Errorf("弹幕记录不正确: %+v", msg)
+	}
+	if msg.MedalLevel != 12 || msg.MedalRoomID != "21452505" || msg.ULevel != 25 {
+		t.Errorf("勋章或等级不正确: medal=%d room=%s ulevel=%d", msg.MedalLevel, msg.MedalRoomID, msg.ULevel)
+	}
+}
@@ -0,0 +1,116 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gobup/server/internal/database"
+	"github.com/gobup/server/internal/models"
+)
+
+// liveSessionMu 串行化直播历史记录的查找和创建，两个录制同时开始时只创建一条记录
+var liveSessionMu sync.Mutex
+
+// liveSessionHistory 获取房间当前直播的历史记录，没有正在录制的记录时创建新记录
+// 内置弹幕录制和直播流录制共用同一条记录，会话ID以live-开头
+func liveSessionHistory(room *models.RecordRoom, start time.Time) (*models.RecordHistory, error) {
+	liveSessionMu.Lock()
+	defer liveSessionMu.Unlock()
+
+	db := database.GetDB()
+
+	var history models.RecordHistory
+	if err := db.Where("room_id = ? AND recording = ?", room.RoomID, true).
+		Order("start_time DESC").First(&history).Error; err == nil {
+		return &history, nil
+	}
+
+	history = models.RecordHistory{
+		RoomID:    room.RoomID,
+		SessionID: fmt.Sprintf("live-%s-%d", room.RoomID, start.Unix()),
+		EventID:   fmt.Sprintf("live_%s_%d", room.RoomID, start.Unix()),
+		Uname:     room.Uname,
+		Title:     room.Title,
+		AreaName:  room.AreaName,
+		StartTime: start,
+		EndTime:   start,
+		Recording: true,
+		Streaming: true,
+		Upload:    room.Upload,
+	}
+	if err := db.Create(&history).Error; err != nil {
+		return nil, fmt.Errorf("创建历史记录失败: %w", err)
+	}
+	return &history, nil
+}
+
+// liveRecordPath 录制文件路径，命名与录播姬一致，便于与同目录的录播文件对应
+func liveRecordPath(room *models.RecordRoom, start time.Time, ext string) string {
+	title := strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`\/:*?"<>|`, r) || r < 32 {
+			return '_'
+		}
+		return r
+	}, room.Title)
+	if len([]rune(title)) > 30 {
+		title = string([]rune(title)[:30])
+	}
+	name := fmt.Sprintf("录制-%s-%s-%03d-%s%s", room.RoomID, start.Format("20060102-150405"), start.Nanosecond()/int(time.Millisecond), title, ext)
+	return filepath.Join(LoadConfigFromDB().WorkPath, room.RoomID, name)
+}
+
+// finishLiveSession 弹幕和直播流录制都结束后统计弹幕数并结束录制状态，弹幕就绪后按房间配置生成高能剪辑
+func finishLiveSession(history *models.RecordHistory, end time.Time) {
+	if GetDanmakuRecorderService().Recording(history.RoomID) || GetStreamRecorderService().Recording(history.RoomID) {
+		return
+	}
+
+	db := database.GetDB()
+
+	var count int64
+	db.Model(&models.LiveMsg{}).Where("session_id = ?", history.SessionID).Count(&count)
+
+	updates := map[string]interface{}{
+		"recording": false,
+		"streaming": false,
+	}
+	if count > 0 {
+		updates["danmaku_count"] = count
+	}
+	if end.After(history.EndTime) {
+		updates["end_time"] = end
+	}
+	// 两个录制同时结束时只处理一次
+	result := db.Model(&models.RecordHistory{}).Where("id = ? AND recording = ?", history.ID, true).Updates(updates)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return
+	}
+
+	if count > 0 {
+		NewHighEnergyCutService().EnqueueIfEnabled(history.ID)
+	}
+}
+
+// FinishStaleLiveSessions 结束服务重启前未正常结束、且直播已经结束的内置录制
+func FinishStaleLiveSessions(streaming map[string]bool) {
+	var histories []models.RecordHistory
+	if err := database.GetDB().Where("recording = ? AND session_id LIKE ?", true, "live-%").Find(&histories).Error; err != nil {
+		return
+	}
+	for i := range histories {
+		history := &histories[i]
+		if streaming[history.RoomID] ||
+			GetDanmakuRecorderService().Recording(history.RoomID) ||
+			GetStreamRecorderService().Recording(history.RoomID) {
+			continue
+		}
+		closeDanmakuXML(history.DanmakuFile)
+		finishInterruptedStreamParts(history.ID)
+		finishLiveSession(history, time.Now())
+		log.Printf("[直播录制] 房间 %s 直播已结束，完成中断的录制: history_id=%d", history.RoomID, history.ID)
+	}
+}
@@ -0,0 +1,346 @@
+package services
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gobup/server/internal/database"
+	"github.com/gobup/server/internal/models"
+)
+
+// LiveSessionEvent 直播期间的信息变更
+type LiveSessionEvent struct {
+	Time time.Time `json:"time"`
+	Type string    `json:"type"` // title, area, cover
+	From string    `json:"from"`
+	To   string    `json:"to"`
+}
+
+// liveSessionRestartTolerance 接口返回的开播时间晚于当前场次开始时间超过该值时，视为下播后重新开播
+const liveSessionRestartTolerance = time.Minute
+
+// liveSessionLocks 按房间串行化直播场次的创建和更新，轮询和信息流可能同时上报开播
+var liveSessionLocks sync.Map // roomID -> *sync.Mutex
+
+// lockLiveSession 锁定房间的直播场次，返回解锁函数
+func lockLiveSession(roomID string) func() {
+	value, _ := liveSessionLocks.LoadOrStore(roomID, &sync.Mutex{})
+	mu := value.(*sync.Mutex)
+	mu.Lock()
+	return mu.Unlock
+}
+
+// openLiveSession 获取房间正在进行的直播场次
+func openLiveSession(roomID string) (*models.LiveSession, bool) {
+	var session models.LiveSession
+	if err := database.GetDB().Where("room_id = ? AND end_time IS NULL", roomID).
+		Order("start_time DESC").First(&session).Error; err != nil {
+		return nil, false
+	}
+	return &session, true
+}
+
+// trackLiveSession 按最新直播状态开始、更新或结束房间的直播场次
+func (s *LiveStatusService) trackLiveSession(room *models.RecordRoom, status *roomLiveStatus, source string) {
+	defer lockLiveSession(room.RoomID)()
+
+	session, open := openLiveSession(room.RoomID)
+	if status.LiveStatus != 1 {
+		if open {
+			s.closeLiveSession(session, time.Now())
+		}
+		return
+	}
+
+	// 两次查询之间下播又重新开播
+	if open && !status.LiveStart.IsZero() && status.LiveStart.After(session.StartTime.Add(liveSessionRestartTolerance)) {
+		s.closeLiveSession(session, status.LiveStart)
+		open = false
+	}
+	if open {
+		s.updateLiveSession(session, status)
+		return
+	}
+
+	// 接口返回的开播时间更准确，不受轮询间隔和服务重启影响
+	start := time.Now()
+	if !status.LiveStart.IsZero() && status.LiveStart.Before(start) && start.Sub(status.LiveStart) < 24*time.Hour {
+		start = status.LiveStart
+	}
+	session = &models.LiveSession{
+		RoomID:         room.RoomID,
+		StartTime:      start,
+		Title:          status.Title,
+		AreaName:       status.AreaName,
+		ParentAreaName: status.ParentAreaName,
+		CoverURL:       status.Cover,
+		PeakOnline:     status.Online,
+		Source:         source,
+	}
+	if err := database.GetDB().Create(session).Error; err != nil {
+		log.Printf("[LiveStatus] 房间 %s 创建直播场次失败: %v", room.RoomID, err)
+		return
+	}
+	log.Printf("[LiveStatus] 房间 %s 开始新的直播场次: session_id=%d, 开播时间=%s", room.RoomID, session.ID, start.Format("2006-01-02 15:04:05"))
+}
+
+// trackRoomChange 记录正在进行的直播场次的标题、分区变更
+func (s *LiveStatusService) trackRoomChange(roomID string, status *roomLiveStatus) {
+	defer lockLiveSession(roomID)()
+
+	if session, ok := openLiveSession(roomID); ok {
+		s.updateLiveSession(session, status)
+	}
+}
+
+// trackPeakOnline 更新正在进行的直播场次的人气峰值
+func (s *LiveStatusService) trackPeakOnline(roomID string, online int64) {
+	if online <= 0 {
+		return
+	}
+	database.GetDB().Model(&models.LiveSession{}).
+		Where("room_id = ? AND end_time IS NULL AND peak_online < ?", roomID, online).
+		Update("peak_online", online)
+}
+
+// updateLiveSession 对比直播间信息，记录变更并更新人气峰值，status中为空的字段表示未知
+func (s *LiveStatusService) updateLiveSession(session *models.LiveSession, status *roomLiveStatus) {
+	var events []LiveSessionEvent
+	if session.Events != "" {
+		json.Unmarshal([]byte(session.Events), &events)
+	}
+	changed := len(events)
+	now := time.Now()
+
+	updates := map[string]interface{}{}
+	if status.Title != "" && status.Title != session.Title {
+		events = append(events, LiveSessionEvent{Time: now, Type: "title", From: session.Title, To: status.Title})
+		updates["title"] = status.Title
+	}
+	if status.AreaName != "" && status.AreaName != session.AreaName {
+		events = append(events, LiveSessionEvent{
+			Time: now,
+			Type: "area",
+			From: session.ParentAreaName + "/" + session.AreaName,
+			To:   status.ParentAreaName + "/" + status.AreaName,
+		})
+		updates["area_name"] = status.AreaName
+		updates["parent_area_name"] = status.ParentAreaName
+	}
+	if status.Cover != "" && status.Cover != session.CoverURL {
+		// 开播时未获取到封面时直接补全，不算变更
+		if session.CoverURL != "" {
+			events = append(events, LiveSessionEvent{Time: now, Type: "cover", From: session.CoverURL, To: status.Cover})
+		}
+		updates["cover_url"] = status.Cover
+	}
+	if status.Online > session.PeakOnline {
+		updates["peak_online"] = status.Online
+	}
+	if len(events) > changed {
+		data, _ := json.Marshal(events)
+		updates["events"] = string(data)
+	}
+	if len(updates) == 0 {
+		return
+	}
+
+	if err := database.GetDB().Model(session).Updates(updates).Error; err != nil {
+		log.Printf("[LiveStatus] 房间 %s 更新直播场次失败: %v", session.RoomID, err)
+	}
+}
+
+// closeLiveSession 结束直播场次并关联录制记录
+func (s *LiveStatusService) closeLiveSession(session *models.LiveSession, end time.Time) {
+	if end.Before(session.StartTime) {
+		end = session.StartTime
+	}
+	if err := database.GetDB().Model(session).Update("end_time", end).Error; err != nil {
+		log.Printf("[LiveStatus] 房间 %s 结束直播场次失败: %v", session.RoomID, err)
+		return
+	}
+	session.EndTime = &end
+	linkLiveSessionHistory(session)
+	log.Printf("[LiveStatus] 房间 %s 直播场次结束: session_id=%d, 时长%s", session.RoomID, session.ID, end.Sub(session.StartTime).Round(time.Second))
+}
+
+// liveSessionEnd 直播场次的结束时间，仍在直播时为当前时间
+func liveSessionEnd(session *models.LiveSession) time.Time {
+	if session.EndTime != nil {
+		return *session.EndTime
+	}
+	return time.Now()
+}
+
+// linkLiveSessionHistory 将直播场次关联到时间重叠最多的原始录制记录，录制记录可能在下播后才由扫盘创建
+func linkLiveSessionHistory(session *models.LiveSession) {
+	db := database.GetDB()
+	end := liveSessionEnd(session)
+
+	var histories []models.RecordHistory
+	if err := db.Where("room_id = ? AND start_time < ? AND end_time > ? AND source_history_id = ? AND highlight_archive = ?",
+		session.RoomID, end, session.StartTime, 0, HighlightPublishOff).Find(&histories).Error; err != nil {
+		return
+	}
+
+	var best uint
+	var bestOverlap time.Duration
+	for i := range histories {
+		overlap := overlapDuration(session.StartTime, end, histories[i].StartTime, histories[i].EndTime)
+		if overlap > bestOverlap {
+			best, bestOverlap = histories[i].ID, overlap
+		}
+	}
+	if best != 0 && best != session.HistoryID {
+		db.Model(session).Update("history_id", best)
+		session.HistoryID = best
+	}
+}
+
+// overlapDuration 两个时间段的重叠时长
+func overlapDuration(aStart, aEnd, bStart, bEnd time.Time) time.Duration {
+	start, end := aStart, aEnd
+	if bStart.After(start) {
+		start = bStart
+	}
+	if bEnd.Before(end) {
+		end = bEnd
+	}
+	if end.After(start) {
+		return end.Sub(start)
+	}
+	return 0
+}
+
+// CoverageGap 直播中没有录制到的时间段
+type CoverageGap struct {
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Duration int64     `json:"duration"` // 秒
+}
+
+// LiveSessionCoverage 单场直播的录制覆盖情况
+type LiveSessionCoverage struct {
+	Session  models.LiveSession `json:"session"`
+	Events   []LiveSessionEvent `json:"events"`
+	Duration int64              `json:"duration"` // 直播时长（秒）
+	Recorded int64              `json:"recorded"` // 已录制时长（秒）
+	Ratio    float64            `json:"ratio"`    // 录制覆盖率 0-1
+	Gaps     []CoverageGap      `json:"gaps"`
+}
+
+// RoomCoverage 房间在一段时间内的录制覆盖情况
+type RoomCoverage struct {
+	RoomID         string                `json:"roomId"`
+	Since          time.Time             `json:"since"`
+	SessionCount   int                   `json:"sessionCount"`
+	MissedSessions int                   `json:"missedSessions"` // 完全没有录制的场次
+	Duration       int64                 `json:"duration"`
+	Recorded       int64                 `json:"recorded"`
+	Ratio          float64               `json:"ratio"`
+	Sessions       []LiveSessionCoverage `json:"sessions"`
+}
+
+// GetRecordingCoverage 统计房间自since以来每场直播的录制覆盖情况，短于minGap的缺口忽略（断流重连的间隙）
+func (s *LiveStatusService) GetRecordingCoverage(roomID string, since time.Time, minGap time.Duration) (*RoomCoverage, error) {
+	db := database.GetDB()
+
+	var sessions []models.LiveSession
+	if err := db.Where("room_id = ? AND (end_time IS NULL OR end_time > ?)", roomID, since).
+		Order("start_time DESC").Find(&sessions).Error; err != nil {
+		return nil, err
+	}
+
+	coverage := &RoomCoverage{RoomID: roomID, Since: since, SessionCount: len(sessions), Sessions: []LiveSessionCoverage{}}
+	for i := range sessions {
+		session := &sessions[i]
+		if session.HistoryID == 0 {
+			linkLiveSessionHistory(session)
+		}
+
+		item := s.sessionCoverage(session, minGap)
+		if item.Recorded == 0 {
+			coverage.MissedSessions++
+		}
+		coverage.Duration += item.Duration
+		coverage.Recorded += item.Recorded
+		coverage.Sessions = append(coverage.Sessions, item)
+	}
+	if coverage.Duration > 0 {
+		coverage.Ratio = float64(coverage.Recorded) / float64(coverage.Duration)
+	}
+	return coverage, nil
+}
+
+// sessionCoverage 以房间所有录制分P的时间段计算单场直播的已录制时长和缺口
+func (s *LiveStatusService) sessionCoverage(session *models.LiveSession, minGap time.Duration) LiveSessionCoverage {
+	start, end := session.StartTime, liveSessionEnd(session)
+	item := LiveSessionCoverage{
+		Session:  *session,
+		Events:   []LiveSessionEvent{},
+		Duration: int64(end.Sub(start).Seconds()),
+		Gaps:     []CoverageGap{},
+	}
+	if session.Events != "" {
+		json.Unmarshal([]byte(session.Events), &item.Events)
+	}
+
+	// 合并后的分P仍保留原时间段，片段剪辑和高能集锦不算录制
+	var parts []models.RecordHistoryPart
+	database.GetDB().Where("room_id = ? AND start_time < ? AND history_id IN (?)", session.RoomID, end,
+		database.GetDB().Model(&models.RecordHistory{}).Select("id").
+			Where("room_id = ? AND source_history_id = ? AND highlight_archive = ?", session.RoomID, 0, HighlightPublishOff)).
+		Find(&parts)
+
+	type span struct{ start, end time.Time }
+	var spans []span
+	for i := range parts {
+		partEnd := parts[i].EndTime
+		if !partEnd.After(parts[i].StartTime) {
+			partEnd = parts[i].StartTime.Add(time.Duration(parts[i].Duration) * time.Second)
+		}
+		if parts[i].Recording && end.After(partEnd) {
+			partEnd = end
+		}
+		if !partEnd.After(start) {
+			continue
+		}
+		spans = append(spans, span{parts[i].StartTime, partEnd})
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start.Before(spans[j].start) })
+
+	var recorded time.Duration
+	cursor := start
+	addGap := func(gapStart, gapEnd time.Time) {
+		if gapEnd.Sub(gapStart) >= minGap && gapEnd.After(gapStart) {
+			item.Gaps = append(item.Gaps, CoverageGap{Start: gapStart, End: gapEnd, Duration: int64(gapEnd.Sub(gapStart).Seconds())})
+		}
+	}
+	for _, sp := range spans {
+		if !sp.start.Before(end) {
+			break
+		}
+		if sp.start.After(cursor) {
+			addGap(cursor, sp.start)
+			cursor = sp.start
+		}
+		spanEnd := sp.end
+		if spanEnd.After(end) {
+			spanEnd = end
+		}
+		if spanEnd.After(cursor) {
+			recorded += spanEnd.Sub(cursor)
+			cursor = spanEnd
+		}
+	}
+	addGap(cursor, end)
+
+	item.Recorded = int64(recorded.Seconds())
+	if item.Duration > 0 {
+		item.Ratio = float64(item.Recorded) / float64(item.Duration)
+	}
+	return item
+}
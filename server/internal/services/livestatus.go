@@ -4,7 +4,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"math/rand"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gobup/server/internal/database"
@@ -24,6 +26,8 @@ type LiveRoomInfo struct {
 		RoomStatus     int    `json:"room_status"` // 0:房间封禁 1:房间正常
 		Title          string `json:"title"`       // 直播标题
 		UserCover      string `json:"user_cover"`  // 直播封面
+		Online         int64  `json:"online"`      // 人气值
+		LiveTime       string `json:"live_time"`   // 开播时间，未开播时为0000-00-00 00:00:00
 		ParentAreaID   int    `json:"parent_area_id"`
 		AreaID         int    `json:"area_id"`
 		AreaName       string `json:"area_name"`
@@ -113,6 +117,98 @@ func (s *LiveStatusService) GetUserInfo(uid int64) (*UserInfo, error) {
 	return &userInfo, nil
 }
 
+// LiveStatusInfo 按主播UID批量查询返回的直播间状态
+type LiveStatusInfo struct {
+	UID            int64  `json:"uid"`
+	RoomID         int64  `json:"room_id"`
+	Uname          string `json:"uname"`
+	Title          string `json:"title"`
+	LiveStatus     int    `json:"live_status"` // 0:未开播 1:正在直播 2:轮播中
+	LiveTime       int64  `json:"live_time"`   // 开播时间戳（秒）
+	Online         int64  `json:"online"`
+	AreaName       string `json:"area_v2_name"`
+	ParentAreaName string `json:"area_v2_parent_name"`
+	CoverFromUser  string `json:"cover_from_user"`
+}
+
+// liveStatusBatchSize 每次批量查询的主播数量
+const liveStatusBatchSize = 50
+
+// GetStatusByUIDs 按主播UID批量查询直播间状态，返回结果以UID为键，查询不到的主播不在结果中
+func (s *LiveStatusService) GetStatusByUIDs(uids []int64) (map[int64]*LiveStatusInfo, error) {
+	var result struct {
+		Code    int             `json:"code"`
+		Message string          `json:"message"`
+		Data    json.RawMessage `json:"data"`
+	}
+
+	resp, err := s.client.R().
+		SetHeader("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36").
+		SetBodyJsonMarshal(map[string]interface{}{"uids": uids}).
+		Post("https://api.live.bilibili.com/room/v1/Room/get_status_info_by_uids")
+	if err != nil {
+		return nil, fmt.Errorf("请求批量直播状态失败: %w", err)
+	}
+
+	if err := json.Unmarshal(resp.Bytes(), &result); err != nil {
+		return nil, fmt.Errorf("解析批量直播状态失败: %w", err)
+	}
+
+	if result.Code != 0 {
+		return nil, fmt.Errorf("获取批量直播状态失败: %s", result.Message)
+	}
+
+	statuses := make(map[int64]*LiveStatusInfo, len(uids))
+	// 没有任何结果时data为空数组
+	if len(result.Data) == 0 || result.Data[0] != '{' {
+		return statuses, nil
+	}
+	var data map[string]*LiveStatusInfo
+	if err := json.Unmarshal(result.Data, &data); err != nil {
+		return nil, fmt.Errorf("解析批量直播状态失败: %w", err)
+	}
+	for _, info := range data {
+		if info != nil && info.UID > 0 {
+			statuses[info.UID] = info
+		}
+	}
+	return statuses, nil
+}
+
+// roomLiveStatus 房间的最新直播状态，来自单房间查询或批量查询
+type roomLiveStatus struct {
+	UID            int64
+	Uname          string
+	Title          string
+	AreaName       string
+	ParentAreaName string
+	LiveStatus     int
+	Cover          string
+	Online         int64
+	LiveStart      time.Time // 开播时间，未知时为零值
+}
+
+// biliLiveTimeLocation 直播间接口返回的开播时间为北京时间，与服务器所在时区无关
+var biliLiveTimeLocation = time.FixedZone("CST", 8*3600)
+
+// roomStatusFromInfo 从直播间信息构造房间状态
+func roomStatusFromInfo(info *LiveRoomInfo, uname string) *roomLiveStatus {
+	status := &roomLiveStatus{
+		UID:            info.Data.UID,
+		Uname:          uname,
+		Title:          info.Data.Title,
+		AreaName:       info.Data.AreaName,
+		ParentAreaName: info.Data.ParentAreaName,
+		LiveStatus:     info.Data.LiveStatus,
+		Cover:          info.Data.UserCover,
+		Online:         info.Data.Online,
+	}
+	if t, err := time.ParseInLocation("2006-01-02 15:04:05", info.Data.LiveTime, biliLiveTimeLocation); err == nil && t.Year() > 2000 {
+		status.LiveStart = t
+	}
+	return status
+}
+
 // UpdateRoomLiveStatus 更新房间的直播状态
 func (s *LiveStatusService) UpdateRoomLiveStatus(room *models.RecordRoom) error {
 	roomInfo, err := s.GetRoomInfo(room.RoomID)
@@ -121,11 +217,6 @@ func (s *LiveStatusService) UpdateRoomLiveStatus(room *models.RecordRoom) error
 		return err
 	}
 
-	db := database.GetDB()
-
-	// 记录之前的状态
-	wasStreaming := room.Streaming
-
 	// 获取主播信息
 	uname := room.Uname // 默认保持原有名称
 	if roomInfo.Data.UID > 0 {
@@ -138,16 +229,30 @@ func (s *LiveStatusService) UpdateRoomLiveStatus(room *models.RecordRoom) error
 		}
 	}
 
-	// 更新房间状态
+	return s.applyRoomStatus(room, roomStatusFromInfo(roomInfo, uname))
+}
+
+// applyRoomStatus 保存房间的直播状态并记录直播场次，缓存主播UID供下次批量查询
+func (s *LiveStatusService) applyRoomStatus(room *models.RecordRoom, status *roomLiveStatus) error {
+	db := database.GetDB()
+
+	uname := status.Uname
+	if uname == "" {
+		uname = room.Uname
+	}
+
+	// 更新房间状态（streaming单独按状态切换更新，避免与直播信息流重复处理开播/下播）
 	updates := map[string]interface{}{
-		"streaming":        roomInfo.Data.LiveStatus == 1,
-		"title":            roomInfo.Data.Title,
+		"title":            status.Title,
 		"uname":            uname,
-		"area_name":        roomInfo.Data.AreaName,
-		"area_name_parent": roomInfo.Data.ParentAreaName,
-		"live_status":      roomInfo.Data.LiveStatus,
+		"area_name":        status.AreaName,
+		"area_name_parent": status.ParentAreaName,
+		"live_status":      status.LiveStatus,
 		"last_check_time":  time.Now(),
 	}
+	if status.UID > 0 && status.UID != room.AnchorUID {
+		updates["anchor_uid"] = status.UID
+	}
 
 	if err := db.Model(room).Updates(updates).Error; err != nil {
 		return fmt.Errorf("更新房间状态失败: %w", err)
@@ -165,25 +270,51 @@ func (s *LiveStatusService) UpdateRoomLiveStatus(room *models.RecordRoom) error
 			log.Printf("[LiveStatus] 已同步更新 %d 条历史记录的主播名: %s -> %s",
 				result.RowsAffected, room.Uname, uname)
 		}
+		room.Uname = uname
 	}
 
 	// 检测直播状态变化
-	isStreaming := roomInfo.Data.LiveStatus == 1
-
-	if wasStreaming && !isStreaming {
-		log.Printf("[LiveStatus] 房间 %s 直播结束，可以处理录播文件", room.RoomID)
-		// 这里可以触发文件扫描或其他处理逻辑
-	} else if !wasStreaming && isStreaming {
-		log.Printf("[LiveStatus] 房间 %s 开始直播: %s", room.RoomID, roomInfo.Data.Title)
-	}
+	isStreaming := status.LiveStatus == 1
+	s.SetStreaming(room, isStreaming, status.Title, status.AreaName, "轮询")
+	s.trackLiveSession(room, status, "轮询")
 
 	log.Printf("[LiveStatus] 房间 %s 状态更新: live_status=%d, streaming=%v, title=%s",
-		room.RoomID, roomInfo.Data.LiveStatus, isStreaming, roomInfo.Data.Title)
+		room.RoomID, status.LiveStatus, isStreaming, status.Title)
 
 	return nil
 }
 
+// SetStreaming 切换房间的开播状态，只在状态确实发生变化时处理开播/下播
+// 使用条件更新保证轮询和直播信息流同时检测到变化时只有一方生效，开播通知不会重复发送
+func (s *LiveStatusService) SetStreaming(room *models.RecordRoom, streaming bool, title, areaName, source string) bool {
+	db := database.GetDB()
+
+	result := db.Model(&models.RecordRoom{}).
+		Where("id = ? AND streaming = ?", room.ID, !streaming).
+		Update("streaming", streaming)
+	if result.Error != nil {
+		log.Printf("[LiveStatus] 更新房间 %s 开播状态失败: %v", room.RoomID, result.Error)
+		return false
+	}
+	room.Streaming = streaming
+	if result.RowsAffected == 0 {
+		return false
+	}
+
+	if !streaming {
+		log.Printf("[LiveStatus] 房间 %s 直播结束（%s），可以处理录播文件", room.RoomID, source)
+		return true
+	}
+
+	log.Printf("[LiveStatus] 房间 %s 开始直播（%s）: %s", room.RoomID, source, title)
+	if room.Wxuid != "" && ContainsPushTag(room.PushMsgTags, "开播") {
+		go NewWxPusherService().NotifyLiveStart(room.UploadUserID, room.Wxuid, room.Uname, title, areaName)
+	}
+	return true
+}
+
 // UpdateAllRoomsStatus 更新所有房间的直播状态
+// 已知主播UID的房间按UID批量查询，批量查询未覆盖的房间再按并发数逐个查询
 func (s *LiveStatusService) UpdateAllRoomsStatus() error {
 	db := database.GetDB()
 
@@ -192,28 +323,116 @@ func (s *LiveStatusService) UpdateAllRoomsStatus() error {
 		return fmt.Errorf("查询房间列表失败: %w", err)
 	}
 
+	workers, jitter := 3, 1000
+	var config models.SystemConfig
+	if err := db.First(&config).Error; err == nil {
+		workers, jitter = config.LiveStatusWorkers, config.LiveStatusJitter
+	}
+	if workers < 1 {
+		workers = 1
+	}
+
 	log.Printf("[LiveStatus] 开始更新 %d 个房间的直播状态", len(rooms))
 
-	successCount := 0
-	failCount := 0
+	pending := s.updateByUIDs(rooms, jitter)
+	batchCount := len(rooms) - len(pending)
+
+	var successCount, failCount int64
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, workers)
+	for _, room := range pending {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(room *models.RecordRoom) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			// 随机等待，避免请求过于集中
+			liveStatusJitter(jitter)
+			err := s.UpdateRoomLiveStatus(room)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				log.Printf("[LiveStatus] 更新房间 %s 状态失败: %v", room.RoomID, err)
+				failCount++
+			} else {
+				successCount++
+			}
+		}(room)
+	}
+	wg.Wait()
 
+	log.Printf("[LiveStatus] 状态更新完成: 批量=%d, 逐个成功=%d, 失败=%d", batchCount, successCount, failCount)
+
+	return nil
+}
+
+// updateByUIDs 按主播UID分批查询并更新房间状态，返回需要逐个查询的房间
+func (s *LiveStatusService) updateByUIDs(rooms []models.RecordRoom, jitter int) []*models.RecordRoom {
+	var pending []*models.RecordRoom
+	byUID := make(map[int64]*models.RecordRoom)
+	var uids []int64
 	for i := range rooms {
-		if err := s.UpdateRoomLiveStatus(&rooms[i]); err != nil {
-			log.Printf("[LiveStatus] 更新房间 %s 状态失败: %v", rooms[i].RoomID, err)
-			failCount++
-		} else {
-			successCount++
+		room := &rooms[i]
+		// 未缓存UID或多个房间对应同一主播时无法按UID区分
+		if room.AnchorUID == 0 || byUID[room.AnchorUID] != nil {
+			pending = append(pending, room)
+			continue
+		}
+		byUID[room.AnchorUID] = room
+		uids = append(uids, room.AnchorUID)
+	}
+
+	for start := 0; start < len(uids); start += liveStatusBatchSize {
+		end := start + liveStatusBatchSize
+		if end > len(uids) {
+			end = len(uids)
+		}
+		batch := uids[start:end]
+		if start > 0 {
+			liveStatusJitter(jitter)
 		}
 
-		// 避免请求过快，每个请求间隔一下
-		if i < len(rooms)-1 {
-			time.Sleep(500 * time.Millisecond)
+		statuses, err := s.GetStatusByUIDs(batch)
+		if err != nil {
+			log.Printf("[LiveStatus] 批量查询 %d 个主播的直播状态失败，改为逐个查询: %v", len(batch), err)
+		}
+		for _, uid := range batch {
+			room := byUID[uid]
+			info := statuses[uid]
+			if info == nil {
+				pending = append(pending, room)
+				continue
+			}
+			status := &roomLiveStatus{
+				UID:            info.UID,
+				Uname:          info.Uname,
+				Title:          info.Title,
+				AreaName:       info.AreaName,
+				ParentAreaName: info.ParentAreaName,
+				LiveStatus:     info.LiveStatus,
+				Cover:          info.CoverFromUser,
+				Online:         info.Online,
+			}
+			if info.LiveTime > 0 {
+				status.LiveStart = time.Unix(info.LiveTime, 0)
+			}
+			if err := s.applyRoomStatus(room, status); err != nil {
+				log.Printf("[LiveStatus] 更新房间 %s 状态失败: %v", room.RoomID, err)
+			}
 		}
 	}
 
-	log.Printf("[LiveStatus] 状态更新完成: 成功=%d, 失败=%d", successCount, failCount)
+	return pending
+}
 
-	return nil
+// liveStatusJitter 随机等待不超过jitter毫秒
+func liveStatusJitter(jitter int) {
+	if jitter > 0 {
+		time.Sleep(time.Duration(rand.Intn(jitter)) * time.Millisecond)
+	}
 }
 
 // IsRoomRecordingFinished 判断房间的录播是否已完成（直播已结束且录制文件稳定）
@@ -0,0 +1,149 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/gobup/server/internal/database"
+	"github.com/gobup/server/internal/models"
+)
+
+// MediaInfo 媒体文件信息（ffprobe）
+type MediaInfo struct {
+	Duration   float64 // 时长（秒）
+	StartTime  float64 // 首个时间戳（秒），录制器未重置时间戳时不为0
+	VideoCodec string
+	AudioCodec string
+	Width      int
+	Height     int
+	SampleRate int
+	Channels   int
+}
+
+// MediaService 媒体文件探测服务
+type MediaService struct{}
+
+func NewMediaService() *MediaService {
+	return &MediaService{}
+}
+
+// ProbeMediaInfo 使用ffprobe获取媒体文件信息
+func (s *MediaService) ProbeMediaInfo(filePath string) (*MediaInfo, error) {
+	if _, err := exec.LookPath("ffprobe"); err != nil {
+		return nil, fmt.Errorf("ffprobe未安装或不在PATH中: %w", err)
+	}
+
+	cmd := exec.Command("ffprobe",
+		"-v", "error",
+		"-show_entries", "format=duration,start_time:stream=codec_type,codec_name,width,height,sample_rate,channels",
+		"-of", "json",
+		filePath,
+	)
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("ffprobe执行失败: %w", err)
+	}
+
+	var probe struct {
+		Format struct {
+			Duration  string `json:"duration"`
+			StartTime string `json:"start_time"`
+		} `json:"format"`
+		Streams []struct {
+			CodecType  string `json:"codec_type"`
+			CodecName  string `json:"codec_name"`
+			Width      int    `json:"width"`
+			Height     int    `json:"height"`
+			SampleRate string `json:"sample_rate"`
+			Channels   int    `json:"channels"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(output, &probe); err != nil {
+		return nil, fmt.Errorf("解析ffprobe输出失败: %w", err)
+	}
+
+	info := &MediaInfo{}
+	info.Duration, _ = strconv.ParseFloat(probe.Format.Duration, 64)
+	info.StartTime, _ = strconv.ParseFloat(probe.Format.StartTime, 64)
+	for _, stream := range probe.Streams {
+		switch stream.CodecType {
+		case "video":
+			if info.VideoCodec == "" {
+				info.VideoCodec = stream.CodecName
+				info.Width = stream.Width
+				info.Height = stream.Height
+			}
+		case "audio":
+			if info.AudioCodec == "" {
+				info.AudioCodec = stream.CodecName
+				info.SampleRate, _ = strconv.Atoi(stream.SampleRate)
+				info.Channels = stream.Channels
+			}
+		}
+	}
+
+	return info, nil
+}
+
+// EnsurePartDuration 获取分P时长（秒），未记录时通过ffprobe探测并回写数据库，探测失败时按起止时间估算
+func (s *MediaService) EnsurePartDuration(part *models.RecordHistoryPart) int {
+	if part.Duration > 0 {
+		return part.Duration
+	}
+
+	if info, err := s.ProbeMediaInfo(part.FilePath); err == nil && info.Duration > 0 {
+		part.Duration = int(info.Duration + 0.5)
+		database.GetDB().Model(part).Update("duration", part.Duration)
+		return part.Duration
+	} else if err != nil {
+		log.Printf("[媒体信息] 探测分P时长失败: part_id=%d, %v", part.ID, err)
+	}
+
+	if !part.StartTime.IsZero() && part.EndTime.After(part.StartTime) {
+		return int(part.EndTime.Sub(part.StartTime).Seconds())
+	}
+	return 0
+}
+
+// keyframeSearchWindow 查找关键帧时最多向后读取的时长（秒）
+const keyframeSearchWindow = 60
+
+// NextKeyframe 返回不早于at秒（相对文件开头）的第一个视频关键帧时间，窗口内没有关键帧时返回false
+func (s *MediaService) NextKeyframe(filePath string, info *MediaInfo, at float64) (float64, bool, error) {
+	if _, err := exec.LookPath("ffprobe"); err != nil {
+		return 0, false, fmt.Errorf("ffprobe未安装或不在PATH中: %w", err)
+	}
+
+	cmd := exec.Command("ffprobe",
+		"-v", "error",
+		"-select_streams", "v:0",
+		"-read_intervals", fmt.Sprintf("%.3f%%+%d", info.StartTime+at, keyframeSearchWindow),
+		"-show_entries", "packet=pts_time,flags",
+		"-of", "csv=p=0",
+		filePath,
+	)
+	output, err := cmd.Output()
+	if err != nil {
+		return 0, false, fmt.Errorf("ffprobe执行失败: %w", err)
+	}
+
+	for _, line := range strings.Split(string(output), "\n") {
+		ptsField, flags, ok := strings.Cut(strings.TrimSpace(line), ",")
+		if !ok || !strings.Contains(flags, "K") {
+			continue
+		}
+		pts, err := strconv.ParseFloat(ptsField, 64)
+		if err != nil {
+			continue
+		}
+		// 容忍毫秒级的时间戳舍入误差
+		if pts -= info.StartTime; pts >= at-0.001 {
+			return pts, true, nil
+		}
+	}
+	return 0, false, nil
+}
@@ -0,0 +1,263 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gobup/server/internal/bili"
+	"github.com/gobup/server/internal/database"
+	"github.com/gobup/server/internal/models"
+)
+
+// 碎片合并状态
+const (
+	MergeStatePending = 0 // 待合并阶段检查
+	MergeStateChecked = 1 // 已检查，不再参与合并
+	MergeStateOutput  = 2 // 合并生成的分P，不再参与合并
+)
+
+// PartMergeService 分P合并服务
+type PartMergeService struct{}
+
+func NewPartMergeService() *PartMergeService {
+	return &PartMergeService{}
+}
+
+// MergeParts 使用ffmpeg concat将同一历史记录的多个连续分P合并为一个新分P
+// 原分P保留文件，标记为不参与投稿并记录合并去向
+func (s *PartMergeService) MergeParts(parts []models.RecordHistoryPart, reason string) (*models.RecordHistoryPart, error) {
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("至少需要两个分P才能合并")
+	}
+
+	db := database.GetDB()
+	first := parts[0]
+	last := parts[len(parts)-1]
+
+	var inputs []string
+	var totalDuration int
+	mediaSvc := NewMediaService()
+	for _, part := range parts {
+		if part.HistoryID != first.HistoryID {
+			return nil, fmt.Errorf("只能合并同一场直播的分P")
+		}
+		if _, err := os.Stat(part.FilePath); err != nil {
+			return nil, fmt.Errorf("分P文件不存在: %s", part.FilePath)
+		}
+		inputs = append(inputs, part.FilePath)
+		totalDuration += mediaSvc.EnsurePartDuration(&part)
+	}
+
+	ext := filepath.Ext(first.FilePath)
+	outputPath := fmt.Sprintf("%s_merged%d%s", strings.TrimSuffix(first.FilePath, ext), len(parts), ext)
+	if err := s.concatFiles(inputs, outputPath); err != nil {
+		return nil, err
+	}
+
+	fileInfo, err := os.Stat(outputPath)
+	if err != nil {
+		return nil, fmt.Errorf("获取合并文件信息失败: %w", err)
+	}
+
+	merged := &models.RecordHistoryPart{
+		HistoryID:  first.HistoryID,
+		RoomID:     first.RoomID,
+		SessionID:  first.SessionID,
+		Title:      first.Title,
+		LiveTitle:  first.LiveTitle,
+		AreaName:   first.AreaName,
+		FilePath:   outputPath,
+		FileName:   filepath.Base(outputPath),
+		FileSize:   fileInfo.Size(),
+		Duration:   totalDuration,
+		StartTime:  first.StartTime,
+		EndTime:    last.EndTime,
+		MergeState: MergeStateOutput,
+	}
+	if err := db.Create(merged).Error; err != nil {
+		os.Remove(outputPath)
+		return nil, fmt.Errorf("创建合并分P记录失败: %w", err)
+	}
+
+	for _, part := range parts {
+		db.Model(&models.RecordHistoryPart{}).Where("id = ?", part.ID).Updates(map[string]interface{}{
+			"excluded":       true,
+			"exclude_reason": fmt.Sprintf("%s，已合并到分P(id=%d)", reason, merged.ID),
+			"merged_into":    merged.ID,
+		})
+	}
+
+	log.Printf("[分P合并] history_id=%d, 合并%d个分P -> part_id=%d, 大小=%d, 时长=%ds",
+		first.HistoryID, len(parts), merged.ID, merged.FileSize, merged.Duration)
+
+	return merged, nil
+}
+
+// maxChunksPerPart 合并后单个分P允许的最大分片数，与自动分割保持一致并留出余量
+const maxChunksPerPart = 9000
+
+// MergeFragments 合并历史记录中连续的短分P（上传前），返回需要暂缓上传的分P ID
+// 直播仍在录制时，末尾的短分P可能还会与后续碎片合并，因此暂缓上传
+// 其余已录制完成的分P标记为已检查，合并生成的分P不会再次参与合并
+func (s *PartMergeService) MergeFragments(room *models.RecordRoom, history *models.RecordHistory) (map[uint]bool, error) {
+	db := database.GetDB()
+	deferred := make(map[uint]bool)
+
+	var parts []models.RecordHistoryPart
+	if err := db.Where("history_id = ? AND excluded = ? AND file_delete = ?", history.ID, false, false).
+		Order("start_time ASC").
+		Find(&parts).Error; err != nil {
+		return deferred, err
+	}
+
+	mediaSvc := NewMediaService()
+	infos := make(map[uint]*MediaInfo)
+	isCandidate := func(part *models.RecordHistoryPart) bool {
+		if part.Recording || part.Upload || part.Uploading || part.MergeState == MergeStateOutput {
+			return false
+		}
+		duration := mediaSvc.EnsurePartDuration(part)
+		return duration > 0 && duration < room.MergeShortLimit
+	}
+	probe := func(part *models.RecordHistoryPart) *MediaInfo {
+		if info, ok := infos[part.ID]; ok {
+			return info
+		}
+		info, err := mediaSvc.ProbeMediaInfo(part.FilePath)
+		if err != nil {
+			log.Printf("[分P合并] 探测媒体信息失败: part_id=%d, %v", part.ID, err)
+		}
+		infos[part.ID] = info
+		return info
+	}
+
+	chunkSize := int64(5 * 1024 * 1024)
+	if room.Line == "app" {
+		chunkSize = 2 * 1024 * 1024
+	}
+
+	var groups [][]models.RecordHistoryPart
+	var current []models.RecordHistoryPart
+	var currentSize int64
+	lastIdx := -1
+	flush := func() {
+		if len(current) >= 2 {
+			groups = append(groups, current)
+		}
+		current = nil
+		currentSize = 0
+	}
+
+	for i := range parts {
+		part := &parts[i]
+		if !isCandidate(part) {
+			flush()
+			continue
+		}
+
+		if len(current) > 0 {
+			tail := current[len(current)-1]
+			gap := part.StartTime.Sub(tail.EndTime)
+			compatible := isMediaCompatible(probe(&tail), probe(part))
+			fits := bili.CalculateChunkCount(currentSize+part.FileSize, chunkSize) <= maxChunksPerPart
+			if gap > time.Duration(room.MergeMaxGap)*time.Second || !compatible || !fits {
+				flush()
+			}
+		}
+		current = append(current, *part)
+		currentSize += part.FileSize
+		lastIdx = i
+	}
+
+	// 末尾的短分P之后只有正在录制的分P（或直播仍在继续）时，可能还会有新碎片，暂缓上传
+	if len(current) > 0 {
+		trailing := true
+		for j := lastIdx + 1; j < len(parts); j++ {
+			if !parts[j].Recording {
+				trailing = false
+				break
+			}
+		}
+		if trailing && (history.Recording || lastIdx < len(parts)-1) {
+			for _, part := range current {
+				deferred[part.ID] = true
+			}
+			current = nil
+		}
+	}
+	flush()
+
+	for _, group := range groups {
+		if _, err := s.MergeParts(group, "连续短分P合并"); err != nil {
+			log.Printf("[分P合并] 合并失败: history_id=%d, 分P数=%d, %v", history.ID, len(group), err)
+		}
+	}
+
+	// 合并失败的分P同样标记为已检查，单独上传
+	var checked []uint
+	for _, part := range parts {
+		if !part.Recording && part.MergeState == MergeStatePending && !deferred[part.ID] {
+			checked = append(checked, part.ID)
+		}
+	}
+	if len(checked) > 0 {
+		db.Model(&models.RecordHistoryPart{}).
+			Where("id IN ? AND merge_state = ?", checked, MergeStatePending).
+			Update("merge_state", MergeStateChecked)
+	}
+
+	return deferred, nil
+}
+
+// isMediaCompatible 判断两个文件的编码参数是否一致，可以无损拼接
+func isMediaCompatible(a, b *MediaInfo) bool {
+	if a == nil || b == nil {
+		return false
+	}
+	return a.VideoCodec == b.VideoCodec &&
+		a.AudioCodec == b.AudioCodec &&
+		a.Width == b.Width &&
+		a.Height == b.Height &&
+		a.SampleRate == b.SampleRate &&
+		a.Channels == b.Channels
+}
+
+// concatFiles 使用ffmpeg concat demuxer无损拼接文件
+func (s *PartMergeService) concatFiles(inputs []string, outputFile string) error {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		return fmt.Errorf("ffmpeg未安装或不在PATH中: %w", err)
+	}
+
+	listFile := outputFile + ".concat.txt"
+	f, err := os.Create(listFile)
+	if err != nil {
+		return fmt.Errorf("创建concat列表失败: %w", err)
+	}
+	for _, input := range inputs {
+		// concat列表中单引号需要转义
+		fmt.Fprintf(f, "file '%s'\n", strings.ReplaceAll(input, "'", `'\''`))
+	}
+	f.Close()
+	defer os.Remove(listFile)
+
+	cmd := exec.Command("ffmpeg",
+		"-f", "concat",
+		"-safe", "0",
+		"-i", listFile,
+		"-c", "copy",
+		"-y",
+		outputFile,
+	)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		log.Printf("[分P合并] ffmpeg合并失败: %s, output: %s", err, string(output))
+		os.Remove(outputFile)
+		return fmt.Errorf("合并视频失败: %w", err)
+	}
+
+	return nil
+}
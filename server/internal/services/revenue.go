@@ -0,0 +1,127 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gobup/server/internal/database"
+	"github.com/gobup/server/internal/models"
+)
+
+// topSupporterLimit 统计中返回的最高贡献用户数
+const topSupporterLimit = 10
+
+// Supporter 贡献用户
+type Supporter struct {
+	UserName string  `json:"userName"`
+	UID      int64   `json:"uid"`
+	Amount   float64 `json:"amount"` // 贡献金额（元）
+}
+
+// SessionRevenue 单场直播收益统计
+type SessionRevenue struct {
+	SessionID     string      `json:"sessionId"`
+	TotalRevenue  float64     `json:"totalRevenue"` // 总收益（元）
+	GiftRevenue   float64     `json:"giftRevenue"`
+	SCRevenue     float64     `json:"scRevenue"`
+	GuardRevenue  float64     `json:"guardRevenue"`
+	GiftCount     int         `json:"giftCount"`  // 礼物个数
+	SCCount       int         `json:"scCount"`    // SC条数
+	GuardCount    int         `json:"guardCount"` // 上舰次数
+	TopSupporters []Supporter `json:"topSupporters"`
+}
+
+// RevenueService 直播收益统计服务
+type RevenueService struct{}
+
+func NewRevenueService() *RevenueService {
+	return &RevenueService{}
+}
+
+// GetSessionRevenue 统计单场直播的礼物、SC、上舰收益及贡献排行
+func (s *RevenueService) GetSessionRevenue(sessionID string) (*SessionRevenue, error) {
+	db := database.GetDB()
+	stats := &SessionRevenue{SessionID: sessionID, TopSupporters: []Supporter{}}
+
+	var rows []struct {
+		Type  string
+		Count int
+		Total float64
+	}
+	if err := db.Model(&models.LiveEvent{}).
+		Select("type, SUM(count) AS count, SUM(price) AS total").
+		Where("session_id = ?", sessionID).
+		Group("type").
+		Scan(&rows).Error; err != nil {
+		return nil, fmt.Errorf("统计收益失败: %w", err)
+	}
+
+	for _, row := range rows {
+		switch row.Type {
+		case "gift":
+			stats.GiftCount, stats.GiftRevenue = row.Count, row.Total
+		case "sc":
+			stats.SCCount, stats.SCRevenue = row.Count, row.Total
+		case "guard":
+			stats.GuardCount, stats.GuardRevenue = row.Count, row.Total
+		}
+		stats.TotalRevenue += row.Total
+	}
+
+	if err := db.Model(&models.LiveEvent{}).
+		Select("user_name, MAX(uid) AS uid, SUM(price) AS amount").
+		Where("session_id = ? AND price > 0", sessionID).
+		Group("user_name").
+		Order("amount DESC").
+		Limit(topSupporterLimit).
+		Scan(&stats.TopSupporters).Error; err != nil {
+		return nil, fmt.Errorf("统计贡献排行失败: %w", err)
+	}
+
+	return stats, nil
+}
+
+// GetRevenueBySessions 批量统计多场直播的总收益
+func (s *RevenueService) GetRevenueBySessions(sessionIDs []string) map[string]float64 {
+	result := make(map[string]float64)
+	if len(sessionIDs) == 0 {
+		return result
+	}
+
+	var rows []struct {
+		SessionID string
+		Total     float64
+	}
+	database.GetDB().Model(&models.LiveEvent{}).
+		Select("session_id, SUM(price) AS total").
+		Where("session_id IN ?", sessionIDs).
+		Group("session_id").
+		Scan(&rows)
+
+	for _, row := range rows {
+		result[row.SessionID] = row.Total
+	}
+	return result
+}
+
+// AddTemplateData 将收益统计加入模板变量，供简介、动态等模板使用
+func (s *RevenueService) AddTemplateData(data map[string]interface{}, sessionID string) {
+	stats, err := s.GetSessionRevenue(sessionID)
+	if err != nil {
+		return
+	}
+
+	var names []string
+	for i, supporter := range stats.TopSupporters {
+		if i >= 3 {
+			break
+		}
+		names = append(names, supporter.UserName)
+	}
+
+	data["revenue"] = fmt.Sprintf("%.2f", stats.TotalRevenue)
+	data["scCount"] = stats.SCCount
+	data["guardCount"] = stats.GuardCount
+	data["giftCount"] = stats.GiftCount
+	data["topSupporters"] = strings.Join(names, "、")
+}
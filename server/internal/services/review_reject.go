@@ -0,0 +1,357 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gobup/server/internal/bili"
+	"github.com/gobup/server/internal/database"
+	"github.com/gobup/server/internal/models"
+)
+
+// 审核退回处理方式
+const (
+	RejectRemediationNotify = 0 // 仅通知
+	RejectRemediationDrop   = 1 // 删除违规分P
+	RejectRemediationCut    = 2 // 剪除违规片段后重传该分P
+)
+
+// 退回处理状态
+const (
+	RemediationStatusNone       = 0
+	RemediationStatusPending    = 1
+	RemediationStatusProcessing = 2
+	RemediationStatusResubmit   = 3
+	RemediationStatusFailed     = 4
+	RemediationStatusNotified   = 5
+)
+
+// MaxRemediationCount 同一稿件最多自动处理次数，避免反复退回时无限循环
+const MaxRemediationCount = 3
+
+// RejectRange 违规分P及时间段（秒）
+// Start/End 均为0表示整个分P违规
+type RejectRange struct {
+	Page  int    `json:"page"`
+	CID   int64  `json:"cid"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+	Desc  string `json:"desc"`
+}
+
+// 匹配 "P1(00:06:49-00:07:01)"、"P2 12:30~13:00"、"【01:02:03-01:05:00】" 等形式
+var (
+	rejectRangeRegex = regexp.MustCompile(`(?:P(\d+)\s*[\(（【\[]?\s*)?((?:\d{1,2}:)?\d{1,2}:\d{2})\s*[-~－—至到]\s*((?:\d{1,2}:)?\d{1,2}:\d{2})`)
+	rejectPageRegex  = regexp.MustCompile(`P(\d+)`)
+)
+
+// ReviewRejectService 审核退回处理服务
+type ReviewRejectService struct {
+	wxPusher *WxPusherService
+}
+
+func NewReviewRejectService() *ReviewRejectService {
+	return &ReviewRejectService{
+		wxPusher: NewWxPusherService(),
+	}
+}
+
+// ParseRejectInfo 从稿件详情中解析退回原因和违规时间段
+func (s *ReviewRejectService) ParseRejectInfo(partInfo *bili.VideoPartInfo) (string, []RejectRange) {
+	var reasons []string
+	var ranges []RejectRange
+
+	if reason := strings.TrimSpace(partInfo.Archive.RejectReason); reason != "" {
+		reasons = append(reasons, reason)
+		ranges = append(ranges, ParseRejectRanges(reason, 0)...)
+	}
+
+	for _, video := range partInfo.Videos {
+		reason := strings.TrimSpace(video.RejectReason)
+		if reason == "" {
+			reason = strings.TrimSpace(video.FailDesc)
+		}
+		if reason == "" {
+			continue
+		}
+		reasons = append(reasons, fmt.Sprintf("P%d: %s", video.Page, reason))
+
+		videoRanges := ParseRejectRanges(reason, video.Page)
+		if len(videoRanges) == 0 {
+			// 分P被退回但没有给出具体时间段，视为整个分P违规
+			videoRanges = []RejectRange{{Page: video.Page, Desc: reason}}
+		}
+		for i := range videoRanges {
+			videoRanges[i].CID = video.CID
+		}
+		ranges = append(ranges, videoRanges...)
+	}
+
+	if len(reasons) == 0 && partInfo.Archive.StateDesc != "" {
+		reasons = append(reasons, partInfo.Archive.StateDesc)
+	}
+
+	return strings.Join(reasons, "\n"), ranges
+}
+
+// ParseRejectRanges 从退回原因文本中提取时间段，defaultPage为文本中未注明分P时使用的分P序号
+func ParseRejectRanges(text string, defaultPage int) []RejectRange {
+	var ranges []RejectRange
+	for _, match := range rejectRangeRegex.FindAllStringSubmatch(text, -1) {
+		page := defaultPage
+		if match[1] != "" {
+			page, _ = strconv.Atoi(match[1])
+		}
+		start := parseClockSeconds(match[2])
+		end := parseClockSeconds(match[3])
+		if end <= start {
+			continue
+		}
+		ranges = append(ranges, RejectRange{Page: page, Start: start, End: end, Desc: match[0]})
+	}
+
+	// 只提到分P没有时间段时，整P视为违规
+	if len(ranges) == 0 && defaultPage == 0 {
+		for _, match := range rejectPageRegex.FindAllStringSubmatch(text, -1) {
+			page, _ := strconv.Atoi(match[1])
+			if page > 0 {
+				ranges = append(ranges, RejectRange{Page: page, Desc: match[0]})
+			}
+		}
+	}
+	return ranges
+}
+
+// parseClockSeconds 将 HH:MM:SS 或 MM:SS 转换为秒
+func parseClockSeconds(clock string) int {
+	total := 0
+	for _, field := range strings.Split(clock, ":") {
+		v, _ := strconv.Atoi(field)
+		total = total*60 + v
+	}
+	return total
+}
+
+// RecordRejection 记录退回信息并推送通知，根据房间配置标记是否需要自动处理
+func (s *ReviewRejectService) RecordRejection(historyID uint) error {
+	db := database.GetDB()
+
+	var history models.RecordHistory
+	if err := db.First(&history, historyID).Error; err != nil {
+		return fmt.Errorf("历史记录不存在: %w", err)
+	}
+
+	var room models.RecordRoom
+	if err := db.Where("room_id = ?", history.RoomID).First(&room).Error; err != nil {
+		return fmt.Errorf("房间不存在: %w", err)
+	}
+
+	var user models.BiliBiliUser
+	if err := db.First(&user, room.UploadUserID).Error; err != nil {
+		return fmt.Errorf("上传用户不存在: %w", err)
+	}
+
+	client := bili.NewBiliClient(user.AccessKey, user.Cookies, user.UID)
+	partInfo, err := client.GetVideoPartInfo(history.BvID)
+	if err != nil {
+		return fmt.Errorf("获取稿件详情失败: %w", err)
+	}
+
+	reason, ranges := s.ParseRejectInfo(partInfo)
+	rangesJSON, _ := json.Marshal(ranges)
+
+	status := RemediationStatusNotified
+	msg := fmt.Sprintf("[%s] 审核退回: %s", time.Now().Format("2006-01-02 15:04:05"), reason)
+	if room.RejectRemediation != RejectRemediationNotify {
+		if history.RemediationCount >= MaxRemediationCount {
+			status = RemediationStatusFailed
+			msg += fmt.Sprintf("\n已自动处理%d次仍被退回，停止自动处理", history.RemediationCount)
+		} else {
+			status = RemediationStatusPending
+		}
+	}
+
+	updates := map[string]interface{}{
+		"reject_reason":      reason,
+		"reject_ranges":      string(rangesJSON),
+		"remediation_status": status,
+		"remediation_msg":    appendRemediationLog(history.RemediationMsg, msg),
+	}
+	if err := db.Model(&history).Updates(updates).Error; err != nil {
+		return fmt.Errorf("保存退回信息失败: %w", err)
+	}
+
+	log.Printf("[审核退回] history_id=%d, BV=%s, 原因=%s, 违规片段=%d", historyID, history.BvID, reason, len(ranges))
+
+	if room.Wxuid != "" && ContainsPushTag(room.PushMsgTags, "审核") {
+		s.wxPusher.NotifyReviewRejected(room.UploadUserID, room.Wxuid, history.Uname, history.BvID, reason, ranges)
+	}
+
+	return nil
+}
+
+// CheckRemediable 检查稿件当前是否可以执行自动处理
+// 只处理待处理或上次处理失败的稿件，且不超过自动处理次数上限
+func (s *ReviewRejectService) CheckRemediable(history *models.RecordHistory, room *models.RecordRoom) error {
+	if room.RejectRemediation == RejectRemediationNotify {
+		return fmt.Errorf("房间未开启审核退回自动处理")
+	}
+	switch history.RemediationStatus {
+	case RemediationStatusPending, RemediationStatusFailed:
+	case RemediationStatusProcessing:
+		return fmt.Errorf("稿件正在处理中")
+	default:
+		return fmt.Errorf("稿件没有待处理的审核退回")
+	}
+	if history.RemediationCount >= MaxRemediationCount {
+		return fmt.Errorf("已自动处理%d次，超过上限，需要人工处理", history.RemediationCount)
+	}
+	return nil
+}
+
+// ClaimRemediation 将稿件标记为处理中，定时任务与手动触发同时处理同一稿件时只有一方能成功
+func (s *ReviewRejectService) ClaimRemediation(historyID uint) bool {
+	result := database.GetDB().Model(&models.RecordHistory{}).
+		Where("id = ? AND remediation_status IN ?", historyID, []int{RemediationStatusPending, RemediationStatusFailed}).
+		Update("remediation_status", RemediationStatusProcessing)
+	return result.Error == nil && result.RowsAffected > 0
+}
+
+// RecoverInterrupted 服务重启前未完成的自动处理标记为失败，可手动重新触发
+func (s *ReviewRejectService) RecoverInterrupted() {
+	db := database.GetDB()
+	var histories []models.RecordHistory
+	db.Where("remediation_status = ?", RemediationStatusProcessing).Find(&histories)
+	for _, history := range histories {
+		s.UpdateRemediation(history.ID, RemediationStatusFailed, "服务重启，自动处理中断")
+	}
+}
+
+// GetRejectRanges 读取历史记录中保存的违规片段
+func (s *ReviewRejectService) GetRejectRanges(history *models.RecordHistory) []RejectRange {
+	var ranges []RejectRange
+	if history.RejectRanges != "" {
+		json.Unmarshal([]byte(history.RejectRanges), &ranges)
+	}
+	return ranges
+}
+
+// UpdateRemediation 更新退回处理状态并追加处理记录
+func (s *ReviewRejectService) UpdateRemediation(historyID uint, status int, msg string) {
+	db := database.GetDB()
+	var history models.RecordHistory
+	if err := db.First(&history, historyID).Error; err != nil {
+		return
+	}
+
+	line := fmt.Sprintf("[%s] %s", time.Now().Format("2006-01-02 15:04:05"), msg)
+	db.Model(&history).Updates(map[string]interface{}{
+		"remediation_status": status,
+		"remediation_msg":    appendRemediationLog(history.RemediationMsg, line),
+	})
+	log.Printf("[审核退回] history_id=%d: %s", historyID, msg)
+}
+
+// CutOutRanges 使用ffmpeg从视频中剪除指定时间段（秒），duration为0时最后一段截取到文件结尾
+// 保留片段以流复制方式截取，只能从关键帧开始，因此每个违规片段的结束位置顺延到其后的第一个关键帧，
+// 避免关键帧之前的违规画面随保留片段一起保留下来
+func (s *ReviewRejectService) CutOutRanges(inputFile, outputFile string, duration int, ranges []RejectRange) error {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		return fmt.Errorf("ffmpeg未安装或不在PATH中: %w", err)
+	}
+
+	mediaSvc := NewMediaService()
+	info, err := mediaSvc.ProbeMediaInfo(inputFile)
+	if err != nil {
+		return fmt.Errorf("获取视频信息失败: %w", err)
+	}
+
+	// 计算需要保留的片段，end<0 表示到文件结尾
+	type keepSegment struct{ start, end float64 }
+	var keeps []keepSegment
+	cursor := 0.0
+	toEnd := true
+	for _, r := range sortRejectRanges(ranges) {
+		start, end := float64(r.Start), float64(r.End)
+		if start > cursor {
+			keeps = append(keeps, keepSegment{cursor, start})
+		}
+		if end <= cursor {
+			continue
+		}
+		keyframe, found, err := mediaSvc.NextKeyframe(inputFile, info, end)
+		if err != nil {
+			return fmt.Errorf("查找关键帧失败: %w", err)
+		}
+		if !found {
+			// 违规片段之后没有关键帧，剩余内容全部舍弃
+			toEnd = false
+			break
+		}
+		cursor = keyframe
+	}
+	if toEnd && (duration == 0 || cursor < float64(duration)) {
+		keeps = append(keeps, keepSegment{cursor, -1})
+	}
+	if len(keeps) == 0 {
+		return fmt.Errorf("剪除后没有剩余内容")
+	}
+
+	var tempFiles []string
+	cleanup := func() {
+		for _, tf := range tempFiles {
+			os.Remove(tf)
+		}
+	}
+
+	base := strings.TrimSuffix(outputFile, filepath.Ext(outputFile))
+	for i, seg := range keeps {
+		tempFile := fmt.Sprintf("%s.keep%d%s", base, i, filepath.Ext(outputFile))
+		tempFiles = append(tempFiles, tempFile)
+
+		args := []string{"-ss", strconv.FormatFloat(seg.start, 'f', -1, 64), "-i", inputFile}
+		if seg.end >= 0 {
+			args = append(args, "-t", strconv.FormatFloat(seg.end-seg.start, 'f', 3, 64))
+		}
+		args = append(args, "-c", "copy", "-avoid_negative_ts", "1", "-y", tempFile)
+
+		cmd := exec.Command("ffmpeg", args...)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			log.Printf("[审核退回] ffmpeg剪辑失败: %s, output: %s", err, string(output))
+			cleanup()
+			return fmt.Errorf("剪辑保留片段%d失败: %w", i, err)
+		}
+	}
+
+	if err := NewHighEnergyCutService().concatenateVideos(tempFiles, outputFile); err != nil {
+		cleanup()
+		return err
+	}
+	cleanup()
+	return nil
+}
+
+// sortRejectRanges 按开始时间排序
+func sortRejectRanges(ranges []RejectRange) []RejectRange {
+	sorted := append([]RejectRange(nil), ranges...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Start < sorted[j].Start
+	})
+	return sorted
+}
+
+func appendRemediationLog(existing, line string) string {
+	if existing == "" {
+		return line
+	}
+	return existing + "\n" + line
+}
@@ -0,0 +1,202 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gobup/server/internal/bili"
+	"github.com/gobup/server/internal/database"
+	"github.com/gobup/server/internal/models"
+)
+
+// 合集小节规则
+const (
+	SeasonSectionRuleDefault = "default" // SeasonID 直接作为小节ID
+	SeasonSectionRuleMonth   = "month"   // 每月一个小节，如 2026年10月
+	SeasonSectionRuleArea    = "area"    // 每个直播分区一个小节
+	SeasonSectionRuleCustom  = "custom"  // 按自定义模板生成小节标题
+)
+
+// SeasonService 合集小节管理服务
+type SeasonService struct {
+	templateSvc *TemplateService
+}
+
+func NewSeasonService() *SeasonService {
+	return &SeasonService{
+		templateSvc: NewTemplateService(),
+	}
+}
+
+// seasonSortDelay 加入合集到重新排序之间的等待时间，刚加入的视频可能还未出现在小节列表中
+const seasonSortDelay = 2 * time.Second
+
+// SeasonEnabled 房间是否配置了合集：default规则需要小节ID，其他规则需要合集ID
+func SeasonEnabled(room *models.RecordRoom) bool {
+	if room.SeasonSectionRule == "" || room.SeasonSectionRule == SeasonSectionRuleDefault {
+		return room.SeasonID > 0
+	}
+	return room.CollectionID > 0
+}
+
+// AddHistoryToSeason 按房间的小节规则将投稿加入合集，必要时创建小节，并在后台按直播时间重新排序
+func (s *SeasonService) AddHistoryToSeason(client *bili.BiliClient, room *models.RecordRoom, history *models.RecordHistory, aid, cid int64, title string) error {
+	if !SeasonEnabled(room) {
+		return nil
+	}
+
+	sectionID, err := s.ResolveSection(client, room, history)
+	if err != nil {
+		return err
+	}
+
+	if err := client.AddToSeason(sectionID, aid, cid, title); err != nil {
+		return err
+	}
+	log.Printf("[合集] 加入合集成功: SectionID=%d, AID=%d", sectionID, aid)
+
+	if room.SeasonSortByLive {
+		// 排序不影响投稿结果，不阻塞投稿流程
+		go func() {
+			time.Sleep(seasonSortDelay)
+			if err := s.SortSectionByLiveTime(client, sectionID); err != nil {
+				log.Printf("[合集] ⚠️ 小节排序失败: SectionID=%d, 错误=%v", sectionID, err)
+			}
+		}()
+	}
+
+	return nil
+}
+
+// ResolveSection 根据规则获取目标小节ID，小节不存在时在合集下自动创建
+func (s *SeasonService) ResolveSection(client *bili.BiliClient, room *models.RecordRoom, history *models.RecordHistory) (int64, error) {
+	sectionTitle := s.RenderSectionTitle(room, history)
+	if sectionTitle == "" {
+		if room.SeasonID <= 0 {
+			return 0, fmt.Errorf("未配置小节ID")
+		}
+		return room.SeasonID, nil
+	}
+
+	sections, err := client.GetSeasonSections(room.CollectionID)
+	if err != nil {
+		return 0, err
+	}
+	for _, section := range sections {
+		if section.Title == sectionTitle {
+			return section.ID, nil
+		}
+	}
+
+	sectionID, err := client.CreateSeasonSection(room.CollectionID, sectionTitle)
+	if err != nil {
+		return 0, err
+	}
+	log.Printf("[合集] 创建小节: CollectionID=%d, 标题=%s", room.CollectionID, sectionTitle)
+
+	// 部分情况下接口不返回新小节ID，重新查询一次
+	if sectionID <= 0 {
+		sections, err := client.GetSeasonSections(room.CollectionID)
+		if err != nil {
+			return 0, err
+		}
+		for _, section := range sections {
+			if section.Title == sectionTitle {
+				sectionID = section.ID
+				break
+			}
+		}
+		if sectionID <= 0 {
+			return 0, fmt.Errorf("创建小节后未找到小节: %s", sectionTitle)
+		}
+	}
+
+	return sectionID, nil
+}
+
+// RenderSectionTitle 计算小节标题，返回空字符串表示直接使用 SeasonID 作为小节
+func (s *SeasonService) RenderSectionTitle(room *models.RecordRoom, history *models.RecordHistory) string {
+	switch room.SeasonSectionRule {
+	case SeasonSectionRuleMonth:
+		return history.StartTime.Format("2006年01月")
+	case SeasonSectionRuleArea:
+		areaName := history.AreaName
+		if areaName == "" {
+			areaName = room.AreaName
+		}
+		if areaName == "" {
+			areaName = "其他"
+		}
+		return areaName
+	case SeasonSectionRuleCustom:
+		if room.SeasonSectionTpl == "" {
+			return ""
+		}
+		data := map[string]interface{}{
+			"uname":     history.Uname,
+			"title":     history.Title,
+			"roomId":    room.RoomID,
+			"areaName":  history.AreaName,
+			"startTime": history.StartTime,
+		}
+		return strings.TrimSpace(s.templateSvc.render(room.SeasonSectionTpl, data))
+	default:
+		return ""
+	}
+}
+
+// SortSectionByLiveTime 按直播开始时间对小节内视频重新排序
+// 非本系统投稿的视频跟随其前一个视频，保持相对位置
+func (s *SeasonService) SortSectionByLiveTime(client *bili.BiliClient, sectionID int64) error {
+	section, episodes, err := client.GetSectionEpisodes(sectionID)
+	if err != nil {
+		return err
+	}
+	if len(episodes) < 2 {
+		return nil
+	}
+
+	db := database.GetDB()
+	type sortItem struct {
+		episode bili.SeasonEpisode
+		key     time.Time
+	}
+
+	items := make([]sortItem, 0, len(episodes))
+	var lastKey time.Time
+	for _, ep := range episodes {
+		key := lastKey
+		var history models.RecordHistory
+		if err := db.Select("start_time").Where("av_id = ?", strconv.FormatInt(ep.Aid, 10)).First(&history).Error; err == nil {
+			key = history.StartTime
+		}
+		items = append(items, sortItem{episode: ep, key: key})
+		lastKey = key
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].key.Before(items[j].key)
+	})
+
+	changed := false
+	sorts := make([]bili.SectionEpisodeSort, 0, len(items))
+	for i, item := range items {
+		if item.episode.ID != episodes[i].ID {
+			changed = true
+		}
+		sorts = append(sorts, bili.SectionEpisodeSort{ID: item.episode.ID, Sort: i + 1})
+	}
+	if !changed {
+		return nil
+	}
+
+	if err := client.SortSectionEpisodes(*section, sorts); err != nil {
+		return err
+	}
+	log.Printf("[合集] 小节已按直播时间排序: SectionID=%d, 视频数=%d", sectionID, len(sorts))
+	return nil
+}
@@ -0,0 +1,225 @@
+package services
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+// FLV标签类型
+const (
+	flvTagAudio  = 8
+	flvTagVideo  = 9
+	flvTagScript = 18
+)
+
+// flvMaxTagSize 单个标签的最大长度，超过时认为数据流已损坏
+const flvMaxTagSize = 16 << 20
+
+// flvSegmenter 读取HTTP-FLV直播流，按大小或时长在视频关键帧处切分为独立可播放的FLV文件
+// 每个分段开头重新写入FLV头、onMetaData和音视频编码参数，时间戳从0开始
+type flvSegmenter struct {
+	maxSize     int64  // 分段大小上限（字节），0不限制
+	maxDuration uint32 // 分段时长上限（毫秒），0不限制
+
+	open  func() (io.Writer, error)    // 开始新分段
+	close func(duration time.Duration) // 结束当前分段
+
+	header   []byte // FLV文件头
+	metadata []byte // onMetaData脚本数据
+	videoSeq []byte // 视频编码参数（AVC/HEVC sequence header）
+	audioSeq []byte // 音频编码参数（AAC sequence header）
+
+	w       io.Writer
+	written int64
+	baseTS  uint32
+	lastTS  uint32
+}
+
+// Run 读取直播流直到结束或出错，返回时结束当前分段
+func (f *flvSegmenter) Run(r io.Reader) error {
+	br := bufio.NewReaderSize(r, 64*1024)
+
+	header := make([]byte, 9)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return fmt.Errorf("读取FLV头失败: %w", err)
+	}
+	if string(header[:3]) != "FLV" {
+		return fmt.Errorf("不是FLV数据流")
+	}
+	offset := binary.BigEndian.Uint32(header[5:9])
+	if offset < 9 {
+		return fmt.Errorf("FLV头长度错误: %d", offset)
+	}
+	// 跳过扩展头和第一个PreviousTagSize
+	if _, err := br.Discard(int(offset-9) + 4); err != nil {
+		return fmt.Errorf("读取FLV头失败: %w", err)
+	}
+	binary.BigEndian.PutUint32(header[5:9], 9)
+	f.header = header
+
+	defer f.endSegment()
+
+	tagHeader := make([]byte, 11)
+	for {
+		if _, err := io.ReadFull(br, tagHeader); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		tagType := tagHeader[0] & 0x1f
+		size := uint32(tagHeader[1])<<16 | uint32(tagHeader[2])<<8 | uint32(tagHeader[3])
+		ts := uint32(tagHeader[7])<<24 | uint32(tagHeader[4])<<16 | uint32(tagHeader[5])<<8 | uint32(tagHeader[6])
+		if size > flvMaxTagSize {
+			return fmt.Errorf("FLV标签长度异常: %d", size)
+		}
+
+		data := make([]byte, size)
+		if _, err := io.ReadFull(br, data); err != nil {
+			return err
+		}
+		if _, err := br.Discard(4); err != nil {
+			return err
+		}
+
+		if err := f.handleTag(tagType, ts, data); err != nil {
+			return err
+		}
+	}
+}
+
+// handleTag 缓存编码参数，在关键帧处按需切分，然后写入当前分段
+func (f *flvSegmenter) handleTag(tagType byte, ts uint32, data []byte) error {
+	switch tagType {
+	case flvTagScript:
+		f.metadata = data
+		return nil
+	case flvTagVideo:
+		if flvVideoSequenceHeader(data) {
+			f.videoSeq = data
+		} else if flvVideoKeyframe(data) && (f.w == nil || f.shouldSplit(ts)) {
+			if err := f.startSegment(ts); err != nil {
+				return err
+			}
+		}
+	case flvTagAudio:
+		if flvAACSequenceHeader(data) {
+			f.audioSeq = data
+		} else if f.videoSeq == nil && (f.w == nil || f.shouldSplit(ts)) {
+			// 纯音频流没有关键帧，任意位置都可以切分
+			if err := f.startSegment(ts); err != nil {
+				return err
+			}
+		}
+	default:
+		return nil
+	}
+
+	// 第一个关键帧之前的数据无法播放，直接丢弃
+	if f.w == nil {
+		return nil
+	}
+	return f.writeTag(tagType, ts, data)
+}
+
+// shouldSplit 当前分段是否达到大小或时长上限
+func (f *flvSegmenter) shouldSplit(ts uint32) bool {
+	if f.maxSize > 0 && f.written >= f.maxSize {
+		return true
+	}
+	return f.maxDuration > 0 && ts > f.baseTS && ts-f.baseTS >= f.maxDuration
+}
+
+// startSegment 结束当前分段并开始新分段
+func (f *flvSegmenter) startSegment(ts uint32) error {
+	f.endSegment()
+
+	w, err := f.open()
+	if err != nil {
+		return err
+	}
+	f.w = w
+	f.written = 0
+	f.baseTS = ts
+	f.lastTS = ts
+
+	if _, err := w.Write(append(append([]byte{}, f.header...), 0, 0, 0, 0)); err != nil {
+		return err
+	}
+	f.written += int64(len(f.header)) + 4
+	if f.metadata != nil {
+		if err := f.writeTag(flvTagScript, ts, f.metadata); err != nil {
+			return err
+		}
+	}
+	if f.videoSeq != nil {
+		if err := f.writeTag(flvTagVideo, ts, f.videoSeq); err != nil {
+			return err
+		}
+	}
+	if f.audioSeq != nil {
+		if err := f.writeTag(flvTagAudio, ts, f.audioSeq); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// endSegment 结束当前分段
+func (f *flvSegmenter) endSegment() {
+	if f.w == nil {
+		return
+	}
+	f.w = nil
+	f.close(time.Duration(f.lastTS-f.baseTS) * time.Millisecond)
+}
+
+// writeTag 以分段起点为0重写时间戳后写入标签
+func (f *flvSegmenter) writeTag(tagType byte, ts uint32, data []byte) error {
+	if ts > f.lastTS {
+		f.lastTS = ts
+	}
+	var rel uint32
+	if ts > f.baseTS {
+		rel = ts - f.baseTS
+	}
+
+	size := uint32(len(data))
+	buf := make([]byte, 11, 11+len(data)+4)
+	buf[0] = tagType
+	buf[1], buf[2], buf[3] = byte(size>>16), byte(size>>8), byte(size)
+	buf[4], buf[5], buf[6], buf[7] = byte(rel>>16), byte(rel>>8), byte(rel), byte(rel>>24)
+	buf = append(buf, data...)
+	buf = binary.BigEndian.AppendUint32(buf, 11+size)
+
+	if _, err := f.w.Write(buf); err != nil {
+		return err
+	}
+	f.written += int64(len(buf))
+	return nil
+}
+
+// flvVideoSequenceHeader 视频标签是否为编码参数，兼容Enhanced FLV的HEVC
+func flvVideoSequenceHeader(data []byte) bool {
+	if len(data) < 2 {
+		return false
+	}
+	if data[0]&0x80 != 0 {
+		return data[0]&0x0f == 0
+	}
+	codec := data[0] & 0x0f
+	return (codec == 7 || codec == 12) && data[1] == 0
+}
+
+// flvVideoKeyframe 视频标签是否为关键帧
+func flvVideoKeyframe(data []byte) bool {
+	return len(data) > 0 && (data[0]>>4)&0x07 == 1
+}
+
+// flvAACSequenceHeader 音频标签是否为AAC编码参数
+func flvAACSequenceHeader(data []byte) bool {
+	return len(data) >= 2 && data[0]>>4 == 10 && data[1] == 0
+}
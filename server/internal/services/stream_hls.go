@@ -0,0 +1,217 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// hlsPlaylist 解析后的HLS播放列表
+type hlsPlaylist struct {
+	targetDuration float64
+	initURL        string // fMP4的初始化分片
+	segments       []hlsSegment
+	endList        bool
+	variant        string // 主播放列表中的第一个子播放列表
+}
+
+// hlsSegment HLS分片
+type hlsSegment struct {
+	seq      int64
+	url      string
+	duration float64
+}
+
+// parseHLSPlaylist 解析m3u8内容，相对地址按播放列表地址补全
+func parseHLSPlaylist(base *url.URL, body string) *hlsPlaylist {
+	playlist := &hlsPlaylist{}
+	resolve := func(ref string) string {
+		u, err := base.Parse(ref)
+		if err != nil {
+			return ref
+		}
+		return u.String()
+	}
+
+	var seq int64
+	var duration float64
+	streamInf := false
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"):
+			seq, _ = strconv.ParseInt(strings.TrimPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"), 10, 64)
+		case strings.HasPrefix(line, "#EXT-X-TARGETDURATION:"):
+			playlist.targetDuration, _ = strconv.ParseFloat(strings.TrimPrefix(line, "#EXT-X-TARGETDURATION:"), 64)
+		case strings.HasPrefix(line, "#EXT-X-MAP:"):
+			for _, attr := range strings.Split(strings.TrimPrefix(line, "#EXT-X-MAP:"), ",") {
+				if key, value, ok := strings.Cut(attr, "="); ok && key == "URI" {
+					playlist.initURL = resolve(strings.Trim(value, `"`))
+				}
+			}
+		case strings.HasPrefix(line, "#EXTINF:"):
+			value, _, _ := strings.Cut(strings.TrimPrefix(line, "#EXTINF:"), ",")
+			duration, _ = strconv.ParseFloat(value, 64)
+		case strings.HasPrefix(line, "#EXT-X-STREAM-INF"):
+			streamInf = true
+		case line == "#EXT-X-ENDLIST":
+			playlist.endList = true
+		case strings.HasPrefix(line, "#"):
+		default:
+			if streamInf {
+				if playlist.variant == "" {
+					playlist.variant = resolve(line)
+				}
+				streamInf = false
+				continue
+			}
+			playlist.segments = append(playlist.segments, hlsSegment{seq: seq, url: resolve(line), duration: duration})
+			seq++
+			duration = 0
+		}
+	}
+	return playlist
+}
+
+// hlsSegmentWriter 按分片顺序写入录制文件，在分片边界处按大小或时长切分
+type hlsSegmentWriter struct {
+	maxSize     int64
+	maxDuration time.Duration
+
+	open  func() (io.Writer, error)
+	close func(duration time.Duration)
+
+	initData []byte
+	w        io.Writer
+	written  int64
+	duration time.Duration
+}
+
+// write 写入一个分片
+func (h *hlsSegmentWriter) write(data []byte, duration time.Duration) error {
+	if h.w != nil && ((h.maxSize > 0 && h.written >= h.maxSize) || (h.maxDuration > 0 && h.duration >= h.maxDuration)) {
+		h.end()
+	}
+	if h.w == nil {
+		w, err := h.open()
+		if err != nil {
+			return err
+		}
+		h.w = w
+		h.written = 0
+		h.duration = 0
+		// fMP4的每个文件都需要以初始化分片开头
+		if h.initData != nil {
+			if _, err := w.Write(h.initData); err != nil {
+				return err
+			}
+			h.written += int64(len(h.initData))
+		}
+	}
+	if _, err := h.w.Write(data); err != nil {
+		return err
+	}
+	h.written += int64(len(data))
+	h.duration += duration
+	return nil
+}
+
+// end 结束当前文件
+func (h *hlsSegmentWriter) end() {
+	if h.w == nil {
+		return
+	}
+	h.w = nil
+	h.close(h.duration)
+}
+
+// recordHLS 轮询HLS播放列表，按顺序下载新分片写入录制文件
+func (s *streamRecordSession) recordHLS(ctx context.Context, playlistURL string, writer *hlsSegmentWriter) error {
+	defer writer.end()
+
+	lastSeq := int64(-1)
+	lastNew := time.Now()
+	for {
+		body, err := s.fetch(ctx, playlistURL)
+		if err != nil {
+			return fmt.Errorf("获取播放列表失败: %w", err)
+		}
+		base, err := url.Parse(playlistURL)
+		if err != nil {
+			return err
+		}
+		playlist := parseHLSPlaylist(base, string(body))
+		if playlist.variant != "" && len(playlist.segments) == 0 {
+			playlistURL = playlist.variant
+			continue
+		}
+
+		if playlist.initURL != "" && writer.initData == nil {
+			initData, err := s.fetch(ctx, playlist.initURL)
+			if err != nil {
+				return fmt.Errorf("获取初始化分片失败: %w", err)
+			}
+			writer.initData = initData
+		}
+
+		for _, segment := range playlist.segments {
+			if segment.seq <= lastSeq {
+				continue
+			}
+			data, err := s.fetch(ctx, segment.url)
+			if err != nil {
+				return fmt.Errorf("下载分片失败: %w", err)
+			}
+			if err := writer.write(data, time.Duration(segment.duration*float64(time.Second))); err != nil {
+				return err
+			}
+			lastSeq = segment.seq
+			lastNew = time.Now()
+		}
+
+		if playlist.endList {
+			return nil
+		}
+		if time.Since(lastNew) > streamRecordIdleTimeout {
+			return fmt.Errorf("直播流超过%d秒没有新分片", int(streamRecordIdleTimeout.Seconds()))
+		}
+
+		interval := time.Duration(playlist.targetDuration * float64(time.Second) / 2)
+		if interval < time.Second {
+			interval = time.Second
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// fetch 下载播放列表或分片
+func (s *streamRecordSession) fetch(ctx context.Context, rawURL string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, streamRecordIdleTimeout)
+	defer cancel()
+
+	req, err := newStreamRequest(ctx, rawURL)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := streamHTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return io.ReadAll(resp.Body)
+}
@@ -0,0 +1,459 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gobup/server/internal/bili"
+	"github.com/gobup/server/internal/database"
+	"github.com/gobup/server/internal/models"
+)
+
+const (
+	streamRecordRetryDelay    = 5 * time.Second  // 断流后重新获取直播流的初始间隔
+	streamRecordMaxRetryDelay = time.Minute      // 连续失败时的最大重试间隔
+	streamRecordIdleTimeout   = 30 * time.Second // 超过该时间没有数据视为断流
+)
+
+// streamHTTPClient 拉流使用的HTTP客户端，直播流长时间不结束，不能设置整体超时
+var streamHTTPClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		ResponseHeaderTimeout: 15 * time.Second,
+	},
+}
+
+// StreamRecorderService 内置直播录制：开播后直接拉取直播流写入文件，断流自动重连，
+// 按大小或时长分段，每个文件对应一个录制中的分P，结束后即可进入上传流程
+type StreamRecorderService struct {
+	mu       sync.Mutex
+	sessions map[string]*streamRecordSession // key: RecordRoom.RoomID
+
+	// ResolveStream 获取房间的直播流地址，默认请求B站接口
+	ResolveStream func(room *models.RecordRoom) (*bili.LiveStreamURL, error)
+}
+
+// streamRecordSession 单个房间的直播流录制
+type streamRecordSession struct {
+	room     models.RecordRoom
+	history  models.RecordHistory
+	resolve  func(room *models.RecordRoom) (*bili.LiveStreamURL, error)
+	cancel   context.CancelFunc
+	done     chan struct{}
+	stopOnce sync.Once
+
+	// 当前分P，只在录制协程中访问
+	part *models.RecordHistoryPart
+	file *os.File
+	out  *bufio.Writer
+}
+
+var (
+	streamRecorderService     *StreamRecorderService
+	streamRecorderServiceOnce sync.Once
+)
+
+// GetStreamRecorderService 获取内置直播录制服务（单例）
+func GetStreamRecorderService() *StreamRecorderService {
+	streamRecorderServiceOnce.Do(func() {
+		streamRecorderService = &StreamRecorderService{
+			sessions:      make(map[string]*streamRecordSession),
+			ResolveStream: resolveLiveStream,
+		}
+	})
+	return streamRecorderService
+}
+
+// Start 开始录制房间直播流，已在录制时跳过
+func (s *StreamRecorderService) Start(room *models.RecordRoom) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.sessions[room.RoomID]; ok {
+		return nil
+	}
+
+	history, err := liveSessionHistory(room, time.Now())
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	session := &streamRecordSession{
+		room:    *room,
+		history: *history,
+		resolve: s.ResolveStream,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+	s.sessions[room.RoomID] = session
+	go session.run(ctx)
+
+	log.Printf("[直播录制] 房间 %s 开始录制: history_id=%d", room.RoomID, history.ID)
+	return nil
+}
+
+// Stop 结束房间的直播录制，等待当前文件写完，live为false表示直播已结束
+func (s *StreamRecorderService) Stop(roomID string, live bool) {
+	s.mu.Lock()
+	session, ok := s.sessions[roomID]
+	s.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	// 文件收尾期间仍视为录制中，避免弹幕录制提前结束历史记录
+	session.stop()
+
+	s.mu.Lock()
+	if s.sessions[roomID] == session {
+		delete(s.sessions, roomID)
+	}
+	s.mu.Unlock()
+
+	if live {
+		log.Printf("[直播录制] 房间 %s 录制暂停: history_id=%d", roomID, session.history.ID)
+		return
+	}
+	finishLiveSession(&session.history, time.Now())
+	log.Printf("[直播录制] 房间 %s 录制结束: history_id=%d", roomID, session.history.ID)
+}
+
+// StopAll 结束所有录制，用于服务退出，录制中的历史记录保留录制状态以便重启后续录
+func (s *StreamRecorderService) StopAll() {
+	s.mu.Lock()
+	sessions := s.sessions
+	s.sessions = make(map[string]*streamRecordSession)
+	s.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, session := range sessions {
+		wg.Add(1)
+		go func(session *streamRecordSession) {
+			defer wg.Done()
+			session.stop()
+		}(session)
+	}
+	wg.Wait()
+}
+
+// Recording 房间是否正在录制直播流
+func (s *StreamRecorderService) Recording(roomID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.sessions[roomID]
+	return ok
+}
+
+// RoomIDs 正在录制直播流的房间
+func (s *StreamRecorderService) RoomIDs() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	roomIDs := make([]string, 0, len(s.sessions))
+	for roomID := range s.sessions {
+		roomIDs = append(roomIDs, roomID)
+	}
+	return roomIDs
+}
+
+// RecoverInterrupted 结束服务异常退出时仍处于录制状态的分P，历史记录保持录制状态，由开播状态决定续录或结束
+func (s *StreamRecorderService) RecoverInterrupted() {
+	finishInterruptedStreamParts(0)
+}
+
+// resolveLiveStream 通过B站接口获取直播流地址，使用房间上传账号的登录信息以获取原画
+func resolveLiveStream(room *models.RecordRoom) (*bili.LiveStreamURL, error) {
+	info, err := NewLiveStatusService().GetRoomInfo(room.RoomID)
+	if err != nil {
+		return nil, err
+	}
+	if info.Data.RoomID == 0 {
+		return nil, fmt.Errorf("获取真实房间号失败")
+	}
+
+	cookies := ""
+	if user := loginUser(room.UploadUserID); user != nil {
+		cookies = user.Cookies
+	}
+	qn := room.StreamQuality
+	if qn <= 0 {
+		qn = 10000
+	}
+	urls, err := bili.GetLiveStreamURLs(info.Data.RoomID, qn, cookies)
+	if err != nil {
+		return nil, err
+	}
+	return pickLiveStream(urls), nil
+}
+
+// pickLiveStream 选择直播流：优先AVC编码（兼容性最好），其次FLV、TS、fMP4，避开不稳定的mcdn节点
+func pickLiveStream(urls []bili.LiveStreamURL) *bili.LiveStreamURL {
+	score := func(u *bili.LiveStreamURL) int {
+		n := 0
+		if u.Codec != "avc" {
+			n += 100
+		}
+		switch u.Format {
+		case "flv":
+		case "ts":
+			n += 10
+		default:
+			n += 20
+		}
+		if strings.Contains(u.URL, ".mcdn.") {
+			n++
+		}
+		return n
+	}
+
+	best := &urls[0]
+	for i := range urls {
+		if score(&urls[i]) < score(best) {
+			best = &urls[i]
+		}
+	}
+	return best
+}
+
+// stop 停止录制协程并等待当前文件写完
+func (s *streamRecordSession) stop() {
+	s.stopOnce.Do(s.cancel)
+	<-s.done
+}
+
+// run 录制循环：直播流结束或出错后重新获取地址继续录制，每次重连写入新文件
+func (s *streamRecordSession) run(ctx context.Context) {
+	defer close(s.done)
+
+	delay := streamRecordRetryDelay
+	for {
+		stream, err := s.resolve(&s.room)
+		if err != nil {
+			log.Printf("[直播录制] 房间 %s 获取直播流失败: %v", s.room.RoomID, err)
+		} else {
+			started := time.Now()
+			err = s.record(ctx, stream)
+			if ctx.Err() != nil {
+				return
+			}
+			// 录制了一段时间才断开的，不累计重试间隔
+			if time.Since(started) > streamRecordMaxRetryDelay {
+				delay = streamRecordRetryDelay
+			}
+			if err != nil {
+				log.Printf("[直播录制] 房间 %s 直播流中断: %v", s.room.RoomID, err)
+			} else {
+				log.Printf("[直播录制] 房间 %s 直播流结束", s.room.RoomID)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > streamRecordMaxRetryDelay {
+			delay = streamRecordMaxRetryDelay
+		}
+	}
+}
+
+// record 按直播流格式录制，直到直播流结束或出错
+func (s *streamRecordSession) record(ctx context.Context, stream *bili.LiveStreamURL) error {
+	maxSize := s.room.StreamSplitSize * 1024 * 1024
+	maxDuration := time.Duration(s.room.StreamSplitTime) * time.Minute
+	log.Printf("[直播录制] 房间 %s 开始拉流: 格式=%s, 编码=%s, 画质=%d", s.room.RoomID, stream.Format, stream.Codec, stream.Qn)
+
+	switch stream.Format {
+	case "flv":
+		return s.recordFLV(ctx, stream.URL, &flvSegmenter{
+			maxSize:     maxSize,
+			maxDuration: uint32(maxDuration.Milliseconds()),
+			open:        func() (io.Writer, error) { return s.openPart(".flv") },
+			close:       s.closePart,
+		})
+	case "ts", "fmp4":
+		ext := ".ts"
+		if stream.Format == "fmp4" {
+			ext = ".mp4"
+		}
+		return s.recordHLS(ctx, stream.URL, &hlsSegmentWriter{
+			maxSize:     maxSize,
+			maxDuration: maxDuration,
+			open:        func() (io.Writer, error) { return s.openPart(ext) },
+			close:       s.closePart,
+		})
+	}
+	return fmt.Errorf("不支持的直播流格式: %s", stream.Format)
+}
+
+// recordFLV 录制HTTP-FLV直播流
+func (s *streamRecordSession) recordFLV(ctx context.Context, streamURL string, segmenter *flvSegmenter) error {
+	reqCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	req, err := newStreamRequest(reqCtx, streamURL)
+	if err != nil {
+		return err
+	}
+	resp, err := streamHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+
+	idle := time.AfterFunc(streamRecordIdleTimeout, cancel)
+	defer idle.Stop()
+
+	err = segmenter.Run(&idleTimeoutReader{r: resp.Body, timer: idle})
+	if err != nil && ctx.Err() == nil && reqCtx.Err() != nil {
+		return fmt.Errorf("直播流超过%d秒没有数据", int(streamRecordIdleTimeout.Seconds()))
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+// newStreamRequest 创建拉流请求，B站直播CDN需要携带Referer
+func newStreamRequest(ctx context.Context, rawURL string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36")
+	req.Header.Set("Referer", "https://live.bilibili.com/")
+	return req, nil
+}
+
+// idleTimeoutReader 每次读到数据时重置计时器，计时器到期时取消请求
+type idleTimeoutReader struct {
+	r     io.Reader
+	timer *time.Timer
+}
+
+func (r *idleTimeoutReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.timer.Reset(streamRecordIdleTimeout)
+	}
+	return n, err
+}
+
+// openPart 创建新的录制文件和录制中的分P
+func (s *streamRecordSession) openPart(ext string) (io.Writer, error) {
+	db := database.GetDB()
+
+	// 分段时使用最新的直播间标题
+	room := s.room
+	db.Where("room_id = ?", room.RoomID).First(&room)
+
+	start := time.Now()
+	path := liveRecordPath(&room, start, ext)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("创建录制目录失败: %w", err)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("创建录制文件失败: %w", err)
+	}
+
+	part := &models.RecordHistoryPart{
+		HistoryID: s.history.ID,
+		RoomID:    room.RoomID,
+		SessionID: s.history.SessionID,
+		Title:     filepath.Base(path),
+		LiveTitle: room.Title,
+		AreaName:  room.AreaName,
+		FilePath:  path,
+		FileName:  filepath.Base(path),
+		StartTime: start,
+		EndTime:   start,
+		Recording: true,
+	}
+	if err := db.Create(part).Error; err != nil {
+		file.Close()
+		os.Remove(path)
+		return nil, fmt.Errorf("创建分P记录失败: %w", err)
+	}
+
+	s.part, s.file = part, file
+	s.out = bufio.NewWriterSize(file, 1<<20)
+	log.Printf("[直播录制] 房间 %s 写入新文件: part_id=%d, %s", room.RoomID, part.ID, part.FileName)
+	return s.out, nil
+}
+
+// closePart 写完当前文件并结束分P的录制状态
+func (s *streamRecordSession) closePart(duration time.Duration) {
+	if s.part == nil {
+		return
+	}
+	part, file, out := s.part, s.file, s.out
+	s.part, s.file, s.out = nil, nil, nil
+
+	if err := out.Flush(); err != nil {
+		log.Printf("[直播录制] 房间 %s 写入录制文件失败: %v", s.room.RoomID, err)
+	}
+	file.Close()
+	finishStreamPart(part, duration)
+}
+
+// finishStreamPart 记录分P的文件大小和时长并结束录制状态，没有写入画面的文件直接删除
+func finishStreamPart(part *models.RecordHistoryPart, duration time.Duration) {
+	db := database.GetDB()
+
+	info, err := os.Stat(part.FilePath)
+	if err != nil || info.Size() == 0 || duration <= 0 {
+		os.Remove(part.FilePath)
+		db.Delete(part)
+		log.Printf("[直播录制] 删除没有有效数据的录制文件: part_id=%d, %s", part.ID, part.FileName)
+		return
+	}
+
+	end := part.StartTime.Add(duration)
+	db.Model(part).Updates(map[string]interface{}{
+		"recording": false,
+		"file_size": info.Size(),
+		"duration":  int(duration.Seconds() + 0.5),
+		"end_time":  end,
+	})
+	db.Model(&models.RecordHistory{}).Where("id = ? AND end_time < ?", part.HistoryID, end).Update("end_time", end)
+	log.Printf("[直播录制] 文件录制完成: part_id=%d, %s, 时长%s, 大小%.1fMB",
+		part.ID, part.FileName, duration.Round(time.Second), float64(info.Size())/1024/1024)
+}
+
+// finishInterruptedStreamParts 结束服务异常退出时仍处于录制状态的分P，historyID为0时处理所有直播录制
+func finishInterruptedStreamParts(historyID uint) {
+	db := database.GetDB()
+	query := db.Where("recording = ? AND session_id LIKE ?", true, "live-%")
+	if historyID > 0 {
+		query = query.Where("history_id = ?", historyID)
+	}
+
+	var parts []models.RecordHistoryPart
+	if err := query.Find(&parts).Error; err != nil {
+		return
+	}
+	for i := range parts {
+		part := &parts[i]
+		// 异常退出的文件没有可靠的时长记录，优先探测，失败时按最后写入时间估算
+		var duration time.Duration
+		if info, err := NewMediaService().ProbeMediaInfo(part.FilePath); err == nil && info.Duration > 0 {
+			duration = time.Duration(info.Duration * float64(time.Second))
+		} else if stat, err := os.Stat(part.FilePath); err == nil {
+			duration = stat.ModTime().Sub(part.StartTime)
+		}
+		finishStreamPart(part, duration)
+	}
+}
@@ -0,0 +1,335 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gobup/server/internal/bili"
+	"github.com/gobup/server/internal/database"
+	"github.com/gobup/server/internal/models"
+)
+
+var (
+	testFLVMetadata = []byte{0x02, 0x00, 0x0a, 'o', 'n', 'M', 'e', 't', 'a', 'D', 'a', 't', 'a'}
+	testFLVVideoSeq = []byte{0x17, 0x00, 0, 0, 0, 0x01, 0x64, 0x00, 0x28}
+	testFLVAudioSeq = []byte{0xaf, 0x00, 0x12, 0x10}
+)
+
+// testFLVTag 编码一个FLV标签及其PreviousTagSize
+func testFLVTag(tagType byte, ts uint32, data []byte) []byte {
+	size := uint32(len(data))
+	buf := []byte{tagType, byte(size >> 16), byte(size >> 8), byte(size), byte(ts >> 16), byte(ts >> 8), byte(ts), byte(ts >> 24), 0, 0, 0}
+	buf = append(buf, data...)
+	return binary.BigEndian.AppendUint32(buf, 11+size)
+}
+
+// testFLVStreamHeader FLV文件头、第一个PreviousTagSize和编码参数
+func testFLVStreamHeader() []byte {
+	buf := []byte{'F', 'L', 'V', 0x01, 0x05, 0, 0, 0, 9, 0, 0, 0, 0}
+	buf = append(buf, testFLVTag(flvTagScript, 0, testFLVMetadata)...)
+	buf = append(buf, testFLVTag(flvTagVideo, 0, testFLVVideoSeq)...)
+	return append(buf, testFLVTag(flvTagAudio, 0, testFLVAudioSeq)...)
+}
+
+// testFLVGOP 从ts开始的一组画面：关键帧和4个间隔40毫秒的非关键帧，每帧附带一个音频帧
+func testFLVGOP(ts uint32, frameSize int) []byte {
+	var buf []byte
+	for i := uint32(0); i < 5; i++ {
+		frame := []byte{0x27, 0x01, 0, 0, 0}
+		if i == 0 {
+			frame[0] = 0x17
+		}
+		frame = append(frame, make([]byte, frameSize)...)
+		buf = append(buf, testFLVTag(flvTagVideo, ts+i*40, frame)...)
+		buf = append(buf, testFLVTag(flvTagAudio, ts+i*40, []byte{0xaf, 0x01, 0x21})...)
+	}
+	return buf
+}
+
+type testFLVParsedTag struct {
+	tagType byte
+	ts      uint32
+	data    []byte
+}
+
+// parseTestFLV 解析分段文件，校验文件头并返回全部标签
+func parseTestFLV(t *testing.T, data []byte) []testFLVParsedTag {
+	t.Helper()
+	if len(data) < 13 || string(data[:3]) != "FLV" || binary.BigEndian.Uint32(data[5:9]) != 9 || binary.BigEndian.Uint32(data[9:13]) != 0 {
+		t.Fatalf("分段文件头不正确: % x", data[:min(len(data), 13)])
+	}
+	data = data[13:]
+	var tags []testFLVParsedTag
+	for len(data) > 0 {
+		if len(data) < 15 {
+			t.Fatalf("分段文件末尾不完整: %d字节", len(data))
+		}
+		size := int(data[1])<<16 | int(data[2])<<8 | int(data[3])
+		ts := uint32(data[7])<<24 | uint32(data[4])<<16 | uint32(data[5])<<8 | uint32(data[6])
+		if binary.BigEndian.Uint32(data[11+size:15+size]) != uint32(11+size) {
+			t.Fatalf("PreviousTagSize不正确")
+		}
+		tags = append(tags, testFLVParsedTag{tagType: data[0], ts: ts, data: data[11 : 11+size]})
+		data = data[15+size:]
+	}
+	return tags
+}
+
+// checkTestFLVSegment 校验分段以元数据和编码参数开头，随后是时间戳为0的关键帧
+func checkTestFLVSegment(t *testing.T, data []byte) []testFLVParsedTag {
+	t.Helper()
+	tags := parseTestFLV(t, data)
+	if len(tags) < 4 {
+		t.Fatalf("分段标签数=%d, 太少", len(tags))
+	}
+	if tags[0].tagType != flvTagScript || !bytes.Equal(tags[0].data, testFLVMetadata) {
+		t.Errorf("分段第1个标签不是onMetaData")
+	}
+	if tags[1].tagType != flvTagVideo || !bytes.Equal(tags[1].data, testFLVVideoSeq) {
+		t.Errorf("分段第2个标签不是视频编码参数")
+	}
+	if tags[2].tagType != flvTagAudio || !bytes.Equal(tags[2].data, testFLVAudioSeq) {
+		t.Errorf("分段第3个标签不是音频编码参数")
+	}
+	if tags[3].tagType != flvTagVideo || !flvVideoKeyframe(tags[3].data) || tags[3].ts != 0 {
+		t.Errorf("分段画面不是从时间戳为0的关键帧开始: type=%d ts=%d", tags[3].tagType, tags[3].ts)
+	}
+	return tags
+}
+
+// runTestSegmenter 使用内存分段运行切分，返回各分段内容和时长
+func runTestSegmenter(t *testing.T, segmenter *flvSegmenter, stream []byte) ([][]byte, []time.Duration) {
+	t.Helper()
+	var segments []*bytes.Buffer
+	var durations []time.Duration
+	segmenter.open = func() (io.Writer, error) {
+		segments = append(segments, &bytes.Buffer{})
+		return segments[len(segments)-1], nil
+	}
+	segmenter.close = func(d time.Duration) { durations = append(durations, d) }
+	if err := segmenter.Run(bytes.NewReader(stream)); err != nil {
+		t.Fatalf("切分失败: %v", err)
+	}
+	if len(durations) != len(segments) {
+		t.Fatalf("结束分段%d次, 开始分段%d次", len(durations), len(segments))
+	}
+	var result [][]byte
+	for _, seg := range segments {
+		result = append(result, seg.Bytes())
+	}
+	return result, durations
+}
+
+func TestFLVSegmenterSplitByDuration(t *testing.T) {
+	stream := testFLVStreamHeader()
+	// 第一个关键帧之前的画面无法播放，应被丢弃
+	stream = append(stream, testFLVTag(flvTagVideo, 0, []byte{0x27, 0x01, 0, 0, 0})...)
+	for i := uint32(0); i < 5; i++ {
+		stream = append(stream, testFLVGOP(1000+i*1000, 16)...)
+	}
+
+	segments, durations := runTestSegmenter(t, &flvSegmenter{maxDuration: 2000}, stream)
+	if len(segments) != 3 {
+		t.Fatalf("分段数=%d, 期望3", len(segments))
+	}
+	wantDurations := []time.Duration{1160 * time.Millisecond, 1160 * time.Millisecond, 160 * time.Millisecond}
+	wantKeyframes := []int{2, 2, 1}
+	for i, seg := range segments {
+		tags := checkTestFLVSegment(t, seg)
+		if durations[i] != wantDurations[i] {
+			t.Errorf("分段%d时长=%v, 期望%v", i+1, durations[i], wantDurations[i])
+		}
+		keyframes := 0
+		for _, tag := range tags[3:] {
+			if tag.tagType == flvTagVideo && flvVideoKeyframe(tag.data) {
+				keyframes++
+			}
+		}
+		if keyframes != wantKeyframes[i] {
+			t.Errorf("分段%d关键帧数=%d, 期望%d", i+1, keyframes, wantKeyframes[i])
+		}
+	}
+}
+
+func TestFLVSegmenterSplitBySize(t *testing.T) {
+	stream := testFLVStreamHeader()
+	for i := uint32(0); i < 4; i++ {
+		stream = append(stream, testFLVGOP(i*1000, 1024)...)
+	}
+
+	// 每组画面约5KB，超过4KB后在下一个关键帧处切分
+	segments, _ := runTestSegmenter(t, &flvSegmenter{maxSize: 4096}, stream)
+	if len(segments) != 4 {
+		t.Fatalf("分段数=%d, 期望4", len(segments))
+	}
+	for _, seg := range segments {
+		tags := checkTestFLVSegment(t, seg)
+		// 非关键帧处不切分，每个分段包含完整的一组画面
+		if n := len(tags) - 3; n != 10 {
+			t.Errorf("分段标签数=%d, 期望10", n)
+		}
+	}
+}
+
+// testFLVServer 模拟直播CDN：每次请求发送一段直播流后断开，第一次3组画面，之后每次1组
+type testFLVServer struct {
+	mu       sync.Mutex
+	requests int
+}
+
+func (s *testFLVServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests++
+	index := s.requests
+	s.mu.Unlock()
+
+	if r.Header.Get("Referer") != "https://live.bilibili.com/" {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	w.Header().Set("Content-Type", "video/x-flv")
+	w.Write(testFLVStreamHeader())
+
+	gops := 3
+	if index > 1 {
+		gops = 1
+	}
+	for i := 0; i < gops; i++ {
+		// 每组画面约1.25MB，超过1MB的分段大小，每个关键帧处都会切分
+		w.Write(testFLVGOP(uint32(i*1000), 256*1024))
+		w.(http.Flusher).Flush()
+		// 分段文件名精确到毫秒，避免同一毫秒内创建两个分段
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestStreamRecorderReconnect(t *testing.T) {
+	setupTestDB(t)
+	db := database.GetDB()
+
+	server := &testFLVServer{}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	room := models.RecordRoom{RoomID: "7734200", Uname: "测试主播", Title: "测试直播", Upload: true, StreamRecord: true, StreamSplitSize: 1}
+	if err := db.Create(&room).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := GetStreamRecorderService()
+	resolve := recorder.ResolveStream
+	defer func() { recorder.ResolveStream = resolve }()
+	recorder.ResolveStream = func(*models.RecordRoom) (*bili.LiveStreamURL, error) {
+		return &bili.LiveStreamURL{URL: ts.URL + "/live.flv", Format: "flv", Codec: "avc", Qn: 10000}, nil
+	}
+
+	if err := recorder.Start(&room); err != nil {
+		t.Fatalf("开始录制失败: %v", err)
+	}
+
+	waitParts := func(total, recording int) []models.RecordHistoryPart {
+		t.Helper()
+		deadline := time.Now().Add(streamRecordRetryDelay + 5*time.Second)
+		for {
+			var parts []models.RecordHistoryPart
+			db.Where("room_id = ?", room.RoomID).Order("id ASC").Find(&parts)
+			active := 0
+			for _, part := range parts {
+				if part.Recording {
+					active++
+				}
+			}
+			if len(parts) == total && active == recording {
+				return parts
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("等待分P超时: 分P数=%d(期望%d), 录制中=%d(期望%d)", len(parts), total, active, recording)
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+
+	// 第一次连接按大小切分为3个分P，断开后全部结束录制
+	first := waitParts(3, 0)
+	// 断流后重新获取直播流写入新的分P，直播流读完后结束录制，不依赖停止时机
+	parts := waitParts(4, 0)
+	for i := range first {
+		if parts[i].ID != first[i].ID || parts[i].EndTime != first[i].EndTime {
+			t.Errorf("断流前的分P%d在重连后发生变化", i+1)
+		}
+	}
+
+	// 在下一次重连之前结束录制
+	recorder.Stop(room.RoomID, false)
+
+	server.mu.Lock()
+	if server.requests != 2 {
+		t.Errorf("拉流请求次数=%d, 期望2", server.requests)
+	}
+	server.mu.Unlock()
+
+	var history models.RecordHistory
+	if err := db.Where("room_id = ?", room.RoomID).First(&history).Error; err != nil {
+		t.Fatalf("未创建历史记录: %v", err)
+	}
+	if history.Recording {
+		t.Error("直播结束后历史记录仍在录制")
+	}
+	for _, part := range parts {
+		if part.HistoryID != history.ID || part.SessionID != history.SessionID {
+			t.Errorf("分P不属于本场直播: %+v", part)
+		}
+		data, err := os.ReadFile(part.FilePath)
+		if err != nil {
+			t.Fatalf("读取录制文件失败: %v", err)
+		}
+		if part.FileSize != int64(len(data)) {
+			t.Errorf("分P文件大小=%d, 实际%d", part.FileSize, len(data))
+		}
+		checkTestFLVSegment(t, data)
+	}
+	if !parts[3].StartTime.After(parts[2].EndTime) {
+		t.Errorf("重连后的分P开始时间应晚于上一分P结束时间")
+	}
+}
+
+func TestLiveSessionHistoryConcurrent(t *testing.T) {
+	setupTestDB(t)
+	room := models.RecordRoom{RoomID: "7734201", Uname: "测试主播", Title: "测试直播"}
+
+	// 弹幕录制和直播录制同时开始时共用一条历史记录
+	var wg sync.WaitGroup
+	ids := make([]uint, 8)
+	for i := range ids {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			history, err := liveSessionHistory(&room, time.Now())
+			if err != nil {
+				t.Errorf("获取历史记录失败: %v", err)
+				return
+			}
+			ids[i] = history.ID
+		}(i)
+	}
+	wg.Wait()
+
+	var count int64
+	database.GetDB().Model(&models.RecordHistory{}).Where("room_id = ?", room.RoomID).Count(&count)
+	if count != 1 {
+		t.Errorf("历史记录数=%d, 期望1", count)
+	}
+	for _, id := range ids {
+		if id != ids[0] {
+			t.Errorf("返回了不同的历史记录: %v", ids)
+			break
+		}
+	}
+}
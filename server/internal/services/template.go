@@ -73,6 +73,29 @@ func (s *TemplateService) render(template string, data map[string]interface{}) s
 		result = strings.ReplaceAll(result, "${fileName}", fileName)
 	}
 
+	// 收益统计变量（由 RevenueService.AddTemplateData 提供）
+	if revenue, ok := data["revenue"].(string); ok {
+		result = strings.ReplaceAll(result, "${revenue}", revenue)
+	}
+	if topSupporters, ok := data["topSupporters"].(string); ok {
+		result = strings.ReplaceAll(result, "${topSupporters}", topSupporters)
+	}
+	for _, key := range []string{"scCount", "guardCount", "giftCount"} {
+		if count, ok := data[key].(int); ok {
+			result = strings.ReplaceAll(result, "${"+key+"}", fmt.Sprintf("%d", count))
+		}
+	}
+
+	// 高能集锦变量（由 HighlightPublishService.AddTemplateData 提供）
+	for _, key := range []string{"fullBvid", "fullUrl", "fullLinks", "weekRange"} {
+		if value, ok := data[key].(string); ok {
+			result = strings.ReplaceAll(result, "${"+key+"}", value)
+		}
+	}
+	if clipCount, ok := data["clipCount"].(int); ok {
+		result = strings.ReplaceAll(result, "${clipCount}", fmt.Sprintf("%d", clipCount))
+	}
+
 	// 替换时间变量
 	var startTime time.Time
 	if t, ok := data["startTime"].(time.Time); ok {
@@ -125,6 +125,16 @@ func (s *VideoSyncService) SyncVideoInfo(historyID uint) error {
 				if err != nil {
 					// 仍然失败，如果是-404，判断为审核中
 					if strings.Contains(err.Error(), "code=-404") {
+						// 仅自己可见的稿件公开接口不可见，以创作中心的稿件状态为准
+						if history.VisibilityStatus == VisibilityStatusPendingReview && partInfo.Archive.State == 0 && partInfo.Archive.Aid > 0 {
+							log.Printf("仅自己可见稿件审核已通过: %s", history.BvID)
+							history.VideoState = 3
+							history.VideoStateDesc = "仅自己可见"
+							history.SyncedAt = &[]time.Time{time.Now()}[0]
+							db.Save(&history)
+							NewVisibilityService().OnReviewPassed(&history, &room)
+							return nil
+						}
 						log.Printf("二次确认返回-404，判断视频为审核中: %s", history.BvID)
 						history.VideoState = 0
 						history.VideoStateDesc = "审核中"
@@ -193,6 +203,8 @@ func (s *VideoSyncService) SyncVideoInfo(historyID uint) error {
 	// videoState=1 表示已通过
 	if oldVideoState != 1 && history.VideoState == 1 {
 		log.Printf("视频 %s 审核通过，检查是否需要处理文件", history.BvID)
+		// 先私密后公开：审核通过后进入公开流程
+		NewVisibilityService().OnReviewPassed(&history, &room)
 		if room.DeleteType == 11 || room.DeleteType == 12 {
 			fileMoverSvc := NewFileMoverService()
 			if err := fileMoverSvc.ProcessFilesByStrategy(historyID, room.DeleteType); err != nil {
@@ -261,6 +273,14 @@ func (s *VideoSyncService) SyncVideoInfo(historyID uint) error {
 		log.Printf("更新历史记录同步信息失败: %v", err)
 	}
 
+	// 检测到审核退回，记录退回原因并推送通知
+	if oldVideoState != -2 && history.VideoState == -2 {
+		rejectSvc := NewReviewRejectService()
+		if err := rejectSvc.RecordRejection(historyID); err != nil {
+			log.Printf("记录审核退回信息失败: %v", err)
+		}
+	}
+
 	log.Printf("视频 %s 信息同步成功，状态: %s", history.BvID, history.VideoStateDesc)
 
 	return nil
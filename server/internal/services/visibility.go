@@ -0,0 +1,157 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/gobup/server/internal/bili"
+	"github.com/gobup/server/internal/database"
+	"github.com/gobup/server/internal/models"
+)
+
+// 先私密后公开流程状态
+const (
+	VisibilityStatusNone            = 0 // 不参与流程
+	VisibilityStatusPendingReview   = 1 // 仅自己可见，等待审核通过
+	VisibilityStatusPendingApproval = 2 // 审核已通过，等待手动确认公开
+	VisibilityStatusScheduled       = 3 // 已计划在 PublicAt 公开
+	VisibilityStatusPublic          = 4 // 已公开
+	VisibilityStatusFailed          = 5 // 公开失败
+)
+
+// VisibilityService 稿件可见性服务
+type VisibilityService struct {
+	wxPusher *WxPusherService
+}
+
+func NewVisibilityService() *VisibilityService {
+	return &VisibilityService{
+		wxPusher: NewWxPusherService(),
+	}
+}
+
+// OnReviewPassed 仅自己可见的稿件审核通过后，根据房间配置进入手动确认或计划公开
+func (s *VisibilityService) OnReviewPassed(history *models.RecordHistory, room *models.RecordRoom) {
+	if history.VisibilityStatus != VisibilityStatusPendingReview {
+		return
+	}
+
+	db := database.GetDB()
+
+	if room.PublicNeedApproval {
+		db.Model(history).Update("visibility_status", VisibilityStatusPendingApproval)
+		log.Printf("[可见性] 稿件 %s 审核通过，等待手动确认公开", history.BvID)
+		if room.Wxuid != "" && ContainsPushTag(room.PushMsgTags, "审核") {
+			s.wxPusher.SendTextMessage(room.UploadUserID, room.Wxuid, fmt.Sprintf(`🔒 稿件审核通过，等待确认公开
+房间: %s
+BV号: %s
+时间: %s`, history.Uname, history.BvID, time.Now().Format("2006-01-02 15:04:05")))
+		}
+		return
+	}
+
+	publicAt := time.Now().Add(time.Duration(room.PublicDelay) * time.Minute)
+	db.Model(history).Updates(map[string]interface{}{
+		"visibility_status": VisibilityStatusScheduled,
+		"public_at":         &publicAt,
+	})
+	log.Printf("[可见性] 稿件 %s 审核通过，计划于 %s 公开", history.BvID, publicAt.Format("2006-01-02 15:04:05"))
+
+	if room.PublicDelay <= 0 {
+		if err := s.MakePublic(history.ID); err != nil {
+			log.Printf("[可见性] 公开稿件失败: %v", err)
+		}
+	}
+}
+
+// ApprovePublic 手动确认公开，delay为0时立即公开
+func (s *VisibilityService) ApprovePublic(historyID uint) error {
+	db := database.GetDB()
+
+	var history models.RecordHistory
+	if err := db.First(&history, historyID).Error; err != nil {
+		return fmt.Errorf("历史记录不存在: %w", err)
+	}
+
+	switch history.VisibilityStatus {
+	case VisibilityStatusPendingApproval, VisibilityStatusScheduled, VisibilityStatusFailed:
+	case VisibilityStatusPendingReview:
+		return fmt.Errorf("稿件尚未审核通过")
+	default:
+		return fmt.Errorf("稿件不在待公开状态")
+	}
+
+	return s.MakePublic(historyID)
+}
+
+// MakePublic 将稿件切换为公开
+func (s *VisibilityService) MakePublic(historyID uint) error {
+	db := database.GetDB()
+
+	var history models.RecordHistory
+	if err := db.First(&history, historyID).Error; err != nil {
+		return fmt.Errorf("历史记录不存在: %w", err)
+	}
+
+	var room models.RecordRoom
+	if err := db.Where("room_id = ?", history.RoomID).First(&room).Error; err != nil {
+		return fmt.Errorf("房间不存在: %w", err)
+	}
+
+	var user models.BiliBiliUser
+	if err := db.First(&user, room.UploadUserID).Error; err != nil {
+		return fmt.Errorf("上传用户不存在: %w", err)
+	}
+	if !user.Login {
+		return fmt.Errorf("用户未登录")
+	}
+
+	aid, err := strconv.ParseInt(history.AvID, 10, 64)
+	if err != nil || aid == 0 {
+		return fmt.Errorf("稿件AID无效: %s", history.AvID)
+	}
+
+	client := bili.NewBiliClient(user.AccessKey, user.Cookies, user.UID)
+	if err := client.UpdateVideoVisibility(aid, false); err != nil {
+		db.Model(&history).Updates(map[string]interface{}{
+			"visibility_status": VisibilityStatusFailed,
+			"message":           fmt.Sprintf("公开失败: %v", err),
+		})
+		return err
+	}
+
+	db.Model(&history).Updates(map[string]interface{}{
+		"visibility_status": VisibilityStatusPublic,
+		"message":           "已公开",
+	})
+	log.Printf("[可见性] 稿件 %s 已切换为公开", history.BvID)
+
+	if room.Wxuid != "" && ContainsPushTag(room.PushMsgTags, "投稿") {
+		s.wxPusher.SendTextMessage(room.UploadUserID, room.Wxuid, fmt.Sprintf(`🌐 稿件已公开
+房间: %s
+链接: https://www.bilibili.com/video/%s
+时间: %s`, history.Uname, history.BvID, time.Now().Format("2006-01-02 15:04:05")))
+	}
+
+	return nil
+}
+
+// ProcessScheduledPublic 公开所有已到计划时间的稿件
+func (s *VisibilityService) ProcessScheduledPublic() error {
+	db := database.GetDB()
+
+	var histories []models.RecordHistory
+	if err := db.Where("visibility_status = ? AND public_at <= ?", VisibilityStatusScheduled, time.Now()).
+		Find(&histories).Error; err != nil {
+		return err
+	}
+
+	for _, history := range histories {
+		if err := s.MakePublic(history.ID); err != nil {
+			log.Printf("[可见性] 公开稿件失败: history_id=%d, %v", history.ID, err)
+		}
+	}
+	return nil
+}
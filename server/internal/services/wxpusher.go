@@ -3,6 +3,7 @@ package services
 import (
 	"fmt"
 	"log"
+	"strings"
 	"time"
 
 	"github.com/gobup/server/internal/database"
@@ -175,3 +176,45 @@ func (s *WxPusherService) NotifyLiveStart(userID uint, wxuid, uname, title, area
 
 	s.SendTextMessage(userID, wxuid, content)
 }
+
+// NotifyReviewRejected 审核退回通知
+func (s *WxPusherService) NotifyReviewRejected(userID uint, wxuid, roomName, bvid, reason string, ranges []RejectRange) {
+	var rangeLines []string
+	for _, r := range ranges {
+		if r.End > r.Start {
+			rangeLines = append(rangeLines, fmt.Sprintf("P%d %s-%s", r.Page, formatClock(r.Start), formatClock(r.End)))
+		} else if r.Page > 0 {
+			rangeLines = append(rangeLines, fmt.Sprintf("P%d 整P", r.Page))
+		}
+	}
+	rangeText := "未标注"
+	if len(rangeLines) > 0 {
+		rangeText = strings.Join(rangeLines, ", ")
+	}
+
+	content := fmt.Sprintf(`⚠️ 稿件审核退回
+房间: %s
+BV号: %s
+原因: %s
+违规片段: %s
+时间: %s`,
+		roomName, bvid, reason, rangeText,
+		time.Now().Format("2006-01-02 15:04:05"))
+
+	s.SendTextMessage(userID, wxuid, content)
+}
+
+// formatClock 将秒数格式化为 HH:MM:SS
+func formatClock(seconds int) string {
+	return fmt.Sprintf("%02d:%02d:%02d", seconds/3600, seconds%3600/60, seconds%60)
+}
+
+// ContainsPushTag 检查推送标签列表（逗号分隔）中是否包含指定标签
+func ContainsPushTag(tags, target string) bool {
+	for _, tag := range strings.Split(tags, ",") {
+		if strings.TrimSpace(tag) == target {
+			return true
+		}
+	}
+	return false
+}
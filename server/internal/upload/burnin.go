@@ -0,0 +1,434 @@
+package upload
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gobup/server/internal/bili"
+	"github.com/gobup/server/internal/database"
+	"github.com/gobup/server/internal/models"
+	"github.com/gobup/server/internal/services"
+)
+
+// maxTitleLength B站稿件标题最大长度
+const maxTitleLength = 80
+
+// errBurnInInProgress 弹幕版已在排队或压制中
+var errBurnInInProgress = errors.New("弹幕版已在处理中")
+
+// BurnInQueue 弹幕版压制队列（全局单例，CPU密集的压制任务串行执行，不占用用户上传队列）
+type BurnInQueue struct {
+	tasks      chan uint
+	processing bool
+	mu         sync.Mutex
+	service    *Service
+}
+
+var (
+	burnInQueueInstance *BurnInQueue
+	burnInQueueOnce     sync.Once
+)
+
+// getBurnInQueue 获取弹幕版压制队列单例
+func (s *Service) getBurnInQueue() *BurnInQueue {
+	burnInQueueOnce.Do(func() {
+		// 队列只保存在内存中，服务重启前排队或压制中的任务标记为失败
+		s.recoverInterruptedBurnIn()
+
+		burnInQueueInstance = &BurnInQueue{
+			tasks:   make(chan uint, 50), // 缓存最多50个压制任务
+			service: s,
+		}
+	})
+	return burnInQueueInstance
+}
+
+// recoverInterruptedBurnIn 将服务重启前未完成的弹幕版标记为失败，并执行被推迟的投稿后文件处理
+func (s *Service) recoverInterruptedBurnIn() {
+	db := database.GetDB()
+	var histories []models.RecordHistory
+	db.Where("burn_in_status IN ?", []int{services.BurnInStatusQueued, services.BurnInStatusProcessing}).Find(&histories)
+	for _, history := range histories {
+		db.Model(&history).Updates(map[string]interface{}{
+			"burn_in_status": services.BurnInStatusFailed,
+			"burn_in_msg":    "服务重启，压制中断",
+		})
+		log.Printf("[弹幕压制] 服务重启，压制中断: history_id=%d", history.ID)
+
+		var room models.RecordRoom
+		if err := db.Where("room_id = ?", history.RoomID).First(&room).Error; err == nil {
+			s.processPublishedFiles(history.ID, &room)
+		}
+	}
+}
+
+// Add 添加压制任务到队列
+func (q *BurnInQueue) Add(historyID uint) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	select {
+	case q.tasks <- historyID:
+		log.Printf("[弹幕压制队列] 添加任务: history_id=%d (队列长度: %d)", historyID, len(q.tasks))
+
+		if !q.processing {
+			q.processing = true
+			go q.process()
+		}
+		return nil
+	default:
+		return fmt.Errorf("弹幕压制队列已满，无法添加新任务")
+	}
+}
+
+// process 处理队列中的任务，队列为空时在持有锁的情况下退出，避免与 Add 之间遗漏任务
+func (q *BurnInQueue) process() {
+	for {
+		q.mu.Lock()
+		var historyID uint
+		select {
+		case historyID = <-q.tasks:
+		default:
+			q.processing = false
+			q.mu.Unlock()
+			return
+		}
+		q.mu.Unlock()
+
+		log.Printf("[弹幕压制队列] 开始处理: history_id=%d (剩余队列: %d)", historyID, len(q.tasks))
+
+		if err := q.service.ProcessBurnIn(historyID); err != nil {
+			log.Printf("[弹幕压制队列] 处理失败: history_id=%d, error=%v", historyID, err)
+		} else {
+			log.Printf("[弹幕压制队列] 处理完成: history_id=%d", historyID)
+		}
+	}
+}
+
+// GetLength 获取队列长度
+func (q *BurnInQueue) GetLength() int {
+	return len(q.tasks)
+}
+
+// EnqueueBurnIn 将历史记录加入弹幕版压制队列
+func (s *Service) EnqueueBurnIn(historyID uint) error {
+	db := database.GetDB()
+	queue := s.getBurnInQueue()
+
+	var history models.RecordHistory
+	if err := db.First(&history, historyID).Error; err != nil {
+		return fmt.Errorf("历史记录不存在: %w", err)
+	}
+	if history.BurnInStatus == services.BurnInStatusQueued || history.BurnInStatus == services.BurnInStatusProcessing {
+		return errBurnInInProgress
+	}
+
+	if err := queue.Add(historyID); err != nil {
+		return err
+	}
+	db.Model(&history).Updates(map[string]interface{}{
+		"burn_in_status": services.BurnInStatusQueued,
+		"burn_in_msg":    "已加入压制队列",
+	})
+	return nil
+}
+
+// ProcessBurnIn 压制并上传历史记录所有分P的弹幕版，然后按房间配置单独投稿或追加到原稿件
+func (s *Service) ProcessBurnIn(historyID uint) error {
+	db := database.GetDB()
+
+	var history models.RecordHistory
+	if err := db.First(&history, historyID).Error; err != nil {
+		return fmt.Errorf("历史记录不存在: %w", err)
+	}
+
+	var room models.RecordRoom
+	if err := db.Where("room_id = ?", history.RoomID).First(&room).Error; err != nil {
+		return fmt.Errorf("房间不存在: %w", err)
+	}
+
+	// 投稿后的文件处理推迟到弹幕版结束后执行，压制失败时同样执行，避免源文件一直保留
+	defer s.processPublishedFiles(historyID, &room)
+
+	setStatus := func(status int, msg string) {
+		db.Model(&history).Updates(map[string]interface{}{
+			"burn_in_status": status,
+			"burn_in_msg":    msg,
+		})
+	}
+	fail := func(err error) error {
+		setStatus(services.BurnInStatusFailed, err.Error())
+		if room.Wxuid != "" && services.ContainsPushTag(room.PushMsgTags, "投稿") {
+			s.wxPusher.SendTextMessage(room.UploadUserID, room.Wxuid,
+				fmt.Sprintf("❌ 弹幕版处理失败\n房间: %s\n标题: %s\n原因: %v", history.Uname, history.Title, err))
+		}
+		return err
+	}
+
+	var user models.BiliBiliUser
+	if err := db.First(&user, room.UploadUserID).Error; err != nil {
+		return fail(fmt.Errorf("上传用户不存在: %w", err))
+	}
+	if !user.Login {
+		return fail(fmt.Errorf("用户未登录"))
+	}
+
+	setStatus(services.BurnInStatusProcessing, "正在压制弹幕版")
+
+	burnSvc := services.NewDanmakuBurnInService()
+	if err := burnSvc.EnsureDanmakuParsed(&history); err != nil {
+		return fail(err)
+	}
+
+	var parts []models.RecordHistoryPart
+	if err := db.Where("history_id = ? AND upload = ? AND file_delete = ? AND excluded = ?", historyID, true, false, false).
+		Order("start_time ASC").
+		Find(&parts).Error; err != nil {
+		return fail(fmt.Errorf("查询分P失败: %w", err))
+	}
+	if len(parts) == 0 {
+		return fail(fmt.Errorf("没有已上传的分P"))
+	}
+
+	client := bili.NewBiliClient(user.AccessKey, user.Cookies, user.UID)
+	client.Line = room.Line
+
+	uploaded := 0
+	for i := range parts {
+		part := &parts[i]
+
+		var burnPart models.BurnInPart
+		db.Where("part_id = ?", part.ID).FirstOrInit(&burnPart, models.BurnInPart{HistoryID: historyID, PartID: part.ID})
+
+		if burnPart.Status == services.BurnInPartUploaded {
+			uploaded++
+			continue
+		}
+
+		if burnPart.Status != services.BurnInPartEncoded || !fileExists(burnPart.FilePath) {
+			setStatus(services.BurnInStatusProcessing, fmt.Sprintf("正在压制第%d/%d个分P", i+1, len(parts)))
+			outputPath, err := burnSvc.RenderPart(&history, part, &room)
+			if errors.Is(err, services.ErrNoBurnInDanmaku) {
+				log.Printf("[弹幕压制] 分P没有弹幕，跳过: part_id=%d", part.ID)
+				continue
+			}
+			if err != nil {
+				burnPart.Status = services.BurnInPartFailed
+				burnPart.ErrorMsg = err.Error()
+				db.Save(&burnPart)
+				return fail(fmt.Errorf("分P[%d]压制失败: %w", i+1, err))
+			}
+			fileInfo, _ := os.Stat(outputPath)
+			burnPart.FilePath = outputPath
+			if fileInfo != nil {
+				burnPart.FileSize = fileInfo.Size()
+			}
+			burnPart.Status = services.BurnInPartEncoded
+			burnPart.ErrorMsg = ""
+			db.Save(&burnPart)
+		}
+
+		setStatus(services.BurnInStatusProcessing, fmt.Sprintf("正在上传第%d/%d个分P", i+1, len(parts)))
+		result, err := s.uploadBurnInFile(client, &room, burnPart.FilePath)
+		if err != nil {
+			burnPart.ErrorMsg = err.Error()
+			db.Save(&burnPart)
+			return fail(fmt.Errorf("分P[%d]弹幕版上传失败: %w", i+1, err))
+		}
+
+		// 上传完成后压制文件不再需要，删除以释放空间
+		os.Remove(burnPart.FilePath)
+		burnPart.FileName = result.FileName
+		burnPart.CID = result.BizID
+		burnPart.Status = services.BurnInPartUploaded
+		db.Save(&burnPart)
+		uploaded++
+	}
+
+	if uploaded == 0 {
+		return fail(fmt.Errorf("所有分P都没有可压制的弹幕"))
+	}
+
+	if room.BurnInTarget == services.BurnInTargetAppend {
+		if err := s.appendBurnInPages(client, &history, &room, parts); err != nil {
+			return fail(err)
+		}
+		setStatus(services.BurnInStatusPublished, fmt.Sprintf("已追加%d个弹幕版分P到原稿件", uploaded))
+	} else {
+		bvid, err := s.publishBurnInArchive(client, &history, &room, parts)
+		if err != nil {
+			return fail(err)
+		}
+		setStatus(services.BurnInStatusPublished, fmt.Sprintf("弹幕版已投稿: %s", bvid))
+	}
+
+	log.Printf("[弹幕压制] history_id=%d 弹幕版处理完成，共%d个分P", historyID, uploaded)
+	return nil
+}
+
+// processPublishedFiles 执行投稿成功后的文件处理：9-投稿成功后删除, 10-投稿成功后移动
+func (s *Service) processPublishedFiles(historyID uint, room *models.RecordRoom) {
+	if room.DeleteType != 9 && room.DeleteType != 10 {
+		return
+	}
+	fileMoverSvc := services.NewFileMoverService()
+	if err := fileMoverSvc.ProcessFilesByStrategy(historyID, room.DeleteType); err != nil {
+		log.Printf("文件处理失败: %v", err)
+	}
+}
+
+// uploadBurnInFile 上传压制后的文件
+func (s *Service) uploadBurnInFile(client *bili.BiliClient, room *models.RecordRoom, filePath string) (*bili.UploadResult, error) {
+	fileInfo, err := os.Stat(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("获取文件信息失败: %w", err)
+	}
+
+	var uploader interface {
+		Upload(string) (*bili.UploadResult, error)
+	}
+	var chunkSize int64
+	switch room.Line {
+	case "app":
+		chunkSize = 2 * 1024 * 1024
+		uploader = bili.NewAppUploader(client)
+	default:
+		chunkSize = ChunkSize
+		uploader = bili.NewUposUploader(client)
+	}
+
+	if bili.ShouldSplitFile(fileInfo.Size(), chunkSize) {
+		return nil, fmt.Errorf("压制后的文件分片数超过限制，请调高CRF或使用更慢的预设")
+	}
+
+	log.Printf("[弹幕压制] 开始上传: %s (%.2f MB)", filePath, float64(fileInfo.Size())/1024/1024)
+	return uploader.Upload(filePath)
+}
+
+// burnInPages 构建已上传的弹幕版分P列表，顺序与原分P一致
+func (s *Service) burnInPages(history *models.RecordHistory, room *models.RecordRoom, parts []models.RecordHistoryPart) []bili.PublishVideoPartRequest {
+	var burnParts []models.BurnInPart
+	database.GetDB().Where("history_id = ? AND status = ?", history.ID, services.BurnInPartUploaded).Find(&burnParts)
+
+	byPartID := make(map[uint]models.BurnInPart, len(burnParts))
+	for _, bp := range burnParts {
+		byPartID[bp.PartID] = bp
+	}
+
+	var videos []bili.PublishVideoPartRequest
+	for i, part := range parts {
+		bp, ok := byPartID[part.ID]
+		if !ok || bp.CID == 0 {
+			continue
+		}
+		partTemplateData := map[string]interface{}{
+			"index":     i + 1,
+			"startTime": part.StartTime,
+			"areaName":  part.AreaName,
+			"uname":     history.Uname,
+			"title":     history.Title,
+			"roomId":    history.RoomID,
+			"fileName":  part.FileName,
+		}
+		videos = append(videos, bili.PublishVideoPartRequest{
+			Title:    s.templateSvc.RenderPartTitle(room.PartTitleTemplate, partTemplateData) + room.BurnInTitleSuffix,
+			Filename: bp.FileName,
+			Cid:      bp.CID,
+		})
+	}
+	return videos
+}
+
+// publishBurnInArchive 以单独稿件投稿弹幕版，标题追加后缀
+func (s *Service) publishBurnInArchive(client *bili.BiliClient, history *models.RecordHistory, room *models.RecordRoom, parts []models.RecordHistoryPart) (string, error) {
+	templateData := map[string]interface{}{
+		"uname":     history.Uname,
+		"title":     history.Title,
+		"roomId":    history.RoomID,
+		"areaName":  history.AreaName,
+		"startTime": history.StartTime,
+		"uid":       client.Mid,
+	}
+
+	title := truncateTitle(s.templateSvc.RenderTitle(room.TitleTemplate, templateData), room.BurnInTitleSuffix)
+	desc := s.templateSvc.RenderDescription(room.DescTemplate, templateData)
+	tags := strings.Join(s.templateSvc.BuildTags(room.Tags, templateData), ",")
+	tid := room.TID
+	if tid == 0 {
+		tid = 171
+	}
+	cover := history.CoverURL
+	if room.CoverType == "diy" && room.CoverURL != "" {
+		cover = room.CoverURL
+	}
+	source := ""
+	if room.Copyright == 2 {
+		source = s.templateSvc.RenderTitle(room.SourceTemplate, templateData)
+	}
+
+	videos := s.burnInPages(history, room, parts)
+	if len(videos) == 0 {
+		return "", fmt.Errorf("没有已上传的弹幕版分P")
+	}
+
+	avID, bvid, err := client.PublishVideo(title, desc, tags, tid, room.Copyright, cover, videos, source, room.IsOnlySelf, room.NoDisturbance)
+	if err != nil {
+		return "", fmt.Errorf("弹幕版投稿失败: %w", err)
+	}
+	if avID == 0 {
+		return "", fmt.Errorf("弹幕版投稿失败: API返回的AID为空")
+	}
+	if !strings.HasPrefix(bvid, "BV") || len(bvid) != 12 {
+		bvid = Av2Bv(avID)
+	}
+
+	database.GetDB().Model(history).Updates(map[string]interface{}{
+		"burn_in_av_id": fmt.Sprintf("%d", avID),
+		"burn_in_bv_id": bvid,
+	})
+	log.Printf("[弹幕压制] 弹幕版投稿成功: AV%d, %s", avID, bvid)
+
+	if room.Wxuid != "" && services.ContainsPushTag(room.PushMsgTags, "投稿") {
+		s.wxPusher.NotifyPublishSuccess(room.UploadUserID, room.Wxuid, history.Uname, title, bvid)
+	}
+	return bvid, nil
+}
+
+// appendBurnInPages 将弹幕版分P追加到原稿件后重新提交
+func (s *Service) appendBurnInPages(client *bili.BiliClient, history *models.RecordHistory, room *models.RecordRoom, parts []models.RecordHistoryPart) error {
+	if history.BvID == "" {
+		return fmt.Errorf("原稿件尚未投稿，无法追加弹幕版分P")
+	}
+	aid, err := strconv.ParseInt(history.AvID, 10, 64)
+	if err != nil || aid == 0 {
+		return fmt.Errorf("稿件AID无效: %s", history.AvID)
+	}
+	return s.resubmitArchive(client, aid, history, room, parts)
+}
+
+// truncateTitle 拼接标题后缀，超出长度限制时截断原标题
+func truncateTitle(title, suffix string) string {
+	runes := []rune(title)
+	limit := maxTitleLength - len([]rune(suffix))
+	if limit < 0 {
+		limit = 0
+	}
+	if len(runes) > limit {
+		runes = runes[:limit]
+	}
+	return string(runes) + suffix
+}
+
+// fileExists 检查文件是否存在
+func fileExists(path string) bool {
+	if path == "" {
+		return false
+	}
+	_, err := os.Stat(path)
+	return err == nil
+}
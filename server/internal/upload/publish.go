@@ -1,6 +1,7 @@
 package upload
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -26,6 +27,8 @@ func (s *Service) PublishHistory(historyID uint, userID uint) error {
 	if err := db.Where("room_id = ?", history.RoomID).First(&room).Error; err != nil {
 		return fmt.Errorf("房间不存在: %w", err)
 	}
+	// 高能集锦稿件使用单独的模板和设置
+	room = *services.HighlightArchiveRoom(&room, &history)
 
 	var user models.BiliBiliUser
 	if err := db.First(&user, userID).Error; err != nil {
@@ -45,9 +48,9 @@ func (s *Service) PublishHistory(historyID uint, userID uint) error {
 	}
 
 	// 获取所有已上传的分P（必须按start_time ASC排序，确保投稿时分P顺序正确）
-	// 排除已删除文件的Parts（例如被切分的原始文件）
+	// 排除已删除文件的Parts（例如被切分的原始文件）以及不参与投稿的Parts
 	var parts []models.RecordHistoryPart
-	if err := db.Where("history_id = ? AND upload = ? AND file_delete = ?", historyID, true, false).
+	if err := db.Where("history_id = ? AND upload = ? AND file_delete = ? AND excluded = ?", historyID, true, false, false).
 		Order("start_time ASC").
 		Find(&parts).Error; err != nil {
 		return fmt.Errorf("查询分P失败: %w", err)
@@ -57,6 +60,23 @@ func (s *Service) PublishHistory(historyID uint, userID uint) error {
 		return fmt.Errorf("没有已上传的分P")
 	}
 
+	// 按房间的时长/大小限制过滤分P，有效内容过少时不投稿（手动剪辑的片段不受限制）
+	if history.SourceHistoryID == 0 {
+		autoUploadSvc := services.NewAutoUploadService()
+		var totalDuration int
+		parts, totalDuration = autoUploadSvc.FilterPublishableParts(&room, parts)
+		if len(parts) == 0 {
+			history.Message = "投稿跳过: 所有分P均低于时长/大小限制"
+			db.Save(&history)
+			return fmt.Errorf("所有分P均低于时长/大小限制，不投稿")
+		}
+		if room.MinTotalDuration > 0 && totalDuration < room.MinTotalDuration {
+			history.Message = fmt.Sprintf("投稿跳过: 有效时长%d秒低于最低要求%d秒", totalDuration, room.MinTotalDuration)
+			db.Save(&history)
+			return fmt.Errorf("有效内容时长%d秒低于最低要求%d秒，不投稿", totalDuration, room.MinTotalDuration)
+		}
+	}
+
 	// 构建模板数据（优先使用历史记录中的实际数据）
 	templateData := map[string]interface{}{
 		"uname":     history.Uname, // 使用历史记录中实际的主播名
@@ -66,12 +86,32 @@ func (s *Service) PublishHistory(historyID uint, userID uint) error {
 		"startTime": history.StartTime,
 		"uid":       user.UID,
 	}
+	services.NewRevenueService().AddTemplateData(templateData, history.SessionID)
+	highlightSvc := services.NewHighlightPublishService()
+	if history.HighlightArchive != services.HighlightPublishOff {
+		highlightSvc.AddTemplateData(templateData, &history)
+	}
 
 	// 使用模板服务渲染
 	title := s.templateSvc.RenderTitle(room.TitleTemplate, templateData)
 	desc := s.templateSvc.RenderDescription(room.DescTemplate, templateData)
+	if history.HighlightArchive != services.HighlightPublishOff {
+		// 高能集锦简介必须带上完整录播链接
+		desc = highlightSvc.EnsureFullLink(desc, templateData)
+	}
 	dynamic := s.templateSvc.RenderDynamic(room.DynamicTemplate, templateData) // 动态模板
 	tags := s.templateSvc.BuildTags(room.Tags, templateData)
+
+	// 历史记录单独设置的稿件信息优先于房间模板（如手动剪辑的片段）
+	if history.PublishTitle != "" {
+		title = s.templateSvc.RenderTitle(history.PublishTitle, templateData)
+	}
+	if history.PublishDesc != "" {
+		desc = s.templateSvc.RenderDescription(history.PublishDesc, templateData)
+	}
+	if history.PublishTags != "" {
+		tags = s.templateSvc.BuildTags(history.PublishTags, templateData)
+	}
 	tagsStr := strings.Join(tags, ",")
 
 	tid := room.TID
@@ -270,7 +310,9 @@ func (s *Service) PublishHistory(historyID uint, userID uint) error {
 	}
 
 	// 投稿，同时获取AID和BV号
-	avID, bvid, err := client.PublishVideo(title, desc, tagsStr, tid, room.Copyright, coverURL, videoParts, source)
+	// 先仅自己可见投稿时，审核通过后再自动公开
+	isOnlySelf := room.IsOnlySelf || room.PrivateFirst
+	avID, bvid, err := client.PublishVideo(title, desc, tagsStr, tid, room.Copyright, coverURL, videoParts, source, isOnlySelf, room.NoDisturbance)
 	if err != nil {
 		// 检查是否是验证码错误
 		captchaService := services.NewCaptchaService()
@@ -322,6 +364,9 @@ func (s *Service) PublishHistory(historyID uint, userID uint) error {
 	history.BvID = bvid
 	history.Publish = true
 	history.Message = "投稿成功"
+	if room.PrivateFirst && !room.IsOnlySelf {
+		history.VisibilityStatus = services.VisibilityStatusPendingReview
+	}
 	// 注意：投稿后不修改UploadStatus，保持为2（已上传）
 	db.Save(&history)
 
@@ -344,13 +389,12 @@ func (s *Service) PublishHistory(historyID uint, userID uint) error {
 	}
 
 	// 加入合集
-	if room.SeasonID > 0 && len(videoParts) > 0 {
+	if services.SeasonEnabled(&room) && len(videoParts) > 0 {
 		// 使用第一个分P的CID
 		cid := videoParts[0].Cid
-		if err := client.AddToSeason(room.SeasonID, avID, cid, title); err != nil {
+		seasonSvc := services.NewSeasonService()
+		if err := seasonSvc.AddHistoryToSeason(client, &room, &history, avID, cid, title); err != nil {
 			log.Printf("加入合集失败: %v", err)
-		} else {
-			log.Printf("加入合集成功: SeasonID=%d, AID=%d", room.SeasonID, avID)
 		}
 	}
 
@@ -361,7 +405,7 @@ func (s *Service) PublishHistory(historyID uint, userID uint) error {
 	}
 
 	// 推送通知（使用历史记录中实际的主播名）
-	if room.Wxuid != "" && containsTag(room.PushMsgTags, "投稿") {
+	if room.Wxuid != "" && services.ContainsPushTag(room.PushMsgTags, "投稿") {
 		s.wxPusher.NotifyPublishSuccess(room.UploadUserID, room.Wxuid, history.Uname, title, history.BvID)
 	}
 
@@ -377,26 +421,22 @@ func (s *Service) PublishHistory(historyID uint, userID uint) error {
 	}
 
 	// 处理文件策略：9-投稿成功后删除, 10-投稿成功后移动
-	if room.DeleteType == 9 || room.DeleteType == 10 {
-		fileMoverSvc := services.NewFileMoverService()
-		if err := fileMoverSvc.ProcessFilesByStrategy(historyID, room.DeleteType); err != nil {
-			log.Printf("文件处理失败: %v", err)
+	// 开启弹幕版时源文件还需用于压制，文件处理推迟到弹幕版完成后执行（手动剪辑的片段没有弹幕，不压制）
+	if room.DanmakuBurnIn && history.SourceHistoryID == 0 {
+		if err := s.EnqueueBurnIn(historyID); err != nil {
+			log.Printf("加入弹幕压制队列失败: %v", err)
+			// 已在压制中时由压制任务结束后处理文件
+			if !errors.Is(err, errBurnInInProgress) {
+				s.processPublishedFiles(historyID, &room)
+			}
 		}
+	} else {
+		s.processPublishedFiles(historyID, &room)
 	}
 
-	// 如果启用高能剪辑，创建高能剪辑任务
-	if room.HighEnergyCut {
-		go func() {
-			log.Printf("开始高能剪辑: history_id=%d", historyID)
-			highEnergySvc := services.NewHighEnergyCutService()
-			outputFile, err := highEnergySvc.CutHighEnergySegments(historyID)
-			if err != nil {
-				log.Printf("高能剪辑失败: %v", err)
-				return
-			}
-			log.Printf("高能剪辑完成: %s", outputFile)
-			// TODO: 自动上传高能剪辑版本
-		}()
+	// 如果启用高能剪辑且弹幕已解析，加入高能剪辑队列（已生成过则跳过）
+	if room.HighEnergyCut && history.SourceHistoryID == 0 {
+		services.NewHighEnergyCutService().EnqueueIfEnabled(historyID)
 	}
 
 	return nil
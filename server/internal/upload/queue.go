@@ -6,6 +6,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/gobup/server/internal/database"
 	"github.com/gobup/server/internal/models"
 )
 
@@ -77,6 +78,13 @@ func (q *UserUploadQueue) process() {
 			continue
 		}
 
+		// 同一分P可能被定时任务重复加入队列，前一次已上传成功时跳过
+		var latest models.RecordHistoryPart
+		if err := database.GetDB().Select("upload").First(&latest, task.Part.ID).Error; err == nil && latest.Upload {
+			log.Printf("[队列] 用户%d的任务part_id=%d已上传，跳过", q.userID, task.Part.ID)
+			continue
+		}
+
 		// 执行上传
 		if err := q.service.uploadPartInternal(task.Part, task.History, task.Room); err != nil {
 			log.Printf("[队列] 用户%d的上传任务失败: part_id=%d, error=%v",
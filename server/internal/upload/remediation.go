@@ -0,0 +1,322 @@
+package upload
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gobup/server/internal/bili"
+	"github.com/gobup/server/internal/database"
+	"github.com/gobup/server/internal/models"
+	"github.com/gobup/server/internal/services"
+)
+
+// ProcessPendingRemediations 处理所有待自动处理的审核退回稿件
+func (s *Service) ProcessPendingRemediations() error {
+	db := database.GetDB()
+
+	var histories []models.RecordHistory
+	if err := db.Where("remediation_status = ? AND bv_id != ''", services.RemediationStatusPending).
+		Find(&histories).Error; err != nil {
+		return err
+	}
+
+	for _, history := range histories {
+		if err := s.RemediateRejectedHistory(history.ID); err != nil {
+			log.Printf("[审核退回] 自动处理失败: history_id=%d, 错误=%v", history.ID, err)
+		}
+	}
+	return nil
+}
+
+// RemediateRejectedHistory 按房间配置处理审核退回的稿件：移除违规分P或剪除违规片段重传，然后通过编辑稿件重新提交
+func (s *Service) RemediateRejectedHistory(historyID uint) error {
+	db := database.GetDB()
+	rejectSvc := services.NewReviewRejectService()
+
+	var history models.RecordHistory
+	if err := db.First(&history, historyID).Error; err != nil {
+		return fmt.Errorf("历史记录不存在: %w", err)
+	}
+
+	var room models.RecordRoom
+	if err := db.Where("room_id = ?", history.RoomID).First(&room).Error; err != nil {
+		return fmt.Errorf("房间不存在: %w", err)
+	}
+
+	fail := func(err error) error {
+		rejectSvc.UpdateRemediation(historyID, services.RemediationStatusFailed, err.Error())
+		if room.Wxuid != "" && services.ContainsPushTag(room.PushMsgTags, "审核") {
+			s.wxPusher.SendTextMessage(room.UploadUserID, room.Wxuid,
+				fmt.Sprintf("❌ 审核退回自动处理失败\n房间: %s\nBV号: %s\n原因: %v", history.Uname, history.BvID, err))
+		}
+		return err
+	}
+
+	if err := rejectSvc.CheckRemediable(&history, &room); err != nil {
+		return err
+	}
+	if !rejectSvc.ClaimRemediation(historyID) {
+		return fmt.Errorf("稿件正在处理中")
+	}
+
+	aid, err := strconv.ParseInt(history.AvID, 10, 64)
+	if err != nil || aid == 0 {
+		return fail(fmt.Errorf("稿件AID无效: %s", history.AvID))
+	}
+
+	var user models.BiliBiliUser
+	if err := db.First(&user, room.UploadUserID).Error; err != nil {
+		return fail(fmt.Errorf("上传用户不存在: %w", err))
+	}
+	if !user.Login {
+		return fail(fmt.Errorf("用户未登录"))
+	}
+
+	rejectSvc.UpdateRemediation(historyID, services.RemediationStatusProcessing,
+		fmt.Sprintf("开始第%d次自动处理", history.RemediationCount+1))
+
+	var parts []models.RecordHistoryPart
+	if err := db.Where("history_id = ? AND upload = ? AND file_delete = ? AND excluded = ?", historyID, true, false, false).
+		Order("start_time ASC").
+		Find(&parts).Error; err != nil {
+		return fail(fmt.Errorf("查询分P失败: %w", err))
+	}
+	if len(parts) == 0 {
+		return fail(fmt.Errorf("没有可处理的分P"))
+	}
+
+	// 将违规片段归属到具体分P
+	ranges := rejectSvc.GetRejectRanges(&history)
+	if len(ranges) == 0 {
+		return fail(fmt.Errorf("未解析到违规分P或时间段，需要人工处理"))
+	}
+
+	flagged := make(map[int][]services.RejectRange) // parts下标 -> 违规片段
+	for _, r := range ranges {
+		idx, err := findRejectedPartIndex(parts, r)
+		if err != nil {
+			return fail(err)
+		}
+		flagged[idx] = append(flagged[idx], r)
+	}
+
+	for idx, partRanges := range flagged {
+		part := &parts[idx]
+
+		var timed []services.RejectRange
+		for _, r := range partRanges {
+			if r.End > r.Start {
+				timed = append(timed, r)
+			}
+		}
+
+		// 删除模式或整P违规时直接移除该分P
+		if room.RejectRemediation == services.RejectRemediationDrop || len(timed) < len(partRanges) {
+			db.Model(part).Updates(map[string]interface{}{
+				"excluded":       true,
+				"exclude_reason": fmt.Sprintf("审核退回，已从稿件中移除: %s", history.RejectReason),
+			})
+			rejectSvc.UpdateRemediation(historyID, services.RemediationStatusProcessing,
+				fmt.Sprintf("已移除违规分P: %s", part.FileName))
+			continue
+		}
+
+		newPart, err := s.cutRejectedRanges(part, &history, &room, timed, history.RemediationCount+1)
+		if err != nil {
+			return fail(err)
+		}
+		rejectSvc.UpdateRemediation(historyID, services.RemediationStatusProcessing,
+			fmt.Sprintf("已剪除违规片段并重新上传: part_id=%d -> part_id=%d, cid=%d", part.ID, newPart.ID, newPart.CID))
+	}
+
+	// 重新查询剩余分P并提交编辑
+	parts = nil
+	if err := db.Where("history_id = ? AND upload = ? AND file_delete = ? AND excluded = ?", historyID, true, false, false).
+		Order("start_time ASC").
+		Find(&parts).Error; err != nil {
+		return fail(fmt.Errorf("查询分P失败: %w", err))
+	}
+	if len(parts) == 0 {
+		return fail(fmt.Errorf("移除违规分P后稿件没有剩余分P"))
+	}
+
+	client := bili.NewBiliClient(user.AccessKey, user.Cookies, user.UID)
+	if err := s.resubmitArchive(client, aid, &history, &room, parts); err != nil {
+		return fail(err)
+	}
+
+	db.Model(&history).Updates(map[string]interface{}{
+		"remediation_count": history.RemediationCount + 1,
+		"video_state":       0,
+		"video_state_desc":  "审核中",
+	})
+	rejectSvc.UpdateRemediation(historyID, services.RemediationStatusResubmit,
+		fmt.Sprintf("已重新提交稿件，剩余%d个分P", len(parts)))
+
+	syncService := services.NewVideoSyncService()
+	if err := syncService.CreateSyncTask(historyID); err != nil {
+		log.Printf("[审核退回] 创建同步任务失败: %v", err)
+	}
+
+	if room.Wxuid != "" && services.ContainsPushTag(room.PushMsgTags, "审核") {
+		s.wxPusher.SendTextMessage(room.UploadUserID, room.Wxuid,
+			fmt.Sprintf("🔁 审核退回已自动处理并重新提交\n房间: %s\nBV号: %s\n剩余分P: %d", history.Uname, history.BvID, len(parts)))
+	}
+
+	return nil
+}
+
+// findRejectedPartIndex 根据CID或已记录的分P序号定位分P，退回原因未注明分P且只有一个分P时默认为该分P
+func findRejectedPartIndex(parts []models.RecordHistoryPart, r services.RejectRange) (int, error) {
+	if r.CID > 0 {
+		for i, part := range parts {
+			if part.CID == r.CID {
+				return i, nil
+			}
+		}
+	}
+	if r.Page > 0 {
+		for i, part := range parts {
+			if part.Page == r.Page {
+				return i, nil
+			}
+		}
+	}
+	if r.CID == 0 && r.Page == 0 && len(parts) == 1 {
+		return 0, nil
+	}
+	return -1, fmt.Errorf("无法定位违规分P: P%d (cid=%d)", r.Page, r.CID)
+}
+
+// cutRejectedRanges 剪除分P中的违规片段，生成新分P并立即上传，原分P标记为不参与投稿
+func (s *Service) cutRejectedRanges(part *models.RecordHistoryPart, history *models.RecordHistory, room *models.RecordRoom, ranges []services.RejectRange, round int) (*models.RecordHistoryPart, error) {
+	db := database.GetDB()
+
+	if _, err := os.Stat(part.FilePath); os.IsNotExist(err) {
+		return nil, fmt.Errorf("分P文件不存在，无法剪辑: %s", part.FilePath)
+	}
+
+	ext := filepath.Ext(part.FilePath)
+	outputPath := fmt.Sprintf("%s_fix%d%s", strings.TrimSuffix(part.FilePath, ext), round, ext)
+
+	rejectSvc := services.NewReviewRejectService()
+	if err := rejectSvc.CutOutRanges(part.FilePath, outputPath, part.Duration, ranges); err != nil {
+		return nil, fmt.Errorf("剪除违规片段失败: %w", err)
+	}
+
+	fileInfo, err := os.Stat(outputPath)
+	if err != nil {
+		return nil, fmt.Errorf("获取剪辑文件信息失败: %w", err)
+	}
+
+	// 违规片段按关键帧顺延后实际剪除的时长会更长，以剪辑结果为准
+	duration := 0
+	if info, err := services.NewMediaService().ProbeMediaInfo(outputPath); err == nil {
+		duration = int(info.Duration + 0.5)
+	} else {
+		removed := 0
+		for _, r := range ranges {
+			removed += r.End - r.Start
+		}
+		duration = max(part.Duration-removed, 0)
+	}
+
+	newPart := &models.RecordHistoryPart{
+		HistoryID: part.HistoryID,
+		RoomID:    part.RoomID,
+		SessionID: part.SessionID,
+		Title:     part.Title,
+		LiveTitle: part.LiveTitle,
+		AreaName:  part.AreaName,
+		FilePath:  outputPath,
+		FileName:  filepath.Base(outputPath),
+		FileSize:  fileInfo.Size(),
+		Duration:  duration,
+		StartTime: part.StartTime,
+		EndTime:   part.EndTime,
+		Page:      part.Page,
+	}
+	if err := db.Create(newPart).Error; err != nil {
+		return nil, fmt.Errorf("创建新分P记录失败: %w", err)
+	}
+
+	db.Model(part).Updates(map[string]interface{}{
+		"excluded":       true,
+		"exclude_reason": fmt.Sprintf("审核退回，已剪除违规片段生成新分P(id=%d)", newPart.ID),
+	})
+
+	if err := s.uploadPartInternal(newPart, history, room); err != nil {
+		return nil, fmt.Errorf("重新上传分P失败: %w", err)
+	}
+	if err := db.First(newPart, newPart.ID).Error; err != nil {
+		return nil, fmt.Errorf("重新加载分P失败: %w", err)
+	}
+	if newPart.CID == 0 {
+		return nil, fmt.Errorf("分P重新上传后CID仍为0")
+	}
+
+	return newPart, nil
+}
+
+// resubmitArchive 使用剩余分P编辑稿件并重新提交审核，稿件信息优先沿用线上版本
+func (s *Service) resubmitArchive(client *bili.BiliClient, aid int64, history *models.RecordHistory, room *models.RecordRoom, parts []models.RecordHistoryPart) error {
+	templateData := map[string]interface{}{
+		"uname":     history.Uname,
+		"title":     history.Title,
+		"roomId":    history.RoomID,
+		"areaName":  history.AreaName,
+		"startTime": history.StartTime,
+		"uid":       client.Mid,
+	}
+
+	title := s.templateSvc.RenderTitle(room.TitleTemplate, templateData)
+	desc := s.templateSvc.RenderDescription(room.DescTemplate, templateData)
+	tags := strings.Join(s.templateSvc.BuildTags(room.Tags, templateData), ",")
+	tid := room.TID
+	copyright := room.Copyright
+	cover := history.CoverURL
+	source := ""
+	if room.Copyright == 2 {
+		source = s.templateSvc.RenderTitle(room.SourceTemplate, templateData)
+	}
+
+	if archiveInfo, err := client.GetVideoPartInfo(history.BvID); err == nil && archiveInfo.Archive.Title != "" {
+		archive := archiveInfo.Archive
+		title, desc, tags, tid = archive.Title, archive.Desc, archive.Tag, archive.Tid
+		copyright, cover, source = archive.Copyright, archive.Cover, archive.Source
+	} else if err != nil {
+		log.Printf("[审核退回] 获取线上稿件信息失败，使用模板重新生成: %v", err)
+	}
+
+	var videos []bili.PublishVideoPartRequest
+	for i, part := range parts {
+		partTemplateData := map[string]interface{}{
+			"index":     i + 1,
+			"startTime": part.StartTime,
+			"areaName":  part.AreaName,
+			"uname":     history.Uname,
+			"title":     history.Title,
+			"roomId":    history.RoomID,
+			"fileName":  part.FileName,
+		}
+		videos = append(videos, bili.PublishVideoPartRequest{
+			Title:    s.templateSvc.RenderPartTitle(room.PartTitleTemplate, partTemplateData),
+			Filename: part.FileName,
+			Cid:      part.CID,
+		})
+	}
+
+	// 弹幕版以分P形式追加在原稿件时，重新提交需保留这些分P
+	if room.BurnInTarget == services.BurnInTargetAppend {
+		videos = append(videos, s.burnInPages(history, room, parts)...)
+	}
+
+	if err := client.EditVideo(aid, title, desc, tags, tid, copyright, cover, videos, source); err != nil {
+		return fmt.Errorf("重新提交稿件失败: %w", err)
+	}
+	return nil
+}
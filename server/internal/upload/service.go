@@ -35,6 +35,7 @@ func NewService() *Service {
 		progressTracker: NewProgressTracker(),
 	}
 	svc.queueManager = NewQueueManager(svc)
+	svc.getBurnInQueue()
 	return svc
 }
 
@@ -50,6 +51,9 @@ func (s *Service) GetQueueManager() *QueueManager {
 
 // UploadPart 上传分P（通过队列）
 func (s *Service) UploadPart(part *models.RecordHistoryPart, history *models.RecordHistory, room *models.RecordRoom) error {
+	// 高能集锦稿件使用单独的投稿账号和配置
+	room = services.HighlightArchiveRoom(room, history)
+
 	// 将任务添加到用户的上传队列
 	if room.UploadUserID == 0 {
 		return fmt.Errorf("房间未配置上传用户")
@@ -75,7 +79,11 @@ func (s *Service) uploadPartInternal(part *models.RecordHistoryPart, history *mo
 		part.RateLimitCooldownAt = nil
 		part.RateLimitRetryCount = 0
 		part.UploadErrorMsg = ""
-		db.Save(part)
+		db.Model(part).Updates(map[string]interface{}{
+			"rate_limit_cooldown_at": nil,
+			"rate_limit_retry_count": 0,
+			"upload_error_msg":       "",
+		})
 	}
 
 	// 防止重复上传
@@ -84,19 +92,27 @@ func (s *Service) uploadPartInternal(part *models.RecordHistoryPart, history *mo
 	}
 	defer s.uploadingParts.Delete(part.ID)
 
-	// 标记为上传中
+	// 标记为上传中，入队后已被合并或排除的分P不再上传
+	claim := db.Model(&models.RecordHistoryPart{}).
+		Where("id = ? AND excluded = ?", part.ID, false).
+		Update("uploading", true)
+	if claim.Error != nil {
+		return fmt.Errorf("标记分P上传状态失败: %w", claim.Error)
+	}
+	if claim.RowsAffected == 0 {
+		return fmt.Errorf("分P %d 已被合并或排除，跳过上传", part.ID)
+	}
 	part.Uploading = true
-	db.Save(part)
 
 	// 更新历史记录的上传状态为“上传中”
 	if history.UploadStatus == 0 {
 		history.UploadStatus = 1
-		db.Save(history)
+		db.Model(history).Update("upload_status", 1)
 	}
 
 	defer func() {
 		part.Uploading = false
-		db.Save(part)
+		db.Model(part).Update("uploading", false)
 	}()
 
 	// 获取用户信息
@@ -154,7 +170,7 @@ func (s *Service) uploadPartInternal(part *models.RecordHistoryPart, history *mo
 	log.Printf("开始上传: room=%s, file=%s, line=%s", room.RoomID, part.FilePath, room.Line)
 
 	// 推送上传开始通知（使用历史记录中实际的主播名）
-	if room.Wxuid != "" && containsTag(room.PushMsgTags, "分P上传") {
+	if room.Wxuid != "" && services.ContainsPushTag(room.PushMsgTags, "分P上传") {
 		s.wxPusher.NotifyUploadStart(room.UploadUserID, room.Wxuid, history.Uname, part.FileName, part.FileSize)
 	}
 
@@ -214,7 +230,11 @@ func (s *Service) uploadPartInternal(part *models.RecordHistoryPart, history *mo
 			part.RateLimitCooldownAt = &cooldownTime
 			part.RateLimitRetryCount++
 			part.UploadErrorMsg = fmt.Sprintf("速率限制(406)，已设置24小时冷却期至 %s", cooldownTime.Format("2006-01-02 15:04:05"))
-			db.Save(part)
+			db.Model(part).Updates(map[string]interface{}{
+				"rate_limit_cooldown_at": part.RateLimitCooldownAt,
+				"rate_limit_retry_count": part.RateLimitRetryCount,
+				"upload_error_msg":       part.UploadErrorMsg,
+			})
 			log.Printf("[速率限制] 分P %d 触发406限制，设置24小时冷却期至: %s", part.ID, cooldownTime.Format("2006-01-02 15:04:05"))
 		}
 
@@ -234,11 +254,11 @@ func (s *Service) uploadPartInternal(part *models.RecordHistoryPart, history *mo
 			} else {
 				history.UploadStatus = 0 // 没有已上传的，设为未上传
 			}
-			db.Save(history)
+			db.Model(history).Update("upload_status", history.UploadStatus)
 		}
 
 		// 推送失败通知（使用历史记录中实际的主播名）
-		if room.Wxuid != "" && containsTag(room.PushMsgTags, "分P上传") {
+		if room.Wxuid != "" && services.ContainsPushTag(room.PushMsgTags, "分P上传") {
 			s.wxPusher.NotifyUploadFailed(room.UploadUserID, room.Wxuid, history.Uname, part.FileName, uploadErr.Error())
 		}
 		return fmt.Errorf("上传失败: %w", uploadErr)
@@ -248,7 +268,11 @@ func (s *Service) uploadPartInternal(part *models.RecordHistoryPart, history *mo
 	part.Upload = true
 	part.FileName = uploadResult.FileName
 	part.CID = uploadResult.BizID
-	db.Save(part)
+	db.Model(part).Updates(map[string]interface{}{
+		"upload":    true,
+		"file_name": part.FileName,
+		"c_id":      part.CID,
+	})
 
 	log.Printf("上传完成: part_id=%d, cid=%d", part.ID, part.CID)
 
@@ -261,11 +285,11 @@ func (s *Service) uploadPartInternal(part *models.RecordHistoryPart, history *mo
 	if totalCount > 0 && uploadedCount == totalCount {
 		// 所有分P已上传完成
 		history.UploadStatus = 2
-		db.Save(history)
+		db.Model(history).Update("upload_status", 2)
 	} else if uploadedCount > 0 {
 		// 部分已上传
 		history.UploadStatus = 2
-		db.Save(history)
+		db.Model(history).Update("upload_status", 2)
 	}
 
 	// 标记上传成功并移除进度
@@ -280,7 +304,7 @@ func (s *Service) uploadPartInternal(part *models.RecordHistoryPart, history *mo
 	}
 
 	// 推送成功通知（使用历史记录中实际的主播名）
-	if room.Wxuid != "" && containsTag(room.PushMsgTags, "分P上传") {
+	if room.Wxuid != "" && services.ContainsPushTag(room.PushMsgTags, "分P上传") {
 		s.wxPusher.NotifyUploadSuccess(room.UploadUserID, room.Wxuid, history.Uname, part.FileName)
 	}
 
@@ -297,8 +321,9 @@ func (s *Service) checkAndPublish(history *models.RecordHistory, room *models.Re
 	var totalCount int64
 	var uploadedCount int64
 
-	db.Model(&models.RecordHistoryPart{}).Where("history_id = ?", history.ID).Count(&totalCount)
-	db.Model(&models.RecordHistoryPart{}).Where("history_id = ? AND upload = ?", history.ID, true).Count(&uploadedCount)
+	// 不参与投稿的分P（过短被跳过、已合并等）不计入
+	db.Model(&models.RecordHistoryPart{}).Where("history_id = ? AND excluded = ?", history.ID, false).Count(&totalCount)
+	db.Model(&models.RecordHistoryPart{}).Where("history_id = ? AND upload = ? AND excluded = ?", history.ID, true, false).Count(&uploadedCount)
 
 	// 如果所有分P都上传完成且未投稿，根据房间的AutoPublish设置决定是否自动投稿
 	if totalCount > 0 && totalCount == uploadedCount && !history.Publish && room.AutoPublish {
@@ -312,17 +337,6 @@ func (s *Service) checkAndPublish(history *models.RecordHistory, room *models.Re
 	}
 }
 
-// containsTag 检查标签列表中是否包含指定标签
-func containsTag(tags, target string) bool {
-	tagList := strings.Split(tags, ",")
-	for _, tag := range tagList {
-		if strings.TrimSpace(tag) == target {
-			return true
-		}
-	}
-	return false
-}
-
 // contains 检查字符串是否包含子串
 func contains(s, substr string) bool {
 	return strings.Contains(s, substr)
@@ -451,7 +465,11 @@ func (s *Service) splitLargeFile(originalPart *models.RecordHistoryPart, history
 	originalPart.Upload = true
 	originalPart.FileDelete = true
 	originalPart.UploadErrorMsg = fmt.Sprintf("文件过大(分片数%d>10000)，已自动分割成%d个Part", totalChunks, numParts)
-	db.Save(originalPart)
+	db.Model(originalPart).Updates(map[string]interface{}{
+		"upload":           true,
+		"file_delete":      true,
+		"upload_error_msg": originalPart.UploadErrorMsg,
+	})
 
 	log.Printf("[自动分P] 文件分割完成，已创建 %d 个新Part，原Part(id=%d)标记为已处理", len(newParts), originalPart.ID)
 
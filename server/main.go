@@ -16,6 +16,7 @@ import (
 	"github.com/gobup/server/internal/models"
 	"github.com/gobup/server/internal/routes"
 	"github.com/gobup/server/internal/scheduler"
+	"github.com/gobup/server/internal/services"
 	"github.com/gobup/server/internal/upload"
 )
 
@@ -101,6 +102,15 @@ func main() {
 	// 初始化管理员用户
 	initAdminUser()
 
+	// 服务重启前未完成的片段剪辑标记为失败
+	services.NewClipExtractService().RecoverInterrupted()
+
+	// 服务重启前未完成的审核退回处理标记为失败
+	services.NewReviewRejectService().RecoverInterrupted()
+
+	// 服务退出时未写完的直播录制文件结束录制状态
+	services.GetStreamRecorderService().RecoverInterrupted()
+
 	// 初始化定时任务
 	scheduler.InitScheduler()
 	defer scheduler.StopScheduler()
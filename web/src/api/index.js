@@ -51,6 +51,7 @@ export const roomAPI = {
   getLines: () => request.get('/room/lines'),
   testLines: () => request.get('/room/testLines'),
   testSpeed: (line) => request.get('/room/testSpeed', { params: { line } }),
+  coverage: (roomId, params) => request.get(`/room/coverage/${roomId}`, { params }),
   verifyTemplate: (data) => request.post('/room/verifyTemplate', data)
 }
 
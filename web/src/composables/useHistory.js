@@ -355,6 +355,29 @@ export function useHistoryOperations() {
     }
   }
 
+  // 导出弹幕
+  const handleExportDanmaku = async (row, format = 'ass') => {
+    try {
+      const response = await axios.get(`/api/history/exportDanmaku/${row.id}`, {
+        params: { format },
+        responseType: 'blob'
+      })
+      if (response.data.type?.includes('application/json')) {
+        const result = JSON.parse(await response.data.text())
+        ElMessage.error(result.msg || '导出弹幕失败')
+        return
+      }
+      const link = document.createElement('a')
+      link.href = URL.createObjectURL(response.data)
+      link.download = `${row.uname || 'danmaku'}_${row.id}.${format}`
+      link.click()
+      URL.revokeObjectURL(link.href)
+    } catch (error) {
+      console.error('导出弹幕失败:', error)
+      ElMessage.error('导出弹幕失败')
+    }
+  }
+
   // 解析弹幕
   const handleParseDanmaku = async (row, callback) => {
     try {
@@ -519,6 +542,7 @@ export function useHistoryOperations() {
     handlePublish,
     handleSendDanmaku,
     handleParseDanmaku,
+    handleExportDanmaku,
     handleBatchParseDanmaku,
     handleSyncVideo,
     handleMoveFiles,